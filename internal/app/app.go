@@ -5,21 +5,26 @@ import (
 	"time"
 
 	"github.com/damonto/telegram-sms/internal/app/router"
+	"github.com/damonto/telegram-sms/internal/pkg/contact"
 	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/ussd"
 	"github.com/mymmrac/telego"
 	th "github.com/mymmrac/telego/telegohandler"
 )
 
 type application struct {
-	Bot     *telego.Bot
-	m       *modem.Manager
-	handler *th.BotHandler
-	updates <-chan telego.Update
-	ctx     context.Context
+	Bot       *telego.Bot
+	m         *modem.Manager
+	book      *contact.Book
+	shortcuts *ussd.Shortcuts
+	balances  *ussd.Balances
+	handler   *th.BotHandler
+	updates   <-chan telego.Update
+	ctx       context.Context
 }
 
-func New(ctx context.Context, bot *telego.Bot, m *modem.Manager) (*application, error) {
-	app := &application{Bot: bot, m: m, ctx: ctx}
+func New(ctx context.Context, bot *telego.Bot, m *modem.Manager, book *contact.Book, shortcuts *ussd.Shortcuts, balances *ussd.Balances) (*application, error) {
+	app := &application{Bot: bot, m: m, book: book, shortcuts: shortcuts, balances: balances, ctx: ctx}
 	var err error
 	app.updates, err = bot.UpdatesViaLongPolling(ctx, nil)
 	if err != nil {
@@ -34,7 +39,7 @@ func New(ctx context.Context, bot *telego.Bot, m *modem.Manager) (*application,
 
 func (app *application) Start() error {
 	app.handler.Use(th.PanicRecovery())
-	router.NewRouter(app.Bot, app.handler, app.m).Register()
+	router.NewRouter(app.Bot, app.handler, app.m, app.book, app.shortcuts, app.balances).Register()
 	return app.handler.Start()
 }
 
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/lpa"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type APDUHandler struct {
+	*Handler
+}
+
+// APDUSessionTimeout closes a console nobody typed into for a while, the LPA cannot use the card meanwhile.
+const APDUSessionTimeout = 5 * time.Minute
+
+type APDUValue struct {
+	Modem   *modem.Modem
+	Console *lpa.Console
+	timer   *time.Timer
+	once    sync.Once
+}
+
+func NewAPDUHandler() state.Handler {
+	h := new(APDUHandler)
+	return h
+}
+
+func (h *APDUHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		m := h.Modem(ctx)
+		console, err := lpa.NewConsole(m)
+		if err != nil {
+			return err
+		}
+		chatID := update.Message.Chat.ID
+		value := &APDUValue{Modem: m, Console: console}
+		value.timer = time.AfterFunc(APDUSessionTimeout, func() {
+			h.close(ctx.Bot(), chatID, value, "The APDU session has been closed after being idle for "+idleFor(APDUSessionTimeout)+".")
+		})
+		state.M.Enter(chatID, &state.ChatState{
+			Handler: h,
+			Value:   value,
+		})
+		text := "Okay, send me the APDUs in hex, e.g. 00A4000C023F00. Send select followed by an AID to open a logical channel, send exit to close the session."
+		if _, aid, ok := strings.Cut(strings.TrimSpace(update.Message.Text), " "); ok {
+			if text, err = h.selectAID(value, aid); err != nil {
+				h.close(ctx.Bot(), chatID, value, "The APDU session has been closed.")
+				return err
+			}
+		}
+		_, err = h.Reply(ctx, update, util.EscapeText(text), nil)
+		return err
+	}
+}
+
+func (h *APDUHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	value := s.Value.(*APDUValue)
+	value.timer.Reset(APDUSessionTimeout)
+	text := strings.TrimSpace(message.Text)
+	if strings.EqualFold(text, "exit") {
+		h.close(ctx.Bot(), message.Chat.ID, value, "The APDU session has been closed.")
+		return nil
+	}
+	if command, aid, ok := strings.Cut(text, " "); ok && strings.EqualFold(command, "select") {
+		reply, err := h.selectAID(value, aid)
+		if err != nil {
+			reply = err.Error()
+		}
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(reply), nil)
+		return err
+	}
+	command, err := h.decode(text)
+	if err != nil {
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText("That is not a valid APDU, send it in hex, e.g. 00A4000C023F00."), nil)
+		return err
+	}
+	response, err := value.Console.Transmit(command)
+	slog.Info("[APDU] Transmitted", "user", message.From.ID, "modem", value.Modem.EquipmentIdentifier, "command", fmt.Sprintf("%X", command), "response", fmt.Sprintf("%X", response), "error", err)
+	if err != nil {
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(err.Error()), nil)
+		return err
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "> %X\n", command)
+	if data := response.Data(); len(data) > 0 {
+		fmt.Fprintf(&sb, "< %X\n", data)
+	}
+	fmt.Fprintf(&sb, "SW %04X: %s", response.SW(), lpa.StatusWord(response.SW()))
+	_, err = h.ReplyMessage(ctx, message, "```\n"+sb.String()+"\n```", nil)
+	return err
+}
+
+func (h *APDUHandler) selectAID(value *APDUValue, text string) (string, error) {
+	aid, err := h.decode(text)
+	if err != nil || len(aid) < 5 || len(aid) > 16 {
+		return "", fmt.Errorf("invalid AID %s, it must be 5 to 16 bytes in hex", text)
+	}
+	channel, err := value.Console.Select(aid)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Selected %X on logical channel %d. Send me the APDUs in hex, send exit to close the session.", aid, channel), nil
+}
+
+func (h *APDUHandler) decode(text string) ([]byte, error) {
+	return hex.DecodeString(strings.NewReplacer(" ", "", ":", "").Replace(strings.TrimSpace(text)))
+}
+
+func (h *APDUHandler) close(bot *telego.Bot, chatID int64, value *APDUValue, text string) {
+	value.once.Do(func() {
+		value.timer.Stop()
+		if err := value.Console.Close(); err != nil {
+			slog.Warn("[APDU] Failed to close the console", "error", err)
+		}
+		state.M.ExitIf(chatID, value)
+		if _, err := bot.SendMessage(context.Background(), tu.Message(tu.ID(chatID), text)); err != nil {
+			slog.Error("Failed to send message", "error", err)
+		}
+	})
+}
+
+func (h *APDUHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	return nil
+}
@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type ATHandler struct {
+	*Handler
+}
+
+const (
+	ATActionConfirm state.State = "at_confirm"
+
+	// ATSessionTimeout closes a console nobody typed into for a while, so the port is not held forever.
+	ATSessionTimeout = 5 * time.Minute
+)
+
+type ATValue struct {
+	Modem   *modem.Modem
+	AT      *modem.AT
+	Pending string
+	timer   *time.Timer
+	once    sync.Once
+}
+
+type atRule struct {
+	prefix string
+	reason string
+}
+
+// atDeniedCommands would take the modem away from the bot, they are never sent.
+var atDeniedCommands = []atRule{
+	{`AT+QCFG="USBNET"`, "it changes the USB composition and the modem would disappear from ModemManager"},
+	{"AT+QFOTADL", "it flashes a new firmware"},
+	{"AT+QPRTPARA", "it restores the factory configuration"},
+	{`AT^SETPORT`, "it changes the USB composition and the modem would disappear from ModemManager"},
+}
+
+// atDangerousCommands are sent only after the admin confirmed them.
+var atDangerousCommands = []atRule{
+	{"AT&F", "it resets the modem to the factory defaults"},
+	{"AT+CFUN", "it changes the functionality level and may reboot the modem"},
+	{"AT+QPOWD", "it powers the modem off"},
+	{`AT+QCFG="BAND"`, "it locks the modem to a set of bands"},
+	{`AT+QNWPREFCFG`, "it changes the network preferences, e.g. a band lock"},
+	{`AT+QCFG="NWSCANMODE"`, "it changes the network scan mode"},
+	{"AT+COPS=", "it changes the network selection"},
+	{"AT+CLCK", "it changes a facility lock, a wrong password burns a PIN attempt"},
+	{"AT+CPWD", "it changes a SIM password, a wrong password burns a PIN attempt"},
+	{"AT+CPIN=", "a wrong PIN burns a PIN attempt"},
+	{"AT+CRSM=214", "it writes to the SIM"},
+	{"AT+CRSM=220", "it writes to the SIM"},
+	{"AT+CMGD", "it deletes messages"},
+}
+
+func NewATHandler() state.Handler {
+	h := new(ATHandler)
+	return h
+}
+
+func (h *ATHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		m := h.Modem(ctx)
+		port, err := m.Port(modem.ModemPortTypeAt)
+		if err != nil {
+			return err
+		}
+		at, err := modem.NewAT(port.Device)
+		if err != nil {
+			return err
+		}
+		chatID := update.Message.Chat.ID
+		value := &ATValue{Modem: m, AT: at}
+		value.timer = time.AfterFunc(ATSessionTimeout, func() {
+			h.close(ctx.Bot(), chatID, value, "The AT session has been closed after being idle for "+idleFor(ATSessionTimeout)+".")
+		})
+		state.M.Enter(chatID, &state.ChatState{
+			Handler: h,
+			Value:   value,
+		})
+		slog.Info("[AT] Console opened", "user", update.Message.From.ID, "modem", m.EquipmentIdentifier, "port", port.Device)
+		_, err = h.Reply(ctx, update, util.EscapeText(fmt.Sprintf(
+			"Okay, the AT session on %s is open. Send me the commands you want to run, send exit to close the session.",
+			port.Device,
+		)), nil)
+		return err
+	}
+}
+
+func (h *ATHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	value := s.Value.(*ATValue)
+	value.timer.Reset(ATSessionTimeout)
+	if strings.EqualFold(strings.TrimSpace(message.Text), "exit") {
+		h.close(ctx.Bot(), message.Chat.ID, value, "The AT session has been closed.")
+		return nil
+	}
+	if s.State == ATActionConfirm {
+		state.M.Current(message.Chat.ID, "")
+		command := value.Pending
+		value.Pending = ""
+		if message.Text != "Yes" {
+			h.audit(message, value, command, "cancelled")
+			_, err := h.ReplyMessage(ctx, message, util.EscapeText("Okay, the command will not be sent."), h.removeKeyboard)
+			return err
+		}
+		return h.run(ctx, message, value, command)
+	}
+	command := strings.TrimSpace(message.Text)
+	if !strings.HasPrefix(strings.ToUpper(command), "AT") {
+		_, err := h.ReplyMessage(ctx, message, util.EscapeText("AT commands start with AT, send exit to close the session."), nil)
+		return err
+	}
+	if rule := h.match(atDeniedCommands, command); rule != nil {
+		h.audit(message, value, command, "denied")
+		_, err := h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("%s is not allowed, %s.", command, rule.reason)), nil)
+		return err
+	}
+	if rule := h.match(atDangerousCommands, command); rule != nil {
+		value.Pending = command
+		state.M.Current(message.Chat.ID, ATActionConfirm)
+		_, err := h.ReplyMessage(
+			ctx,
+			message,
+			util.EscapeText(fmt.Sprintf("Are you sure you want to send %s? Be careful, %s.", command, rule.reason)),
+			func(m *telego.SendMessageParams) error {
+				m.WithReplyMarkup(tu.Keyboard(
+					tu.KeyboardRow(
+						tu.KeyboardButton("Yes"),
+						tu.KeyboardButton("No"),
+					),
+				).WithOneTimeKeyboard().WithResizeKeyboard())
+				return nil
+			},
+		)
+		return err
+	}
+	return h.run(ctx, message, value, command)
+}
+
+func (h *ATHandler) run(ctx *th.Context, message telego.Message, value *ATValue, command string) error {
+	response, err := value.AT.Run(command)
+	if err != nil {
+		h.audit(message, value, command, err.Error())
+		if errors.Is(err, modem.ErrATClosed) {
+			h.close(ctx.Bot(), message.Chat.ID, value, "The AT port has been closed, the modem may have been unplugged.")
+			return nil
+		}
+		var atErr *modem.ATError
+		if !errors.As(err, &atErr) && !errors.Is(err, modem.ErrATTimeout) {
+			return err
+		}
+		_, err = h.ReplyMessage(ctx, message, h.pre(err.Error()), h.removeKeyboard)
+		return err
+	}
+	h.audit(message, value, command, "OK")
+	_, err = h.ReplyMessage(ctx, message, h.pre(strings.TrimLeft(response+"\nOK", "\n")), h.removeKeyboard)
+	return err
+}
+
+// match returns the rule of the first command in the line that has one.
+func (h *ATHandler) match(rules []atRule, command string) *atRule {
+	for _, part := range h.split(command) {
+		for _, rule := range rules {
+			if strings.HasPrefix(part, rule.prefix) {
+				return &rule
+			}
+		}
+	}
+	return nil
+}
+
+// split breaks a command line into its commands, each with the AT prefix, e.g. ATE0&F;+CFUN=1,1
+// into ATE0, AT&F and AT+CFUN=1,1. Commands are separated by semicolons, or follow each other
+// directly when they start with +, & or ^. Separators in quoted strings are left alone.
+func (h *ATHandler) split(command string) []string {
+	command = strings.TrimPrefix(strings.ToUpper(strings.ReplaceAll(command, " ", "")), "AT")
+	var parts []string
+	var part strings.Builder
+	flush := func() {
+		if part.Len() > 0 {
+			parts = append(parts, "AT"+strings.TrimPrefix(part.String(), "AT"))
+			part.Reset()
+		}
+	}
+	var quoted bool
+	for _, r := range command {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == ';':
+			flush()
+			continue
+		case strings.ContainsRune("+&^", r):
+			flush()
+		}
+		part.WriteRune(r)
+	}
+	flush()
+	return parts
+}
+
+// audit writes one entry per command, whether it was sent or not.
+func (h *ATHandler) audit(message telego.Message, value *ATValue, command string, result string) {
+	slog.Info("[AT] Audit",
+		"user", message.From.ID,
+		"username", message.From.Username,
+		"modem", value.Modem.EquipmentIdentifier,
+		"command", command,
+		"result", result,
+	)
+}
+
+func (h *ATHandler) close(bot *telego.Bot, chatID int64, value *ATValue, text string) {
+	value.once.Do(func() {
+		value.timer.Stop()
+		if err := value.AT.Close(); err != nil {
+			slog.Warn("[AT] Failed to close the AT port", "error", err)
+		}
+		state.M.ExitIf(chatID, value)
+		slog.Info("[AT] Console closed", "modem", value.Modem.EquipmentIdentifier)
+		if _, err := bot.SendMessage(context.Background(), tu.Message(tu.ID(chatID), text).
+			WithReplyMarkup(tu.ReplyKeyboardRemove())); err != nil {
+			slog.Error("Failed to send message", "error", err)
+		}
+	})
+}
+
+func (h *ATHandler) pre(text string) string {
+	text = strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(text)
+	return "```\n" + text + "\n```"
+}
+
+func (h *ATHandler) removeKeyboard(m *telego.SendMessageParams) error {
+	m.WithReplyMarkup(tu.ReplyKeyboardRemove())
+	return nil
+}
+
+func (h *ATHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	return nil
+}
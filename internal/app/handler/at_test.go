@@ -0,0 +1,41 @@
+package handler
+
+import "testing"
+
+func TestATHandlerMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		rules   []atRule
+		want    string
+	}{
+		{name: "harmless", command: "AT+CSQ", rules: atDangerousCommands},
+		{name: "dangerous", command: "AT+CFUN=1,1", rules: atDangerousCommands, want: "AT+CFUN"},
+		{name: "lower case with spaces", command: "at + cfun = 1,1", rules: atDangerousCommands, want: "AT+CFUN"},
+		{name: "chained extended command", command: "AT+CSQ;+CFUN=1,1", rules: atDangerousCommands, want: "AT+CFUN"},
+		{name: "chained basic command", command: "AT+CSQ;&F", rules: atDangerousCommands, want: "AT&F"},
+		{name: "chained with AT prefix", command: "AT+CSQ;AT+CFUN=0", rules: atDangerousCommands, want: "AT+CFUN"},
+		{name: "concatenated basic commands", command: "ATE0&F", rules: atDangerousCommands, want: "AT&F"},
+		{name: "basic command followed by extended", command: "ATE1+COPS=1,2,\"22210\"", rules: atDangerousCommands, want: "AT+COPS="},
+		{name: "chained band lock", command: "AT+CSQ;+QCFG=\"band\",0,1", rules: atDangerousCommands, want: `AT+QCFG="BAND"`},
+		{name: "separator in quotes", command: `AT+CPBF="a;+CFUN"`, rules: atDangerousCommands},
+		{name: "chained harmless commands", command: "AT+CSQ;+CREG?;E0", rules: atDangerousCommands},
+		{name: "read command of a dangerous one", command: "AT+COPS?", rules: atDangerousCommands},
+		{name: "denied", command: `AT+QCFG="usbnet",1`, rules: atDeniedCommands, want: `AT+QCFG="USBNET"`},
+		{name: "chained denied", command: `AT+CSQ;+QCFG="usbnet",1`, rules: atDeniedCommands, want: `AT+QCFG="USBNET"`},
+		{name: "chained caret command", command: "AT+CSQ;^SETPORT=\"A1\"", rules: atDeniedCommands, want: "AT^SETPORT"},
+	}
+	h := new(ATHandler)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := h.match(tt.rules, tt.command)
+			got := ""
+			if rule != nil {
+				got = rule.prefix
+			}
+			if got != tt.want {
+				t.Errorf("match(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
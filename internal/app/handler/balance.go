@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/ussd"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+)
+
+type BalanceHandler struct {
+	*Handler
+	mm        *modem.Manager
+	shortcuts *ussd.Shortcuts
+	balances  *ussd.Balances
+}
+
+type balanceResult struct {
+	modem   *modem.Modem
+	balance *ussd.Balance
+	err     error
+}
+
+func NewBalanceHandler(mm *modem.Manager, shortcuts *ussd.Shortcuts, balances *ussd.Balances) *BalanceHandler {
+	h := new(BalanceHandler)
+	h.mm = mm
+	h.shortcuts = shortcuts
+	h.balances = balances
+	return h
+}
+
+func (h *BalanceHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		modems, err := h.mm.Modems()
+		if err != nil {
+			return err
+		}
+		if len(modems) == 0 {
+			_, err := h.Reply(ctx, update, util.EscapeText("No modems were found."), nil)
+			return err
+		}
+		results := make([]*balanceResult, 0, len(modems))
+		for _, m := range modems {
+			results = append(results, &balanceResult{modem: m})
+		}
+		slices.SortFunc(results, func(a, b *balanceResult) int {
+			return strings.Compare(a.modem.EquipmentIdentifier, b.modem.EquipmentIdentifier)
+		})
+		// Every modem runs its own USSD session, so they can be asked at the same time.
+		var wg sync.WaitGroup
+		for _, result := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result.balance, result.err = ussd.CheckBalance(result.modem, h.shortcuts, h.balances)
+				if result.err != nil {
+					slog.Warn("Failed to check balance", "modem", result.modem.EquipmentIdentifier, "error", result.err)
+				}
+			}()
+		}
+		wg.Wait()
+		_, err = h.Reply(ctx, update, h.message(results), nil)
+		return err
+	}
+}
+
+func (h *BalanceHandler) message(results []*balanceResult) string {
+	var table, failures strings.Builder
+	fmt.Fprintf(&table, "%-14s %-12s %10s  %s\n", "SIM", "Operator", "Balance", "Expiry")
+	for _, result := range results {
+		name := h.name(result.modem)
+		operator := util.If(result.modem.Sim.OperatorName != "", result.modem.Sim.OperatorName, util.LookupCarrier(result.modem.Sim.OperatorIdentifier))
+		if r := []rune(operator); len(r) > 12 {
+			operator = string(r[:12])
+		}
+		if result.err != nil {
+			fmt.Fprintf(&table, "%-14s %-12s %10s  %s\n", name, operator, "-", "-")
+			fmt.Fprintf(&failures, "%s: %s\n", name, result.err)
+			continue
+		}
+		fmt.Fprintf(&table, "%-14s %-12s %10.2f  %s\n", name, operator, result.balance.Amount, util.If(result.balance.Expiry != "", result.balance.Expiry, "-"))
+	}
+	message := "```\n" + strings.TrimRight(table.String(), "\n") + "\n```"
+	if failures.Len() > 0 {
+		message += "\n" + util.EscapeText(strings.TrimRight(failures.String(), "\n"))
+	}
+	return message
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type BandsHandler struct {
+	*Handler
+}
+
+// BandsValue holds the bands being toggled, they are only applied once the admin confirms.
+type BandsValue struct {
+	Modem     *modem.Modem
+	Supported []modem.ModemBand
+	Selected  []modem.ModemBand
+}
+
+const (
+	CallbackQueryBandsPrefix = "bands"
+	CallbackQueryBandsApply  = "apply"
+	// CallbackQueryBandsAny allows every supported band again.
+	CallbackQueryBandsAny = "any"
+
+	// BandsPerRow keeps the toggles compact, modems often support dozens of bands.
+	BandsPerRow = 4
+)
+
+func NewBandsHandler() state.Handler {
+	h := new(BandsHandler)
+	return h
+}
+
+func (h *BandsHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		m := h.Modem(ctx)
+		supported, err := m.SupportedBands()
+		if err != nil {
+			return err
+		}
+		supported = slices.DeleteFunc(supported, func(b modem.ModemBand) bool {
+			return b == modem.ModemBandAny || b == modem.ModemBandUnknown
+		})
+		if len(supported) < 2 {
+			_, err = h.Reply(ctx, update, util.EscapeText("The modem doesn't support changing its bands."), nil)
+			return err
+		}
+		slices.Sort(supported)
+		value := &BandsValue{Modem: m, Supported: supported}
+		if err := h.reset(value); err != nil {
+			return err
+		}
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			Value:   value,
+		})
+		_, err = h.Reply(ctx, update, h.message(value), func(message *telego.SendMessageParams) error {
+			message.WithReplyMarkup(h.keyboard(value))
+			return nil
+		})
+		return err
+	}
+}
+
+// reset selects the bands the modem is currently set to, ModemBandAny stands for all of them.
+func (h *BandsHandler) reset(value *BandsValue) error {
+	current, err := value.Modem.CurrentBands()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(current, modem.ModemBandAny) {
+		current = value.Supported
+	}
+	value.Selected = slices.DeleteFunc(slices.Clone(current), func(b modem.ModemBand) bool {
+		return !slices.Contains(value.Supported, b)
+	})
+	return nil
+}
+
+func (h *BandsHandler) names(bands []modem.ModemBand) []string {
+	names := make([]string, 0, len(bands))
+	for _, band := range bands {
+		names = append(names, band.String())
+	}
+	return names
+}
+
+func (h *BandsHandler) message(value *BandsValue) string {
+	text := fmt.Sprintf("The modem is allowed to use %d of %d bands: %s\nToggle the bands and press Apply.",
+		len(value.Selected), len(value.Supported), strings.Join(h.names(value.Selected), ", "))
+	return util.EscapeText(text)
+}
+
+func (h *BandsHandler) keyboard(value *BandsValue) *telego.InlineKeyboardMarkup {
+	var buttons [][]telego.InlineKeyboardButton
+	var row []telego.InlineKeyboardButton
+	for _, band := range value.Supported {
+		row = append(row, telego.InlineKeyboardButton{
+			Text:         util.If(slices.Contains(value.Selected, band), "✅ ", "") + band.String(),
+			CallbackData: fmt.Sprintf("%s:%d", CallbackQueryBandsPrefix, band),
+		})
+		if len(row) == BandsPerRow {
+			buttons = append(buttons, tu.InlineKeyboardRow(row...))
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		buttons = append(buttons, tu.InlineKeyboardRow(row...))
+	}
+	buttons = append(buttons, tu.InlineKeyboardRow(
+		telego.InlineKeyboardButton{Text: "Apply", CallbackData: fmt.Sprintf("%s:%s", CallbackQueryBandsPrefix, CallbackQueryBandsApply)},
+		telego.InlineKeyboardButton{Text: "Allow all", CallbackData: fmt.Sprintf("%s:%s", CallbackQueryBandsPrefix, CallbackQueryBandsAny)},
+	), tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+		Text:         "💾 Save as default",
+		CallbackData: fmt.Sprintf("%s:%s", CallbackQueryBandsPrefix, CallbackQueryRadioSave),
+	}))
+	return tu.InlineKeyboard(buttons...)
+}
+
+func (h *BandsHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*BandsValue)
+	data := query.Data[len(CallbackQueryBandsPrefix)+1:]
+	switch data {
+	case CallbackQueryBandsApply, CallbackQueryBandsAny:
+		bands := util.If(data == CallbackQueryBandsAny, []modem.ModemBand{modem.ModemBandAny}, value.Selected)
+		if len(bands) == 0 {
+			_, err := h.ReplyCallbackQuery(ctx, query, util.EscapeText("Select at least one band."), nil)
+			return err
+		}
+		if err := value.Modem.SetCurrentBands(bands); err != nil {
+			_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Failed to set the bands: %s", err)), nil)
+			return err
+		}
+		slog.Info("Bands changed", "modem", value.Modem.EquipmentIdentifier, "bands", h.names(bands))
+		if err := h.reset(value); err != nil {
+			return err
+		}
+	case CallbackQueryRadioSave:
+		current, err := value.Modem.CurrentBands()
+		if err != nil {
+			return err
+		}
+		text, err := saveRadio(value.Modem, func(radio *config.Radio) {
+			radio.Bands = h.names(current)
+		})
+		if err != nil {
+			return err
+		}
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(text), nil)
+		return err
+	default:
+		band, err := strconv.ParseUint(data, 10, 32)
+		if err != nil || !slices.Contains(value.Supported, modem.ModemBand(band)) {
+			return errors.New("invalid band")
+		}
+		if idx := slices.Index(value.Selected, modem.ModemBand(band)); idx >= 0 {
+			value.Selected = slices.Delete(value.Selected, idx, idx+1)
+		} else {
+			value.Selected = append(value.Selected, modem.ModemBand(band))
+			slices.Sort(value.Selected)
+		}
+	}
+	return h.editCallbackQuery(ctx, query, h.message(value), h.keyboard(value))
+}
+
+func (h *BandsHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	return nil
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/app/middleware"
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/bulk"
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type BulkHandler struct {
+	*Handler
+	mm *modem.Manager
+}
+
+type BulkValue struct {
+	Sheet    *bulk.Sheet
+	Messages []*bulk.Message
+}
+
+const (
+	BulkActionAskFile     state.State = "bulk_ask_file"
+	BulkActionAskTemplate state.State = "bulk_ask_template"
+
+	CallbackQueryBulkPrefix     = "bulk"
+	CallbackQueryBulkRoundRobin = "all"
+	CallbackQueryBulkCancel     = "cancel"
+	CallbackQueryBulkStopPrefix = "bulk_stop"
+	BulkMaxFileSize             = 1 << 20
+	BulkPreviewMessages         = 3
+	BulkProgressInterval        = 3 * time.Second
+)
+
+type bulkJobKey struct {
+	chatID    int64
+	messageID int
+}
+
+// bulkJobs cancel the jobs being sent by their progress message, the chat may have moved on to other commands.
+var bulkJobs sync.Map
+
+func NewBulkHandler(mm *modem.Manager) state.Handler {
+	h := new(BulkHandler)
+	h.mm = mm
+	return h
+}
+
+// NewBulkStopHandler stops a job from the Stop button of its progress message.
+func NewBulkStopHandler() th.CallbackQueryHandler {
+	return func(ctx *th.Context, query telego.CallbackQuery) error {
+		if err := ctx.Bot().AnswerCallbackQuery(ctx, &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+		}); err != nil {
+			return err
+		}
+		if !slices.Contains(config.C.AdminId.MarshalInt64(), query.From.ID) {
+			return middleware.ErrPermissionDenied
+		}
+		if cancel, ok := bulkJobs.Load(bulkJobKey{chatID: query.Message.GetChat().ID, messageID: query.Message.GetMessageID()}); ok {
+			cancel.(context.CancelFunc)()
+		}
+		return nil
+	}
+}
+
+func (h *BulkHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			State:   BulkActionAskFile,
+			Value:   &BulkValue{},
+		})
+		text := fmt.Sprintf("Send me a CSV file with a number column and a text column, or the columns to fill a template with, e.g. number,name. It may have up to %d rows.", bulk.MaxMessages)
+		_, err := h.Reply(ctx, update, util.EscapeText(text), nil)
+		return err
+	}
+}
+
+func (h *BulkHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	value := s.Value.(*BulkValue)
+	switch s.State {
+	case BulkActionAskFile:
+		if message.Document == nil {
+			_, err := h.ReplyMessage(ctx, message, util.EscapeText("Send the CSV file as a document."), nil)
+			return err
+		}
+		if message.Document.FileSize > BulkMaxFileSize {
+			_, err := h.ReplyMessage(ctx, message, util.EscapeText("The file is too large, it may be up to 1 MiB."), nil)
+			return err
+		}
+		data, err := h.download(ctx, message.Document.FileID)
+		if err != nil {
+			return err
+		}
+		if value.Sheet, err = bulk.Parse(data); err != nil {
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("The file can't be read: %s.", err)), nil)
+			return err
+		}
+		if !value.Sheet.HasText() {
+			state.M.Current(message.Chat.ID, BulkActionAskTemplate)
+			text := fmt.Sprintf("The file has %d rows and no text column. Send me the text, every {column} in it is replaced with the column of the row. The columns are: %s.",
+				value.Sheet.Len(), strings.Join(value.Sheet.Columns, ", "))
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText(text), nil)
+			return err
+		}
+		value.Messages, err = value.Sheet.Messages("")
+		if err != nil {
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("The file can't be sent: %s.", err)), nil)
+			return err
+		}
+	case BulkActionAskTemplate:
+		var err error
+		if value.Messages, err = value.Sheet.Messages(message.Text); err != nil {
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("The messages can't be made: %s. Send me another text.", err)), nil)
+			return err
+		}
+	default:
+		return nil
+	}
+	state.M.Current(message.Chat.ID, "")
+	keyboard, err := h.keyboard()
+	if err != nil {
+		return err
+	}
+	_, err = h.ReplyMessage(ctx, message, h.preview(value.Messages), func(params *telego.SendMessageParams) error {
+		params.WithReplyMarkup(keyboard)
+		return nil
+	})
+	return err
+}
+
+// preview shows the first messages and how many SMS they take altogether.
+func (h *BulkHandler) preview(messages []*bulk.Message) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d messages, %d SMS in total.\n", len(messages), bulk.Segments(messages))
+	for _, message := range messages[:min(len(messages), BulkPreviewMessages)] {
+		fmt.Fprintf(&text, "\nTo %s:\n%s\n", message.Number, message.Text)
+	}
+	if len(messages) > BulkPreviewMessages {
+		fmt.Fprintf(&text, "\nand %d more.\n", len(messages)-BulkPreviewMessages)
+	}
+	text.WriteString("\nChoose the modem to send them from, or let the modems take turns.")
+	return util.EscapeText(text.String())
+}
+
+func (h *BulkHandler) keyboard() (*telego.InlineKeyboardMarkup, error) {
+	modems, err := sortedModems(h.mm)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]telego.InlineKeyboardButton
+	for _, m := range modems {
+		rows = append(rows, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+			Text:         fmt.Sprintf("%s (%s)", h.name(m), m.Model),
+			CallbackData: fmt.Sprintf("%s:%s", CallbackQueryBulkPrefix, m.EquipmentIdentifier),
+		}))
+	}
+	last := tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+		Text:         "Cancel",
+		CallbackData: fmt.Sprintf("%s:%s", CallbackQueryBulkPrefix, CallbackQueryBulkCancel),
+	})
+	if len(modems) > 1 {
+		last = slices.Insert(last, 0, telego.InlineKeyboardButton{
+			Text:         "🔁 Round-robin",
+			CallbackData: fmt.Sprintf("%s:%s", CallbackQueryBulkPrefix, CallbackQueryBulkRoundRobin),
+		})
+	}
+	return tu.InlineKeyboard(append(rows, last)...), nil
+}
+
+func (h *BulkHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*BulkValue)
+	if value.Messages == nil {
+		return nil
+	}
+	chatID := query.Message.GetChat().ID
+	state.M.Exit(chatID)
+	data := query.Data[len(CallbackQueryBulkPrefix)+1:]
+	if data == CallbackQueryBulkCancel {
+		return h.editCallbackQuery(ctx, query, util.EscapeText("Cancelled."), nil)
+	}
+	modems, err := sortedModems(h.mm)
+	if err != nil {
+		return err
+	}
+	if data != CallbackQueryBulkRoundRobin {
+		modems = slices.DeleteFunc(modems, func(m *modem.Modem) bool { return m.EquipmentIdentifier != data })
+	}
+	if len(modems) == 0 {
+		return h.editCallbackQuery(ctx, query, util.EscapeText("The modem is no longer available."), nil)
+	}
+	job := &bulk.Job{Messages: value.Messages, Modems: modems, Interval: config.C.BulkInterval}
+	key := bulkJobKey{chatID: chatID, messageID: query.Message.GetMessageID()}
+	jobCtx, cancel := context.WithCancel(context.Background())
+	bulkJobs.Store(key, cancel)
+	slog.Info("Bulk SMS started", "messages", len(job.Messages), "modems", len(job.Modems))
+	go func() {
+		defer bulkJobs.Delete(key)
+		defer cancel()
+		h.run(jobCtx, ctx.Bot(), key, job)
+	}()
+	return h.edit(ctx.Bot(), key, h.progress(job, nil, true), true)
+}
+
+// run sends the job, edits the progress message every few seconds and sends the results as a file once it is done.
+func (h *BulkHandler) run(ctx context.Context, bot *telego.Bot, key bulkJobKey, job *bulk.Job) {
+	edited := time.Now()
+	results := job.Run(ctx, func(results []*bulk.Result) {
+		if time.Since(edited) < BulkProgressInterval {
+			return
+		}
+		edited = time.Now()
+		if err := h.edit(bot, key, h.progress(job, results, true), true); err != nil {
+			slog.Warn("Failed to update the bulk SMS progress", "error", err)
+		}
+	})
+	sent, failed := bulk.Count(results)
+	slog.Info("Bulk SMS finished", "sent", sent, "failed", failed, "cancelled", len(results)-sent-failed)
+	if err := h.edit(bot, key, h.progress(job, results, false), false); err != nil {
+		slog.Warn("Failed to update the bulk SMS progress", "error", err)
+	}
+	var file bytes.Buffer
+	if err := bulk.WriteResults(&file, results); err != nil {
+		slog.Error("Failed to write the bulk SMS results", "error", err)
+		return
+	}
+	name := fmt.Sprintf("bulk-%s.csv", time.Now().Format("20060102150405"))
+	if _, err := bot.SendDocument(context.Background(), tu.Document(tu.ID(key.chatID), tu.FileFromBytes(file.Bytes(), name)).
+		WithReplyParameters(&telego.ReplyParameters{MessageID: key.messageID})); err != nil {
+		slog.Error("Failed to send the bulk SMS results", "error", err)
+	}
+}
+
+func (h *BulkHandler) progress(job *bulk.Job, results []*bulk.Result, live bool) string {
+	var text strings.Builder
+	if len(job.Modems) == 1 {
+		fmt.Fprintf(&text, "Sending %d messages from %s, one every %s.\n", len(job.Messages), h.name(job.Modems[0]), job.Interval)
+	} else {
+		fmt.Fprintf(&text, "Sending %d messages from %d modems in turn, one every %s.\n", len(job.Messages), len(job.Modems), job.Interval)
+	}
+	sent, failed := bulk.Count(results)
+	fmt.Fprintf(&text, "%d sent, %d failed", sent, failed)
+	switch {
+	case live:
+		fmt.Fprintf(&text, ", %d to go.", len(job.Messages)-sent-failed)
+	case sent+failed < len(job.Messages):
+		fmt.Fprintf(&text, ", %d cancelled.", len(job.Messages)-sent-failed)
+	default:
+		text.WriteString(", done.")
+	}
+	return util.EscapeText(text.String())
+}
+
+func (h *BulkHandler) edit(bot *telego.Bot, key bulkJobKey, text string, live bool) error {
+	params := &telego.EditMessageTextParams{
+		ChatID:    tu.ID(key.chatID),
+		MessageID: key.messageID,
+		Text:      text,
+		ParseMode: telego.ModeMarkdownV2,
+	}
+	if live {
+		params.ReplyMarkup = tu.InlineKeyboard(tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+			Text:         "Stop",
+			CallbackData: CallbackQueryBulkStopPrefix + ":" + strconv.Itoa(key.messageID),
+		}))
+	}
+	_, err := bot.EditMessageText(context.Background(), params)
+	return err
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type CallHandler struct {
+	*Handler
+}
+
+type CallValue struct {
+	Modem   *modem.Modem
+	Number  string
+	Path    dbus.ObjectPath
+	Message *telego.Message
+	mutex   sync.Mutex
+	state   modem.CallState
+	reason  modem.CallStateReason
+	// tones are the DTMF tones sent so far, shown to keep track of the IVR menu.
+	tones    string
+	answered time.Time
+}
+
+const (
+	CallActionAskNumber state.State = "call_ask_number"
+
+	CallbackQueryCallPrefix = "call"
+	CallbackQueryCallHangup = "hangup"
+
+	// CallMaxDuration hangs up calls that were forgotten.
+	CallMaxDuration = 30 * time.Minute
+)
+
+var dtmfPattern = regexp.MustCompile(`^[0-9*#A-Da-d]+$`)
+
+func NewCallHandler() state.Handler {
+	h := new(CallHandler)
+	return h
+}
+
+func (h *CallHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		value := &CallValue{Modem: h.Modem(ctx)}
+		_, number, _ := strings.Cut(update.Message.Text, " ")
+		if number = strings.TrimSpace(number); number == "" {
+			state.M.Enter(update.Message.Chat.ID, &state.ChatState{Handler: h, State: CallActionAskNumber, Value: value})
+			_, err := h.Reply(ctx, update, util.EscapeText("Enter the phone number you want to call."), nil)
+			return err
+		}
+		return h.call(ctx, *update.Message, value, number)
+	}
+}
+
+func (h *CallHandler) call(ctx *th.Context, message telego.Message, value *CallValue, number string) error {
+	var err error
+	value.Number = number
+	if value.Path, err = value.Modem.CreateCall(number); err != nil {
+		state.M.Exit(message.Chat.ID)
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("Failed to call %s: %s", number, err)), nil)
+		return err
+	}
+	state.M.Enter(message.Chat.ID, &state.ChatState{Handler: h, Value: value})
+	if value.Message, err = h.ReplyMessage(ctx, message, h.message(value), func(params *telego.SendMessageParams) error {
+		params.WithReplyMarkup(h.keyboard())
+		return nil
+	}); err != nil {
+		return err
+	}
+	slog.Info("Calling", "modem", value.Modem.EquipmentIdentifier, "number", number, "path", value.Path)
+	go h.watch(ctx.Bot(), message.Chat.ID, value)
+	return nil
+}
+
+// watch dials the call and keeps the message up to date with its state until it is terminated.
+func (h *CallHandler) watch(bot *telego.Bot, chatID int64, value *CallValue) {
+	ctx, cancel := context.WithTimeout(context.Background(), CallMaxDuration)
+	defer cancel()
+	var started bool
+	err := value.Modem.WatchCall(ctx, value.Path, func(call *modem.Call) error {
+		// The call is only dialed once it is watched, so none of its state changes is missed.
+		if !started {
+			started = true
+			if err := value.Modem.StartCall(value.Path); err != nil {
+				slog.Error("Failed to start call", "error", err, "path", value.Path)
+				value.update(modem.CallStateTerminated, modem.CallStateReasonError)
+				return h.edit(bot, value)
+			}
+		}
+		value.update(call.State, call.StateReason)
+		return h.edit(bot, value)
+	})
+	if err != nil {
+		slog.Error("Failed to watch call", "error", err, "path", value.Path)
+	}
+	if value.current() != modem.CallStateTerminated {
+		if err := value.Modem.HangupCall(value.Path); err != nil {
+			slog.Warn("Failed to hang up call", "error", err, "path", value.Path)
+		}
+		value.update(modem.CallStateTerminated, modem.CallStateReasonTerminated)
+		if err := h.edit(bot, value); err != nil {
+			slog.Warn("Failed to update the call message", "error", err)
+		}
+	}
+	if err := value.Modem.DeleteCall(value.Path); err != nil {
+		slog.Warn("Failed to delete call", "error", err, "path", value.Path)
+	}
+	state.M.ExitIf(chatID, value)
+}
+
+func (v *CallValue) update(state modem.CallState, reason modem.CallStateReason) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if state == modem.CallStateActive && v.answered.IsZero() {
+		v.answered = time.Now()
+	}
+	v.state, v.reason = state, reason
+}
+
+func (v *CallValue) current() modem.CallState {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.state
+}
+
+func (h *CallHandler) message(value *CallValue) string {
+	value.mutex.Lock()
+	defer value.mutex.Unlock()
+	var text strings.Builder
+	fmt.Fprintf(&text, "📞 %s\n", value.Number)
+	switch value.state {
+	case modem.CallStateUnknown, modem.CallStateDialing:
+		text.WriteString("Dialing...")
+	case modem.CallStateRingingOut:
+		text.WriteString("Ringing...")
+	case modem.CallStateActive:
+		fmt.Fprintf(&text, "Connected since %s, press the keys to send tones or hang up.", value.answered.Format(time.TimeOnly))
+	case modem.CallStateTerminated:
+		if value.answered.IsZero() {
+			fmt.Fprintf(&text, "The call ended without being answered: %s.", strings.ToLower(value.reason.String()))
+		} else {
+			fmt.Fprintf(&text, "The call ended after %s.", time.Since(value.answered).Round(time.Second))
+		}
+	default:
+		text.WriteString(value.state.String())
+	}
+	if value.tones != "" {
+		fmt.Fprintf(&text, "\nTones sent: %s", value.tones)
+	}
+	return util.EscapeText(text.String())
+}
+
+func (h *CallHandler) keyboard() *telego.InlineKeyboardMarkup {
+	var buttons [][]telego.InlineKeyboardButton
+	for _, row := range []string{"123", "456", "789", "*0#"} {
+		var keys []telego.InlineKeyboardButton
+		for _, key := range row {
+			keys = append(keys, telego.InlineKeyboardButton{
+				Text:         string(key),
+				CallbackData: fmt.Sprintf("%s:%c", CallbackQueryCallPrefix, key),
+			})
+		}
+		buttons = append(buttons, tu.InlineKeyboardRow(keys...))
+	}
+	buttons = append(buttons, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+		Text:         "🔴 Hang up",
+		CallbackData: fmt.Sprintf("%s:%s", CallbackQueryCallPrefix, CallbackQueryCallHangup),
+	}))
+	return tu.InlineKeyboard(buttons...)
+}
+
+func (h *CallHandler) edit(bot *telego.Bot, value *CallValue) error {
+	params := &telego.EditMessageTextParams{
+		ChatID:    value.Message.Chat.ChatID(),
+		MessageID: value.Message.MessageID,
+		Text:      h.message(value),
+		ParseMode: telego.ModeMarkdownV2,
+	}
+	if value.current() != modem.CallStateTerminated {
+		params.ReplyMarkup = h.keyboard()
+	}
+	_, err := bot.EditMessageText(context.Background(), params)
+	return err
+}
+
+// dtmf sends the tones, which only works once the call has been answered.
+func (h *CallHandler) dtmf(bot *telego.Bot, value *CallValue, tones string) error {
+	if value.current() != modem.CallStateActive {
+		return nil
+	}
+	if err := value.Modem.SendDtmf(value.Path, tones); err != nil {
+		return err
+	}
+	value.mutex.Lock()
+	value.tones += tones
+	value.mutex.Unlock()
+	return h.edit(bot, value)
+}
+
+func (h *CallHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*CallValue)
+	data := query.Data[len(CallbackQueryCallPrefix)+1:]
+	if data == CallbackQueryCallHangup {
+		return value.Modem.HangupCall(value.Path)
+	}
+	if !dtmfPattern.MatchString(data) {
+		return nil
+	}
+	return h.dtmf(ctx.Bot(), value, data)
+}
+
+// HandleMessage takes the number to call, and then sequences of tones typed in the chat.
+func (h *CallHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	value := s.Value.(*CallValue)
+	if s.State == CallActionAskNumber {
+		return h.call(ctx, message, value, strings.TrimSpace(message.Text))
+	}
+	tones := strings.ReplaceAll(message.Text, " ", "")
+	if !dtmfPattern.MatchString(tones) {
+		_, err := h.ReplyMessage(ctx, message, util.EscapeText("Only 0-9, *, # and A-D can be sent as tones."), nil)
+		return err
+	}
+	return h.dtmf(ctx.Bot(), value, strings.ToUpper(tones))
+}
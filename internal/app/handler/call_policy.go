@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type CallPolicyHandler struct {
+	*Handler
+}
+
+type CallPolicyValue struct {
+	Modem *modem.Modem
+}
+
+const (
+	CallbackQueryCallPolicyPrefix = "call_policy"
+	// CallPolicyNotify stands for config.CallPolicyNone in the callback data, which can't be empty.
+	CallPolicyNotify = "notify"
+)
+
+var callPolicies = []struct {
+	Policy config.CallPolicy
+	Name   string
+}{
+	{config.CallPolicyNone, "Notify only"},
+	{config.CallPolicyReject, "Reject"},
+	{config.CallPolicyHangup, "Answer and hang up"},
+}
+
+func NewCallPolicyHandler() state.Handler {
+	h := new(CallPolicyHandler)
+	return h
+}
+
+func (h *CallPolicyHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		value := &CallPolicyValue{Modem: h.Modem(ctx)}
+		text, keyboard, err := h.message(value.Modem)
+		if err != nil {
+			return err
+		}
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			Value:   value,
+		})
+		_, err = h.Reply(ctx, update, text, func(message *telego.SendMessageParams) error {
+			message.WithReplyMarkup(keyboard)
+			return nil
+		})
+		return err
+	}
+}
+
+func (h *CallPolicyHandler) message(m *modem.Modem) (string, *telego.InlineKeyboardMarkup, error) {
+	current, err := config.C.CallPolicy(m.EquipmentIdentifier)
+	if err != nil {
+		return "", nil, err
+	}
+	var buttons [][]telego.InlineKeyboardButton
+	for _, p := range callPolicies {
+		buttons = append(buttons, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+			Text:         util.If(p.Policy == current, "✅ ", "") + p.Name,
+			CallbackData: fmt.Sprintf("%s:%s", CallbackQueryCallPolicyPrefix, util.If(p.Policy == config.CallPolicyNone, CallPolicyNotify, string(p.Policy))),
+		}))
+	}
+	text := "You are notified of every incoming call once it has ended. What else should happen to incoming calls?"
+	return util.EscapeText(text), tu.InlineKeyboard(buttons...), nil
+}
+
+func (h *CallPolicyHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*CallPolicyValue)
+	policy := config.CallPolicy(query.Data[len(CallbackQueryCallPolicyPrefix)+1:])
+	if policy == CallPolicyNotify {
+		policy = config.CallPolicyNone
+	}
+	if err := config.C.SaveCallPolicy(value.Modem.EquipmentIdentifier, policy); err != nil {
+		return err
+	}
+	slog.Info("Call policy changed", "modem", value.Modem.EquipmentIdentifier, "policy", policy)
+	text, keyboard, err := h.message(value.Modem)
+	if err != nil {
+		return err
+	}
+	return h.editCallbackQuery(ctx, query, text, keyboard)
+}
+
+func (h *CallPolicyHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	return nil
+}
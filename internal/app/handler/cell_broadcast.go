@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type CellBroadcastHandler struct {
+	*Handler
+}
+
+const (
+	CallbackQueryCellBroadcastPrefix    = "cell_broadcast"
+	CallbackQueryCellBroadcastEmergency = "emergency"
+	CallbackQueryCellBroadcastOff       = "off"
+)
+
+func NewCellBroadcastHandler() state.Handler {
+	h := new(CellBroadcastHandler)
+	return h
+}
+
+func (h *CellBroadcastHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		m := h.Modem(ctx)
+		channels, err := m.CellBroadcastChannels()
+		if err != nil {
+			slog.Warn("Failed to get the cell broadcast channels", "modem", m.EquipmentIdentifier, "error", err)
+			_, err = h.Reply(ctx, update, util.EscapeText("The modem doesn't support cell broadcasts."), nil)
+			return err
+		}
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{Handler: h, Value: m})
+		text := fmt.Sprintf("The cell broadcasts received on these channels are forwarded: %s\nSend me the channels to forward instead, e.g. 4370-4399, 50.",
+			util.If(len(channels) > 0, modem.FormatCellBroadcastChannels(channels), "none"))
+		_, err = h.Reply(ctx, update, util.EscapeText(text), func(message *telego.SendMessageParams) error {
+			message.WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(
+				telego.InlineKeyboardButton{
+					Text:         "🚨 Emergency alerts",
+					CallbackData: fmt.Sprintf("%s:%s", CallbackQueryCellBroadcastPrefix, CallbackQueryCellBroadcastEmergency),
+				},
+				telego.InlineKeyboardButton{
+					Text:         "Turn off",
+					CallbackData: fmt.Sprintf("%s:%s", CallbackQueryCellBroadcastPrefix, CallbackQueryCellBroadcastOff),
+				},
+			)))
+			return nil
+		})
+		return err
+	}
+}
+
+// apply sets the channels and saves them, so they are set again whenever the modem is plugged in.
+func (h *CellBroadcastHandler) apply(m *modem.Modem, channels []modem.CellBroadcastChannels) (string, error) {
+	if err := m.SetCellBroadcastChannels(channels); err != nil {
+		return fmt.Sprintf("Failed to set the channels: %s", err), nil
+	}
+	formatted := modem.FormatCellBroadcastChannels(channels)
+	slog.Info("Cell broadcast channels changed", "modem", m.EquipmentIdentifier, "channels", formatted)
+	if err := config.C.SaveCellBroadcastChannels(m.EquipmentIdentifier, formatted); err != nil {
+		return "", err
+	}
+	if len(channels) == 0 {
+		return "Cell broadcasts are turned off.", nil
+	}
+	return fmt.Sprintf("Cell broadcasts on channels %s will be forwarded.", formatted), nil
+}
+
+func (h *CellBroadcastHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	state.M.Exit(query.Message.GetChat().ID)
+	channels := modem.EmergencyCellBroadcastChannels
+	if query.Data[len(CallbackQueryCellBroadcastPrefix)+1:] == CallbackQueryCellBroadcastOff {
+		channels = nil
+	}
+	text, err := h.apply(s.Value.(*modem.Modem), channels)
+	if err != nil {
+		return err
+	}
+	_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(text), nil)
+	return err
+}
+
+func (h *CellBroadcastHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	channels, err := modem.ParseCellBroadcastChannels(message.Text)
+	if err != nil || len(channels) == 0 {
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText("Send the channels separated by commas, e.g. 4370-4399, 50."), nil)
+		return err
+	}
+	state.M.Exit(message.Chat.ID)
+	text, err := h.apply(s.Value.(*modem.Modem), channels)
+	if err != nil {
+		return err
+	}
+	_, err = h.ReplyMessage(ctx, message, util.EscapeText(text), nil)
+	return err
+}
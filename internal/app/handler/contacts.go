@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/contact"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type ContactsHandler struct {
+	*Handler
+	mm   *modem.Manager
+	book *contact.Book
+}
+
+type ContactsValue struct {
+	// Editing is the number of the contact being edited, empty while one is added.
+	Editing string
+}
+
+const (
+	ContactsActionSearch     state.State = "contacts_search"
+	ContactsActionAskContact state.State = "contacts_ask_contact"
+
+	CallbackQueryContactsPrefix    = "contacts"
+	CallbackQueryContactsAdd       = "add"
+	CallbackQueryContactsSIM       = "sim"
+	CallbackQueryContactsSaveSIM   = "save_sim"
+	CallbackQueryContactsShow      = "show"
+	CallbackQueryContactsEdit      = "edit"
+	CallbackQueryContactsFavourite = "favourite"
+	CallbackQueryContactsDelete    = "delete"
+
+	// ContactsListLimit is the most contacts listed in a message, the others are found by searching.
+	ContactsListLimit   = 50
+	ContactsMaxMatches  = 10
+	ContactsMaxFileSize = 1 << 20
+)
+
+// contactPattern splits "Anna Smith +1 415 555 0100" into the name and the number.
+var contactPattern = regexp.MustCompile(`^(.+?)[\s,;:]+(\+?[0-9][0-9 ().-]{2,})$`)
+
+func NewContactsHandler(mm *modem.Manager, book *contact.Book) state.Handler {
+	h := new(ContactsHandler)
+	h.mm = mm
+	h.book = book
+	return h
+}
+
+func (h *ContactsHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		contacts, err := h.book.List()
+		if err != nil {
+			return err
+		}
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			State:   ContactsActionSearch,
+			Value:   &ContactsValue{},
+		})
+		var text strings.Builder
+		if len(contacts) == 0 {
+			text.WriteString("There are no contacts yet.\n")
+		} else {
+			fmt.Fprintf(&text, "%d contacts:\n", len(contacts))
+		}
+		for _, c := range contacts[:min(len(contacts), ContactsListLimit)] {
+			fmt.Fprintf(&text, "%s%s\n", util.If(c.Favourite, "⭐ ", ""), c)
+		}
+		if len(contacts) > ContactsListLimit {
+			fmt.Fprintf(&text, "and %d more.\n", len(contacts)-ContactsListLimit)
+		}
+		text.WriteString("\nSend me a name or a number to find a contact, or a vCard or CSV file to import.")
+		_, err = h.Reply(ctx, update, util.EscapeText(text.String()), func(message *telego.SendMessageParams) error {
+			message.WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(
+				h.button("➕ Add", CallbackQueryContactsAdd, ""),
+				h.button("📥 Import from SIM", CallbackQueryContactsSIM, ""),
+			)))
+			return nil
+		})
+		return err
+	}
+}
+
+func (h *ContactsHandler) button(text string, action string, argument string) telego.InlineKeyboardButton {
+	data := fmt.Sprintf("%s:%s", CallbackQueryContactsPrefix, action)
+	if argument != "" {
+		data += ":" + argument
+	}
+	return telego.InlineKeyboardButton{Text: text, CallbackData: data}
+}
+
+func (h *ContactsHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	value := s.Value.(*ContactsValue)
+	switch s.State {
+	case ContactsActionSearch:
+		if message.Document != nil {
+			return h.importFile(ctx, message)
+		}
+		return h.search(ctx, message)
+	case ContactsActionAskContact:
+		match := contactPattern.FindStringSubmatch(strings.TrimSpace(message.Text))
+		if match == nil {
+			_, err := h.ReplyMessage(ctx, message, util.EscapeText("Send the name followed by the number, e.g. Anna +14155550100."), nil)
+			return err
+		}
+		c := &contact.Contact{Name: match[1], Number: match[2]}
+		if value.Editing != "" {
+			if previous, err := h.book.Find(value.Editing); err == nil {
+				c.Favourite, c.LastUsed = previous.Favourite, previous.LastUsed
+			}
+		}
+		if err := h.book.Update(value.Editing, c); err != nil {
+			if errors.Is(err, contact.ErrContactExists) {
+				_, err = h.ReplyMessage(ctx, message, util.EscapeText("Another contact has this number, send me another one."), nil)
+			}
+			return err
+		}
+		slog.Info("Contact saved", "name", c.Name, "number", c.Number)
+		state.M.Current(message.Chat.ID, ContactsActionSearch)
+		_, err := h.ReplyMessage(ctx, message, h.card(c), func(params *telego.SendMessageParams) error {
+			params.WithReplyMarkup(h.keyboard(c))
+			return nil
+		})
+		return err
+	}
+	return nil
+}
+
+func (h *ContactsHandler) search(ctx *th.Context, message telego.Message) error {
+	contacts, err := h.book.Search(message.Text)
+	if err != nil {
+		return err
+	}
+	switch len(contacts) {
+	case 0:
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText("No contact matches."), func(params *telego.SendMessageParams) error {
+			params.WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(h.button("➕ Add", CallbackQueryContactsAdd, ""))))
+			return nil
+		})
+	case 1:
+		_, err = h.ReplyMessage(ctx, message, h.card(contacts[0]), func(params *telego.SendMessageParams) error {
+			params.WithReplyMarkup(h.keyboard(contacts[0]))
+			return nil
+		})
+	default:
+		var rows [][]telego.InlineKeyboardButton
+		for _, c := range contacts[:min(len(contacts), ContactsMaxMatches)] {
+			rows = append(rows, tu.InlineKeyboardRow(h.button(c.String(), CallbackQueryContactsShow, c.Number)))
+		}
+		text := fmt.Sprintf("%d contacts match, choose one.", len(contacts))
+		if len(contacts) > ContactsMaxMatches {
+			text = fmt.Sprintf("%d contacts match, these are the first %d. Send me more of the name to narrow it down.", len(contacts), ContactsMaxMatches)
+		}
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(text), func(params *telego.SendMessageParams) error {
+			params.WithReplyMarkup(tu.InlineKeyboard(rows...))
+			return nil
+		})
+	}
+	return err
+}
+
+func (h *ContactsHandler) importFile(ctx *th.Context, message telego.Message) error {
+	if message.Document.FileSize > ContactsMaxFileSize {
+		_, err := h.ReplyMessage(ctx, message, util.EscapeText("The file is too large, it may be up to 1 MiB."), nil)
+		return err
+	}
+	data, err := h.download(ctx, message.Document.FileID)
+	if err != nil {
+		return err
+	}
+	contacts, err := contact.Parse(data)
+	if err != nil {
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("The file can't be imported: %s.", err)), nil)
+		return err
+	}
+	text, err := h.merge(contacts)
+	if err != nil {
+		return err
+	}
+	_, err = h.ReplyMessage(ctx, message, util.EscapeText(text), nil)
+	return err
+}
+
+func (h *ContactsHandler) merge(contacts []*contact.Contact) (string, error) {
+	added, updated, err := h.book.Import(contacts)
+	if err != nil {
+		return "", err
+	}
+	slog.Info("Contacts imported", "added", added, "updated", updated)
+	return fmt.Sprintf("%d contacts added and %d renamed.", added, updated), nil
+}
+
+// card shows a single contact, with the buttons to change it.
+func (h *ContactsHandler) card(c *contact.Contact) string {
+	text := fmt.Sprintf("%s%s\n%s", util.If(c.Favourite, "⭐ ", ""), c.Name, c.Number)
+	if !c.LastUsed.IsZero() {
+		text += fmt.Sprintf("\nLast SMS sent on %s", c.LastUsed.Format("2006-01-02 15:04"))
+	}
+	return util.EscapeText(text)
+}
+
+func (h *ContactsHandler) keyboard(c *contact.Contact) *telego.InlineKeyboardMarkup {
+	return tu.InlineKeyboard(tu.InlineKeyboardRow(
+		h.button("✏️ Edit", CallbackQueryContactsEdit, c.Number),
+		h.button(util.If(c.Favourite, "Unfavourite", "⭐ Favourite"), CallbackQueryContactsFavourite, c.Number),
+		h.button("🗑 Delete", CallbackQueryContactsDelete, c.Number),
+	), tu.InlineKeyboardRow(h.button("💾 Save to SIM", CallbackQueryContactsSaveSIM, c.Number)))
+}
+
+func (h *ContactsHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*ContactsValue)
+	chatID := query.Message.GetChat().ID
+	action, argument, _ := strings.Cut(query.Data[len(CallbackQueryContactsPrefix)+1:], ":")
+	switch action {
+	case CallbackQueryContactsAdd:
+		value.Editing = ""
+		state.M.Current(chatID, ContactsActionAskContact)
+		_, err := h.ReplyCallbackQuery(ctx, query, util.EscapeText("Send me the name and the number, e.g. Anna +14155550100."), nil)
+		return err
+	case CallbackQueryContactsSIM:
+		return h.importSIM(ctx, query, argument)
+	}
+	// The SIM to save to follows the number once it is chosen.
+	number, imei, _ := strings.Cut(argument, ":")
+	c, err := h.book.Find(number)
+	if errors.Is(err, contact.ErrContactNotFound) {
+		return h.editCallbackQuery(ctx, query, util.EscapeText("The contact no longer exists."), nil)
+	}
+	if err != nil {
+		return err
+	}
+	switch action {
+	case CallbackQueryContactsShow:
+		_, err = h.ReplyCallbackQuery(ctx, query, h.card(c), func(message *telego.SendMessageParams) error {
+			message.WithReplyMarkup(h.keyboard(c))
+			return nil
+		})
+		return err
+	case CallbackQueryContactsEdit:
+		value.Editing = c.Number
+		state.M.Current(chatID, ContactsActionAskContact)
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Send me the new name and number of %s, e.g. %s %s.", c.Name, c.Name, c.Number)), nil)
+		return err
+	case CallbackQueryContactsFavourite:
+		c.Favourite = !c.Favourite
+		if err := h.book.Save(c); err != nil {
+			return err
+		}
+		return h.editCallbackQuery(ctx, query, h.card(c), h.keyboard(c))
+	case CallbackQueryContactsDelete:
+		if err := h.book.Delete(c.Number); err != nil {
+			return err
+		}
+		slog.Info("Contact deleted", "name", c.Name, "number", c.Number)
+		return h.editCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("%s is deleted.", c)), nil)
+	case CallbackQueryContactsSaveSIM:
+		return h.saveSIM(ctx, query, c, imei)
+	}
+	return nil
+}
+
+// chooseModem finds the modem chosen with a button, it asks which one with buttons of the action if there are several.
+// A nil modem means the chat has been replied to.
+func (h *ContactsHandler) chooseModem(ctx *th.Context, query telego.CallbackQuery, prompt string, action string, argument string, imei string) (*modem.Modem, error) {
+	modems, err := sortedModems(h.mm)
+	if err != nil {
+		return nil, err
+	}
+	if imei == "" && len(modems) > 1 {
+		var rows [][]telego.InlineKeyboardButton
+		for _, m := range modems {
+			rows = append(rows, tu.InlineKeyboardRow(h.button(
+				fmt.Sprintf("%s (%s)", h.name(m), m.Model),
+				action,
+				strings.TrimPrefix(argument+":"+m.EquipmentIdentifier, ":"),
+			)))
+		}
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(prompt), func(message *telego.SendMessageParams) error {
+			message.WithReplyMarkup(tu.InlineKeyboard(rows...))
+			return nil
+		})
+		return nil, err
+	}
+	for _, m := range modems {
+		if imei == "" || m.EquipmentIdentifier == imei {
+			return m, nil
+		}
+	}
+	_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText("The modem is no longer available."), nil)
+	return nil, err
+}
+
+// importSIM imports the phonebook of the SIM in the chosen modem.
+func (h *ContactsHandler) importSIM(ctx *th.Context, query telego.CallbackQuery, imei string) error {
+	m, err := h.chooseModem(ctx, query, "Choose the SIM to import the phonebook of.", CallbackQueryContactsSIM, "", imei)
+	if m == nil {
+		return err
+	}
+	entries, err := h.phonebook(m)
+	if err != nil {
+		slog.Warn("Failed to read the SIM phonebook", "modem", m.EquipmentIdentifier, "error", err)
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Failed to read the phonebook: %s", err)), nil)
+		return err
+	}
+	contacts := make([]*contact.Contact, 0, len(entries))
+	for _, entry := range entries {
+		contacts = append(contacts, &contact.Contact{Name: entry.Name, Number: entry.Number})
+	}
+	text, err := h.merge(contacts)
+	if err != nil {
+		return err
+	}
+	_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("%d contacts on the SIM of %s. %s", len(entries), h.name(m), text)), nil)
+	return err
+}
+
+// saveSIM writes the contact into the first free record of the phonebook on the SIM in the chosen modem.
+func (h *ContactsHandler) saveSIM(ctx *th.Context, query telego.CallbackQuery, c *contact.Contact, imei string) error {
+	m, err := h.chooseModem(ctx, query, fmt.Sprintf("Choose the SIM to save %s on.", c), CallbackQueryContactsSaveSIM, c.Number, imei)
+	if m == nil {
+		return err
+	}
+	phonebook, err := m.OpenPhonebook()
+	if err == nil {
+		defer phonebook.Close()
+		entry := &modem.PhonebookEntry{Name: c.Name, Number: c.Number}
+		if err = phonebook.Write(entry); err == nil {
+			slog.Info("Phonebook record saved", "modem", m.EquipmentIdentifier, "index", entry.Index, "name", entry.Name, "number", entry.Number)
+			_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("%s is saved on the SIM of %s as record %d.", c, h.name(m), entry.Index)), nil)
+			return err
+		}
+	}
+	slog.Warn("Failed to write the SIM phonebook", "modem", m.EquipmentIdentifier, "error", err)
+	_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Failed to save %s on the SIM: %s", c, err)), nil)
+	return err
+}
+
+func (h *ContactsHandler) phonebook(m *modem.Modem) ([]*modem.PhonebookEntry, error) {
+	phonebook, err := m.OpenPhonebook()
+	if err != nil {
+		return nil, err
+	}
+	defer phonebook.Close()
+	return phonebook.Entries()
+}
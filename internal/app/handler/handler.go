@@ -1,8 +1,14 @@
 package handler
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/damonto/telegram-sms/internal/pkg/lpa"
 	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
 	"github.com/mymmrac/telego"
 	th "github.com/mymmrac/telego/telegohandler"
 	tu "github.com/mymmrac/telego/telegoutil"
@@ -49,3 +55,54 @@ func (h *Handler) ReplyMessage(ctx *th.Context, message telego.Message, text str
 func (h *Handler) ReplyCallbackQuery(ctx *th.Context, query telego.CallbackQuery, text string, with WithFunc) (*telego.Message, error) {
 	return h.reply(ctx, query.From.ID, text, query.Message.GetMessageID(), with)
 }
+
+// editCallbackQuery replaces the message the pressed button belongs to, e.g. to redraw toggles.
+func (h *Handler) editCallbackQuery(ctx *th.Context, query telego.CallbackQuery, text string, keyboard *telego.InlineKeyboardMarkup) error {
+	_, err := ctx.Bot().EditMessageText(ctx, &telego.EditMessageTextParams{
+		ChatID:      tu.ID(query.Message.GetChat().ID),
+		MessageID:   query.Message.GetMessageID(),
+		Text:        text,
+		ParseMode:   telego.ModeMarkdownV2,
+		ReplyMarkup: keyboard,
+	})
+	return err
+}
+
+// name identifies the SIM by its number, or by the end of its ICCID if the number is unknown.
+func (h *Handler) name(m *modem.Modem) string {
+	if m.Number != "" {
+		return m.Number
+	}
+	return "*" + m.Sim.Identifier[max(len(m.Sim.Identifier)-6, 0):]
+}
+
+// download fetches a file sent to the bot, e.g. a document to import.
+func (h *Handler) download(ctx *th.Context, fileID string) ([]byte, error) {
+	file, err := ctx.Bot().GetFile(ctx, &telego.GetFileParams{FileID: fileID})
+	if err != nil {
+		return nil, err
+	}
+	return tu.DownloadFile(ctx.Bot().FileDownloadURL(file.FilePath))
+}
+
+// sortedModems returns the plugged in modems ordered by IMEI, so buttons listing them keep their order.
+func sortedModems(mm *modem.Manager) ([]*modem.Modem, error) {
+	plugged, err := mm.Modems()
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]*modem.Modem, 0, len(plugged))
+	for _, m := range plugged {
+		sorted = append(sorted, m)
+	}
+	slices.SortFunc(sorted, func(a, b *modem.Modem) int {
+		return strings.Compare(a.EquipmentIdentifier, b.EquipmentIdentifier)
+	})
+	return sorted, nil
+}
+
+// idleFor tells how long a session was idle before it was closed, e.g. "5 minutes".
+func idleFor(timeout time.Duration) string {
+	minutes := int(timeout.Round(time.Minute) / time.Minute)
+	return fmt.Sprintf("%d %s", minutes, util.If(minutes == 1, "minute", "minutes"))
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleFor(t *testing.T) {
+	tests := map[time.Duration]string{
+		time.Minute:             "1 minute",
+		USSDSessionTimeout:      "2 minutes",
+		ATSessionTimeout:        "5 minutes",
+		90 * time.Second:        "2 minutes",
+		time.Hour + time.Minute: "61 minutes",
+	}
+	for timeout, want := range tests {
+		if got := idleFor(timeout); got != want {
+			t.Errorf("idleFor(%s) = %q, want %q", timeout, got, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type ModeHandler struct {
+	*Handler
+}
+
+type ModeValue struct {
+	Modem     *modem.Modem
+	Supported []modem.ModemModes
+}
+
+const (
+	CallbackQueryModePrefix = "mode"
+	// CallbackQueryRadioSave saves the current radio configuration as the default of the modem.
+	CallbackQueryRadioSave = "save"
+)
+
+func NewModeHandler() state.Handler {
+	h := new(ModeHandler)
+	return h
+}
+
+func (h *ModeHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		m := h.Modem(ctx)
+		supported, err := m.SupportedModes()
+		if err != nil {
+			return err
+		}
+		if len(supported) < 2 {
+			_, err = h.Reply(ctx, update, util.EscapeText("The modem doesn't support changing its mode."), nil)
+			return err
+		}
+		value := &ModeValue{Modem: m, Supported: supported}
+		text, keyboard, err := h.message(value)
+		if err != nil {
+			return err
+		}
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			Value:   value,
+		})
+		_, err = h.Reply(ctx, update, text, func(message *telego.SendMessageParams) error {
+			message.WithReplyMarkup(keyboard)
+			return nil
+		})
+		return err
+	}
+}
+
+func (h *ModeHandler) message(value *ModeValue) (string, *telego.InlineKeyboardMarkup, error) {
+	current, err := value.Modem.CurrentModes()
+	if err != nil {
+		return "", nil, err
+	}
+	var buttons [][]telego.InlineKeyboardButton
+	for idx, modes := range value.Supported {
+		buttons = append(buttons, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+			Text:         util.If(modes == current, "✅ ", "") + modes.String(),
+			CallbackData: fmt.Sprintf("%s:%d", CallbackQueryModePrefix, idx),
+		}))
+	}
+	buttons = append(buttons, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+		Text:         "💾 Save as default",
+		CallbackData: fmt.Sprintf("%s:%s", CallbackQueryModePrefix, CallbackQueryRadioSave),
+	}))
+	text := fmt.Sprintf("The modem is set to %s. Which mode do you want to use?", current)
+	return util.EscapeText(text), tu.InlineKeyboard(buttons...), nil
+}
+
+func (h *ModeHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*ModeValue)
+	data := query.Data[len(CallbackQueryModePrefix)+1:]
+	if data == CallbackQueryRadioSave {
+		current, err := value.Modem.CurrentModes()
+		if err != nil {
+			return err
+		}
+		text, err := saveRadio(value.Modem, func(radio *config.Radio) {
+			radio.Modes = current.Allowed.String()
+			radio.Preferred = util.If(current.Preferred != modem.ModemModeNone, current.Preferred.String(), "")
+		})
+		if err != nil {
+			return err
+		}
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(text), nil)
+		return err
+	}
+	idx, err := strconv.Atoi(data)
+	if err != nil || idx < 0 || idx >= len(value.Supported) {
+		return errors.New("invalid mode")
+	}
+	if err := value.Modem.SetCurrentModes(value.Supported[idx]); err != nil {
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Failed to set the mode: %s", err)), nil)
+		return err
+	}
+	text, keyboard, err := h.message(value)
+	if err != nil {
+		return err
+	}
+	return h.editCallbackQuery(ctx, query, text, keyboard)
+}
+
+func (h *ModeHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	return nil
+}
+
+// saveRadio updates the radio defaults of the modem, which are applied every time it is plugged in.
+func saveRadio(m *modem.Modem, update func(radio *config.Radio)) (string, error) {
+	if err := config.C.UpdateRadio(m.EquipmentIdentifier, update); err != nil {
+		return "", err
+	}
+	return "Saved, it will be applied every time the modem is plugged in.", nil
+}
@@ -23,9 +23,11 @@ Model: %s
 Firmware Revision: %s
 IMEI: %s
 Network: %s
+Roaming: %s
 Operator: %s
 Number: %s
 Signal: %d%%
+Radio: %s
 ICCID: %s
 EID: %s
 `
@@ -72,14 +74,29 @@ func (h *ListModemHandler) message(m *modem.Modem) string {
 		util.EscapeText(
 			fmt.Sprintf("%s (%s - %s)", util.LookupCarrier(code), strings.Join(accessTech, ", "), state),
 		),
+		util.EscapeText(modem.NewRoaming(m.Sim.OperatorIdentifier, state, code).String()),
 		util.EscapeText(util.If(m.Sim.OperatorName != "", m.Sim.OperatorName, util.LookupCarrier(m.Sim.OperatorIdentifier))),
 		util.EscapeText(m.Number),
 		percent,
+		util.EscapeText(h.radio(m)),
 		m.Sim.Identifier,
 		h.EID(m))
 	return message
 }
 
+// radio returns the extended signal metrics, which are only polled if the modem supports the Signal interface.
+func (h *ListModemHandler) radio(m *modem.Modem) string {
+	metrics, err := m.Signal()
+	if err != nil || len(metrics) == 0 {
+		return "Unavailable"
+	}
+	var radio []string
+	for _, s := range metrics {
+		radio = append(radio, s.String())
+	}
+	return strings.Join(radio, "; ")
+}
+
 func (h *ListModemHandler) EID(m *modem.Modem) string {
 	lpa, err := lpa.New(m)
 	if err != nil {
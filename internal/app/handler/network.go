@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type NetworkHandler struct {
+	*Handler
+}
+
+type NetworkValue struct {
+	Modem    *modem.Modem
+	Networks []*modem.Network
+}
+
+const (
+	CallbackQueryNetworkPrefix = "network"
+	// CallbackQueryNetworkAutomatic returns to automatic network selection.
+	CallbackQueryNetworkAutomatic = "auto"
+
+	NetworkScanProgressInterval = 5 * time.Second
+)
+
+func NewNetworkHandler() state.Handler {
+	h := new(NetworkHandler)
+	return h
+}
+
+func (h *NetworkHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		m := h.Modem(ctx)
+		progress, err := h.Reply(ctx, update, h.progress(0), nil)
+		if err != nil {
+			return err
+		}
+		done := make(chan struct{})
+		go h.showProgress(ctx.Bot(), progress, done)
+		networks, err := m.ScanNetworks(ctx)
+		close(done)
+		if err != nil {
+			return h.edit(ctx.Bot(), progress, util.EscapeText(fmt.Sprintf("Failed to scan for networks: %s", err)), nil)
+		}
+		if len(networks) == 0 {
+			return h.edit(ctx.Bot(), progress, util.EscapeText("No networks were found."), nil)
+		}
+		h.sort(networks)
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			Value:   &NetworkValue{Modem: m, Networks: networks},
+		})
+		return h.edit(ctx.Bot(), progress, h.message(networks), h.keyboard(networks))
+	}
+}
+
+// showProgress keeps the admin informed while the scan is running, as it takes a while and can't be followed.
+func (h *NetworkHandler) showProgress(bot *telego.Bot, progress *telego.Message, done chan struct{}) {
+	ticker := time.NewTicker(NetworkScanProgressInterval)
+	defer ticker.Stop()
+	started := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := h.edit(bot, progress, h.progress(time.Since(started)), nil); err != nil {
+				slog.Warn("Failed to update the scan progress", "error", err)
+			}
+		}
+	}
+}
+
+func (h *NetworkHandler) progress(elapsed time.Duration) string {
+	return util.EscapeText(fmt.Sprintf("Scanning for networks, this can take a minute or two.\n ⏳ %s", elapsed.Round(time.Second)))
+}
+
+func (h *NetworkHandler) edit(bot *telego.Bot, message *telego.Message, text string, keyboard *telego.InlineKeyboardMarkup) error {
+	_, err := bot.EditMessageText(context.Background(), &telego.EditMessageTextParams{
+		ChatID:      message.Chat.ChatID(),
+		MessageID:   message.MessageID,
+		Text:        text,
+		ParseMode:   telego.ModeMarkdownV2,
+		ReplyMarkup: keyboard,
+	})
+	return err
+}
+
+// sort lists the current network first, then the available ones and the forbidden ones last.
+func (h *NetworkHandler) sort(networks []*modem.Network) {
+	rank := map[modem.Modem3gppNetworkAvailability]int{
+		modem.Modem3gppNetworkAvailabilityCurrent:   0,
+		modem.Modem3gppNetworkAvailabilityAvailable: 1,
+		modem.Modem3gppNetworkAvailabilityUnknown:   2,
+		modem.Modem3gppNetworkAvailabilityForbidden: 3,
+	}
+	slices.SortStableFunc(networks, func(a, b *modem.Network) int {
+		if a.Availability != b.Availability {
+			return rank[a.Availability] - rank[b.Availability]
+		}
+		return strings.Compare(a.OperatorCode, b.OperatorCode)
+	})
+}
+
+func (h *NetworkHandler) message(networks []*modem.Network) string {
+	var text strings.Builder
+	text.WriteString("These networks are around, which one do you want to register to?\n")
+	for _, network := range networks {
+		fmt.Fprintf(&text, "\n%s %s (%s) %s - %s", h.icon(network), h.name(network), network.OperatorCode, network.AccessTechnology, network.Availability)
+	}
+	return util.EscapeText(text.String())
+}
+
+func (h *NetworkHandler) keyboard(networks []*modem.Network) *telego.InlineKeyboardMarkup {
+	var buttons [][]telego.InlineKeyboardButton
+	for _, network := range networks {
+		if network.Availability == modem.Modem3gppNetworkAvailabilityForbidden {
+			continue
+		}
+		buttons = append(buttons, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+			Text:         fmt.Sprintf("%s %s %s", h.icon(network), h.name(network), network.AccessTechnology),
+			CallbackData: fmt.Sprintf("%s:%s", CallbackQueryNetworkPrefix, network.OperatorCode),
+		}))
+	}
+	buttons = append(buttons, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+		Text:         "Automatic",
+		CallbackData: fmt.Sprintf("%s:%s", CallbackQueryNetworkPrefix, CallbackQueryNetworkAutomatic),
+	}))
+	return tu.InlineKeyboard(buttons...)
+}
+
+func (h *NetworkHandler) icon(network *modem.Network) string {
+	switch network.Availability {
+	case modem.Modem3gppNetworkAvailabilityCurrent:
+		return "✅"
+	case modem.Modem3gppNetworkAvailabilityAvailable:
+		return "🟢"
+	case modem.Modem3gppNetworkAvailabilityForbidden:
+		return "🔴"
+	default:
+		return "⚪"
+	}
+}
+
+func (h *NetworkHandler) name(network *modem.Network) string {
+	if name := util.LookupCarrier(network.OperatorCode); name != "Unknown" {
+		return name
+	}
+	return util.If(network.OperatorLong != "", network.OperatorLong, network.OperatorShort)
+}
+
+func (h *NetworkHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	defer state.M.Exit(query.From.ID)
+	value := s.Value.(*NetworkValue)
+	code := query.Data[len(CallbackQueryNetworkPrefix)+1:]
+	var text string
+	if code == CallbackQueryNetworkAutomatic {
+		if err := value.Modem.RegisterNetwork(""); err != nil {
+			return err
+		}
+		text = "The modem is back to automatic network selection."
+	} else {
+		idx := slices.IndexFunc(value.Networks, func(n *modem.Network) bool { return n.OperatorCode == code })
+		if idx < 0 {
+			return errors.New("invalid network")
+		}
+		if err := value.Modem.RegisterNetwork(code); err != nil {
+			_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Failed to register to %s: %s", h.name(value.Networks[idx]), err)), nil)
+			return err
+		}
+		text = fmt.Sprintf("The modem has been registered to %s (%s).", h.name(value.Networks[idx]), code)
+	}
+	slog.Info("Network selected", "modem", value.Modem.EquipmentIdentifier, "operator", util.If(code == CallbackQueryNetworkAutomatic, "", code))
+	_, err := h.ReplyCallbackQuery(ctx, query, util.EscapeText(text), nil)
+	return err
+}
+
+func (h *NetworkHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	return nil
+}
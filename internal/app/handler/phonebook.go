@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/contact"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type PhonebookHandler struct {
+	*Handler
+	book *contact.Book
+}
+
+type PhonebookValue struct {
+	Modem *modem.Modem
+	// Editing is the index of the record being edited, zero while one is added.
+	Editing int
+}
+
+const (
+	PhonebookActionSelect   state.State = "phonebook_select"
+	PhonebookActionAskEntry state.State = "phonebook_ask_entry"
+
+	CallbackQueryPhonebookPrefix = "phonebook"
+	CallbackQueryPhonebookAdd    = "add"
+	CallbackQueryPhonebookExport = "export"
+	CallbackQueryPhonebookCopy   = "copy"
+	CallbackQueryPhonebookEdit   = "edit"
+	CallbackQueryPhonebookDelete = "delete"
+
+	// PhonebookListLimit is the most records listed in a message, the others are shown by their index.
+	PhonebookListLimit = 50
+)
+
+var errPhonebookEntryNotFound = errors.New("the record is empty")
+
+func NewPhonebookHandler(book *contact.Book) state.Handler {
+	h := new(PhonebookHandler)
+	h.book = book
+	return h
+}
+
+func (h *PhonebookHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		m := h.Modem(ctx)
+		phonebook, entries, err := h.entries(m)
+		if err != nil {
+			slog.Warn("Failed to read the SIM phonebook", "modem", m.EquipmentIdentifier, "error", err)
+			_, err = h.Reply(ctx, update, util.EscapeText(fmt.Sprintf("Failed to read the phonebook: %s", err)), nil)
+			return err
+		}
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			State:   PhonebookActionSelect,
+			Value:   &PhonebookValue{Modem: m},
+		})
+		var text strings.Builder
+		fmt.Fprintf(&text, "%d of %d records used (%s).\n", len(entries), phonebook.Last-phonebook.First+1, phonebook.Method())
+		for _, entry := range entries[:min(len(entries), PhonebookListLimit)] {
+			fmt.Fprintf(&text, "%d. %s %s\n", entry.Index, entry.Name, entry.Number)
+		}
+		if len(entries) > PhonebookListLimit {
+			fmt.Fprintf(&text, "and %d more.\n", len(entries)-PhonebookListLimit)
+		}
+		text.WriteString("\nSend me the index of a record to edit or delete it, or a vCard or CSV file to write into the free records.")
+		_, err = h.Reply(ctx, update, util.EscapeText(text.String()), func(message *telego.SendMessageParams) error {
+			message.WithReplyMarkup(tu.InlineKeyboard(
+				tu.InlineKeyboardRow(
+					h.button("➕ Add", CallbackQueryPhonebookAdd, 0),
+					h.button("📤 Export vCard", CallbackQueryPhonebookExport, 0),
+				),
+				tu.InlineKeyboardRow(h.button("📇 Copy to contacts", CallbackQueryPhonebookCopy, 0)),
+			))
+			return nil
+		})
+		return err
+	}
+}
+
+func (h *PhonebookHandler) button(text string, action string, index int) telego.InlineKeyboardButton {
+	data := fmt.Sprintf("%s:%s", CallbackQueryPhonebookPrefix, action)
+	if index > 0 {
+		data += ":" + strconv.Itoa(index)
+	}
+	return telego.InlineKeyboardButton{Text: text, CallbackData: data}
+}
+
+// entries reads the records in use, the phonebook is closed again so the AT port isn't held while the chat goes on.
+func (h *PhonebookHandler) entries(m *modem.Modem) (*modem.Phonebook, []*modem.PhonebookEntry, error) {
+	phonebook, err := m.OpenPhonebook()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer phonebook.Close()
+	entries, err := phonebook.Entries()
+	return phonebook, entries, err
+}
+
+func (h *PhonebookHandler) entry(m *modem.Modem, index int) (*modem.PhonebookEntry, error) {
+	_, entries, err := h.entries(m)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(entries, func(entry *modem.PhonebookEntry) bool { return entry.Index == index })
+	if i < 0 {
+		return nil, errPhonebookEntryNotFound
+	}
+	return entries[i], nil
+}
+
+func (h *PhonebookHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	value := s.Value.(*PhonebookValue)
+	switch s.State {
+	case PhonebookActionSelect:
+		if message.Document != nil {
+			return h.importFile(ctx, message, value.Modem)
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(message.Text))
+		if err != nil {
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText("Send me the index of a record, e.g. 1."), nil)
+			return err
+		}
+		entry, err := h.entry(value.Modem, index)
+		if err != nil {
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("Record %d can't be shown: %s.", index, err)), nil)
+			return err
+		}
+		_, err = h.ReplyMessage(ctx, message, h.card(entry), func(params *telego.SendMessageParams) error {
+			params.WithReplyMarkup(h.keyboard(entry))
+			return nil
+		})
+		return err
+	case PhonebookActionAskEntry:
+		match := contactPattern.FindStringSubmatch(strings.TrimSpace(message.Text))
+		if match == nil {
+			_, err := h.ReplyMessage(ctx, message, util.EscapeText("Send the name followed by the number, e.g. Anna +14155550100."), nil)
+			return err
+		}
+		entry := &modem.PhonebookEntry{Index: value.Editing, Name: match[1], Number: contact.Normalize(match[2])}
+		if err := h.write(value.Modem, entry); err != nil {
+			slog.Warn("Failed to write the SIM phonebook", "modem", value.Modem.EquipmentIdentifier, "error", err)
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("Failed to save the record: %s. Send me another one.", err)), nil)
+			return err
+		}
+		slog.Info("Phonebook record saved", "modem", value.Modem.EquipmentIdentifier, "index", entry.Index, "name", entry.Name, "number", entry.Number)
+		state.M.Current(message.Chat.ID, PhonebookActionSelect)
+		_, err := h.ReplyMessage(ctx, message, h.card(entry), func(params *telego.SendMessageParams) error {
+			params.WithReplyMarkup(h.keyboard(entry))
+			return nil
+		})
+		return err
+	}
+	return nil
+}
+
+func (h *PhonebookHandler) write(m *modem.Modem, entry *modem.PhonebookEntry) error {
+	phonebook, err := m.OpenPhonebook()
+	if err != nil {
+		return err
+	}
+	defer phonebook.Close()
+	return phonebook.Write(entry)
+}
+
+// importFile writes the contacts of a vCard or CSV file into the free records, until the phonebook is full.
+func (h *PhonebookHandler) importFile(ctx *th.Context, message telego.Message, m *modem.Modem) error {
+	if message.Document.FileSize > ContactsMaxFileSize {
+		_, err := h.ReplyMessage(ctx, message, util.EscapeText("The file is too large, it may be up to 1 MiB."), nil)
+		return err
+	}
+	data, err := h.download(ctx, message.Document.FileID)
+	if err != nil {
+		return err
+	}
+	contacts, err := contact.Parse(data)
+	if err != nil {
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("The file can't be imported: %s.", err)), nil)
+		return err
+	}
+	phonebook, err := m.OpenPhonebook()
+	if err != nil {
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("Failed to open the phonebook: %s", err)), nil)
+		return err
+	}
+	defer phonebook.Close()
+	var written int
+	var failed []string
+	for _, c := range contacts {
+		err := phonebook.Write(&modem.PhonebookEntry{Name: c.Name, Number: c.Number})
+		if errors.Is(err, modem.ErrPhonebookFull) {
+			break
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", c, err))
+			continue
+		}
+		written++
+	}
+	slog.Info("Phonebook imported", "modem", m.EquipmentIdentifier, "written", written, "failed", len(failed))
+	text := fmt.Sprintf("%d of %d contacts written to the SIM.", written, len(contacts))
+	if skipped := len(contacts) - written - len(failed); skipped > 0 {
+		text += fmt.Sprintf(" The phonebook is full, %d are left out.", skipped)
+	}
+	if len(failed) > 0 {
+		text += "\n\nThese can't be written:\n" + strings.Join(failed, "\n")
+	}
+	_, err = h.ReplyMessage(ctx, message, util.EscapeText(text), nil)
+	return err
+}
+
+// card shows a single record, with the buttons to change it.
+func (h *PhonebookHandler) card(entry *modem.PhonebookEntry) string {
+	return util.EscapeText(fmt.Sprintf("Record %d\n%s\n%s", entry.Index, entry.Name, entry.Number))
+}
+
+func (h *PhonebookHandler) keyboard(entry *modem.PhonebookEntry) *telego.InlineKeyboardMarkup {
+	return tu.InlineKeyboard(tu.InlineKeyboardRow(
+		h.button("✏️ Edit", CallbackQueryPhonebookEdit, entry.Index),
+		h.button("🗑 Delete", CallbackQueryPhonebookDelete, entry.Index),
+	))
+}
+
+func (h *PhonebookHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*PhonebookValue)
+	chatID := query.Message.GetChat().ID
+	action, argument, _ := strings.Cut(query.Data[len(CallbackQueryPhonebookPrefix)+1:], ":")
+	switch action {
+	case CallbackQueryPhonebookAdd:
+		value.Editing = 0
+		state.M.Current(chatID, PhonebookActionAskEntry)
+		_, err := h.ReplyCallbackQuery(ctx, query, util.EscapeText("Send me the name and the number, e.g. Anna +14155550100."), nil)
+		return err
+	case CallbackQueryPhonebookExport, CallbackQueryPhonebookCopy:
+		return h.export(ctx, query, value.Modem, action == CallbackQueryPhonebookCopy)
+	}
+	index, err := strconv.Atoi(argument)
+	if err != nil {
+		return err
+	}
+	entry, err := h.entry(value.Modem, index)
+	if err != nil {
+		return h.editCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Record %d can't be shown: %s.", index, err)), nil)
+	}
+	switch action {
+	case CallbackQueryPhonebookEdit:
+		value.Editing = entry.Index
+		state.M.Current(chatID, PhonebookActionAskEntry)
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Send me the new name and number of record %d, e.g. %s %s.", entry.Index, entry.Name, entry.Number)), nil)
+		return err
+	case CallbackQueryPhonebookDelete:
+		phonebook, err := value.Modem.OpenPhonebook()
+		if err != nil {
+			return err
+		}
+		defer phonebook.Close()
+		if err := phonebook.Delete(entry.Index); err != nil {
+			slog.Warn("Failed to delete the SIM phonebook record", "modem", value.Modem.EquipmentIdentifier, "index", entry.Index, "error", err)
+			return h.editCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Failed to delete the record: %s", err)), nil)
+		}
+		slog.Info("Phonebook record deleted", "modem", value.Modem.EquipmentIdentifier, "index", entry.Index, "name", entry.Name, "number", entry.Number)
+		return h.editCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Record %d (%s %s) is deleted.", entry.Index, entry.Name, entry.Number)), nil)
+	}
+	return nil
+}
+
+// export sends the phonebook as a vCard file, or copies it into the contact book.
+func (h *PhonebookHandler) export(ctx *th.Context, query telego.CallbackQuery, m *modem.Modem, toBook bool) error {
+	_, entries, err := h.entries(m)
+	if err != nil {
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("Failed to read the phonebook: %s", err)), nil)
+		return err
+	}
+	contacts := make([]*contact.Contact, 0, len(entries))
+	for _, entry := range entries {
+		contacts = append(contacts, &contact.Contact{Name: entry.Name, Number: entry.Number})
+	}
+	if toBook {
+		added, updated, err := h.book.Import(contacts)
+		if err != nil {
+			return err
+		}
+		slog.Info("Contacts imported", "added", added, "updated", updated)
+		_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(fmt.Sprintf("%d contacts added and %d renamed.", added, updated)), nil)
+		return err
+	}
+	var file bytes.Buffer
+	if err := contact.WriteVCard(&file, contacts); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("phonebook-%s.vcf", util.If(m.Sim.Identifier != "", m.Sim.Identifier, m.EquipmentIdentifier))
+	_, err = ctx.Bot().SendDocument(ctx, tu.Document(tu.ID(query.Message.GetChat().ID), tu.FileFromBytes(file.Bytes(), name)).
+		WithReplyParameters(&telego.ReplyParameters{MessageID: query.Message.GetMessageID()}))
+	return err
+}
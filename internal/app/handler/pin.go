@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type PINHandler struct {
+	*Handler
+}
+
+type PINValue struct {
+	Modem *modem.Modem
+	// PIN is the last accepted PIN, kept to be remembered, or the current one while it is being changed.
+	PIN string
+	PUK string
+}
+
+const (
+	PINActionAskPIN       state.State = "pin_ask_pin"
+	PINActionAskPUK       state.State = "pin_ask_puk"
+	PINActionAskNewPIN    state.State = "pin_ask_new_pin"
+	PINActionAskEnable    state.State = "pin_ask_enable"
+	PINActionAskDisable   state.State = "pin_ask_disable"
+	PINActionAskCurrent   state.State = "pin_ask_current"
+	PINActionAskChangeNew state.State = "pin_ask_change_new"
+
+	CallbackQueryPINPrefix  = "pin"
+	CallbackQueryPINEnable  = "enable"
+	CallbackQueryPINDisable = "disable"
+	CallbackQueryPINChange  = "change"
+	CallbackQueryPINSave    = "save"
+	CallbackQueryPINForget  = "forget"
+)
+
+var (
+	pinPattern = regexp.MustCompile(`^\d{4,8}$`)
+	pukPattern = regexp.MustCompile(`^\d{8}$`)
+)
+
+func NewPINHandler() state.Handler {
+	h := new(PINHandler)
+	return h
+}
+
+func (h *PINHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		value := &PINValue{Modem: h.Modem(ctx)}
+		lock, err := value.Modem.UnlockRequired()
+		if err != nil {
+			return err
+		}
+		var text string
+		switch lock {
+		case modem.ModemLockSimPin:
+			state.M.Enter(update.Message.Chat.ID, &state.ChatState{Handler: h, State: PINActionAskPIN, Value: value})
+			text = "The SIM is locked. Send me the PIN" + h.retries(value.Modem, modem.ModemLockSimPin) + "."
+		case modem.ModemLockSimPuk:
+			state.M.Enter(update.Message.Chat.ID, &state.ChatState{Handler: h, State: PINActionAskPUK, Value: value})
+			text = "The SIM is blocked. Send me the PUK" + h.retries(value.Modem, modem.ModemLockSimPuk) + "."
+		case modem.ModemLockNone, modem.ModemLockUnknown:
+			state.M.Enter(update.Message.Chat.ID, &state.ChatState{Handler: h, Value: value})
+			_, err = h.Reply(ctx, update, util.EscapeText(h.unlocked(value.Modem)), func(message *telego.SendMessageParams) error {
+				message.WithReplyMarkup(h.keyboard(value.Modem))
+				return nil
+			})
+			return err
+		default:
+			text = fmt.Sprintf("The modem requires the %s, which can't be entered from here.", lock)
+		}
+		_, err = h.Reply(ctx, update, util.EscapeText(text), nil)
+		return err
+	}
+}
+
+// retries describes the attempts left for the code, if the modem reports them.
+func (h *PINHandler) retries(m *modem.Modem, lock modem.ModemLock) string {
+	retries, err := m.UnlockRetries()
+	if err != nil {
+		slog.Warn("Failed to get the unlock retries", "error", err)
+		return ""
+	}
+	count, ok := retries[lock]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(", %d %s left", count, util.If(count == 1, "attempt", "attempts"))
+}
+
+func (h *PINHandler) unlocked(m *modem.Modem) string {
+	text := "The SIM is unlocked."
+	if retries := h.retries(m, modem.ModemLockSimPin); retries != "" {
+		text += fmt.Sprintf("\nPIN%s.", retries)
+	}
+	if retries := h.retries(m, modem.ModemLockSimPuk); retries != "" {
+		text += fmt.Sprintf("\nPUK%s.", retries)
+	}
+	return text + "\nWhat do you want to do?"
+}
+
+func (h *PINHandler) keyboard(m *modem.Modem) *telego.InlineKeyboardMarkup {
+	button := func(text string, action string) telego.InlineKeyboardButton {
+		return telego.InlineKeyboardButton{Text: text, CallbackData: fmt.Sprintf("%s:%s", CallbackQueryPINPrefix, action)}
+	}
+	buttons := [][]telego.InlineKeyboardButton{
+		tu.InlineKeyboardRow(button("Turn PIN lock on", CallbackQueryPINEnable), button("Turn PIN lock off", CallbackQueryPINDisable)),
+		tu.InlineKeyboardRow(button("Change PIN", CallbackQueryPINChange)),
+	}
+	if pin, err := config.C.PIN(m.Sim.Identifier); err == nil && pin != "" {
+		buttons = append(buttons, tu.InlineKeyboardRow(button("Forget saved PIN", CallbackQueryPINForget)))
+	}
+	return tu.InlineKeyboard(buttons...)
+}
+
+func (h *PINHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*PINValue)
+	chatID := query.Message.GetChat().ID
+	var text string
+	switch query.Data[len(CallbackQueryPINPrefix)+1:] {
+	case CallbackQueryPINEnable:
+		state.M.Current(chatID, PINActionAskEnable)
+		text = "Send me the PIN to turn the PIN lock on" + h.retries(value.Modem, modem.ModemLockSimPin) + "."
+	case CallbackQueryPINDisable:
+		state.M.Current(chatID, PINActionAskDisable)
+		text = "Send me the PIN to turn the PIN lock off" + h.retries(value.Modem, modem.ModemLockSimPin) + "."
+	case CallbackQueryPINChange:
+		state.M.Current(chatID, PINActionAskCurrent)
+		text = "Send me the current PIN" + h.retries(value.Modem, modem.ModemLockSimPin) + "."
+	case CallbackQueryPINSave:
+		state.M.Exit(chatID)
+		if err := config.C.SavePIN(value.Modem.Sim.Identifier, value.PIN); err != nil {
+			return err
+		}
+		text = "The PIN has been saved, the SIM will be unlocked automatically from now on."
+	case CallbackQueryPINForget:
+		state.M.Exit(chatID)
+		if err := config.C.SavePIN(value.Modem.Sim.Identifier, ""); err != nil {
+			return err
+		}
+		text = "The saved PIN has been forgotten."
+	}
+	_, err := h.ReplyCallbackQuery(ctx, query, util.EscapeText(text), nil)
+	return err
+}
+
+func (h *PINHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	// Waiting for the PIN to be remembered, there is nothing to enter.
+	if s.State == "" {
+		return nil
+	}
+	// Codes shouldn't linger in the chat history.
+	defer h.delete(ctx, message)
+	value := s.Value.(*PINValue)
+	code := message.Text
+	if !util.If(s.State == PINActionAskPUK, pukPattern, pinPattern).MatchString(code) {
+		_, err := h.ReplyMessage(ctx, message, util.EscapeText(util.If(s.State == PINActionAskPUK,
+			"A PUK is 8 digits, please try again.",
+			"A PIN is 4 to 8 digits, please try again.")), nil)
+		return err
+	}
+	switch s.State {
+	case PINActionAskPUK:
+		value.PUK = code
+		state.M.Current(message.Chat.ID, PINActionAskNewPIN)
+		_, err := h.ReplyMessage(ctx, message, util.EscapeText("Send me the new PIN."), nil)
+		return err
+	case PINActionAskCurrent:
+		value.PIN = code
+		state.M.Current(message.Chat.ID, PINActionAskChangeNew)
+		_, err := h.ReplyMessage(ctx, message, util.EscapeText("Send me the new PIN."), nil)
+		return err
+	case PINActionAskPIN:
+		return h.apply(ctx, message, value, modem.ModemLockSimPin, "The SIM has been unlocked.", func() error {
+			return value.Modem.SendPin(code)
+		}, code)
+	case PINActionAskNewPIN:
+		return h.apply(ctx, message, value, modem.ModemLockSimPuk, "The SIM has been unblocked with the new PIN.", func() error {
+			return value.Modem.SendPuk(value.PUK, code)
+		}, code)
+	case PINActionAskEnable:
+		return h.apply(ctx, message, value, modem.ModemLockSimPin, "The PIN lock is on.", func() error {
+			return value.Modem.EnablePin(code, true)
+		}, code)
+	case PINActionAskDisable:
+		return h.apply(ctx, message, value, modem.ModemLockSimPin, "The PIN lock is off.", func() error {
+			return value.Modem.EnablePin(code, false)
+		}, "")
+	case PINActionAskChangeNew:
+		return h.apply(ctx, message, value, modem.ModemLockSimPin, "The PIN has been changed.", func() error {
+			if err := value.Modem.ChangePin(value.PIN, code); err != nil {
+				return err
+			}
+			// Keep unlocking automatically with the new PIN.
+			if saved, err := config.C.PIN(value.Modem.Sim.Identifier); err == nil && saved != "" {
+				return config.C.SavePIN(value.Modem.Sim.Identifier, code)
+			}
+			return nil
+		}, code)
+	}
+	return nil
+}
+
+// apply runs the SIM operation and reports the outcome, along with the attempts left for the code on failure.
+// Once a PIN has been accepted the admin is offered to remember it.
+func (h *PINHandler) apply(ctx *th.Context, message telego.Message, value *PINValue, lock modem.ModemLock, success string, operation func() error, pin string) error {
+	if err := operation(); err != nil {
+		state.M.Exit(message.Chat.ID)
+		slog.Warn("SIM PIN operation failed", "modem", value.Modem.EquipmentIdentifier, "error", err)
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(fmt.Sprintf("Failed: %s%s. /pin", err, h.retries(value.Modem, lock))), nil)
+		return err
+	}
+	slog.Info("SIM PIN operation succeeded", "modem", value.Modem.EquipmentIdentifier, "iccid", value.Modem.Sim.Identifier)
+	saved, err := config.C.PIN(value.Modem.Sim.Identifier)
+	if err != nil || pin == "" || saved == pin {
+		state.M.Exit(message.Chat.ID)
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(success), nil)
+		return err
+	}
+	value.PIN = pin
+	state.M.Current(message.Chat.ID, "")
+	_, err = h.ReplyMessage(ctx, message, util.EscapeText(success), func(params *telego.SendMessageParams) error {
+		params.WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+			Text:         "💾 Unlock automatically with this PIN",
+			CallbackData: fmt.Sprintf("%s:%s", CallbackQueryPINPrefix, CallbackQueryPINSave),
+		})))
+		return nil
+	})
+	return err
+}
+
+func (h *PINHandler) delete(ctx *th.Context, message telego.Message) {
+	if err := ctx.Bot().DeleteMessage(ctx, &telego.DeleteMessageParams{
+		ChatID:    message.Chat.ChatID(),
+		MessageID: message.MessageID,
+	}); err != nil {
+		slog.Warn("Failed to delete the message with the code", "error", err)
+	}
+}
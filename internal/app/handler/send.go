@@ -1,29 +1,64 @@
 package handler
 
 import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/contact"
 	"github.com/damonto/telegram-sms/internal/pkg/modem"
 	"github.com/damonto/telegram-sms/internal/pkg/util"
 	"github.com/mymmrac/telego"
 	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
 )
 
 type SendHandler struct {
 	*Handler
+	book *contact.Book
 }
 
 type SMSValue struct {
-	To    string
-	Modem *modem.Modem
+	To      string
+	Text    string
+	Modem   *modem.Modem
+	Options modem.SMSOptions
 }
 
 const (
 	SendActionAskPhoneNumber state.State = "send_ask_phone_number"
 	SendActionAskText        state.State = "send_ask_text"
+	SendActionAskSMSC        state.State = "send_ask_smsc"
+
+	CallbackQuerySendPrefix   = "send"
+	CallbackQuerySendTo       = "to"
+	CallbackQuerySendFlash    = "flash"
+	CallbackQuerySendGSM7     = "gsm7"
+	CallbackQuerySendValidity = "validity"
+	CallbackQuerySendSMSC     = "smsc"
+	CallbackQuerySendData     = "data"
+	CallbackQuerySendStore    = "store"
+	CallbackQuerySendConfirm  = "confirm"
+	CallbackQuerySendCancel   = "cancel"
 )
 
-func NewSendHandler() state.Handler {
+// SendSuggestedContacts is how many favourite and recent contacts are offered as recipients.
+const SendSuggestedContacts = 6
+
+// phoneNumber tells a typed number from the name of a contact.
+var phoneNumber = regexp.MustCompile(`^\+?[0-9][0-9 ().-]*$`)
+
+// sendValidities are the validity periods to choose from, zero leaves it to the network.
+var sendValidities = []time.Duration{0, time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+func NewSendHandler(book *contact.Book) state.Handler {
 	h := new(SendHandler)
+	h.book = book
 	return h
 }
 
@@ -34,31 +69,206 @@ func (h *SendHandler) Handle() th.Handler {
 			State:   SendActionAskPhoneNumber,
 			Value:   &SMSValue{Modem: h.Modem(ctx)},
 		})
-		_, err := h.Reply(ctx, update, util.EscapeText("Enter the phone number you want to send the SMS to."), nil)
+		suggestions, err := h.book.Suggestions(SendSuggestedContacts)
+		if err != nil {
+			slog.Warn("Failed to load the contacts", "error", err)
+		}
+		text := util.If(len(suggestions) > 0, "Enter the phone number or the name of a contact you want to send the SMS to, or choose one.",
+			"Enter the phone number or the name of a contact you want to send the SMS to.")
+		_, err = h.Reply(ctx, update, util.EscapeText(text), func(message *telego.SendMessageParams) error {
+			if len(suggestions) > 0 {
+				message.WithReplyMarkup(h.contacts(suggestions))
+			}
+			return nil
+		})
 		return err
 	}
 }
 
+// contacts offers the contacts as recipients, two per row.
+func (h *SendHandler) contacts(contacts []*contact.Contact) *telego.InlineKeyboardMarkup {
+	var rows [][]telego.InlineKeyboardButton
+	for chunk := range slices.Chunk(contacts, 2) {
+		var row []telego.InlineKeyboardButton
+		for _, c := range chunk {
+			row = append(row, telego.InlineKeyboardButton{
+				Text:         util.If(c.Favourite, "⭐ ", "") + c.Name,
+				CallbackData: fmt.Sprintf("%s:%s:%s", CallbackQuerySendPrefix, CallbackQuerySendTo, c.Number),
+			})
+		}
+		rows = append(rows, row)
+	}
+	return tu.InlineKeyboard(rows...)
+}
+
 func (h *SendHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
-	defer state.M.Exit(message.Chat.ID)
 	value := s.Value.(*SMSValue)
-	if s.State == SendActionAskPhoneNumber {
-		value.To = message.Text
-		state.M.Current(message.Chat.ID, SendActionAskText)
-		_, err := h.ReplyMessage(ctx, message, util.EscapeText("Enter the text of the SMS you want to send."), nil)
-		return err
-	}
-	if s.State == SendActionAskText {
-		_, err := value.Modem.SendSMS(value.To, message.Text)
+	switch s.State {
+	case SendActionAskPhoneNumber:
+		if phoneNumber.MatchString(strings.TrimSpace(message.Text)) {
+			value.To = message.Text
+			return h.askText(ctx, message.Chat.ID, message.MessageID, value.To)
+		}
+		contacts, err := h.book.Search(message.Text)
 		if err != nil {
 			return err
 		}
-		_, err = h.ReplyMessage(ctx, message, util.EscapeText("SMS sent successfully."), nil)
+		switch len(contacts) {
+		case 0:
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText("No contact has this name, enter the phone number instead."), nil)
+		case 1:
+			value.To = contacts[0].Number
+			return h.askText(ctx, message.Chat.ID, message.MessageID, value.To)
+		default:
+			_, err = h.ReplyMessage(ctx, message, util.EscapeText("Several contacts match, choose one."), func(params *telego.SendMessageParams) error {
+				params.WithReplyMarkup(h.contacts(contacts[:min(len(contacts), 10)]))
+				return nil
+			})
+		}
 		return err
+	case SendActionAskText:
+		value.Text = message.Text
+	case SendActionAskSMSC:
+		value.Options.SMSC = strings.TrimSpace(message.Text)
+	default:
+		return nil
+	}
+	state.M.Current(message.Chat.ID, "")
+	_, err := h.ReplyMessage(ctx, message, h.message(value), func(params *telego.SendMessageParams) error {
+		params.WithReplyMarkup(h.keyboard(value))
+		return nil
+	})
+	return err
+}
+
+func (h *SendHandler) askText(ctx *th.Context, chatID int64, replyTo int, to string) error {
+	state.M.Current(chatID, SendActionAskText)
+	_, err := h.reply(ctx, chatID, util.EscapeText(fmt.Sprintf("Enter the text of the SMS you want to send to %s.", h.book.Label(to))), replyTo, nil)
+	return err
+}
+
+// message previews the SMS, with the encoding and the number of messages it is split into.
+func (h *SendHandler) message(value *SMSValue) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "To: %s\n", h.book.Label(value.To))
+	if value.Options.Data != nil {
+		fmt.Fprintf(&text, "Data: %d bytes\n%s, %s\n", len(value.Options.Data), modem.SMSEncodingData, h.segments(modem.SMSDataSegments(value.Options.Data)))
+	} else {
+		body := util.If(value.Options.GSM7, modem.ToGSM7(value.Text), value.Text)
+		encoding, segments := modem.SMSSegments(body)
+		fmt.Fprintf(&text, "Text: %s\n%s, %s\n", body, util.If(value.Options.GSM7, "Forced GSM-7", encoding.String()), h.segments(segments))
+	}
+	if value.Options.Flash {
+		text.WriteString("Flash message, shown straight away and not stored\n")
+	}
+	if value.Options.Validity > 0 {
+		fmt.Fprintf(&text, "Valid for %s\n", h.validity(value.Options.Validity))
 	}
-	return nil
+	if value.Options.SMSC != "" {
+		fmt.Fprintf(&text, "SMSC: %s\n", value.Options.SMSC)
+	}
+	if value.Options.Storage != modem.SMSStorageUnknown {
+		fmt.Fprintf(&text, "Saved on the %s instead of being sent\n", value.Options.Storage)
+	}
+	return util.EscapeText(strings.TrimSpace(text.String()))
+}
+
+func (h *SendHandler) segments(segments int) string {
+	if segments == 1 {
+		return "1 message"
+	}
+	return fmt.Sprintf("%d messages", segments)
+}
+
+func (h *SendHandler) validity(validity time.Duration) string {
+	switch {
+	case validity == 0:
+		return "network default"
+	case validity%(7*24*time.Hour) == 0:
+		return fmt.Sprintf("%d %s", validity/(7*24*time.Hour), util.If(validity == 7*24*time.Hour, "week", "weeks"))
+	case validity%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d %s", validity/(24*time.Hour), util.If(validity == 24*time.Hour, "day", "days"))
+	default:
+		return fmt.Sprintf("%d %s", validity/time.Hour, util.If(validity == time.Hour, "hour", "hours"))
+	}
+}
+
+func (h *SendHandler) keyboard(value *SMSValue) *telego.InlineKeyboardMarkup {
+	button := func(text string, action string) telego.InlineKeyboardButton {
+		return telego.InlineKeyboardButton{Text: text, CallbackData: fmt.Sprintf("%s:%s", CallbackQuerySendPrefix, action)}
+	}
+	return tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			button(util.If(value.Options.Flash, "✅ ", "")+"Flash", CallbackQuerySendFlash),
+			button(util.If(value.Options.GSM7, "✅ ", "")+"Force GSM-7", CallbackQuerySendGSM7),
+			button(util.If(value.Options.Data != nil, "✅ ", "")+"Hex data", CallbackQuerySendData),
+		),
+		tu.InlineKeyboardRow(
+			button("Validity: "+h.validity(value.Options.Validity), CallbackQuerySendValidity),
+			button("SMSC", CallbackQuerySendSMSC),
+			button(util.If(value.Options.Storage != modem.SMSStorageUnknown, "✅ ", "")+"Save to SIM", CallbackQuerySendStore),
+		),
+		tu.InlineKeyboardRow(
+			button(util.If(value.Options.Storage != modem.SMSStorageUnknown, "💾 Save", "📤 Send"), CallbackQuerySendConfirm),
+			button("Cancel", CallbackQuerySendCancel),
+		),
+	)
 }
 
 func (h *SendHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
-	return nil
+	value := s.Value.(*SMSValue)
+	chatID := query.Message.GetChat().ID
+	if number, ok := strings.CutPrefix(query.Data[len(CallbackQuerySendPrefix)+1:], CallbackQuerySendTo+":"); ok {
+		if s.State != SendActionAskPhoneNumber {
+			return nil
+		}
+		value.To = number
+		return h.askText(ctx, chatID, query.Message.GetMessageID(), value.To)
+	}
+	switch query.Data[len(CallbackQuerySendPrefix)+1:] {
+	case CallbackQuerySendFlash:
+		value.Options.Flash = !value.Options.Flash
+	case CallbackQuerySendGSM7:
+		value.Options.GSM7 = !value.Options.GSM7
+	case CallbackQuerySendValidity:
+		idx := slices.Index(sendValidities, value.Options.Validity)
+		value.Options.Validity = sendValidities[(idx+1)%len(sendValidities)]
+	case CallbackQuerySendStore:
+		value.Options.Storage = util.If(value.Options.Storage == modem.SMSStorageUnknown, modem.SMSStorageSm, modem.SMSStorageUnknown)
+	case CallbackQuerySendData:
+		if value.Options.Data != nil {
+			value.Options.Data = nil
+			break
+		}
+		data, err := hex.DecodeString(strings.Join(strings.Fields(value.Text), ""))
+		if err != nil || len(data) == 0 {
+			_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText("The text isn't hex encoded data, e.g. 48656C6C6F."), nil)
+			return err
+		}
+		value.Options.Data = data
+	case CallbackQuerySendSMSC:
+		state.M.Current(chatID, SendActionAskSMSC)
+		_, err := h.ReplyCallbackQuery(ctx, query, util.EscapeText("Enter the number of the SMSC to send the SMS through."), nil)
+		return err
+	case CallbackQuerySendCancel:
+		state.M.Exit(chatID)
+		return h.editCallbackQuery(ctx, query, h.message(value)+"\n"+util.EscapeText("Cancelled."), nil)
+	case CallbackQuerySendConfirm:
+		state.M.Exit(chatID)
+		return h.send(ctx, query, value)
+	}
+	return h.editCallbackQuery(ctx, query, h.message(value), h.keyboard(value))
+}
+
+func (h *SendHandler) send(ctx *th.Context, query telego.CallbackQuery, value *SMSValue) error {
+	if _, err := value.Modem.SendMessage(value.To, value.Text, value.Options); err != nil {
+		slog.Warn("Failed to send SMS", "modem", value.Modem.EquipmentIdentifier, "to", value.To, "error", err)
+		return h.editCallbackQuery(ctx, query, h.message(value)+"\n"+util.EscapeText(fmt.Sprintf("Failed: %s", err)), nil)
+	}
+	slog.Info("SMS sent", "modem", value.Modem.EquipmentIdentifier, "to", value.To, "stored", value.Options.Storage != modem.SMSStorageUnknown)
+	if err := h.book.Use(value.To); err != nil {
+		slog.Warn("Failed to update the contact", "number", value.To, "error", err)
+	}
+	return h.editCallbackQuery(ctx, query, h.message(value)+"\n"+util.EscapeText(util.If(value.Options.Storage != modem.SMSStorageUnknown,
+		"SMS saved successfully.", "SMS sent successfully.")), nil)
 }
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/ussd"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type ShortcutHandler struct {
+	*Handler
+	shortcuts *ussd.Shortcuts
+	balances  *ussd.Balances
+}
+
+type ShortcutValue struct {
+	Modem     *modem.Modem
+	Shortcuts []*ussd.Shortcut
+}
+
+const (
+	CallbackQueryShortcutPrefix = "shortcut"
+
+	ShortcutUsage = `Usage:
+/shortcuts - list and run the shortcuts of the SIM
+/shortcuts add <name> <code> [sim] - save a shortcut for the operator, or only for this SIM with sim
+/shortcuts delete <name> [sim] - delete a shortcut
+
+The shortcut named balance is used by /balance.`
+)
+
+func NewShortcutHandler(shortcuts *ussd.Shortcuts, balances *ussd.Balances) state.Handler {
+	h := new(ShortcutHandler)
+	h.shortcuts = shortcuts
+	h.balances = balances
+	return h
+}
+
+func (h *ShortcutHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		m := h.Modem(ctx)
+		args := strings.Fields(update.Message.Text)[1:]
+		if len(args) > 0 {
+			text, err := h.manage(m, args)
+			if err != nil {
+				text = err.Error()
+			}
+			_, err = h.Reply(ctx, update, util.EscapeText(text), nil)
+			return err
+		}
+		shortcuts, err := h.shortcuts.For(m.Sim.Identifier, ussd.Operator(m))
+		if err != nil {
+			return err
+		}
+		if len(shortcuts) == 0 {
+			_, err = h.Reply(ctx, update, util.EscapeText("There are no shortcuts for this SIM yet.\n\n"+ShortcutUsage), nil)
+			return err
+		}
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			Value:   &ShortcutValue{Modem: m, Shortcuts: shortcuts},
+		})
+		var buttons [][]telego.InlineKeyboardButton
+		for idx, shortcut := range shortcuts {
+			buttons = append(buttons, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+				Text:         fmt.Sprintf("%s (%s)", shortcut.Name, shortcut.Code),
+				CallbackData: fmt.Sprintf("%s:%d", CallbackQueryShortcutPrefix, idx),
+			}))
+		}
+		_, err = h.Reply(ctx, update, util.EscapeText("Which shortcut do you want to run?"), func(message *telego.SendMessageParams) error {
+			message.ReplyMarkup = tu.InlineKeyboard(buttons...)
+			return nil
+		})
+		return err
+	}
+}
+
+func (h *ShortcutHandler) manage(m *modem.Modem, args []string) (string, error) {
+	action, args := args[0], args[1:]
+	sim := len(args) > 0 && strings.EqualFold(args[len(args)-1], "sim")
+	if sim {
+		args = args[:len(args)-1]
+	}
+	shortcut := &ussd.Shortcut{
+		ICCID:    util.If(sim, m.Sim.Identifier, ""),
+		Operator: util.If(sim, "", ussd.Operator(m)),
+	}
+	switch {
+	case action == "add" && len(args) == 2:
+		shortcut.Name, shortcut.Code = args[0], args[1]
+		if err := h.shortcuts.Save(shortcut); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("The shortcut %s (%s) has been saved for %s.", shortcut.Name, shortcut.Code, shortcut.Scope()), nil
+	case action == "delete" && len(args) == 1:
+		shortcut.Name = args[0]
+		if err := h.shortcuts.Delete(shortcut); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("The shortcut %s has been deleted for %s.", shortcut.Name, shortcut.Scope()), nil
+	}
+	return ShortcutUsage, nil
+}
+
+func (h *ShortcutHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	defer state.M.Exit(query.From.ID)
+	value := s.Value.(*ShortcutValue)
+	idx, err := strconv.Atoi(query.Data[len(CallbackQueryShortcutPrefix)+1:])
+	if err != nil || idx < 0 || idx >= len(value.Shortcuts) {
+		return errors.New("invalid shortcut")
+	}
+	shortcut := value.Shortcuts[idx]
+	var text string
+	if shortcut.Name == ussd.BalanceShortcut {
+		text, err = h.balance(value.Modem)
+	} else {
+		text, err = ussd.Run(value.Modem, shortcut.Code)
+	}
+	if err != nil {
+		text = err.Error()
+	}
+	_, err = h.ReplyCallbackQuery(ctx, query, util.EscapeText(text), nil)
+	return err
+}
+
+// balance runs the balance shortcut like /balance does, so the result ends up in the history too.
+func (h *ShortcutHandler) balance(m *modem.Modem) (string, error) {
+	balance, err := ussd.CheckBalance(m, h.shortcuts, h.balances)
+	if balance == nil {
+		return "", err
+	}
+	if err != nil {
+		return fmt.Sprintf("%s\n\n(%s)", balance.Response, err), nil
+	}
+	return balance.Response, nil
+}
+
+func (h *ShortcutHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	return nil
+}
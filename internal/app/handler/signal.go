@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+type SignalHandler struct {
+	*Handler
+}
+
+type SignalValue struct {
+	Modem   *modem.Modem
+	Message *telego.Message
+	stop    chan struct{}
+	once    sync.Once
+}
+
+const (
+	CallbackQuerySignalPrefix = "signal"
+
+	SignalRefreshInterval = 3 * time.Second
+	SignalLiveTimeout     = 10 * time.Minute
+)
+
+func NewSignalHandler() state.Handler {
+	h := new(SignalHandler)
+	return h
+}
+
+func (h *SignalHandler) Handle() th.Handler {
+	return func(ctx *th.Context, update telego.Update) error {
+		value := &SignalValue{Modem: h.Modem(ctx), stop: make(chan struct{})}
+		// Poll faster while the message is live, the background rate is too slow to position an antenna.
+		if err := value.Modem.SetupSignal(modem.LiveSignalRate); err != nil {
+			slog.Warn("Failed to set up extended signal metrics", "error", err)
+		}
+		var err error
+		value.Message, err = h.Reply(ctx, update, h.message(value.Modem, true), h.stopButton)
+		if err != nil {
+			return err
+		}
+		state.M.Enter(update.Message.Chat.ID, &state.ChatState{
+			Handler: h,
+			Value:   value,
+		})
+		go h.watch(ctx.Bot(), update.Message.Chat.ID, value)
+		return nil
+	}
+}
+
+// watch edits the message with the latest metrics until it is stopped, times out or another command takes over the chat.
+func (h *SignalHandler) watch(bot *telego.Bot, chatID int64, value *SignalValue) {
+	defer h.close(bot, chatID, value)
+	ticker := time.NewTicker(SignalRefreshInterval)
+	defer ticker.Stop()
+	timeout := time.After(SignalLiveTimeout)
+	for {
+		select {
+		case <-value.stop:
+			return
+		case <-timeout:
+			return
+		case <-ticker.C:
+			if s, ok := state.M.Get(chatID); !ok || s.Value != value {
+				return
+			}
+			if err := h.edit(bot, value, true); err != nil {
+				slog.Warn("Failed to update the signal message", "error", err)
+			}
+		}
+	}
+}
+
+func (h *SignalHandler) close(bot *telego.Bot, chatID int64, value *SignalValue) {
+	state.M.ExitIf(chatID, value)
+	if err := value.Modem.SetupSignal(modem.DefaultSignalRate); err != nil {
+		slog.Warn("Failed to restore the signal refresh rate", "error", err)
+	}
+	if err := h.edit(bot, value, false); err != nil {
+		slog.Warn("Failed to update the signal message", "error", err)
+	}
+}
+
+func (h *SignalHandler) edit(bot *telego.Bot, value *SignalValue, live bool) error {
+	params := &telego.EditMessageTextParams{
+		ChatID:    value.Message.Chat.ChatID(),
+		MessageID: value.Message.MessageID,
+		Text:      h.message(value.Modem, live),
+		ParseMode: telego.ModeMarkdownV2,
+	}
+	if live {
+		params.ReplyMarkup = h.keyboard()
+	}
+	_, err := bot.EditMessageText(context.Background(), params)
+	return err
+}
+
+func (h *SignalHandler) message(m *modem.Modem, live bool) string {
+	var text strings.Builder
+	if percent, _, err := m.SignalQuality(); err == nil {
+		bars := min(int(percent+5)/10, 10)
+		fmt.Fprintf(&text, "Signal: %s %d%%\n", strings.Repeat("⣿", bars)+strings.Repeat("⣀", 10-bars), percent)
+	}
+	metrics, err := m.Signal()
+	if err != nil || len(metrics) == 0 {
+		text.WriteString("The modem didn't report any radio metrics yet.\n")
+	}
+	for _, s := range metrics {
+		text.WriteString(s.String() + "\n")
+	}
+	if live {
+		fmt.Fprintf(&text, "\nUpdated at %s, press Stop when you are done.", time.Now().Format(time.TimeOnly))
+	} else {
+		fmt.Fprintf(&text, "\nStopped at %s.", time.Now().Format(time.TimeOnly))
+	}
+	return util.EscapeText(text.String())
+}
+
+func (h *SignalHandler) keyboard() *telego.InlineKeyboardMarkup {
+	return tu.InlineKeyboard(tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+		Text:         "Stop",
+		CallbackData: CallbackQuerySignalPrefix + ":stop",
+	}))
+}
+
+func (h *SignalHandler) stopButton(message *telego.SendMessageParams) error {
+	message.WithReplyMarkup(h.keyboard())
+	return nil
+}
+
+func (h *SignalHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	value := s.Value.(*SignalValue)
+	value.once.Do(func() { close(value.stop) })
+	return nil
+}
+
+func (h *SignalHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	return nil
+}
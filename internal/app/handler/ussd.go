@@ -1,11 +1,22 @@
 package handler
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/app/middleware"
 	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/config"
 	"github.com/damonto/telegram-sms/internal/pkg/modem"
 	"github.com/damonto/telegram-sms/internal/pkg/util"
 	"github.com/mymmrac/telego"
 	th "github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
 )
 
 type USSDHandler struct {
@@ -15,15 +26,89 @@ type USSDHandler struct {
 type USSDValue struct {
 	Command string
 	Modem   *modem.Modem
+	mutex   sync.Mutex
+	timer   *time.Timer
 }
 
-const USSDActionRespond state.State = "ussd_respond"
+const (
+	USSDActionRespond state.State = "ussd_respond"
+
+	CallbackQueryUSSDPrefix = "ussd"
+	// CallbackQueryUSSDNetworkPrefix is carried by the reply button of a forwarded network request, followed by the IMEI.
+	CallbackQueryUSSDNetworkPrefix = "ussd_network"
+
+	// USSDSessionTimeout is how long a session waiting for our response is kept open,
+	// most networks drop it on their side after a few minutes anyway.
+	USSDSessionTimeout = 2 * time.Minute
+)
+
+// ussdOption matches numbered menu entries such as "1. Balance", "2) Data" or "3 - My number".
+var ussdOption = regexp.MustCompile(`(?m)^\s*(\d{1,3})\s*[.):\-]\s*(\S.*?)\s*$`)
 
 func NewUSSDHandler() state.Handler {
 	h := new(USSDHandler)
 	return h
 }
 
+// NewUSSDNetworkRequestHandler lets an admin answer a request pushed by the network from the forwarded message.
+func NewUSSDNetworkRequestHandler(mm *modem.Manager) th.CallbackQueryHandler {
+	h := new(USSDHandler)
+	return func(ctx *th.Context, query telego.CallbackQuery) error {
+		if err := ctx.Bot().AnswerCallbackQuery(ctx, &telego.AnswerCallbackQueryParams{
+			CallbackQueryID: query.ID,
+		}); err != nil {
+			return err
+		}
+		if !slices.Contains(config.C.AdminId.MarshalInt64(), query.From.ID) {
+			return middleware.ErrPermissionDenied
+		}
+		chatID := query.Message.GetChat().ID
+		m, err := h.find(mm, query.Data[len(CallbackQueryUSSDNetworkPrefix)+1:])
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			_, err = h.reply(ctx, chatID, util.EscapeText("The modem is no longer available."), query.Message.GetMessageID(), nil)
+			return err
+		}
+		if current, err := m.USSDState(); err != nil || current != modem.Modem3gppUssdSessionStateUserResponse {
+			_, err = h.reply(ctx, chatID, util.EscapeText("The network request has expired."), query.Message.GetMessageID(), nil)
+			return err
+		}
+		request, err := m.USSDNetworkRequest()
+		if err != nil {
+			return err
+		}
+		value := &USSDValue{Modem: m}
+		state.M.Enter(chatID, &state.ChatState{
+			Handler: h,
+			State:   USSDActionRespond,
+			Value:   value,
+		})
+		h.watch(ctx.Bot(), chatID, value)
+		_, err = h.reply(ctx, chatID, util.EscapeText("Okay, send me your response."), query.Message.GetMessageID(), func(message *telego.SendMessageParams) error {
+			if buttons := h.options(request); len(buttons) > 0 {
+				message.WithReplyMarkup(tu.InlineKeyboard(buttons...))
+			}
+			return nil
+		})
+		return err
+	}
+}
+
+func (h *USSDHandler) find(mm *modem.Manager, imei string) (*modem.Modem, error) {
+	modems, err := mm.Modems()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range modems {
+		if m.EquipmentIdentifier == imei {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
 func (h *USSDHandler) Handle() th.Handler {
 	return func(ctx *th.Context, update telego.Update) error {
 		m := h.Modem(ctx)
@@ -46,38 +131,120 @@ func (h *USSDHandler) Handle() th.Handler {
 }
 
 func (h *USSDHandler) HandleMessage(ctx *th.Context, message telego.Message, s *state.ChatState) error {
-	if s.State != USSDActionRespond {
-		return h.initiate(ctx, message, s)
-	}
 	if s.State == USSDActionRespond {
-		return h.respond(ctx, message, s)
+		return h.respond(ctx, message.Chat.ID, message.MessageID, message.Text, s)
+	}
+	return h.initiate(ctx, message, s)
+}
+
+func (h *USSDHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
+	if s.State != USSDActionRespond || len(query.Data) <= len(CallbackQueryUSSDPrefix)+1 {
+		return nil
 	}
-	return nil
+	return h.respond(ctx, query.Message.GetChat().ID, query.Message.GetMessageID(), query.Data[len(CallbackQueryUSSDPrefix)+1:], s)
 }
 
-func (h *USSDHandler) respond(ctx *th.Context, message telego.Message, s *state.ChatState) error {
-	m := s.Value.(*USSDValue).Modem
-	response, err := m.RespondUSSD(message.Text)
+func (h *USSDHandler) initiate(ctx *th.Context, message telego.Message, s *state.ChatState) error {
+	value := s.Value.(*USSDValue)
+	value.Command = message.Text
+	response, err := value.Modem.InitiateUSSD(message.Text)
 	if err != nil {
-		h.ReplyMessage(ctx, message, util.EscapeText(err.Error()), nil)
+		state.M.Exit(message.Chat.ID)
+		_, err = h.ReplyMessage(ctx, message, util.EscapeText(err.Error()), nil)
 		return err
 	}
-	_, err = h.ReplyMessage(ctx, message, util.EscapeText(response), nil)
-	return err
+	return h.show(ctx, message.Chat.ID, message.MessageID, response, s)
 }
 
-func (h *USSDHandler) initiate(ctx *th.Context, message telego.Message, s *state.ChatState) error {
-	m := s.Value.(*USSDValue).Modem
-	response, err := m.InitiateUSSD(message.Text)
+func (h *USSDHandler) respond(ctx *th.Context, chatID int64, replyTo int, text string, s *state.ChatState) error {
+	value := s.Value.(*USSDValue)
+	// The network may have closed the session while we were waiting for the user.
+	if current, err := value.Modem.USSDState(); err != nil || current != modem.Modem3gppUssdSessionStateUserResponse {
+		h.stop(value)
+		state.M.Exit(chatID)
+		_, err := h.reply(ctx, chatID, util.EscapeText("The USSD session has ended. /ussd"), replyTo, nil)
+		return err
+	}
+	response, err := value.Modem.RespondUSSD(text)
+	if err != nil {
+		h.stop(value)
+		state.M.Exit(chatID)
+		_, err = h.reply(ctx, chatID, util.EscapeText(err.Error()), replyTo, nil)
+		return err
+	}
+	return h.show(ctx, chatID, replyTo, response, s)
+}
+
+// show shows the network's answer and keeps the chat in the session only while the network waits for a response.
+func (h *USSDHandler) show(ctx *th.Context, chatID int64, replyTo int, response string, s *state.ChatState) error {
+	value := s.Value.(*USSDValue)
+	current, err := value.Modem.USSDState()
 	if err != nil {
-		h.ReplyMessage(ctx, message, util.EscapeText(err.Error()), nil)
 		return err
 	}
-	state.M.Current(message.Chat.ID, USSDActionRespond)
-	_, err = h.ReplyMessage(ctx, message, util.EscapeText(response), nil)
+	if current != modem.Modem3gppUssdSessionStateUserResponse {
+		h.stop(value)
+		state.M.Exit(chatID)
+		_, err = h.reply(ctx, chatID, util.EscapeText(response), replyTo, nil)
+		return err
+	}
+	if response == "" {
+		if response, err = value.Modem.USSDNetworkRequest(); err != nil {
+			return err
+		}
+	}
+	state.M.Current(chatID, USSDActionRespond)
+	h.watch(ctx.Bot(), chatID, value)
+	_, err = h.reply(ctx, chatID, util.EscapeText(response), replyTo, func(message *telego.SendMessageParams) error {
+		if buttons := h.options(response); len(buttons) > 0 {
+			message.WithReplyMarkup(tu.InlineKeyboard(buttons...))
+		}
+		return nil
+	})
 	return err
 }
 
-func (h *USSDHandler) HandleCallbackQuery(ctx *th.Context, query telego.CallbackQuery, s *state.ChatState) error {
-	return nil
+func (h *USSDHandler) options(response string) [][]telego.InlineKeyboardButton {
+	var buttons [][]telego.InlineKeyboardButton
+	for _, match := range ussdOption.FindAllStringSubmatch(response, -1) {
+		buttons = append(buttons, tu.InlineKeyboardRow(telego.InlineKeyboardButton{
+			Text:         fmt.Sprintf("%s. %s", match[1], match[2]),
+			CallbackData: fmt.Sprintf("%s:%s", CallbackQueryUSSDPrefix, match[1]),
+		}))
+	}
+	return buttons
+}
+
+// watch (re)starts the idle timer, an abandoned session is cancelled so the modem can start a new one.
+func (h *USSDHandler) watch(bot *telego.Bot, chatID int64, value *USSDValue) {
+	value.mutex.Lock()
+	defer value.mutex.Unlock()
+	if value.timer != nil {
+		value.timer.Reset(USSDSessionTimeout)
+		return
+	}
+	value.timer = time.AfterFunc(USSDSessionTimeout, func() {
+		if !state.M.ExitIf(chatID, value) {
+			return
+		}
+		if current, err := value.Modem.USSDState(); err == nil && current != modem.Modem3gppUssdSessionStateIdle {
+			if err := value.Modem.CancelUSSD(); err != nil {
+				slog.Warn("Failed to cancel USSD session", "error", err)
+			}
+		}
+		if _, err := bot.SendMessage(context.Background(), tu.Message(
+			tu.ID(chatID),
+			"The USSD session has been cancelled after being idle for "+idleFor(USSDSessionTimeout)+". /ussd",
+		)); err != nil {
+			slog.Error("Failed to send message", "error", err)
+		}
+	})
+}
+
+func (h *USSDHandler) stop(value *USSDValue) {
+	value.mutex.Lock()
+	defer value.mutex.Unlock()
+	if value.timer != nil {
+		value.timer.Stop()
+	}
 }
@@ -49,6 +49,7 @@ func (m *ModemRequiredMiddleware) Middleware(eUICCRequired bool) th.Handler {
 				if err != nil {
 					delete(modems, path)
 					slog.Error("Failed to create LPA", "error", err)
+					continue
 				}
 				slog.Info("The SIM card is an eUICC", "objectPath", path)
 				l.Close()
@@ -9,23 +9,31 @@ import (
 	"github.com/damonto/telegram-sms/internal/app/handler"
 	"github.com/damonto/telegram-sms/internal/app/middleware"
 	"github.com/damonto/telegram-sms/internal/app/state"
+	"github.com/damonto/telegram-sms/internal/pkg/contact"
 	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/ussd"
 	"github.com/mymmrac/telego"
 	th "github.com/mymmrac/telego/telegohandler"
 )
 
 type router struct {
 	*th.BotHandler
-	bot *telego.Bot
-	mm  *modem.Manager
-	sm  *state.StateManager
+	bot       *telego.Bot
+	mm        *modem.Manager
+	book      *contact.Book
+	shortcuts *ussd.Shortcuts
+	balances  *ussd.Balances
+	sm        *state.StateManager
 }
 
-func NewRouter(bot *telego.Bot, handler *th.BotHandler, mm *modem.Manager) *router {
-	return &router{bot: bot, BotHandler: handler, mm: mm, sm: state.NewStateManager(handler)}
+func NewRouter(bot *telego.Bot, handler *th.BotHandler, mm *modem.Manager, book *contact.Book, shortcuts *ussd.Shortcuts, balances *ussd.Balances) *router {
+	return &router{bot: bot, BotHandler: handler, mm: mm, book: book, shortcuts: shortcuts, balances: balances, sm: state.NewStateManager(handler)}
 }
 
 func (r *router) Register() {
+	// Callbacks not bound to a chat state must be registered before the state manager takes over the rest.
+	r.HandleCallbackQuery(handler.NewUSSDNetworkRequestHandler(r.mm), th.CallbackDataPrefix(handler.CallbackQueryUSSDNetworkPrefix))
+	r.HandleCallbackQuery(handler.NewBulkStopHandler(), th.CallbackDataPrefix(handler.CallbackQueryBulkStopPrefix))
 	r.sm.RegisterCallback(r.BotHandler)
 	r.registerCommands()
 	r.registerHandlers()
@@ -38,9 +46,24 @@ func (r *router) registerCommands() {
 		{Command: "modem", Description: "List all plugged in modems"},
 		{Command: "slot", Description: "List all SIM slots on the modem"},
 		{Command: "chip", Description: "Get the eUICC chip information"},
+		{Command: "network", Description: "Scan for networks and pick one to register to"},
+		{Command: "signal", Description: "Watch the signal of the modem live"},
+		{Command: "mode", Description: "Choose the radio modes the modem may use"},
+		{Command: "bands", Description: "Choose the frequency bands the modem may use"},
 		{Command: "ussd", Description: "Send a USSD command to the carrier"},
+		{Command: "shortcuts", Description: "Run or manage the saved USSD shortcuts"},
+		{Command: "balance", Description: "Check the balance of every SIM"},
 		{Command: "send", Description: "Send an SMS to a phone number"},
+		{Command: "bulk", Description: "Send SMS to the numbers in a CSV file"},
+		{Command: "contacts", Description: "Find, add, edit or import contacts"},
+		{Command: "phonebook", Description: "List, edit or export the phonebook on the SIM"},
+		{Command: "calls", Description: "Choose what happens to incoming calls"},
+		{Command: "call", Description: "Call a phone number and send DTMF tones"},
+		{Command: "broadcast", Description: "Choose the cell broadcast channels to forward"},
 		{Command: "msisdn", Description: "Update the MSISDN(phone number) on the SIM"},
+		{Command: "pin", Description: "Unlock the SIM or manage its PIN"},
+		{Command: "at", Description: "Open an AT command session on the modem"},
+		{Command: "apdu", Description: "Send raw APDUs to the SIM"},
 		{Command: "profiles", Description: "List all profiles on the eUICC"},
 		{Command: "download", Description: "Download a profile into the eUICC"},
 	}
@@ -63,14 +86,29 @@ func (r *router) registerHandlers() {
 	admin := r.Group(th.Not(th.CommandEqual("start")))
 	admin.Use(middleware.Admin())
 	admin.Handle(handler.NewListModemHandler(r.mm).Handle(), th.CommandEqual("modem"))
+	admin.Handle(handler.NewBalanceHandler(r.mm, r.shortcuts, r.balances).Handle(), th.CommandEqual("balance"))
+	admin.Handle(handler.NewBulkHandler(r.mm).Handle(), th.CommandEqual("bulk"))
+	admin.Handle(handler.NewContactsHandler(r.mm, r.book).Handle(), th.CommandEqual("contacts"))
 
 	{
-		standard := admin.Group(r.predicate([]string{"/send", "/slot", "/ussd", "/msisdn"}))
+		standard := admin.Group(r.predicate([]string{"/send", "/slot", "/ussd", "/msisdn", "/at", "/apdu", "/shortcuts", "/signal", "/network", "/mode", "/bands", "/pin", "/calls", "/call", "/broadcast", "/phonebook"}))
 		standard.Use(modemRequiredMiddleware.Middleware(false))
 		standard.Handle(handler.NewSIMSlotHandler().Handle(), th.CommandEqual("slot"))
 		standard.Handle(handler.NewUSSDHandler().Handle(), th.CommandEqual("ussd"))
-		standard.Handle(handler.NewSendHandler().Handle(), th.CommandEqual("send"))
+		standard.Handle(handler.NewSendHandler(r.book).Handle(), th.CommandEqual("send"))
 		standard.Handle(handler.NewMSISDNHandler().Handle(), th.CommandEqual("msisdn"))
+		standard.Handle(handler.NewATHandler().Handle(), th.CommandEqual("at"))
+		standard.Handle(handler.NewAPDUHandler().Handle(), th.CommandEqual("apdu"))
+		standard.Handle(handler.NewShortcutHandler(r.shortcuts, r.balances).Handle(), th.CommandEqual("shortcuts"))
+		standard.Handle(handler.NewSignalHandler().Handle(), th.CommandEqual("signal"))
+		standard.Handle(handler.NewNetworkHandler().Handle(), th.CommandEqual("network"))
+		standard.Handle(handler.NewModeHandler().Handle(), th.CommandEqual("mode"))
+		standard.Handle(handler.NewBandsHandler().Handle(), th.CommandEqual("bands"))
+		standard.Handle(handler.NewPINHandler().Handle(), th.CommandEqual("pin"))
+		standard.Handle(handler.NewCallPolicyHandler().Handle(), th.CommandEqual("calls"))
+		standard.Handle(handler.NewCallHandler().Handle(), th.CommandEqual("call"))
+		standard.Handle(handler.NewCellBroadcastHandler().Handle(), th.CommandEqual("broadcast"))
+		standard.Handle(handler.NewPhonebookHandler(r.book).Handle(), th.CommandEqual("phonebook"))
 	}
 
 	{
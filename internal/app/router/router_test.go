@@ -0,0 +1,115 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mymmrac/telego"
+	th "github.com/mymmrac/telego/telegohandler"
+
+	"github.com/damonto/telegram-sms/internal/app/handler"
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/contact"
+	"github.com/damonto/telegram-sms/internal/pkg/fake/telegram"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/simulator"
+	"github.com/damonto/telegram-sms/internal/pkg/ussd"
+)
+
+const adminID = 42
+
+// reply waits for the next message the bot sends, skipping the ones match rejects.
+func reply(t *testing.T, server *telegram.Server, match func(m *telego.Message) bool) *telego.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+		message, err := server.WaitForMessage(ctx)
+		if err != nil {
+			t.Fatalf("no reply from the bot: %v", err)
+		}
+		if match(message) {
+			return message
+		}
+	}
+}
+
+// text matches the messages containing the text, which must have no character MarkdownV2 escapes.
+func text(contains string) func(m *telego.Message) bool {
+	return func(m *telego.Message) bool { return strings.Contains(m.Text, contains) }
+}
+
+func TestRouter(t *testing.T) {
+	sim, err := simulator.New(&simulator.Scenario{Modems: []*simulator.ModemSpec{{
+		ID:                 "alpha",
+		IMEI:               "861234567890123",
+		OperatorCode:       "22210",
+		OperatorName:       "Vodafone",
+		Registration:       "home",
+		AccessTechnologies: []string{"lte"},
+		SIM: simulator.SIMSpec{
+			ICCID:              "8939100000000000077",
+			IMSI:               "222100000000077",
+			OperatorIdentifier: "22210",
+			Phonebook:          []*simulator.PhonebookSpec{{Name: "Voicemail", Number: "+393492000200"}},
+		},
+	}}})
+	if err != nil {
+		t.Skipf("the simulator can't be started: %v", err)
+	}
+	defer sim.Close()
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", sim.Address)
+	mm, err := modem.NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := telegram.NewServer()
+	defer server.Close()
+	defer server.Use()()
+	admins, dataDir := config.C.AdminId, config.C.DataDir
+	config.C.AdminId, config.C.DataDir = config.AdminId{fmt.Sprint(adminID)}, t.TempDir()
+	defer func() { config.C.AdminId, config.C.DataDir = admins, dataDir }()
+
+	// The bot is built the way main builds it, from the endpoint in the configuration.
+	bot, err := telego.NewBot(config.C.BotToken, telego.WithAPIServer(config.C.Endpoint), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := bot.UpdatesViaLongPolling(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bh, err := th.NewBotHandler(bot, updates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewRouter(bot, bh, mm, contact.NewBook(config.C.DataPath("contacts.json")), ussd.NewShortcuts(config.C.DataPath("shortcuts.json")), ussd.NewBalances(config.C.DataPath("balances.jsonl"))).Register()
+	go bh.Start()
+	defer bh.Stop()
+
+	server.SendMessage(adminID, "/contacts")
+	reply(t, server, text("There are no contacts yet"))
+
+	// The file is fetched with getFile and downloaded from the file endpoint.
+	server.SendDocument(adminID, "contacts.vcf", []byte("BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Anna\r\nTEL:+14155550100\r\nEND:VCARD\r\n"))
+	reply(t, server, text("1 contacts added and 0 renamed"))
+	server.SendMessage(adminID, "Anna")
+	reply(t, server, text("14155550100"))
+
+	server.SendMessage(adminID, "/phonebook")
+	list := reply(t, server, text("Voicemail"))
+	server.Click(adminID, *list, fmt.Sprintf("%s:%s", handler.CallbackQueryPhonebookPrefix, handler.CallbackQueryPhonebookExport))
+	export := reply(t, server, func(m *telego.Message) bool { return m.Document != nil })
+	if !strings.HasSuffix(export.Document.FileName, ".vcf") {
+		t.Errorf("exported file name = %q, want a vCard", export.Document.FileName)
+	}
+	if data, _ := server.File(export.Document.FileID); !strings.Contains(string(data), "+393492000200") {
+		t.Errorf("exported phonebook = %q, want the voicemail number", data)
+	}
+}
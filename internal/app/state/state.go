@@ -79,10 +79,24 @@ func (m *StateManager) Current(chatId int64, current State) {
 }
 
 func (m *StateManager) Get(chatID int64) (*ChatState, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	state, ok := m.states[chatID]
 	return state, ok
 }
 
+// ExitIf leaves the state of the chat only if its value is still value, another command may have taken over
+// the chat in the meantime. It reports whether the state was left.
+func (m *StateManager) ExitIf(chatID int64, value any) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if state, ok := m.states[chatID]; !ok || state.Value != value {
+		return false
+	}
+	delete(m.states, chatID)
+	return true
+}
+
 func (m *StateManager) Exit(chatID int64) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
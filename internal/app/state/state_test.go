@@ -0,0 +1,43 @@
+package state
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestExitIf(t *testing.T) {
+	m := NewStateManager(nil)
+	mine, theirs := new(int), new(int)
+	m.Enter(1, &ChatState{Value: theirs})
+	if m.ExitIf(1, mine) {
+		t.Fatal("ExitIf() left the state of another command")
+	}
+	if _, ok := m.Get(1); !ok {
+		t.Fatal("the state of another command is gone")
+	}
+	if !m.ExitIf(1, theirs) {
+		t.Fatal("ExitIf() didn't leave its own state")
+	}
+	if m.ExitIf(1, theirs) {
+		t.Fatal("ExitIf() left a state that was already left")
+	}
+}
+
+func TestExitIfConcurrent(t *testing.T) {
+	m := NewStateManager(nil)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		value := new(int)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.Enter(int64(i%2), &ChatState{Value: value})
+			m.Get(int64(i % 2))
+		}()
+		go func() {
+			defer wg.Done()
+			m.ExitIf(int64(i%2), value)
+		}()
+	}
+	wg.Wait()
+}
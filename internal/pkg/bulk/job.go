@@ -0,0 +1,104 @@
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+)
+
+type Status string
+
+const (
+	StatusSent      Status = "sent"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Result is the outcome of a message, the messages left when a job is cancelled stay cancelled.
+type Result struct {
+	*Message
+	Modem  *modem.Modem
+	Status Status
+	Err    error
+	Time   time.Time
+}
+
+// Job sends messages one at a time with a pause in between, sending them in a burst gets SIMs blocked for spam.
+type Job struct {
+	Messages []*Message
+	// Modems take turns sending the messages, a single modem sends them all.
+	Modems   []*modem.Modem
+	Interval time.Duration
+}
+
+// Run sends the messages until all of them are sent or ctx is cancelled, progress is called after every message.
+func (j *Job) Run(ctx context.Context, progress func(results []*Result)) []*Result {
+	results := make([]*Result, len(j.Messages))
+	for i, message := range j.Messages {
+		results[i] = &Result{Message: message, Status: StatusCancelled}
+	}
+	for i, result := range results {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(j.Interval):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		result.Modem = j.Modems[i%len(j.Modems)]
+		result.Time = time.Now()
+		if _, result.Err = result.Modem.SendSMS(result.Number, result.Text); result.Err != nil {
+			slog.Warn("Failed to send bulk SMS", "modem", result.Modem.EquipmentIdentifier, "line", result.Line, "to", result.Number, "error", result.Err)
+			result.Status = StatusFailed
+		} else {
+			result.Status = StatusSent
+		}
+		if progress != nil {
+			progress(results)
+		}
+	}
+	return results
+}
+
+// Count returns how many of the messages were sent and how many failed.
+func Count(results []*Result) (sent int, failed int) {
+	for _, result := range results {
+		switch result.Status {
+		case StatusSent:
+			sent++
+		case StatusFailed:
+			failed++
+		}
+	}
+	return sent, failed
+}
+
+// WriteResults writes a CSV file with the outcome of every row.
+func WriteResults(w io.Writer, results []*Result) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"line", "number", "modem", "status", "error", "time"}); err != nil {
+		return err
+	}
+	for _, result := range results {
+		var m, reason, sent string
+		if result.Modem != nil {
+			m = result.Modem.EquipmentIdentifier
+			sent = result.Time.Format(time.RFC3339)
+		}
+		if result.Err != nil {
+			reason = result.Err.Error()
+		}
+		if err := writer.Write([]string{strconv.Itoa(result.Line), result.Number, m, string(result.Status), reason, sent}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,142 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/simulator"
+)
+
+func TestJobRun(t *testing.T) {
+	spec := func(id string, imei string, iccid string) *simulator.ModemSpec {
+		return &simulator.ModemSpec{
+			ID:                 id,
+			IMEI:               imei,
+			OperatorCode:       "22210",
+			OperatorName:       "Vodafone",
+			Registration:       "home",
+			AccessTechnologies: []string{"lte"},
+			SIM:                simulator.SIMSpec{ICCID: iccid, IMSI: "2221000000" + iccid[len(iccid)-5:], OperatorIdentifier: "22210"},
+		}
+	}
+	sim, err := simulator.New(&simulator.Scenario{Modems: []*simulator.ModemSpec{
+		spec("alpha", "861234567890123", "8939100000000000077"),
+		spec("beta", "861234567890456", "8939100000000000088"),
+	}})
+	if err != nil {
+		t.Skipf("the simulator can't be started: %v", err)
+	}
+	defer sim.Close()
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", sim.Address)
+	mm, err := modem.NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := mm.Modems()
+	if err != nil || len(found) != 2 {
+		t.Fatalf("Modems() = %v, %v, want two modems", found, err)
+	}
+	modems := slices.SortedFunc(maps.Values(found), func(a, b *modem.Modem) int {
+		return strings.Compare(a.EquipmentIdentifier, b.EquipmentIdentifier)
+	})
+	messages := func(numbers ...string) []*Message {
+		var messages []*Message
+		for idx, number := range numbers {
+			messages = append(messages, &Message{Line: idx + 2, Number: number, Text: "Hi"})
+		}
+		return messages
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		job := &Job{
+			Messages: messages("+393330000001", "+393330000002", "", "+393330000004", "+393330000005"),
+			Modems:   modems,
+			Interval: time.Millisecond,
+		}
+		var calls int
+		results := job.Run(context.Background(), func(results []*Result) { calls++ })
+		if calls != len(results) {
+			t.Errorf("progress called %d times, want %d", calls, len(results))
+		}
+		for idx, result := range results {
+			if want := modems[idx%len(modems)]; result.Modem != want {
+				t.Errorf("message %d sent by %v, want %s", idx, result.Modem, want.EquipmentIdentifier)
+			}
+			want := StatusSent
+			if result.Number == "" {
+				want = StatusFailed
+			}
+			if result.Status != want || (want == StatusFailed) != (result.Err != nil) {
+				t.Errorf("message %d = %s, %v, want %s", idx, result.Status, result.Err, want)
+			}
+		}
+		if sent, failed := Count(results); sent != 4 || failed != 1 {
+			t.Errorf("Count() = %d, %d, want 4, 1", sent, failed)
+		}
+		for idx, m := range modems {
+			sent, err := m.ListMessages()
+			if err != nil {
+				t.Fatalf("ListMessages() error = %v", err)
+			}
+			var numbers []string
+			for _, sms := range sent {
+				numbers = append(numbers, sms.Number)
+			}
+			slices.Sort(numbers)
+			want := [][]string{{"+393330000001", "+393330000005"}, {"+393330000002", "+393330000004"}}[idx]
+			if !slices.Equal(numbers, want) {
+				t.Errorf("modem %s sent to %v, want %v", m.EquipmentIdentifier, numbers, want)
+			}
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		job := &Job{
+			Messages: messages("+393330000011", "+393330000012", "+393330000013"),
+			Modems:   modems[:1],
+			Interval: time.Hour,
+		}
+		done := make(chan []*Result)
+		go func() {
+			done <- job.Run(ctx, func(results []*Result) { cancel() })
+		}()
+		var results []*Result
+		select {
+		case results = <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Run() kept waiting after the job was cancelled")
+		}
+		if results[0].Status != StatusSent {
+			t.Errorf("message 0 = %s, want %s", results[0].Status, StatusSent)
+		}
+		for _, result := range results[1:] {
+			if result.Status != StatusCancelled || result.Modem != nil || result.Err != nil {
+				t.Errorf("message on line %d = %s by %v, want %s and never sent", result.Line, result.Status, result.Modem, StatusCancelled)
+			}
+		}
+		if sent, failed := Count(results); sent != 1 || failed != 0 {
+			t.Errorf("Count() = %d, %d, want 1, 0", sent, failed)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteResults(&buf, results); err != nil {
+			t.Fatalf("WriteResults() error = %v", err)
+		}
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil || len(records) != len(results)+1 {
+			t.Fatalf("WriteResults() = %v, %v, want a header and %d rows", records, err, len(results))
+		}
+		if got := records[2]; got[0] != "3" || got[1] != "+393330000012" || got[2] != "" || got[3] != string(StatusCancelled) || got[5] != "" {
+			t.Errorf("cancelled row = %q, want no modem and no time", got)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+)
+
+// MaxMessages is the most rows a file may have, sending more at the slow pace of bulk messages takes hours.
+const MaxMessages = 1000
+
+var (
+	ErrEmptyFile        = errors.New("the file has no rows")
+	ErrNoNumberColumn   = errors.New("the file has no number column")
+	ErrTooManyMessages  = fmt.Errorf("the file has more than %d rows", MaxMessages)
+	ErrTemplateRequired = errors.New("the file has no text column, a template is required")
+)
+
+var (
+	// numberColumns and textColumns are the header names the recipient and the text are looked up by.
+	numberColumns = []string{"number", "phone", "to"}
+	textColumns   = []string{"text", "message"}
+	placeholder   = regexp.MustCompile(`\{([^{}]+)\}`)
+)
+
+// Message is an SMS to send, from a row of the file.
+type Message struct {
+	// Line is the line of the row in the file, to tell which row a result belongs to.
+	Line   int
+	Number string
+	Text   string
+}
+
+type row struct {
+	line   int
+	fields []string
+}
+
+// Sheet is a CSV file of recipients. Its header names the number column and either a text column
+// or the columns a template refers to, e.g. "Hi {name}, your code is {code}".
+type Sheet struct {
+	Columns []string
+	rows    []row
+	number  int
+	text    int
+}
+
+// Parse reads a CSV file separated by commas, semicolons as spreadsheets in many locales save it, or tabs.
+func Parse(data []byte) (*Sheet, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = delimiter(data)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ErrEmptyFile
+	}
+	sheet := &Sheet{}
+	for _, column := range header {
+		sheet.Columns = append(sheet.Columns, strings.ToLower(strings.TrimSpace(column)))
+	}
+	if sheet.number = sheet.column(numberColumns...); sheet.number < 0 {
+		return nil, ErrNoNumberColumn
+	}
+	sheet.text = sheet.column(textColumns...)
+	for {
+		fields, err := reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, err
+			}
+			break
+		}
+		if strings.TrimSpace(strings.Join(fields, "")) == "" {
+			continue
+		}
+		if len(sheet.rows) == MaxMessages {
+			return nil, ErrTooManyMessages
+		}
+		line, _ := reader.FieldPos(0)
+		sheet.rows = append(sheet.rows, row{line: line, fields: fields})
+	}
+	if len(sheet.rows) == 0 {
+		return nil, ErrEmptyFile
+	}
+	return sheet, nil
+}
+
+// delimiter picks the separator found most often in the header.
+func delimiter(data []byte) rune {
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	comma := ','
+	for _, r := range []rune{';', '\t'} {
+		if bytes.Count(header, []byte(string(r))) > bytes.Count(header, []byte(string(comma))) {
+			comma = r
+		}
+	}
+	return comma
+}
+
+func (s *Sheet) column(names ...string) int {
+	for _, name := range names {
+		if i := slices.Index(s.Columns, name); i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Sheet) field(r row, column int) string {
+	if column < 0 || column >= len(r.fields) {
+		return ""
+	}
+	return strings.TrimSpace(r.fields[column])
+}
+
+// HasText tells whether the file has a text column, otherwise the messages need a template.
+func (s *Sheet) HasText() bool {
+	return s.text >= 0
+}
+
+// Len returns the number of rows.
+func (s *Sheet) Len() int {
+	return len(s.rows)
+}
+
+// Messages returns the message of every row, with its text column or the template filled with its columns.
+func (s *Sheet) Messages(template string) ([]*Message, error) {
+	if template == "" && !s.HasText() {
+		return nil, ErrTemplateRequired
+	}
+	for _, match := range placeholder.FindAllStringSubmatch(template, -1) {
+		if s.column(strings.ToLower(strings.TrimSpace(match[1]))) < 0 {
+			return nil, fmt.Errorf("the file has no %q column", match[1])
+		}
+	}
+	messages := make([]*Message, 0, len(s.rows))
+	for _, r := range s.rows {
+		message := &Message{Line: r.line, Number: strings.Join(strings.Fields(s.field(r, s.number)), "")}
+		if message.Number == "" {
+			return nil, fmt.Errorf("line %d has no number", r.line)
+		}
+		if template != "" {
+			message.Text = placeholder.ReplaceAllStringFunc(template, func(match string) string {
+				return s.field(r, s.column(strings.ToLower(strings.TrimSpace(match[1:len(match)-1]))))
+			})
+		} else {
+			message.Text = s.field(r, s.text)
+		}
+		if message.Text == "" {
+			return nil, fmt.Errorf("line %d has no text", r.line)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// Segments returns the number of messages the texts are split into altogether.
+func Segments(messages []*Message) int {
+	var total int
+	for _, message := range messages {
+		_, segments := modem.SMSSegments(message.Text)
+		total += segments
+	}
+	return total
+}
@@ -0,0 +1,154 @@
+package bulk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	rows := func(n int) string {
+		var b strings.Builder
+		b.WriteString("number,text\n")
+		for i := range n {
+			fmt.Fprintf(&b, "+39333%07d,Hi\n", i)
+		}
+		return b.String()
+	}
+	tests := []struct {
+		name    string
+		data    string
+		columns []string
+		rows    int
+		text    bool
+		wantErr error
+	}{
+		{name: "comma", data: "Number,Text\n+393331234567,Hi\n", columns: []string{"number", "text"}, rows: 1, text: true},
+		{name: "BOM", data: "\xef\xbb\xbfnumber,name\n+393331234567,Anna\n", columns: []string{"number", "name"}, rows: 1},
+		{name: "semicolon", data: "phone;name;message\n+393331234567;Anna;Hi, Anna\n", columns: []string{"phone", "name", "message"}, rows: 1, text: true},
+		{name: "tab", data: "to\tname\n+393331234567\tAnna, Bea\n", columns: []string{"to", "name"}, rows: 1},
+		{name: "blank rows skipped", data: "number,text\n\n+393331234567,Hi\n , \n+393337654321,Bye\n", columns: []string{"number", "text"}, rows: 2, text: true},
+		{name: "short rows", data: "number,name,text\n+393331234567\n", columns: []string{"number", "name", "text"}, rows: 1, text: true},
+		{name: "most messages", data: rows(MaxMessages), columns: []string{"number", "text"}, rows: MaxMessages, text: true},
+		{name: "too many messages", data: rows(MaxMessages + 1), wantErr: ErrTooManyMessages},
+		{name: "empty", data: "", wantErr: ErrEmptyFile},
+		{name: "header only", data: "number,text\n", wantErr: ErrEmptyFile},
+		{name: "no number column", data: "name,text\nAnna,Hi\n", wantErr: ErrNoNumberColumn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sheet, err := Parse([]byte(tt.data))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if strings.Join(sheet.Columns, "|") != strings.Join(tt.columns, "|") {
+				t.Errorf("Columns = %q, want %q", sheet.Columns, tt.columns)
+			}
+			if sheet.Len() != tt.rows || sheet.HasText() != tt.text {
+				t.Errorf("Parse() = %d rows, text %t, want %d rows, text %t", sheet.Len(), sheet.HasText(), tt.rows, tt.text)
+			}
+		})
+	}
+	if _, err := Parse([]byte("number,text\n+393331234567,Say \"hi\"\n")); err != nil {
+		t.Errorf("Parse() of a bare quote error = %v", err)
+	}
+}
+
+func TestDelimiter(t *testing.T) {
+	tests := map[string]rune{
+		"number,text\n1;2;3":    ',',
+		"number;text\n1,2":      ';',
+		"number;name;text":      ';',
+		"number\ttext\n1,2,3,4": '\t',
+		"number":                ',',
+		"a,b;c":                 ',',
+		"a;b;c,d,e\tf":          ',',
+	}
+	for header, want := range tests {
+		if got := delimiter([]byte(header)); got != want {
+			t.Errorf("delimiter(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestSheetMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		template string
+		want     []Message
+		wantErr  string
+	}{
+		{
+			name: "text column",
+			data: "number,text\n+39 333 123 4567,Hi\n+393337654321,Bye\n",
+			want: []Message{{Line: 2, Number: "+393331234567", Text: "Hi"}, {Line: 3, Number: "+393337654321", Text: "Bye"}},
+		},
+		{
+			name:     "template",
+			data:     "Number;Name;Code\n+393331234567;Anna;1234\n\n+393337654321;Bea;5678\n",
+			template: "Hi { Name }, your code is {code}. {code}!",
+			want: []Message{
+				{Line: 2, Number: "+393331234567", Text: "Hi Anna, your code is 1234. 1234!"},
+				{Line: 4, Number: "+393337654321", Text: "Hi Bea, your code is 5678. 5678!"},
+			},
+		},
+		{
+			name:     "template over the text column",
+			data:     "number,text,name\n+393331234567,Hi,Anna\n",
+			template: "Hello {name}",
+			want:     []Message{{Line: 2, Number: "+393331234567", Text: "Hello Anna"}},
+		},
+		{
+			name:     "missing field",
+			data:     "number,name\n+393331234567\n",
+			template: "Hello {name}",
+			want:     []Message{{Line: 2, Number: "+393331234567", Text: "Hello "}},
+		},
+		{name: "template required", data: "number,name\n+393331234567,Anna\n", wantErr: ErrTemplateRequired.Error()},
+		{name: "unknown column", data: "number,name\n+393331234567,Anna\n", template: "Hi {surname}", wantErr: `no "surname" column`},
+		{name: "empty number", data: "number,text\n+393331234567,Hi\n ,Bye\n", wantErr: "line 3 has no number"},
+		{name: "empty text", data: "number,text\n+393331234567, \n", wantErr: "line 2 has no text"},
+		{name: "empty template result", data: "number,name\n+393331234567,\n", template: "{name}", wantErr: "line 2 has no text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sheet, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			messages, err := sheet.Messages(tt.template)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Messages() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Messages() error = %v", err)
+			}
+			if len(messages) != len(tt.want) {
+				t.Fatalf("Messages() = %d messages, want %d", len(messages), len(tt.want))
+			}
+			for idx, message := range messages {
+				if *message != tt.want[idx] {
+					t.Errorf("Messages()[%d] = %+v, want %+v", idx, *message, tt.want[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestSegments(t *testing.T) {
+	messages := []*Message{{Text: "Hi"}, {Text: strings.Repeat("a", 161)}, {Text: strings.Repeat("я", 71)}}
+	if got := Segments(messages); got != 5 {
+		t.Errorf("Segments() = %d, want 5", got)
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"sync"
+)
+
+// CallPolicy is what happens to an incoming call besides notifying the admins.
+type CallPolicy string
+
+const (
+	CallPolicyNone   CallPolicy = ""
+	CallPolicyReject CallPolicy = "reject"
+	// CallPolicyHangup answers the call and hangs up shortly after, for callers that need the call to be picked up.
+	CallPolicyHangup CallPolicy = "hangup"
+)
+
+// callFile maps the IMEIs to the call policy of their modem.
+type callFile struct {
+	Modems map[string]CallPolicy `json:"modems"`
+}
+
+var callMutex sync.Mutex
+
+func (c *Config) CallPolicy(imei string) (CallPolicy, error) {
+	callMutex.Lock()
+	defer callMutex.Unlock()
+	f, err := c.loadCalls()
+	if err != nil {
+		return CallPolicyNone, err
+	}
+	return f.Modems[imei], nil
+}
+
+func (c *Config) SaveCallPolicy(imei string, policy CallPolicy) error {
+	callMutex.Lock()
+	defer callMutex.Unlock()
+	f, err := c.loadCalls()
+	if err != nil {
+		return err
+	}
+	if policy == CallPolicyNone {
+		delete(f.Modems, imei)
+	} else {
+		f.Modems[imei] = policy
+	}
+	return c.saveData("calls.json", f)
+}
+
+func (c *Config) loadCalls() (*callFile, error) {
+	f := new(callFile)
+	if err := c.loadData("calls.json", f); err != nil {
+		return nil, err
+	}
+	if f.Modems == nil {
+		f.Modems = make(map[string]CallPolicy)
+	}
+	return f, nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"sync"
+)
+
+// cellBroadcastFile maps the IMEIs to the cell broadcast channels their modem listens to, e.g. 4370-4399, 50.
+// An empty list turns cell broadcasts off.
+type cellBroadcastFile struct {
+	Modems map[string]string `json:"modems"`
+}
+
+var cellBroadcastMutex sync.Mutex
+
+// CellBroadcastChannels returns the channels saved for the modem, ok is false if the modem keeps its own.
+func (c *Config) CellBroadcastChannels(imei string) (channels string, ok bool, err error) {
+	cellBroadcastMutex.Lock()
+	defer cellBroadcastMutex.Unlock()
+	f, err := c.loadCellBroadcast()
+	if err != nil {
+		return "", false, err
+	}
+	channels, ok = f.Modems[imei]
+	return channels, ok, nil
+}
+
+func (c *Config) SaveCellBroadcastChannels(imei string, channels string) error {
+	cellBroadcastMutex.Lock()
+	defer cellBroadcastMutex.Unlock()
+	f, err := c.loadCellBroadcast()
+	if err != nil {
+		return err
+	}
+	f.Modems[imei] = channels
+	return c.saveData("cell_broadcast.json", f)
+}
+
+func (c *Config) loadCellBroadcast() (*cellBroadcastFile, error) {
+	f := new(cellBroadcastFile)
+	if err := c.loadData("cell_broadcast.json", f); err != nil {
+		return nil, err
+	}
+	if f.Modems == nil {
+		f.Modems = make(map[string]string)
+	}
+	return f, nil
+}
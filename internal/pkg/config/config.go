@@ -2,9 +2,13 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type AdminId []string
@@ -38,6 +42,33 @@ type Config struct {
 	Slowdown   bool
 	Compatible bool
 	Verbose    bool
+	Simulate   bool
+	Scenario   string
+	DataDir    string
+	// NetworkEvents are the comma separated network changes the admins are notified of.
+	NetworkEvents   string
+	SignalThreshold uint
+	SignalDuration  time.Duration
+	// BulkInterval is the pause between the messages of a bulk send.
+	BulkInterval time.Duration
+}
+
+type NetworkEvent string
+
+const (
+	NetworkEventRegistration NetworkEvent = "registration"
+	NetworkEventRoaming      NetworkEvent = "roaming"
+	NetworkEventOperator     NetworkEvent = "operator"
+	NetworkEventSignal       NetworkEvent = "signal"
+	NetworkEventFallback     NetworkEvent = "fallback"
+)
+
+var networkEvents = []NetworkEvent{
+	NetworkEventRegistration,
+	NetworkEventRoaming,
+	NetworkEventOperator,
+	NetworkEventSignal,
+	NetworkEventFallback,
 }
 
 var C = new(Config)
@@ -45,6 +76,7 @@ var C = new(Config)
 var (
 	ErrBotTokenRequired = errors.New("bot token is required")
 	ErrAdminIdRequired  = errors.New("admin id is required")
+	ErrSignalThreshold  = errors.New("signal threshold must be between 0 and 100")
 )
 
 func (c *Config) IsValid() error {
@@ -54,5 +86,33 @@ func (c *Config) IsValid() error {
 	if len(c.AdminId) == 0 {
 		return ErrAdminIdRequired
 	}
+	if c.SignalThreshold > 100 {
+		return ErrSignalThreshold
+	}
+	for _, event := range c.networkEvents() {
+		if !slices.Contains(networkEvents, event) {
+			return fmt.Errorf("unknown network event %q", event)
+		}
+	}
 	return nil
 }
+
+// NetworkEvent reports whether the admins want to be notified of the network event.
+func (c *Config) NetworkEvent(event NetworkEvent) bool {
+	return slices.Contains(c.networkEvents(), event)
+}
+
+func (c *Config) networkEvents() []NetworkEvent {
+	var events []NetworkEvent
+	for _, event := range strings.Split(c.NetworkEvents, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, NetworkEvent(event))
+		}
+	}
+	return events
+}
+
+// DataPath returns the path of a file kept in the data directory, e.g. the saved USSD shortcuts.
+func (c *Config) DataPath(name string) string {
+	return filepath.Join(c.DataDir, name)
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+// loadData decodes a JSON file of the data directory into v, which is left untouched if the file doesn't exist yet.
+func (c *Config) loadData(name string, v any) error {
+	data, err := os.ReadFile(c.DataPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", c.DataPath(name), err)
+	}
+	return nil
+}
+
+// saveData replaces a JSON file of the data directory, readable by the owner only as it may hold secrets.
+func (c *Config) saveData(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(c.DataPath(name), data)
+}
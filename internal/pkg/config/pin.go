@@ -0,0 +1,50 @@
+package config
+
+import (
+	"sync"
+)
+
+// pinFile maps the ICCIDs to the PINs their SIM is unlocked with automatically.
+type pinFile struct {
+	SIMs map[string]string `json:"sims"`
+}
+
+var pinMutex sync.Mutex
+
+// PIN returns the PIN saved for the SIM, empty if there is none.
+func (c *Config) PIN(iccid string) (string, error) {
+	pinMutex.Lock()
+	defer pinMutex.Unlock()
+	f, err := c.loadPINs()
+	if err != nil {
+		return "", err
+	}
+	return f.SIMs[iccid], nil
+}
+
+// SavePIN remembers the PIN of the SIM, an empty PIN forgets it.
+func (c *Config) SavePIN(iccid string, pin string) error {
+	pinMutex.Lock()
+	defer pinMutex.Unlock()
+	f, err := c.loadPINs()
+	if err != nil {
+		return err
+	}
+	if pin == "" {
+		delete(f.SIMs, iccid)
+	} else {
+		f.SIMs[iccid] = pin
+	}
+	return c.saveData("pins.json", f)
+}
+
+func (c *Config) loadPINs() (*pinFile, error) {
+	f := new(pinFile)
+	if err := c.loadData("pins.json", f); err != nil {
+		return nil, err
+	}
+	if f.SIMs == nil {
+		f.SIMs = make(map[string]string)
+	}
+	return f, nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"slices"
+	"sync"
+)
+
+// Radio is the radio configuration a modem is set to every time it is plugged in.
+type Radio struct {
+	IMEI string `json:"imei"`
+	// Modes are the allowed modes separated by slashes, e.g. 4G or 3G/4G, and Preferred the one to prefer among them.
+	Modes     string `json:"modes,omitempty"`
+	Preferred string `json:"preferred,omitempty"`
+	// Bands are named like B3 for LTE, n78 for 5G, UMTS B1 for 3G and GSM 900 for 2G.
+	Bands []string `json:"bands,omitempty"`
+}
+
+type radioFile struct {
+	Modems []*Radio `json:"modems"`
+}
+
+var radioMutex sync.Mutex
+
+// Radio returns the radio defaults of the modem, nil if it has none.
+func (c *Config) Radio(imei string) (*Radio, error) {
+	radioMutex.Lock()
+	defer radioMutex.Unlock()
+	f, err := c.loadRadio()
+	if err != nil {
+		return nil, err
+	}
+	if idx := slices.IndexFunc(f.Modems, func(r *Radio) bool { return r.IMEI == imei }); idx >= 0 {
+		return f.Modems[idx], nil
+	}
+	return nil, nil
+}
+
+// UpdateRadio changes the radio defaults of a modem, starting from empty ones if it has none.
+// The file is read, updated and written under the same lock so concurrent updates aren't lost.
+func (c *Config) UpdateRadio(imei string, update func(radio *Radio)) error {
+	radioMutex.Lock()
+	defer radioMutex.Unlock()
+	f, err := c.loadRadio()
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(f.Modems, func(r *Radio) bool { return r.IMEI == imei })
+	if idx < 0 {
+		f.Modems = append(f.Modems, &Radio{IMEI: imei})
+		idx = len(f.Modems) - 1
+	}
+	update(f.Modems[idx])
+	f.Modems[idx].IMEI = imei
+	return c.saveData("radio.json", f)
+}
+
+func (c *Config) loadRadio() (*radioFile, error) {
+	f := new(radioFile)
+	if err := c.loadData("radio.json", f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestUpdateRadio(t *testing.T) {
+	c := &Config{DataDir: t.TempDir()}
+	const imei = "861234567890123"
+	if err := c.UpdateRadio(imei, func(radio *Radio) { radio.Modes = "4G" }); err != nil {
+		t.Fatalf("UpdateRadio() error = %v", err)
+	}
+
+	// Saving the modes and the bands at the same time keeps both.
+	var wg sync.WaitGroup
+	for _, band := range []string{"B1", "B3", "B7", "B20"} {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := c.UpdateRadio(imei, func(radio *Radio) { radio.Bands = append(radio.Bands, band) }); err != nil {
+				t.Errorf("UpdateRadio() error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := c.UpdateRadio(imei, func(radio *Radio) { radio.Preferred = "4G" }); err != nil {
+				t.Errorf("UpdateRadio() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := c.UpdateRadio("861234567890456", func(radio *Radio) { radio.IMEI = "changed" }); err != nil {
+		t.Fatalf("UpdateRadio() error = %v", err)
+	}
+
+	radio, err := c.Radio(imei)
+	if err != nil || radio == nil {
+		t.Fatalf("Radio() = %v, %v", radio, err)
+	}
+	slices.Sort(radio.Bands)
+	if radio.Modes != "4G" || radio.Preferred != "4G" || !slices.Equal(radio.Bands, []string{"B1", "B20", "B3", "B7"}) {
+		t.Errorf("Radio() = %+v, want every update kept", radio)
+	}
+	if other, err := c.Radio("861234567890456"); err != nil || other == nil {
+		t.Errorf("Radio() of the other modem = %v, %v, want it kept under its IMEI", other, err)
+	}
+}
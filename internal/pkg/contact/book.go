@@ -0,0 +1,286 @@
+package contact
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+var (
+	ErrContactNotFound = errors.New("contact not found")
+	ErrContactExists   = errors.New("another contact has this number")
+)
+
+// minSuffix is the fewest digits a national number must share with an international one to be taken
+// for the same, so a number stored in international format matches the national format the network
+// may deliver it in.
+const minSuffix = 7
+
+// Contact is a name for a phone number.
+type Contact struct {
+	Name      string `json:"name"`
+	Number    string `json:"number"`
+	Favourite bool   `json:"favourite,omitempty"`
+	// LastUsed is when an SMS was last sent to the contact, to offer the recent ones first.
+	LastUsed time.Time `json:"lastUsed,omitzero"`
+}
+
+// String shows the name with the number, e.g. "Anna (+14155550100)".
+func (c *Contact) String() string {
+	return fmt.Sprintf("%s (%s)", c.Name, c.Number)
+}
+
+// Book is the file the contacts are saved in.
+// The file is read on every call, so it can be edited by hand while the bot is running.
+type Book struct {
+	path  string
+	mutex sync.Mutex
+}
+
+type bookFile struct {
+	Contacts []*Contact `json:"contacts"`
+}
+
+func NewBook(path string) *Book {
+	return &Book{path: path}
+}
+
+// List returns the contacts sorted by name.
+func (b *Book) List() ([]*Contact, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	contacts, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(contacts, func(a, b *Contact) int {
+		return cmp.Or(strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), strings.Compare(a.Number, b.Number))
+	})
+	return contacts, nil
+}
+
+// Find returns the contact of the number, in whatever format either of them is written.
+func (b *Book) Find(number string) (*Contact, error) {
+	contacts, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	if index := find(contacts, number); index >= 0 {
+		return contacts[index], nil
+	}
+	return nil, ErrContactNotFound
+}
+
+// Search returns the contacts whose name contains the query, or whose number matches it.
+func (b *Book) Search(query string) ([]*Contact, error) {
+	contacts, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	return slices.DeleteFunc(contacts, func(c *Contact) bool {
+		return !strings.Contains(strings.ToLower(c.Name), query) && !strings.Contains(c.Number, Normalize(query)) && !Same(c.Number, query)
+	}), nil
+}
+
+// Label names the number if it belongs to a contact, e.g. "Anna (+14155550100)", or returns it as it is.
+func (b *Book) Label(number string) string {
+	c, err := b.Find(number)
+	if err != nil {
+		if !errors.Is(err, ErrContactNotFound) {
+			slog.Warn("Failed to look up the contact", "number", number, "error", err)
+		}
+		return number
+	}
+	return c.String()
+}
+
+// Suggestions returns up to n contacts to pick from, the favourites first and then the most recently used.
+func (b *Book) Suggestions(n int) ([]*Contact, error) {
+	contacts, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	contacts = slices.DeleteFunc(contacts, func(c *Contact) bool { return !c.Favourite && c.LastUsed.IsZero() })
+	slices.SortStableFunc(contacts, func(a, b *Contact) int {
+		switch {
+		case a.Favourite && !b.Favourite:
+			return -1
+		case b.Favourite && !a.Favourite:
+			return 1
+		case a.Favourite:
+			return 0
+		}
+		return b.LastUsed.Compare(a.LastUsed)
+	})
+	return contacts[:min(len(contacts), n)], nil
+}
+
+// Save adds the contact or replaces the one with its number.
+func (b *Book) Save(contact *Contact) error {
+	return b.Update(contact.Number, contact)
+}
+
+// Update replaces the contact of the number, e.g. to rename it or to change its number.
+// With an empty number the contact is added, unless another one has its number.
+func (b *Book) Update(number string, contact *Contact) error {
+	contact.Name, contact.Number = strings.TrimSpace(contact.Name), Normalize(contact.Number)
+	if contact.Name == "" || contact.Number == "" {
+		return errors.New("a contact needs a name and a number")
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	contacts, err := b.load()
+	if err != nil {
+		return err
+	}
+	if index := find(contacts, number); index >= 0 {
+		contacts = slices.Delete(contacts, index, index+1)
+	}
+	if find(contacts, contact.Number) >= 0 {
+		return ErrContactExists
+	}
+	return b.store(append(contacts, contact))
+}
+
+func (b *Book) Delete(number string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	contacts, err := b.load()
+	if err != nil {
+		return err
+	}
+	index := find(contacts, number)
+	if index < 0 {
+		return ErrContactNotFound
+	}
+	return b.store(slices.Delete(contacts, index, index+1))
+}
+
+// Import merges the contacts into the book, the name of a number already saved is replaced.
+func (b *Book) Import(imported []*Contact) (added int, updated int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	contacts, err := b.load()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, contact := range imported {
+		contact.Name, contact.Number = strings.TrimSpace(contact.Name), Normalize(contact.Number)
+		if contact.Name == "" || contact.Number == "" {
+			continue
+		}
+		if index := find(contacts, contact.Number); index >= 0 {
+			if contacts[index].Name != contact.Name {
+				contacts[index].Name = contact.Name
+				updated++
+			}
+			continue
+		}
+		contacts = append(contacts, contact)
+		added++
+	}
+	if added+updated == 0 {
+		return 0, 0, nil
+	}
+	return added, updated, b.store(contacts)
+}
+
+// Use marks the contact of the number as just used, numbers not in the book are ignored.
+func (b *Book) Use(number string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	contacts, err := b.load()
+	if err != nil {
+		return err
+	}
+	index := find(contacts, number)
+	if index < 0 {
+		return nil
+	}
+	contacts[index].LastUsed = time.Now()
+	return b.store(contacts)
+}
+
+func find(contacts []*Contact, number string) int {
+	return slices.IndexFunc(contacts, func(c *Contact) bool { return Same(c.Number, number) })
+}
+
+// Normalize drops the spaces, dashes and brackets people write numbers with, e.g. "+1 (415) 555-0100".
+func Normalize(number string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -(). ", r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(number))
+}
+
+// Same tells whether two numbers are the same, "+393331234567", "00393331234567" and "3331234567" all are.
+// Numbers in the same format must match exactly, a national number matches the end of an international one,
+// so "+447700900123" is not taken for "+397700900123".
+func Same(a string, b string) bool {
+	da, db := digits(a), digits(b)
+	if da == "" || db == "" {
+		return false
+	}
+	if da == db {
+		return true
+	}
+	if international(a) == international(b) {
+		return false
+	}
+	if international(b) {
+		da, db = db, da
+	}
+	return len(db) >= minSuffix && strings.HasSuffix(da, db)
+}
+
+// international tells whether the number starts with a country code, e.g. "+39" or "0039".
+func international(number string) bool {
+	number = Normalize(number)
+	return strings.HasPrefix(number, "+") || strings.HasPrefix(number, "00")
+}
+
+// digits keeps the digits of the number without the leading zeros of a trunk or international prefix.
+// Alphanumeric senders, e.g. "Amazon", are kept as they are.
+func digits(number string) string {
+	number = Normalize(number)
+	trimmed := strings.TrimLeft(strings.TrimPrefix(number, "+"), "0")
+	if strings.IndexFunc(trimmed, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return strings.ToLower(number)
+	}
+	return trimmed
+}
+
+func (b *Book) load() ([]*Contact, error) {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f bookFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.path, err)
+	}
+	return f.Contacts, nil
+}
+
+func (b *Book) store(contacts []*Contact) error {
+	data, err := json.MarshalIndent(bookFile{Contacts: contacts}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(b.path, data)
+}
@@ -0,0 +1,48 @@
+package contact
+
+import "testing"
+
+func TestSame(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"+393331234567", "+393331234567", true},
+		{"+39 333 123 4567", "0039-333-1234567", true},
+		{"+393331234567", "3331234567", true},
+		{"03331234567", "+393331234567", true},
+		{"+447700900123", "07700900123", true},
+		{"3331234567", "3331234567", true},
+		{"Amazon", "amazon", true},
+		// Foreign numbers sharing the subscriber part are different numbers.
+		{"+393331234567", "+13331234567", false},
+		{"+447700900123", "0039 7700900123", false},
+		{"3331234567", "13331234567", false},
+		{"+393331234567", "234567", false},
+		{"+393331234567", "", false},
+		{"Amazon", "Amazon Pay", false},
+	}
+	for _, tt := range tests {
+		if got := Same(tt.a, tt.b); got != tt.want {
+			t.Errorf("Same(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := Same(tt.b, tt.a); got != tt.want {
+			t.Errorf("Same(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestBookFind(t *testing.T) {
+	book := NewBook(t.TempDir() + "/contacts.json")
+	for _, c := range []*Contact{{Name: "Anna", Number: "+393331234567"}, {Name: "Bob", Number: "+13331234567"}} {
+		if err := book.Save(c); err != nil {
+			t.Fatalf("Save(%v) error = %v", c, err)
+		}
+	}
+	for number, want := range map[string]string{"+393331234567": "Anna", "3331234567": "Anna", "001 333 123 4567": "Bob"} {
+		c, err := book.Find(number)
+		if err != nil || c.Name != want {
+			t.Errorf("Find(%q) = %v, %v, want %s", number, c, err, want)
+		}
+	}
+}
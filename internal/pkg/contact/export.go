@@ -0,0 +1,19 @@
+package contact
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteVCard writes the contacts as vCard 3.0, which phones and webmail services import.
+func WriteVCard(w io.Writer, contacts []*Contact) error {
+	escape := strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`)
+	for _, c := range contacts {
+		name := escape.Replace(c.Name)
+		if _, err := fmt.Fprintf(w, "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:%s\r\nN:;%s;;;\r\nTEL;TYPE=CELL:%s\r\nEND:VCARD\r\n", name, name, c.Number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
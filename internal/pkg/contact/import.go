@@ -0,0 +1,129 @@
+package contact
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownFormat = errors.New("the file is neither a vCard nor a CSV file")
+	ErrNoContacts    = errors.New("the file has no contacts")
+)
+
+var (
+	// nameColumns and numberColumns are the CSV header names the contacts are read from,
+	// including the ones phones and webmail services export.
+	nameColumns   = []string{"name", "full name", "display name", "first name"}
+	numberColumns = []string{"number", "phone", "mobile", "tel", "phone 1 - value", "mobile phone", "primary phone"}
+)
+
+// Parse reads the contacts of a vCard (.vcf) or CSV file.
+func Parse(data []byte) ([]*Contact, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var contacts []*Contact
+	var err error
+	if bytes.Contains(bytes.ToUpper(data), []byte("BEGIN:VCARD")) {
+		contacts = ParseVCard(data)
+	} else if contacts, err = ParseCSV(data); err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, ErrNoContacts
+	}
+	return contacts, nil
+}
+
+// ParseVCard reads every card with a name and a number, a card with several numbers gives a contact for each.
+func ParseVCard(data []byte) []*Contact {
+	var contacts []*Contact
+	var name, fallback string
+	var numbers []string
+	// Folded lines continue with a space or a tab.
+	text := strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(string(data))
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		property, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, _, _ := strings.Cut(strings.ToUpper(property), ";")
+		// Apple and Google prefix grouped properties, e.g. item1.TEL.
+		if _, after, ok := strings.Cut(key, "."); ok {
+			key = after
+		}
+		switch key {
+		case "BEGIN":
+			name, fallback, numbers = "", "", nil
+		case "FN":
+			name = unescapeVCard(value)
+		case "N":
+			// Family;Given;Additional;Prefix;Suffix
+			family, rest, _ := strings.Cut(value, ";")
+			given, _, _ := strings.Cut(rest, ";")
+			fallback = unescapeVCard(given + " " + family)
+		case "TEL":
+			numbers = append(numbers, strings.TrimPrefix(strings.TrimSpace(value), "tel:"))
+		case "END":
+			if name == "" {
+				name = fallback
+			}
+			for _, number := range numbers {
+				contacts = append(contacts, &Contact{Name: name, Number: number})
+			}
+			name, fallback, numbers = "", "", nil
+		}
+	}
+	return contacts
+}
+
+func unescapeVCard(value string) string {
+	return strings.TrimSpace(strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(value))
+}
+
+// ParseCSV reads a CSV file separated by commas, semicolons or tabs, whose header names a name and a number column.
+func ParseCSV(data []byte) ([]*Contact, error) {
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	for _, r := range []rune{';', '\t'} {
+		if bytes.Count(header, []byte(string(r))) > bytes.Count(header, []byte(string(reader.Comma))) {
+			reader.Comma = r
+		}
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrNoContacts
+	}
+	columns := make([]string, len(records[0]))
+	for i, column := range records[0] {
+		columns[i] = strings.ToLower(strings.TrimSpace(column))
+	}
+	name, number := index(columns, nameColumns), index(columns, numberColumns)
+	if name < 0 || number < 0 {
+		return nil, fmt.Errorf("%w, a CSV file needs a name and a number column", ErrUnknownFormat)
+	}
+	var contacts []*Contact
+	for _, record := range records[1:] {
+		if max(name, number) < len(record) {
+			contacts = append(contacts, &Contact{Name: record[name], Number: record[number]})
+		}
+	}
+	return contacts, nil
+}
+
+func index(columns []string, names []string) int {
+	for _, name := range names {
+		if i := slices.Index(columns, name); i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
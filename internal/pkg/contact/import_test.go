@@ -0,0 +1,156 @@
+package contact
+
+import (
+	"errors"
+	"testing"
+)
+
+func equalContacts(got []*Contact, want []Contact) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if *got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseVCard(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Contact
+	}{
+		{
+			name: "single",
+			data: "BEGIN:VCARD\nVERSION:3.0\nFN:Anna Rossi\nTEL;TYPE=CELL:+393331234567\nEND:VCARD\n",
+			want: []Contact{{Name: "Anna Rossi", Number: "+393331234567"}},
+		},
+		{
+			name: "CRLF and folding",
+			data: "BEGIN:VCARD\r\nFN:Anna Maria\r\n  Rossi\r\nTEL:+39333\r\n\t1234567\r\nEND:VCARD\r\n",
+			want: []Contact{{Name: "Anna Maria Rossi", Number: "+393331234567"}},
+		},
+		{
+			name: "escaping",
+			data: `BEGIN:VCARD` + "\n" + `FN:Rossi\, Anna\; Bea\nHome \\ Office` + "\n" + `TEL:+393331234567` + "\n" + `END:VCARD`,
+			want: []Contact{{Name: `Rossi, Anna; Bea Home \ Office`, Number: "+393331234567"}},
+		},
+		{
+			name: "several numbers",
+			data: "BEGIN:VCARD\nFN:Anna\nTEL;TYPE=CELL:+393331234567\nitem1.TEL;TYPE=WORK:+390612345678\nTEL;VALUE=uri:tel:+14155550100\nEND:VCARD\n",
+			want: []Contact{
+				{Name: "Anna", Number: "+393331234567"},
+				{Name: "Anna", Number: "+390612345678"},
+				{Name: "Anna", Number: "+14155550100"},
+			},
+		},
+		{
+			name: "name from N",
+			data: "BEGIN:VCARD\nN:Rossi;Anna;;;\nTEL:+393331234567\nEND:VCARD\n",
+			want: []Contact{{Name: "Anna Rossi", Number: "+393331234567"}},
+		},
+		{
+			name: "FN over N",
+			data: "BEGIN:VCARD\nN:Rossi;Anna;;;\nFN:Annina\nTEL:+393331234567\nEND:VCARD\n",
+			want: []Contact{{Name: "Annina", Number: "+393331234567"}},
+		},
+		{
+			name: "several cards",
+			data: "begin:vcard\nfn:Anna\ntel:+393331234567\nend:vcard\nBEGIN:VCARD\nFN:No number\nEND:VCARD\nBEGIN:VCARD\nFN:Bea\nTEL:+393337654321\nEND:VCARD\n",
+			want: []Contact{{Name: "Anna", Number: "+393331234567"}, {Name: "Bea", Number: "+393337654321"}},
+		},
+		{
+			name: "properties of a card don't leak into the next",
+			data: "BEGIN:VCARD\nFN:Anna\nTEL:+393331234567\nBEGIN:VCARD\nN:Bianchi;Bea;;;\nTEL:+393337654321\nEND:VCARD\n",
+			want: []Contact{{Name: "Bea Bianchi", Number: "+393337654321"}},
+		},
+		{name: "not a vCard", data: "name,number\nAnna,+393331234567\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseVCard([]byte(tt.data)); !equalContacts(got, tt.want) {
+				t.Errorf("ParseVCard() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Contact
+		wantErr error
+	}{
+		{
+			name: "comma",
+			data: "Name,Number\nAnna,+393331234567\nBea,+393337654321\n",
+			want: []Contact{{Name: "Anna", Number: "+393331234567"}, {Name: "Bea", Number: "+393337654321"}},
+		},
+		{
+			name: "semicolon",
+			data: "name;phone\n\"Rossi, Anna\";+393331234567\n",
+			want: []Contact{{Name: "Rossi, Anna", Number: "+393331234567"}},
+		},
+		{
+			name: "tab",
+			data: "name\tmobile\nRossi, Anna\t+393331234567\n",
+			want: []Contact{{Name: "Rossi, Anna", Number: "+393331234567"}},
+		},
+		{
+			name: "Google export",
+			data: "First Name,Last Name,Phone 1 - Label,Phone 1 - Value\nAnna,Rossi,Mobile,+393331234567\n",
+			want: []Contact{{Name: "Anna", Number: "+393331234567"}},
+		},
+		{
+			name: "Outlook export",
+			data: "Display Name,E-mail Address,Mobile Phone\nAnna Rossi,anna@example.com,+393331234567\n",
+			want: []Contact{{Name: "Anna Rossi", Number: "+393331234567"}},
+		},
+		{
+			name: "alias precedence",
+			data: "First Name,Full Name,Tel,Number\nAnna,Anna Rossi,+390612345678,+393331234567\n",
+			want: []Contact{{Name: "Anna Rossi", Number: "+393331234567"}},
+		},
+		{
+			name: "short rows skipped",
+			data: "name,notes,number\nAnna\nBea,,+393337654321\n",
+			want: []Contact{{Name: "Bea", Number: "+393337654321"}},
+		},
+		{name: "header only", data: "name,number\n"},
+		{name: "empty", data: "", wantErr: ErrNoContacts},
+		{name: "no number column", data: "name,email\nAnna,anna@example.com\n", wantErr: ErrUnknownFormat},
+		{name: "no name column", data: "phone\n+393331234567\n", wantErr: ErrUnknownFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCSV([]byte(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseCSV() error = %v, want %v", err, tt.wantErr)
+			}
+			if !equalContacts(got, tt.want) {
+				t.Errorf("ParseCSV() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	vcard, err := Parse([]byte("\xef\xbb\xbfBEGIN:VCARD\nFN:Anna\nTEL:+393331234567\nEND:VCARD\n"))
+	if err != nil || !equalContacts(vcard, []Contact{{Name: "Anna", Number: "+393331234567"}}) {
+		t.Errorf("Parse() of a vCard = %v, %v", vcard, err)
+	}
+	csv, err := Parse([]byte("\xef\xbb\xbfname,number\nAnna,+393331234567\n"))
+	if err != nil || !equalContacts(csv, []Contact{{Name: "Anna", Number: "+393331234567"}}) {
+		t.Errorf("Parse() of a CSV file = %v, %v", csv, err)
+	}
+	if _, err := Parse([]byte("BEGIN:VCARD\nFN:Anna\nEND:VCARD\n")); !errors.Is(err, ErrNoContacts) {
+		t.Errorf("Parse() of a card without numbers error = %v, want %v", err, ErrNoContacts)
+	}
+	if _, err := Parse([]byte("name,number\n")); !errors.Is(err, ErrNoContacts) {
+		t.Errorf("Parse() of a header only error = %v, want %v", err, ErrNoContacts)
+	}
+}
@@ -0,0 +1,513 @@
+package euicc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/bertlv"
+	"github.com/damonto/euicc-go/bertlv/primitive"
+	"github.com/damonto/euicc-go/lpa"
+	sgp22 "github.com/damonto/euicc-go/v2"
+
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+// Card is an in-memory eUICC.
+// It implements apdu.SmartCardChannel so it can be handed to lpa.NewWithChannel,
+// and Exchange answers raw command APDUs for transports such as AT+CSIM.
+type Card struct {
+	EID []byte
+	// AIDs are the ISD-R AIDs the card answers to, defaults to the GSMA ISD-R AID.
+	AIDs          [][]byte
+	Profiles      []*Profile
+	Notifications []*Notification
+	// OnEnable is called once a profile has been enabled.
+	// It runs while the card is locked and must not call back into the card.
+	OnEnable func(iccid sgp22.ICCID)
+
+	mutex     sync.Mutex
+	channel   byte
+	selected  bool
+	command   []byte
+	response  []byte
+	sequence  sgp22.SequenceNumber
+	connected bool
+}
+
+type Profile struct {
+	ICCID               sgp22.ICCID
+	ISDPAID             []byte
+	State               sgp22.ProfileState
+	Class               sgp22.ProfileClass
+	Nickname            string
+	ServiceProviderName string
+	ProfileName         string
+	// SMDPAddress receives the notifications of the profile, no notification is generated when it is empty.
+	SMDPAddress string
+}
+
+type Notification struct {
+	SequenceNumber sgp22.SequenceNumber
+	Event          sgp22.NotificationEvent
+	Address        string
+	ICCID          sgp22.ICCID
+}
+
+const (
+	SWOK                = 0x9000
+	SWWrongLength       = 0x6700
+	SWConditionsNotMet  = 0x6985
+	SWIncorrectData     = 0x6A80
+	SWFileNotFound      = 0x6A82
+	SWInsNotSupported   = 0x6D00
+	SWClassNotSupported = 0x6E00
+	SWMoreData          = 0x6100
+
+	maxShortResponseSize = 256
+)
+
+// CIKeyID is the GSMA test CI key identifier reported in EUICCInfo2.
+var CIKeyID = []byte{0x81, 0x37, 0x0F, 0x51, 0x25, 0xD0, 0xB1, 0xD4, 0x08, 0xD4, 0xC3, 0xB2, 0x32, 0xE6, 0xD2, 0x5E, 0x79, 0x5B, 0xEB, 0xFB}
+
+// SasAccreditationNumber is the SAS-UP accreditation number reported in EUICCInfo2.
+const SasAccreditationNumber = "GD-MM-UP-0726"
+
+// ISDPAID returns the ISD-P AID assigned to the n-th profile of a card.
+func ISDPAID(n int) []byte {
+	return []byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x10, byte(n)}
+}
+
+// region apdu.SmartCardChannel
+
+func (c *Card) Connect() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.connected = true
+	return nil
+}
+
+func (c *Card) Disconnect() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.connected = false
+	c.channel, c.selected = 0, false
+	return nil
+}
+
+func (c *Card) OpenLogicalChannel(aid []byte) (byte, error) {
+	response, err := c.Transmit([]byte{0x00, 0x70, 0x00, 0x00, 0x01})
+	if err != nil {
+		return 0, err
+	}
+	channel := response[0]
+	if _, err := c.Transmit(append([]byte{channel, 0xA4, 0x04, 0x00, byte(len(aid))}, aid...)); err != nil {
+		c.CloseLogicalChannel(channel)
+		return 0, fmt.Errorf("failed to select AID: %w", err)
+	}
+	return channel, nil
+}
+
+func (c *Card) CloseLogicalChannel(channel byte) error {
+	_, err := c.Transmit([]byte{0x00, 0x70, 0x80, channel, 0x00})
+	return err
+}
+
+// Transmit behaves like the modem drivers: it fails on any status word other than 90XX and 61XX.
+func (c *Card) Transmit(command []byte) ([]byte, error) {
+	c.mutex.Lock()
+	connected := c.connected
+	c.mutex.Unlock()
+	if !connected {
+		return nil, errors.New("card is not connected")
+	}
+	response := c.Exchange(command)
+	if sw1 := response[len(response)-2]; sw1 != 0x90 && sw1 != 0x61 {
+		return response, fmt.Errorf("unexpected response: %X", response)
+	}
+	return response, nil
+}
+
+// endregion
+
+// Connected reports whether the channel is currently connected.
+func (c *Card) Connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.connected
+}
+
+// Exchange executes a single command APDU and returns the response APDU including the status word.
+func (c *Card) Exchange(command []byte) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(command) < 4 {
+		return sw(nil, SWWrongLength)
+	}
+	var data []byte
+	if len(command) > 5 {
+		if len(command) < 5+int(command[4]) {
+			return sw(nil, SWWrongLength)
+		}
+		data = command[5 : 5+int(command[4])]
+	}
+	if command[1] != 0x70 && command[0]&0x0F != c.channel {
+		return sw(nil, SWClassNotSupported)
+	}
+	switch command[1] {
+	case 0x70:
+		return c.manageChannel(command[2], command[3])
+	case 0xA4:
+		return c.selectApplication(data)
+	case 0xE2:
+		return c.storeData(command[2], data)
+	case 0xC0:
+		return c.getResponse(command[len(command)-1])
+	}
+	return sw(nil, SWInsNotSupported)
+}
+
+func (c *Card) manageChannel(p1 byte, p2 byte) []byte {
+	if p1 == 0x80 {
+		if p2 != c.channel {
+			return sw(nil, SWIncorrectData)
+		}
+		c.channel, c.selected, c.command, c.response = 0, false, nil, nil
+		return sw(nil, SWOK)
+	}
+	c.channel, c.selected = 1, false
+	return sw([]byte{c.channel}, SWOK)
+}
+
+func (c *Card) selectApplication(aid []byte) []byte {
+	aids := c.AIDs
+	if len(aids) == 0 {
+		aids = [][]byte{lpa.GSMAISDRApplicationAID}
+	}
+	if c.channel == 0 || !slices.ContainsFunc(aids, func(v []byte) bool { return bytes.Equal(v, aid) }) {
+		return sw(nil, SWFileNotFound)
+	}
+	c.selected = true
+	return sw(nil, SWOK)
+}
+
+func (c *Card) storeData(p1 byte, block []byte) []byte {
+	if !c.selected {
+		return sw(nil, SWConditionsNotMet)
+	}
+	c.command = append(c.command, block...)
+	// Bit 8 of P1 marks the last block of the command.
+	if p1&0x80 == 0 {
+		return sw(nil, SWOK)
+	}
+	var request bertlv.TLV
+	err := request.UnmarshalBinary(c.command)
+	c.command = nil
+	if err != nil {
+		return sw(nil, SWIncorrectData)
+	}
+	response := c.handle(&request)
+	if response == nil {
+		return sw(nil, SWIncorrectData)
+	}
+	data, _ := response.MarshalBinary()
+	if len(data) <= maxShortResponseSize {
+		return sw(data, SWOK)
+	}
+	c.response = data
+	return sw(nil, moreData(len(data)))
+}
+
+func (c *Card) getResponse(le byte) []byte {
+	n := int(le)
+	if n == 0 {
+		n = maxShortResponseSize
+	}
+	n = min(n, len(c.response))
+	chunk := c.response[:n]
+	c.response = c.response[n:]
+	if len(c.response) == 0 {
+		return sw(chunk, SWOK)
+	}
+	return sw(chunk, moreData(len(c.response)))
+}
+
+func (c *Card) handle(request *bertlv.TLV) *bertlv.TLV {
+	switch {
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 62):
+		return bertlv.NewChildren(request.Tag, bertlv.NewValue(bertlv.Application.Primitive(26), c.EID))
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 34):
+		return c.euiccInfo2()
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 45):
+		return c.profileInfoList(request)
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 49):
+		return result(request.Tag, c.setProfileState(request, sgp22.ProfileEnabled))
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 50):
+		return result(request.Tag, c.setProfileState(request, sgp22.ProfileDisabled))
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 51):
+		return result(request.Tag, c.deleteProfile(request))
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 41):
+		return result(request.Tag, c.setNickname(request))
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 40):
+		return c.listNotification(request)
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 43):
+		return c.retrieveNotificationsList(request)
+	case request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 48):
+		return result(request.Tag, c.removeNotification(request))
+	}
+	return nil
+}
+
+// region Profiles
+
+func (c *Card) euiccInfo2() *bertlv.TLV {
+	return bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(34),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), []byte{0x02, 0x03, 0x00}),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(2), []byte{0x02, 0x02, 0x00}),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(3), []byte{0x04, 0x02, 0x00}),
+		// extCardResource: installed applications, free non-volatile memory and free volatile memory.
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(4), []byte{0x81, 0x01, byte(len(c.Profiles)), 0x82, 0x03, 0x01, 0xF4, 0x00, 0x83, 0x02, 0x10, 0x00}),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(9), bertlv.NewValue(bertlv.Universal.Primitive(4), CIKeyID)),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(10), bertlv.NewValue(bertlv.Universal.Primitive(4), CIKeyID)),
+		bertlv.NewValue(bertlv.Universal.Primitive(12), []byte(SasAccreditationNumber)),
+	)
+}
+
+func (c *Card) profileInfoList(request *bertlv.TLV) *bertlv.TLV {
+	var criteria *bertlv.TLV
+	if searchCriteria := request.First(bertlv.ContextSpecific.Constructed(0)); searchCriteria != nil && len(searchCriteria.Children) > 0 {
+		criteria = searchCriteria.Children[0]
+	}
+	var profiles []*bertlv.TLV
+	for idx, p := range c.Profiles {
+		if criteria != nil && !c.match(idx, p, criteria) {
+			continue
+		}
+		info := bertlv.NewChildren(
+			bertlv.Private.Constructed(3),
+			bertlv.NewValue(bertlv.Application.Primitive(26), p.ICCID),
+			bertlv.NewValue(bertlv.Application.Primitive(15), c.isdpAID(idx, p)),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(112), []byte{byte(p.State)}),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(17), []byte(p.ServiceProviderName)),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(18), []byte(p.ProfileName)),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(21), []byte{byte(c.class(p))}),
+		)
+		if p.Nickname != "" {
+			info.Children = append(info.Children, bertlv.NewValue(bertlv.ContextSpecific.Primitive(16), []byte(p.Nickname)))
+		}
+		profiles = append(profiles, info)
+	}
+	return bertlv.NewChildren(request.Tag, bertlv.NewChildren(bertlv.ContextSpecific.Constructed(0), profiles...))
+}
+
+func (c *Card) setProfileState(request *bertlv.TLV, state sgp22.ProfileState) byte {
+	identifier := request.First(bertlv.ContextSpecific.Constructed(0))
+	if identifier == nil || len(identifier.Children) == 0 {
+		return 1
+	}
+	p := c.find(identifier.Children[0])
+	if p == nil {
+		return 1
+	}
+	if p.State == state {
+		return 2
+	}
+	if state == sgp22.ProfileEnabled {
+		for _, other := range c.Profiles {
+			if other != p && other.State == sgp22.ProfileEnabled {
+				other.State = sgp22.ProfileDisabled
+				c.notify(other, sgp22.NotificationEventDisable)
+			}
+		}
+	}
+	p.State = state
+	c.notify(p, util.If(state == sgp22.ProfileEnabled, sgp22.NotificationEventEnable, sgp22.NotificationEventDisable))
+	if state == sgp22.ProfileEnabled && c.OnEnable != nil {
+		c.OnEnable(p.ICCID)
+	}
+	return 0
+}
+
+func (c *Card) deleteProfile(request *bertlv.TLV) byte {
+	if len(request.Children) == 0 {
+		return 1
+	}
+	p := c.find(request.Children[0])
+	if p == nil {
+		return 1
+	}
+	if p.State == sgp22.ProfileEnabled {
+		return 2
+	}
+	c.Profiles = slices.DeleteFunc(c.Profiles, func(v *Profile) bool { return v == p })
+	c.notify(p, sgp22.NotificationEventDelete)
+	return 0
+}
+
+func (c *Card) setNickname(request *bertlv.TLV) byte {
+	iccid := request.First(bertlv.Application.Primitive(26))
+	if iccid == nil {
+		return 1
+	}
+	p := c.find(iccid)
+	if p == nil {
+		return 1
+	}
+	p.Nickname = ""
+	if nickname := request.First(bertlv.ContextSpecific.Primitive(16)); nickname != nil {
+		p.Nickname = string(nickname.Value)
+	}
+	return 0
+}
+
+// find looks a profile up by ICCID (5A) or ISD-P AID (4F).
+func (c *Card) find(identifier *bertlv.TLV) *Profile {
+	for idx, p := range c.Profiles {
+		if c.match(idx, p, identifier) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (c *Card) match(idx int, p *Profile, criteria *bertlv.TLV) bool {
+	switch {
+	case criteria.Tag.If(bertlv.Application, bertlv.Primitive, 26):
+		return bytes.Equal(criteria.Value, p.ICCID)
+	case criteria.Tag.If(bertlv.Application, bertlv.Primitive, 15):
+		return bytes.Equal(criteria.Value, c.isdpAID(idx, p))
+	case criteria.Tag.If(bertlv.ContextSpecific, bertlv.Primitive, 21):
+		return len(criteria.Value) == 1 && sgp22.ProfileClass(criteria.Value[0]) == c.class(p)
+	}
+	return false
+}
+
+func (c *Card) isdpAID(idx int, p *Profile) []byte {
+	if p.ISDPAID == nil {
+		p.ISDPAID = ISDPAID(idx + 1)
+	}
+	return p.ISDPAID
+}
+
+func (c *Card) class(p *Profile) sgp22.ProfileClass {
+	if p.Class == 0 {
+		return sgp22.ProfileClassOperational
+	}
+	return p.Class
+}
+
+// endregion
+
+// region Notifications
+
+func (c *Card) notify(p *Profile, event sgp22.NotificationEvent) {
+	if p.SMDPAddress == "" {
+		return
+	}
+	for _, n := range c.Notifications {
+		c.sequence = max(c.sequence, n.SequenceNumber)
+	}
+	c.sequence++
+	c.Notifications = append(c.Notifications, &Notification{
+		SequenceNumber: c.sequence,
+		Event:          event,
+		Address:        p.SMDPAddress,
+		ICCID:          p.ICCID,
+	})
+}
+
+func (c *Card) listNotification(request *bertlv.TLV) *bertlv.TLV {
+	var filter []bool
+	if f := request.First(bertlv.ContextSpecific.Primitive(1)); f != nil {
+		primitive.UnmarshalBitString(&filter).UnmarshalBinary(f.Value)
+	}
+	filtered := slices.Contains(filter, true)
+	var notifications []*bertlv.TLV
+	for _, n := range c.Notifications {
+		if filtered && (int(n.Event) >= len(filter) || !filter[n.Event]) {
+			continue
+		}
+		notifications = append(notifications, n.metadata())
+	}
+	return bertlv.NewChildren(request.Tag, bertlv.NewChildren(bertlv.ContextSpecific.Constructed(0), notifications...))
+}
+
+func (c *Card) retrieveNotificationsList(request *bertlv.TLV) *bertlv.TLV {
+	var sequence *sgp22.SequenceNumber
+	var event *sgp22.NotificationEvent
+	if criteria := request.First(bertlv.ContextSpecific.Constructed(0)); criteria != nil {
+		if v := criteria.First(bertlv.ContextSpecific.Primitive(0)); v != nil {
+			sequence = new(sgp22.SequenceNumber)
+			v.UnmarshalValue(primitive.UnmarshalInt(sequence))
+		}
+		if v := criteria.First(bertlv.ContextSpecific.Primitive(1)); v != nil {
+			event = new(sgp22.NotificationEvent)
+			v.UnmarshalValue(event)
+		}
+	}
+	var notifications []*bertlv.TLV
+	for _, n := range c.Notifications {
+		if (sequence != nil && n.SequenceNumber != *sequence) || (event != nil && n.Event != *event) {
+			continue
+		}
+		// otherSignedNotification: the signature and certificates are not verified by the LPA.
+		notifications = append(notifications, bertlv.NewChildren(
+			bertlv.Universal.Constructed(16),
+			n.metadata(),
+			bertlv.NewValue(bertlv.Application.Primitive(55), make([]byte, 64)),
+		))
+	}
+	return bertlv.NewChildren(request.Tag, bertlv.NewChildren(bertlv.ContextSpecific.Constructed(0), notifications...))
+}
+
+func (c *Card) removeNotification(request *bertlv.TLV) byte {
+	var sequence sgp22.SequenceNumber
+	if v := request.First(bertlv.ContextSpecific.Primitive(0)); v == nil || v.UnmarshalValue(primitive.UnmarshalInt(&sequence)) != nil {
+		return 127
+	}
+	length := len(c.Notifications)
+	c.Notifications = slices.DeleteFunc(c.Notifications, func(n *Notification) bool { return n.SequenceNumber == sequence })
+	if len(c.Notifications) == length {
+		return 1
+	}
+	return 0
+}
+
+func (n *Notification) metadata() *bertlv.TLV {
+	event := n.Event
+	return bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(47),
+		must(bertlv.MarshalValue(bertlv.ContextSpecific.Primitive(0), primitive.MarshalInt(n.SequenceNumber))),
+		must(bertlv.MarshalValue(bertlv.ContextSpecific.Primitive(1), &event)),
+		bertlv.NewValue(bertlv.Universal.Primitive(12), []byte(n.Address)),
+		bertlv.NewValue(bertlv.Application.Primitive(26), n.ICCID),
+	)
+}
+
+// endregion
+
+var _ apdu.SmartCardChannel = (*Card)(nil)
+
+func result(tag bertlv.Tag, code byte) *bertlv.TLV {
+	return bertlv.NewChildren(tag, bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), []byte{code}))
+}
+
+// moreData tells the terminal how many bytes are left, 0x00 standing for 256 or more.
+func moreData(n int) uint16 {
+	return SWMoreData | uint16(min(n, maxShortResponseSize)&0xFF)
+}
+
+func sw(data []byte, sw uint16) []byte {
+	return append(append([]byte{}, data...), byte(sw>>8), byte(sw))
+}
+
+func must(tlv *bertlv.TLV, err error) *bertlv.TLV {
+	if err != nil {
+		panic(err)
+	}
+	return tlv
+}
@@ -0,0 +1,207 @@
+package serial
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Modem is a scripted AT modem behind a pseudo terminal.
+// Open Device like a real serial port, the fake answers on the master side.
+type Modem struct {
+	Device string
+	// Echo writes every received command back before the response, like ATE1.
+	Echo bool
+
+	master   *os.File
+	slave    *os.File
+	mutex    sync.Mutex
+	write    sync.Mutex
+	handlers []handler
+	commands []string
+	done     chan struct{}
+}
+
+// Handler answers a single command line, without the trailing CR LF.
+type Handler func(command string) Response
+
+type handler struct {
+	prefix string
+	fn     Handler
+}
+
+// Response is everything the modem writes back for a single command.
+type Response struct {
+	// URCs are unsolicited result codes written before the response.
+	URCs []string
+	// Lines are the information text lines of the response.
+	Lines []string
+	// Result is the final result code, e.g. OK, ERROR or +CME ERROR: 10.
+	// An empty result leaves the command unanswered, which is useful to test timeouts.
+	Result string
+	// Delay is waited before the response is written.
+	Delay time.Duration
+}
+
+func OK(lines ...string) Response { return Response{Lines: lines, Result: "OK"} }
+
+func Error() Response { return Response{Result: "ERROR"} }
+
+func CMEError(code int) Response { return Response{Result: fmt.Sprintf("+CME ERROR: %d", code)} }
+
+func CMSError(code int) Response { return Response{Result: fmt.Sprintf("+CMS ERROR: %d", code)} }
+
+func NewModem() (*Modem, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	m := &Modem{master: master, done: make(chan struct{})}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	m.Device = fmt.Sprintf("/dev/pts/%d", n)
+	// Keep the slave side open so the master does not see EIO once a client closes the port,
+	// and put it in raw mode, otherwise the line discipline would echo the responses back.
+	if m.slave, err = os.OpenFile(m.Device, os.O_RDWR|unix.O_NOCTTY, 0); err != nil {
+		master.Close()
+		return nil, err
+	}
+	if err := m.raw(); err != nil {
+		m.Close()
+		return nil, err
+	}
+	go m.serve()
+	return m, nil
+}
+
+func (m *Modem) raw() error {
+	fd := int(m.slave.Fd())
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
+
+// Handle registers fn for every command starting with prefix, compared case-insensitively.
+// Handlers registered later take precedence, an empty prefix matches every command.
+// Commands without a handler are answered with ERROR.
+func (m *Modem) Handle(prefix string, fn Handler) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.handlers = append(m.handlers, handler{prefix: strings.ToUpper(prefix), fn: fn})
+}
+
+// Reply registers a fixed response for every command starting with prefix.
+func (m *Modem) Reply(prefix string, response Response) {
+	m.Handle(prefix, func(string) Response { return response })
+}
+
+// URC writes an unsolicited result code, e.g. +CMTI: "SM",3.
+func (m *Modem) URC(line string) error {
+	return m.writeLines(line)
+}
+
+// Commands returns every command received so far.
+func (m *Modem) Commands() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string{}, m.commands...)
+}
+
+func (m *Modem) Close() error {
+	select {
+	case <-m.done:
+		return nil
+	default:
+		close(m.done)
+	}
+	if m.slave != nil {
+		m.slave.Close()
+	}
+	return m.master.Close()
+}
+
+func (m *Modem) serve() {
+	reader := bufio.NewReader(m.master)
+	for {
+		line, err := reader.ReadString('\r')
+		if err != nil {
+			return
+		}
+		command := strings.TrimSpace(line)
+		if command == "" {
+			continue
+		}
+		slog.Debug("[Fake Modem] Received", "device", m.Device, "command", command)
+		if m.Echo {
+			if err := m.writeRaw(command + "\r"); err != nil {
+				return
+			}
+		}
+		response := m.handle(command)
+		select {
+		case <-time.After(response.Delay):
+		case <-m.done:
+			return
+		}
+		lines := append(append(response.URCs, response.Lines...), response.Result)
+		if response.Result == "" {
+			lines = lines[:len(lines)-1]
+		}
+		if err := m.writeLines(lines...); err != nil {
+			return
+		}
+	}
+}
+
+func (m *Modem) handle(command string) Response {
+	m.mutex.Lock()
+	m.commands = append(m.commands, command)
+	var fn Handler
+	for idx := len(m.handlers) - 1; idx >= 0; idx-- {
+		if strings.HasPrefix(strings.ToUpper(command), m.handlers[idx].prefix) {
+			fn = m.handlers[idx].fn
+			break
+		}
+	}
+	m.mutex.Unlock()
+	if fn == nil {
+		return Error()
+	}
+	return fn(command)
+}
+
+func (m *Modem) writeLines(lines ...string) error {
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString("\r\n" + line + "\r\n")
+	}
+	return m.writeRaw(sb.String())
+}
+
+func (m *Modem) writeRaw(data string) error {
+	m.write.Lock()
+	defer m.write.Unlock()
+	_, err := m.master.WriteString(data)
+	return err
+}
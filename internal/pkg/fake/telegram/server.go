@@ -0,0 +1,446 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/mymmrac/telego"
+)
+
+// Token is a well-formed bot token accepted by telego.NewBot.
+const Token = "123456789:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+
+// Server is an in-process stand-in for the Telegram Bot API.
+type Server struct {
+	*httptest.Server
+	Me telego.User
+
+	mutex     sync.Mutex
+	updateID  int
+	messageID int
+	updates   []telego.Update
+	pending   chan struct{}
+	closed    chan struct{}
+	sent      chan telego.Message
+	calls     []Call
+	messages  map[int64][]*telego.Message
+	answers   []telego.AnswerCallbackQueryParams
+	commands  []telego.BotCommand
+	fileID    int
+	files     map[string][]byte
+}
+
+// Call is a single Bot API method invocation received by the server.
+type Call struct {
+	Method string
+	Body   json.RawMessage
+}
+
+type response struct {
+	Ok          bool   `json:"ok"`
+	Result      any    `json:"result,omitempty"`
+	ErrorCode   int    `json:"error_code,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// outgoing covers the parameters of every message-producing method.
+// ReplyMarkup is kept raw because telego.ReplyMarkup is an interface.
+type outgoing struct {
+	ChatID          telego.ChatID           `json:"chat_id"`
+	MessageID       int                     `json:"message_id"`
+	Text            string                  `json:"text"`
+	ParseMode       string                  `json:"parse_mode"`
+	ReplyParameters *telego.ReplyParameters `json:"reply_parameters"`
+	ReplyMarkup     json.RawMessage         `json:"reply_markup"`
+	// Caption and Document are the parameters of sendDocument, Document is the ID of a file already sent.
+	Caption  string `json:"caption"`
+	Document string `json:"document"`
+}
+
+// upload is a file sent to the server.
+type upload struct {
+	name string
+	data []byte
+}
+
+func NewServer() *Server {
+	s := &Server{
+		Me: telego.User{
+			ID:        123456789,
+			IsBot:     true,
+			FirstName: "SMS",
+			Username:  "sms_bot",
+		},
+		pending:  make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+		sent:     make(chan telego.Message, 256),
+		messages: make(map[int64][]*telego.Message, 4),
+		files:    make(map[string][]byte),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Use points config.C at the server and returns a function that restores the previous values.
+func (s *Server) Use() (restore func()) {
+	endpoint, token := config.C.Endpoint, config.C.BotToken
+	config.C.Endpoint, config.C.BotToken = s.URL, Token
+	return func() {
+		config.C.Endpoint, config.C.BotToken = endpoint, token
+	}
+}
+
+// Close releases pending long polls and shuts the server down.
+func (s *Server) Close() {
+	close(s.closed)
+	s.Server.Close()
+}
+
+// Bot returns a bot talking to the server.
+func (s *Server) Bot() (*telego.Bot, error) {
+	return telego.NewBot(Token, telego.WithAPIServer(s.URL), telego.WithDiscardLogger())
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if path, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+Token+"/"); ok {
+		s.download(w, path)
+		return
+	}
+	method, ok := s.method(r.URL.Path)
+	if !ok {
+		s.write(w, http.StatusNotFound, response{ErrorCode: http.StatusNotFound, Description: "Not Found"})
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.write(w, http.StatusBadRequest, response{ErrorCode: http.StatusBadRequest, Description: err.Error()})
+		return
+	}
+	slog.Debug("[Fake Bot API] Received", "method", method, "body", string(body))
+	s.mutex.Lock()
+	s.calls = append(s.calls, Call{Method: method, Body: body})
+	s.mutex.Unlock()
+
+	var result any
+	switch method {
+	case "getMe":
+		result = s.Me
+	case "getUpdates":
+		result, err = s.getUpdates(r.Context(), body)
+	case "sendMessage":
+		result, err = s.sendMessage(body)
+	case "sendDocument":
+		result, err = s.sendDocument(r.Header.Get("Content-Type"), body)
+	case "getFile":
+		result, err = s.getFile(body)
+	case "editMessageText":
+		result, err = s.editMessageText(body)
+	case "deleteMessage":
+		result, err = s.deleteMessage(body)
+	case "answerCallbackQuery":
+		result, err = s.answerCallbackQuery(body)
+	case "setMyCommands":
+		result, err = s.setMyCommands(body)
+	default:
+		s.write(w, http.StatusNotFound, response{ErrorCode: http.StatusNotFound, Description: "Not Found: method not found"})
+		return
+	}
+	if err != nil {
+		s.write(w, http.StatusBadRequest, response{ErrorCode: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+		return
+	}
+	s.write(w, http.StatusOK, response{Ok: true, Result: result})
+}
+
+func (s *Server) method(path string) (string, bool) {
+	path, ok := strings.CutPrefix(path, "/bot"+Token+"/")
+	if !ok || path == "" || strings.Contains(path, "/") {
+		return "", false
+	}
+	return path, true
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, r response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		slog.Error("[Fake Bot API] Failed to write response", "error", err)
+	}
+}
+
+func (s *Server) getUpdates(ctx context.Context, body []byte) ([]telego.Update, error) {
+	var params telego.GetUpdatesParams
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &params); err != nil {
+			return nil, err
+		}
+	}
+	timeout := time.After(time.Duration(params.Timeout) * time.Second)
+	for {
+		if updates := s.confirm(params.Offset); len(updates) > 0 || params.Timeout == 0 {
+			return updates, nil
+		}
+		select {
+		case <-s.pending:
+		case <-timeout:
+			return []telego.Update{}, nil
+		case <-ctx.Done():
+			return []telego.Update{}, nil
+		case <-s.closed:
+			return []telego.Update{}, nil
+		}
+	}
+}
+
+// confirm drops every update below offset and returns the remaining ones.
+func (s *Server) confirm(offset int) []telego.Update {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	updates := s.updates[:0]
+	for _, update := range s.updates {
+		if update.UpdateID >= offset {
+			updates = append(updates, update)
+		}
+	}
+	s.updates = updates
+	return append([]telego.Update{}, s.updates...)
+}
+
+func (s *Server) sendMessage(body []byte) (*telego.Message, error) {
+	var params outgoing
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, err
+	}
+	return s.send(params, nil)
+}
+
+// sendDocument takes the document uploaded in a multipart form, or the ID of a file already sent.
+func (s *Server) sendDocument(contentType string, body []byte) (*telego.Message, error) {
+	var params outgoing
+	var document *upload
+	mediaType, mediaParams, _ := mime.ParseMediaType(contentType)
+	if mediaType == "multipart/form-data" {
+		var err error
+		if params, document, err = s.form(body, mediaParams["boundary"]); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(body, &params); err != nil {
+		return nil, err
+	}
+	if document == nil {
+		s.mutex.Lock()
+		data, ok := s.files[params.Document]
+		s.mutex.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("wrong file identifier %q", params.Document)
+		}
+		document = &upload{name: params.Document, data: data}
+	}
+	return s.send(params, document)
+}
+
+// form reads the parameters and the document of a multipart form, the parameters holding JSON are decoded.
+func (s *Server) form(body []byte, boundary string) (outgoing, *upload, error) {
+	var params outgoing
+	var document *upload
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return params, nil, err
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return params, nil, err
+		}
+		switch part.FormName() {
+		case "chat_id":
+			params.ChatID.ID, err = strconv.ParseInt(string(value), 10, 64)
+		case "caption":
+			params.Caption = string(value)
+		case "parse_mode":
+			params.ParseMode = string(value)
+		case "reply_parameters":
+			err = json.Unmarshal(value, &params.ReplyParameters)
+		case "reply_markup":
+			params.ReplyMarkup = value
+		case "document":
+			if part.FileName() == "" {
+				params.Document = string(value)
+			} else {
+				document = &upload{name: part.FileName(), data: value}
+			}
+		}
+		if err != nil {
+			return params, nil, fmt.Errorf("%s: %w", part.FormName(), err)
+		}
+	}
+	return params, document, nil
+}
+
+// send stores the message the bot sent, with the document if it has one.
+func (s *Server) send(params outgoing, document *upload) (*telego.Message, error) {
+	if params.ChatID.ID == 0 {
+		return nil, fmt.Errorf("chat not found")
+	}
+	s.mutex.Lock()
+	s.messageID++
+	message := &telego.Message{
+		MessageID:   s.messageID,
+		From:        &s.Me,
+		Date:        time.Now().Unix(),
+		Chat:        telego.Chat{ID: params.ChatID.ID, Type: telego.ChatTypePrivate},
+		Text:        params.Text,
+		Caption:     params.Caption,
+		ReplyMarkup: s.inlineKeyboard(params.ReplyMarkup),
+	}
+	if document != nil {
+		message.Document = s.store(document)
+	}
+	if params.ReplyParameters != nil {
+		message.ReplyToMessage = s.find(params.ChatID.ID, params.ReplyParameters.MessageID)
+	}
+	s.messages[message.Chat.ID] = append(s.messages[message.Chat.ID], message)
+	s.mutex.Unlock()
+	select {
+	case s.sent <- *message:
+	default:
+		slog.Warn("[Fake Bot API] Sent message buffer is full, dropping", "chatID", message.Chat.ID)
+	}
+	return message, nil
+}
+
+// store keeps the file so it can be fetched with getFile, the caller holds the mutex.
+func (s *Server) store(file *upload) *telego.Document {
+	s.fileID++
+	id := fmt.Sprintf("file-%d", s.fileID)
+	s.files[id] = file.data
+	return &telego.Document{FileID: id, FileUniqueID: id, FileName: file.name, FileSize: int64(len(file.data))}
+}
+
+func (s *Server) getFile(body []byte) (*telego.File, error) {
+	var params telego.GetFileParams
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.files[params.FileID]
+	if !ok {
+		return nil, fmt.Errorf("invalid file_id")
+	}
+	return &telego.File{FileID: params.FileID, FileUniqueID: params.FileID, FileSize: int64(len(data)), FilePath: "documents/" + params.FileID}, nil
+}
+
+// download serves the content of a file, at the path getFile returned.
+func (s *Server) download(w http.ResponseWriter, path string) {
+	s.mutex.Lock()
+	data, ok := s.files[strings.TrimPrefix(path, "documents/")]
+	s.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := w.Write(data); err != nil {
+		slog.Error("[Fake Bot API] Failed to write file", "error", err)
+	}
+}
+
+func (s *Server) editMessageText(body []byte) (*telego.Message, error) {
+	var params outgoing
+	if err := json.Unmarshal(body, &params); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	message := s.find(params.ChatID.ID, params.MessageID)
+	if message == nil {
+		return nil, fmt.Errorf("message to edit not found")
+	}
+	// Like Telegram, an edit is only refused when it changes neither the text nor the buttons.
+	markup := s.inlineKeyboard(params.ReplyMarkup)
+	if message.Text == params.Text && reflect.DeepEqual(message.ReplyMarkup, markup) {
+		return nil, fmt.Errorf("message is not modified")
+	}
+	message.Text = params.Text
+	message.ReplyMarkup = markup
+	message.EditDate = time.Now().Unix()
+	return message, nil
+}
+
+func (s *Server) deleteMessage(body []byte) (bool, error) {
+	var params outgoing
+	if err := json.Unmarshal(body, &params); err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	messages := s.messages[params.ChatID.ID]
+	for idx, message := range messages {
+		if message.MessageID == params.MessageID {
+			s.messages[params.ChatID.ID] = append(messages[:idx], messages[idx+1:]...)
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("message to delete not found")
+}
+
+func (s *Server) answerCallbackQuery(body []byte) (bool, error) {
+	var params telego.AnswerCallbackQueryParams
+	if err := json.Unmarshal(body, &params); err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.answers = append(s.answers, params)
+	return true, nil
+}
+
+func (s *Server) setMyCommands(body []byte) (bool, error) {
+	var params struct {
+		Commands []telego.BotCommand `json:"commands"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.commands = params.Commands
+	return true, nil
+}
+
+func (s *Server) inlineKeyboard(raw json.RawMessage) *telego.InlineKeyboardMarkup {
+	var markup telego.InlineKeyboardMarkup
+	if len(raw) == 0 || json.Unmarshal(raw, &markup) != nil || markup.InlineKeyboard == nil {
+		return nil
+	}
+	return &markup
+}
+
+func (s *Server) find(chatID int64, messageID int) *telego.Message {
+	for _, message := range s.messages[chatID] {
+		if message.MessageID == messageID {
+			return message
+		}
+	}
+	return nil
+}
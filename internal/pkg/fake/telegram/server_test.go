@@ -0,0 +1,185 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const chatID = 42
+
+func newBot(t *testing.T) (*Server, *telego.Bot) {
+	t.Helper()
+	server := NewServer()
+	t.Cleanup(server.Close)
+	bot, err := server.Bot()
+	if err != nil {
+		t.Fatalf("Bot() error = %v", err)
+	}
+	return server, bot
+}
+
+func TestUpdates(t *testing.T) {
+	server, bot := newBot(t)
+	ctx := context.Background()
+	sent := server.SendMessage(chatID, "/start now")
+	updates, err := bot.GetUpdates(ctx, &telego.GetUpdatesParams{})
+	if err != nil || len(updates) != 1 {
+		t.Fatalf("GetUpdates() = %v, %v, want one update", updates, err)
+	}
+	message := updates[0].Message
+	if message == nil || message.MessageID != sent.MessageID || message.Text != "/start now" {
+		t.Fatalf("GetUpdates() = %+v, want the message sent", updates[0])
+	}
+	if len(message.Entities) != 1 || message.Entities[0].Type != telego.EntityTypeBotCommand || message.Entities[0].Length != len("/start") {
+		t.Errorf("entities = %+v, want the /start command", message.Entities)
+	}
+
+	// Updates below the offset are confirmed and never returned again.
+	offset := updates[0].UpdateID + 1
+	updates, err = bot.GetUpdates(ctx, &telego.GetUpdatesParams{Offset: offset})
+	if err != nil || len(updates) != 0 {
+		t.Fatalf("GetUpdates() = %v, %v, want no update", updates, err)
+	}
+
+	// A long poll returns as soon as an update arrives.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.Click(chatID, *sent, "data")
+	}()
+	updates, err = bot.GetUpdates(ctx, &telego.GetUpdatesParams{Offset: offset, Timeout: 5})
+	if err != nil || len(updates) != 1 || updates[0].CallbackQuery == nil || updates[0].CallbackQuery.Data != "data" {
+		t.Fatalf("GetUpdates() = %v, %v, want the callback query", updates, err)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	server, bot := newBot(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := bot.SendMessage(ctx, tu.Message(tu.ID(0), "Hello")); err == nil {
+		t.Fatal("SendMessage() to no chat succeeded")
+	}
+	request := server.SendMessage(chatID, "Hi")
+	message, err := bot.SendMessage(ctx, tu.Message(tu.ID(chatID), "Hello").
+		WithReplyParameters(&telego.ReplyParameters{MessageID: request.MessageID}).
+		WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(tu.InlineKeyboardButton("OK").WithCallbackData("ok")))))
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	received, err := server.WaitForMessage(ctx)
+	if err != nil {
+		t.Fatalf("WaitForMessage() error = %v", err)
+	}
+	if received.MessageID != message.MessageID || received.Text != "Hello" {
+		t.Errorf("WaitForMessage() = %+v, want the message sent", received)
+	}
+	if received.ReplyToMessage == nil || received.ReplyToMessage.MessageID != request.MessageID {
+		t.Errorf("reply to = %+v, want message %d", received.ReplyToMessage, request.MessageID)
+	}
+	if received.ReplyMarkup == nil || received.ReplyMarkup.InlineKeyboard[0][0].CallbackData != "ok" {
+		t.Errorf("reply markup = %+v, want the OK button", received.ReplyMarkup)
+	}
+	if messages := server.Messages(chatID); len(messages) != 2 {
+		t.Errorf("Messages() = %d messages, want 2", len(messages))
+	}
+}
+
+func TestEditMessageText(t *testing.T) {
+	server, bot := newBot(t)
+	ctx := context.Background()
+	keyboard := func(data string) *telego.InlineKeyboardMarkup {
+		return tu.InlineKeyboard(tu.InlineKeyboardRow(tu.InlineKeyboardButton(data).WithCallbackData(data)))
+	}
+	message, err := bot.SendMessage(ctx, tu.Message(tu.ID(chatID), "Bands").WithReplyMarkup(keyboard("on")))
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		text    string
+		markup  *telego.InlineKeyboardMarkup
+		wantErr bool
+	}{
+		{name: "markup only", text: "Bands", markup: keyboard("off")},
+		{name: "unchanged", text: "Bands", markup: keyboard("off"), wantErr: true},
+		{name: "text only", text: "Bands saved", markup: keyboard("off")},
+		{name: "markup removed", text: "Bands saved"},
+		{name: "unchanged without markup", text: "Bands saved", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := &telego.EditMessageTextParams{ChatID: tu.ID(chatID), MessageID: message.MessageID, Text: tt.text}
+			if tt.markup != nil {
+				params = params.WithReplyMarkup(tt.markup)
+			}
+			_, err := bot.EditMessageText(ctx, params)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "message is not modified") {
+					t.Fatalf("EditMessageText() error = %v, want message is not modified", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EditMessageText() error = %v", err)
+			}
+			edited, _ := server.Message(chatID, message.MessageID)
+			if edited.Text != tt.text || (tt.markup == nil) != (edited.ReplyMarkup == nil) {
+				t.Errorf("Message() = %q with %+v, want %q with %+v", edited.Text, edited.ReplyMarkup, tt.text, tt.markup)
+			}
+		})
+	}
+	if _, err := bot.EditMessageText(ctx, &telego.EditMessageTextParams{ChatID: tu.ID(chatID), MessageID: 999, Text: "x"}); err == nil {
+		t.Error("EditMessageText() of a missing message succeeded")
+	}
+}
+
+func TestDocument(t *testing.T) {
+	server, bot := newBot(t)
+	ctx := context.Background()
+	message, err := bot.SendDocument(ctx, tu.Document(tu.ID(chatID), tu.FileFromReader(bytes.NewReader([]byte("a,b\n1,2\n")), "sheet.csv")).WithCaption("Report"))
+	if err != nil {
+		t.Fatalf("SendDocument() error = %v", err)
+	}
+	if message.Document == nil || message.Document.FileName != "sheet.csv" || message.Caption != "Report" {
+		t.Fatalf("SendDocument() = %+v, want sheet.csv with its caption", message)
+	}
+	if data, ok := server.File(message.Document.FileID); !ok || string(data) != "a,b\n1,2\n" {
+		t.Errorf("File() = %q, %t, want the document", data, ok)
+	}
+
+	// A document sent by the user is fetched by the bot with getFile and a download.
+	upload := server.SendDocument(chatID, "contacts.vcf", []byte("BEGIN:VCARD"))
+	file, err := bot.GetFile(ctx, &telego.GetFileParams{FileID: upload.Document.FileID})
+	if err != nil {
+		t.Fatalf("GetFile() error = %v", err)
+	}
+	response, err := http.Get(bot.FileDownloadURL(file.FilePath))
+	if err != nil {
+		t.Fatalf("download error = %v", err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil || string(data) != "BEGIN:VCARD" {
+		t.Errorf("download = %q, %v, want the document", data, err)
+	}
+	if _, err := bot.GetFile(ctx, &telego.GetFileParams{FileID: "unknown"}); err == nil {
+		t.Error("GetFile() of an unknown file succeeded")
+	}
+
+	// A file already sent is sent again by its ID.
+	forwarded, err := bot.SendDocument(ctx, tu.Document(tu.ID(chatID), tu.FileFromID(upload.Document.FileID)))
+	if err != nil {
+		t.Fatalf("SendDocument() by ID error = %v", err)
+	}
+	if data, ok := server.File(forwarded.Document.FileID); !ok || string(data) != "BEGIN:VCARD" {
+		t.Errorf("File() = %q, %t, want the document sent again", data, ok)
+	}
+}
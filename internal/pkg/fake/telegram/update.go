@@ -0,0 +1,149 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mymmrac/telego"
+)
+
+// Inject queues an update for the next getUpdates call and returns it with its update ID assigned.
+func (s *Server) Inject(update telego.Update) telego.Update {
+	s.mutex.Lock()
+	s.updateID++
+	update.UpdateID = s.updateID
+	s.updates = append(s.updates, update)
+	s.mutex.Unlock()
+	select {
+	case s.pending <- struct{}{}:
+	default:
+	}
+	return update
+}
+
+// SendMessage injects a private text message from the given user.
+// Commands (text starting with "/") get a bot_command entity just like real clients send.
+func (s *Server) SendMessage(from int64, text string) *telego.Message {
+	s.mutex.Lock()
+	s.messageID++
+	message := &telego.Message{
+		MessageID: s.messageID,
+		From:      &telego.User{ID: from, FirstName: "Admin"},
+		Date:      time.Now().Unix(),
+		Chat:      telego.Chat{ID: from, Type: telego.ChatTypePrivate},
+		Text:      text,
+	}
+	if len(text) > 1 && text[0] == '/' {
+		length := len(text)
+		for idx, r := range text {
+			if r == ' ' || r == '\n' {
+				length = idx
+				break
+			}
+		}
+		message.Entities = []telego.MessageEntity{{Type: telego.EntityTypeBotCommand, Offset: 0, Length: length}}
+	}
+	s.messages[from] = append(s.messages[from], message)
+	s.mutex.Unlock()
+	s.Inject(telego.Update{Message: message})
+	return message
+}
+
+// SendDocument injects a private message from the given user with a file attached, the bot can fetch it with getFile.
+func (s *Server) SendDocument(from int64, name string, data []byte) *telego.Message {
+	s.mutex.Lock()
+	s.messageID++
+	message := &telego.Message{
+		MessageID: s.messageID,
+		From:      &telego.User{ID: from, FirstName: "Admin"},
+		Date:      time.Now().Unix(),
+		Chat:      telego.Chat{ID: from, Type: telego.ChatTypePrivate},
+		Document:  s.store(&upload{name: name, data: data}),
+	}
+	s.messages[from] = append(s.messages[from], message)
+	s.mutex.Unlock()
+	s.Inject(telego.Update{Message: message})
+	return message
+}
+
+// File returns the content of a file sent by the bot or by a user.
+func (s *Server) File(fileID string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data, ok := s.files[fileID]
+	return data, ok
+}
+
+// Click injects a callback query as if the user pressed the inline button carrying data on message.
+func (s *Server) Click(from int64, message telego.Message, data string) telego.Update {
+	return s.Inject(telego.Update{
+		CallbackQuery: &telego.CallbackQuery{
+			ID:           fmt.Sprintf("%d:%d", message.Chat.ID, time.Now().UnixNano()),
+			From:         telego.User{ID: from, FirstName: "Admin"},
+			Message:      &message,
+			ChatInstance: fmt.Sprintf("%d", message.Chat.ID),
+			Data:         data,
+		},
+	})
+}
+
+// WaitForMessage returns the next message the bot sends to any chat.
+func (s *Server) WaitForMessage(ctx context.Context) (*telego.Message, error) {
+	select {
+	case message := <-s.sent:
+		return &message, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Messages returns the current state of every message in the chat, in order.
+// Messages sent by the bot reflect later edits; deleted messages are omitted.
+func (s *Server) Messages(chatID int64) []telego.Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	messages := make([]telego.Message, 0, len(s.messages[chatID]))
+	for _, message := range s.messages[chatID] {
+		messages = append(messages, *message)
+	}
+	return messages
+}
+
+// Message returns the current state of a single message.
+func (s *Server) Message(chatID int64, messageID int) (telego.Message, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if message := s.find(chatID, messageID); message != nil {
+		return *message, true
+	}
+	return telego.Message{}, false
+}
+
+// Calls returns every method call received so far, optionally filtered by method name.
+func (s *Server) Calls(methods ...string) []Call {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var calls []Call
+	for _, call := range s.calls {
+		if len(methods) == 0 || slices.Contains(methods, call.Method) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Commands returns the command list set by the latest setMyCommands call.
+func (s *Server) Commands() []telego.BotCommand {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.commands)
+}
+
+// Answers returns every answered callback query.
+func (s *Server) Answers() []telego.AnswerCallbackQueryParams {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.answers)
+}
@@ -0,0 +1,168 @@
+package lpa
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+)
+
+// Console sends raw APDUs to the SIM through the same driver the LPA uses.
+// Until an AID is selected the APDUs go to the basic channel as they are,
+// which only the AT driver supports, QMI and MBIM need a logical channel.
+type Console struct {
+	ch      apdu.SmartCardChannel
+	channel byte
+	mutex   sync.Mutex
+}
+
+func NewConsole(m *modem.Modem) (*Console, error) {
+	ch, err := createChannel(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Connect(); err != nil {
+		ch.Disconnect()
+		return nil, err
+	}
+	return &Console{ch: ch}, nil
+}
+
+// Select opens a logical channel with the AID selected on it, replacing the previous one.
+func (c *Console) Select(aid []byte) (byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.channel > 0 {
+		if err := c.ch.CloseLogicalChannel(c.channel); err != nil {
+			return 0, err
+		}
+		c.channel = 0
+	}
+	channel, err := c.ch.OpenLogicalChannel(aid)
+	if err != nil {
+		return 0, err
+	}
+	c.channel = channel
+	return channel, nil
+}
+
+// Transmit sends the command on the selected channel and returns the complete response.
+// Data announced with 61xx is fetched with GET RESPONSE and a wrong Le (6Cxx) is corrected once.
+func (c *Console) Transmit(command []byte) (apdu.Response, error) {
+	if len(command) < 4 {
+		return nil, errors.New("an APDU has at least 4 bytes: CLA INS P1 P2")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	command = c.onChannel(command)
+	response, err := c.transmit(command)
+	if err != nil {
+		return nil, err
+	}
+	if response.SW1() == 0x6C && len(command) == 5 {
+		command[4] = response.SW2()
+		if response, err = c.transmit(command); err != nil {
+			return nil, err
+		}
+	}
+	var data []byte
+	for response.HasMore() {
+		data = append(data, response.Data()...)
+		if response, err = c.transmit(c.onChannel([]byte{0x00, 0xC0, 0x00, 0x00, response.SW2()})); err != nil {
+			return nil, err
+		}
+	}
+	return append(data, response...), nil
+}
+
+// onChannel copies the command with the logical channel number encoded in CLA.
+func (c *Console) onChannel(command []byte) []byte {
+	command = append([]byte{}, command...)
+	if c.channel > 0 {
+		command[0] = command[0]&0xF0 | c.channel&0x0F
+	}
+	return command
+}
+
+// transmit returns whatever status word the card answered, the drivers report
+// anything but 90xx and 61xx as an error although the response is still there.
+func (c *Console) transmit(command []byte) (apdu.Response, error) {
+	response, err := c.ch.Transmit(command)
+	if len(response) >= 2 {
+		return response, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("invalid response: %X", response)
+	}
+	return nil, err
+}
+
+func (c *Console) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var err error
+	if c.channel > 0 {
+		err = c.ch.CloseLogicalChannel(c.channel)
+	}
+	return errors.Join(err, c.ch.Disconnect())
+}
+
+// StatusWord describes the status word of a response as defined in ISO/IEC 7816-4 and ETSI TS 102 221.
+func StatusWord(sw uint16) string {
+	if description, ok := statusWords[sw]; ok {
+		return description
+	}
+	sw1, sw2 := byte(sw>>8), byte(sw)
+	switch {
+	case sw1 == 0x61:
+		return fmt.Sprintf("%d bytes still available", sw2)
+	case sw1 == 0x6C:
+		return fmt.Sprintf("wrong Le, exact length is %d", sw2)
+	case sw1 == 0x63 && sw2&0xF0 == 0xC0:
+		return fmt.Sprintf("verification failed, %d retries left", sw2&0x0F)
+	case sw1 == 0x91:
+		return fmt.Sprintf("normal ending, %d bytes of proactive command pending", sw2)
+	case sw1 == 0x92:
+		return "normal ending with retries"
+	}
+	return "unknown status"
+}
+
+var statusWords = map[uint16]string{
+	0x9000: "success",
+	0x6200: "no information given, state unchanged",
+	0x6281: "part of returned data may be corrupted",
+	0x6282: "end of file or record reached before reading Le bytes",
+	0x6283: "selected file invalidated",
+	0x6285: "selected file in termination state",
+	0x6300: "authentication failed",
+	0x6400: "execution error, state unchanged",
+	0x6500: "execution error, state changed",
+	0x6581: "memory failure",
+	0x6700: "wrong length",
+	0x6881: "logical channel not supported",
+	0x6882: "secure messaging not supported",
+	0x6900: "command not allowed",
+	0x6981: "command incompatible with file structure",
+	0x6982: "security status not satisfied",
+	0x6983: "authentication method blocked",
+	0x6984: "referenced data invalidated",
+	0x6985: "conditions of use not satisfied",
+	0x6986: "command not allowed, no current EF",
+	0x6A80: "incorrect data",
+	0x6A81: "function not supported",
+	0x6A82: "file or application not found",
+	0x6A83: "record not found",
+	0x6A84: "not enough memory space",
+	0x6A86: "incorrect P1 P2",
+	0x6A87: "Lc inconsistent with P1 P2",
+	0x6A88: "referenced data not found",
+	0x6B00: "wrong P1 P2",
+	0x6D00: "instruction not supported",
+	0x6E00: "class not supported",
+	0x6F00: "technical problem, no precise diagnosis",
+	0x9862: "authentication error, application specific",
+	0x9863: "security session or association expired",
+}
@@ -51,11 +51,16 @@ var AIDs = [][]byte{
 }
 
 func New(m *modem.Modem) (*LPA, error) {
-	var l = new(LPA)
-	ch, err := l.createChannel(m)
+	ch, err := createChannel(m)
 	if err != nil {
 		return nil, err
 	}
+	return NewWithChannel(ch)
+}
+
+// NewWithChannel creates the LPA on top of the given smart card channel.
+func NewWithChannel(ch apdu.SmartCardChannel) (*LPA, error) {
+	var l = new(LPA)
 	opt := &lpa.Option{
 		Channel:              ch,
 		AdminProtocolVersion: "2.2.0",
@@ -80,7 +85,7 @@ func (l *LPA) tryCreateClient(opt *lpa.Option) error {
 	return errors.New("no supported ISD-R AID found or it's not an eUICC")
 }
 
-func (l *LPA) createChannel(m *modem.Modem) (apdu.SmartCardChannel, error) {
+func createChannel(m *modem.Modem) (apdu.SmartCardChannel, error) {
 	slot := uint8(util.If(m.PrimarySimSlot > 0, m.PrimarySimSlot, 1))
 	var err error
 	switch m.PrimaryPortType() {
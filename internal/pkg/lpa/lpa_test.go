@@ -0,0 +1,225 @@
+package lpa
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	sgp22 "github.com/damonto/euicc-go/v2"
+
+	"github.com/damonto/telegram-sms/internal/pkg/fake/euicc"
+)
+
+const testEID = "89049032123451234512345678901235"
+
+func mustICCID(t *testing.T, value string) sgp22.ICCID {
+	t.Helper()
+	iccid, err := sgp22.NewICCID(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return iccid
+}
+
+// newTestLPA creates the LPA on a card with two profiles, the first one enabled.
+// Their notifications go to smdp, which counts the notifications it receives.
+func newTestLPA(t *testing.T, smdp *smdpServer) (*LPA, *euicc.Card) {
+	t.Helper()
+	eid, _ := hex.DecodeString(testEID)
+	card := &euicc.Card{
+		EID: eid,
+		Profiles: []*euicc.Profile{
+			{ICCID: mustICCID(t, "8944110000000000011"), State: sgp22.ProfileEnabled, ServiceProviderName: "Alpha", ProfileName: "Alpha Mobile"},
+			{ICCID: mustICCID(t, "8944110000000000029"), State: sgp22.ProfileDisabled, ServiceProviderName: "Beta", ProfileName: "Beta Mobile"},
+		},
+	}
+	if smdp != nil {
+		for _, p := range card.Profiles {
+			p.SMDPAddress = smdp.Listener.Addr().String()
+		}
+	}
+	l, err := NewWithChannel(card)
+	if err != nil {
+		t.Fatalf("NewWithChannel() error = %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if smdp != nil {
+		l.HTTP.Client = smdp.Client()
+	}
+	return l, card
+}
+
+// smdpServer is an SM-DP+ that accepts every notification.
+type smdpServer struct {
+	*httptest.Server
+	mutex         sync.Mutex
+	notifications int
+}
+
+func newSMDPServer(t *testing.T) *smdpServer {
+	s := new(smdpServer)
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gsma/rsp2/es9plus/handleNotification" {
+			http.NotFound(w, r)
+			return
+		}
+		s.mutex.Lock()
+		s.notifications++
+		s.mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *smdpServer) received() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.notifications
+}
+
+func TestInfo(t *testing.T) {
+	l, _ := newTestLPA(t, nil)
+	info, err := l.Info()
+	if err != nil {
+		t.Fatalf("Info() error = %v", err)
+	}
+	if info.EID != testEID {
+		t.Errorf("EID = %s, want %s", info.EID, testEID)
+	}
+	if info.SasAcreditationNumber != euicc.SasAccreditationNumber {
+		t.Errorf("SAS accreditation number = %q, want %q", info.SasAcreditationNumber, euicc.SasAccreditationNumber)
+	}
+	if info.FreeSpace != 0x01F400 {
+		t.Errorf("free space = %d, want %d", info.FreeSpace, 0x01F400)
+	}
+	if len(info.Certificates) != 1 {
+		t.Errorf("certificates = %v, want one", info.Certificates)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	l, card := newTestLPA(t, nil)
+	alpha, beta := card.Profiles[0].ICCID, card.Profiles[1].ICCID
+	state := func(iccid sgp22.ICCID) sgp22.ProfileState {
+		t.Helper()
+		profiles, err := l.ListProfile(iccid, nil)
+		if err != nil || len(profiles) != 1 {
+			t.Fatalf("ListProfile(%s) = %v, %v, want one profile", iccid, profiles, err)
+		}
+		return profiles[0].ProfileState
+	}
+
+	profiles, err := l.ListProfile(nil, nil)
+	if err != nil {
+		t.Fatalf("ListProfile() error = %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].ServiceProviderName != "Alpha" || profiles[1].ProfileName != "Beta Mobile" {
+		t.Fatalf("ListProfile() = %v, want Alpha and Beta", profiles)
+	}
+
+	if err := l.EnableProfile(beta, false); err != nil {
+		t.Fatalf("EnableProfile() error = %v", err)
+	}
+	if state(alpha) != sgp22.ProfileDisabled || state(beta) != sgp22.ProfileEnabled {
+		t.Fatal("enabling Beta didn't disable Alpha")
+	}
+	if err := l.EnableProfile(beta, false); err == nil {
+		t.Error("EnableProfile() of the enabled profile error = nil")
+	}
+	if err := l.DisableProfile(beta, false); err != nil {
+		t.Fatalf("DisableProfile() error = %v", err)
+	}
+	if state(beta) != sgp22.ProfileDisabled {
+		t.Fatal("Beta is still enabled")
+	}
+
+	if err := l.SetNickname(alpha, "Work"); err != nil {
+		t.Fatalf("SetNickname() error = %v", err)
+	}
+	if profiles, _ := l.ListProfile(alpha, nil); len(profiles) != 1 || profiles[0].ProfileNickname != "Work" {
+		t.Fatalf("ListProfile() = %v, want the nickname Work", profiles)
+	}
+
+	if err := l.EnableProfile(alpha, false); err != nil {
+		t.Fatalf("EnableProfile() error = %v", err)
+	}
+	if _, err := l.Delete(alpha); err == nil {
+		t.Error("Delete() of the enabled profile error = nil")
+	}
+	if seq, err := l.Delete(beta); err != nil || seq != 0 {
+		t.Fatalf("Delete() = %d, %v, want no notification", seq, err)
+	}
+	if profiles, _ := l.ListProfile(nil, nil); len(profiles) != 1 || profiles[0].ICCID.String() != alpha.String() {
+		t.Fatalf("ListProfile() = %v, want only Alpha left", profiles)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	smdp := newSMDPServer(t)
+	l, card := newTestLPA(t, smdp)
+	beta := card.Profiles[1].ICCID
+
+	if err := l.EnableProfile(beta, false); err != nil {
+		t.Fatalf("EnableProfile() error = %v", err)
+	}
+	notifications, err := l.ListNotification()
+	if err != nil {
+		t.Fatalf("ListNotification() error = %v", err)
+	}
+	events := make([]sgp22.NotificationEvent, 0, len(notifications))
+	for _, n := range notifications {
+		events = append(events, n.ProfileManagementOperation)
+	}
+	if !slices.Equal(events, []sgp22.NotificationEvent{sgp22.NotificationEventDisable, sgp22.NotificationEventEnable}) {
+		t.Fatalf("ListNotification() events = %v, want disable and enable", events)
+	}
+	if enabled, err := l.ListNotification(sgp22.NotificationEventEnable); err != nil || len(enabled) != 1 || enabled[0].ICCID.String() != beta.String() {
+		t.Fatalf("ListNotification(enable) = %v, %v, want the one of Beta", enabled, err)
+	}
+
+	if err := l.SendNotification(notifications[1].SequenceNumber); err != nil {
+		t.Fatalf("SendNotification() error = %v", err)
+	}
+	if smdp.received() != 1 {
+		t.Fatalf("the SM-DP+ received %d notifications, want 1", smdp.received())
+	}
+	if err := l.RemoveNotificationFromList(notifications[1].SequenceNumber); err != nil {
+		t.Fatalf("RemoveNotificationFromList() error = %v", err)
+	}
+	if remaining, _ := l.ListNotification(); len(remaining) != 1 {
+		t.Fatalf("%d notifications left, want 1", len(remaining))
+	}
+
+	// Deleting a profile sends the delete notification straight away.
+	if err := l.DisableProfile(beta, false); err != nil {
+		t.Fatalf("DisableProfile() error = %v", err)
+	}
+	seq, err := l.Delete(beta)
+	if err != nil || seq == 0 {
+		t.Fatalf("Delete() = %d, %v, want the sequence number of the notification", seq, err)
+	}
+	if smdp.received() != 2 {
+		t.Fatalf("the SM-DP+ received %d notifications, want 2", smdp.received())
+	}
+}
+
+func TestNewWithChannelTriesEveryAID(t *testing.T) {
+	eid, _ := hex.DecodeString(testEID)
+	// The card rejects the GSMA ISD-R AID and answers to the one of the 5ber.
+	l, err := NewWithChannel(&euicc.Card{EID: eid, AIDs: [][]byte{AIDs[1]}})
+	if err != nil {
+		t.Fatalf("NewWithChannel() error = %v", err)
+	}
+	defer l.Close()
+	if got, err := l.EID(); err != nil || hex.EncodeToString(got) != testEID {
+		t.Fatalf("EID() = %X, %v, want %s", got, err, testEID)
+	}
+
+	if _, err := NewWithChannel(&euicc.Card{EID: eid, AIDs: [][]byte{{0xA0, 0x00, 0x00, 0x00, 0x01}}}); err == nil {
+		t.Fatal("NewWithChannel() of a card without an ISD-R error = nil")
+	}
+}
@@ -1,7 +1,35 @@
 package modem
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
 const Modem3GPPInterface = ModemInterface + ".Modem3gpp"
 
+// NetworkScanTimeout is how long a network scan may take, modems usually answer within two minutes.
+const NetworkScanTimeout = 3 * time.Minute
+
+// USSDNetworkMessage is a USSD message pushed by the network, a request expects a response.
+type USSDNetworkMessage struct {
+	Text    string
+	Request bool
+}
+
+// Network is a network found by a scan.
+type Network struct {
+	Availability     Modem3gppNetworkAvailability
+	OperatorLong     string
+	OperatorShort    string
+	OperatorCode     string
+	AccessTechnology ModemAccessTechnology
+}
+
 func (m *Modem) IMEI() (string, error) {
 	variant, err := m.dbusObject.GetProperty(Modem3GPPInterface + ".Imei")
 	if err != nil {
@@ -34,6 +62,51 @@ func (m *Modem) OperatorName() (string, error) {
 	return variant.Value().(string), nil
 }
 
+// ScanNetworks asks the modem for the networks around, it usually takes a minute or more.
+// The scan is abandoned when ctx is done or after NetworkScanTimeout.
+func (m *Modem) ScanNetworks(ctx context.Context) ([]*Network, error) {
+	ctx, cancel := context.WithTimeout(ctx, NetworkScanTimeout)
+	defer cancel()
+	var results []map[string]dbus.Variant
+	if err := m.dbusObject.CallWithContext(ctx, Modem3GPPInterface+".Scan", 0).Store(&results); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("the modem didn't answer within %s", NetworkScanTimeout)
+		}
+		return nil, err
+	}
+	networks := make([]*Network, 0, len(results))
+	for _, result := range results {
+		network := new(Network)
+		for key, variant := range result {
+			switch value := variant.Value().(type) {
+			case uint32:
+				switch key {
+				case "status":
+					network.Availability = Modem3gppNetworkAvailability(value)
+				case "access-technology":
+					network.AccessTechnology = ModemAccessTechnology(value)
+				}
+			case string:
+				switch key {
+				case "operator-long":
+					network.OperatorLong = value
+				case "operator-short":
+					network.OperatorShort = value
+				case "operator-code":
+					network.OperatorCode = value
+				}
+			}
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// RegisterNetwork registers to the network with the MCC/MNC, an empty one returns to automatic selection.
+func (m *Modem) RegisterNetwork(operatorCode string) error {
+	return m.dbusObject.Call(Modem3GPPInterface+".Register", 0, operatorCode).Err
+}
+
 func (m *Modem) InitiateUSSD(command string) (string, error) {
 	var reply string
 	err := m.dbusObject.Call(Modem3GPPInterface+".Ussd.Initiate", 0, command).Store(&reply)
@@ -60,5 +133,57 @@ func (m *Modem) USSDState() (Modem3gppUssdSessionState, error) {
 
 func (m *Modem) USSDNetworkRequest() (string, error) {
 	variant, err := m.dbusObject.GetProperty(Modem3GPPInterface + ".Ussd.NetworkRequest")
-	return variant.Value().(string), err
+	if err != nil {
+		return "", err
+	}
+	return variant.Value().(string), nil
+}
+
+// SubscribeUSSD calls subscriber for every notification or request initiated by the network.
+func (m *Modem) SubscribeUSSD(ctx context.Context, subscriber func(message *USSDNetworkMessage) error) error {
+	dbusConn, err := m.SystemBusPrivate()
+	if err != nil {
+		return err
+	}
+	defer dbusConn.Close()
+	if err := dbusConn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchObjectPath(m.objectPath),
+		dbus.WithMatchArg(0, Modem3GPPInterface+".Ussd"),
+	); err != nil {
+		return err
+	}
+	signalChan := make(chan *dbus.Signal, 10)
+	dbusConn.Signal(signalChan)
+	defer dbusConn.RemoveSignal(signalChan)
+	for {
+		select {
+		case sig, ok := <-signalChan:
+			if !ok {
+				return nil
+			}
+			if len(sig.Body) < 2 {
+				continue
+			}
+			changed, ok := sig.Body[1].(map[string]dbus.Variant)
+			if !ok {
+				continue
+			}
+			for property, request := range map[string]bool{"NetworkNotification": false, "NetworkRequest": true} {
+				variant, ok := changed[property]
+				if !ok {
+					continue
+				}
+				if text, ok := variant.Value().(string); ok && text != "" {
+					if err := subscriber(&USSDNetworkMessage{Text: text, Request: request}); err != nil {
+						slog.Error("Failed to process USSD message", "error", err, "path", sig.Path)
+					}
+				}
+			}
+		case <-ctx.Done():
+			slog.Info("Unsubscribing from modem USSD", "path", m.dbusObject.Path())
+			return nil
+		}
+	}
 }
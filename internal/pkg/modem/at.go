@@ -2,76 +2,278 @@ package modem
 
 import (
 	"bufio"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
+
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+// DefaultATTimeout is the deadline of a command run without an explicit one.
+const DefaultATTimeout = 10 * time.Second
+
+var (
+	ErrATTimeout = errors.New("AT command timed out")
+	ErrATClosed  = errors.New("AT port is closed")
 )
 
+// unsolicitedPrefixes are the result codes a modem may send at any time, not only in response to a command.
+var unsolicitedPrefixes = []string{
+	"RING", "+CRING:", "+CLIP:", "+CCWA:", "+CMTI:", "+CMT:", "+CDSI:", "+CDS:", "+CBM:", "+CBMI:",
+	"+CUSD:", "+CREG:", "+CGREG:", "+CEREG:", "+C5GREG:", "+CIEV:", "+CPIN:", "+QIND:", "^",
+}
+
+// AT is an AT command engine on top of a serial port.
+// A single reader owns the port, response lines go to the running command
+// and unsolicited result codes go to the subscribers.
 type AT struct {
-	f          *os.File
-	oldTermios *unix.Termios
-	mutex      sync.Mutex
+	// Timeout is used by Run, it defaults to DefaultATTimeout.
+	Timeout     time.Duration
+	f           *os.File
+	oldTermios  *unix.Termios
+	mutex       sync.Mutex
+	state       sync.Mutex
+	pending     *pendingCommand
+	subscribers map[chan string]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+	// stale is set once a command times out, its late response is discarded
+	// until the echo of the next command or a final result code.
+	stale bool
+}
+
+type pendingCommand struct {
+	command string
+	name    string
+	lines   []string
+	result  chan error
+	// resynced is set when a final result code ended the stale response while the command ran,
+	// the result may have been its own.
+	resynced bool
 }
 
 func NewAT(device string) (*AT, error) {
-	var at AT
+	at := AT{
+		Timeout:     DefaultATTimeout,
+		subscribers: make(map[chan string]struct{}),
+		done:        make(chan struct{}),
+	}
 	var err error
 	if at.f, err = os.OpenFile(device, os.O_RDWR|unix.O_NOCTTY, 0666); err != nil {
 		return nil, err
 	}
 	if err := at.setTermios(); err != nil {
+		at.f.Close()
 		return nil, err
 	}
+	go at.read()
 	return &at, nil
 }
 
-func (a *AT) setTermios() error {
-	fd := int(a.f.Fd())
-	var err error
-	if a.oldTermios, err = unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+// control runs fn on the descriptor of the port. Unlike File.Fd it leaves the port in non-blocking mode,
+// so Close interrupts the reader instead of leaving it behind to take the responses of the next session.
+func (a *AT) control(fn func(fd int) error) error {
+	conn, err := a.f.SyscallConn()
+	if err != nil {
 		return err
 	}
-	t := unix.Termios{
-		Ispeed: unix.B9600,
-		Ospeed: unix.B9600,
+	var fnErr error
+	if err := conn.Control(func(fd uintptr) { fnErr = fn(int(fd)) }); err != nil {
+		return err
 	}
-	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
-	t.Oflag &^= unix.OPOST
-	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
-	t.Cflag &^= unix.CSIZE | unix.PARENB
-	t.Cflag |= unix.CS8
-	t.Cc[unix.VMIN] = 1
-	t.Cc[unix.VTIME] = 0
-	return unix.IoctlSetTermios(fd, unix.TCSETS, &t)
+	return fnErr
+}
+
+func (a *AT) setTermios() error {
+	return a.control(func(fd int) error {
+		var err error
+		if a.oldTermios, err = unix.IoctlGetTermios(fd, unix.TCGETS); err != nil {
+			return err
+		}
+		t := unix.Termios{
+			Ispeed: unix.B9600,
+			Ospeed: unix.B9600,
+		}
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB
+		t.Cflag |= unix.CS8
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		return unix.IoctlSetTermios(fd, unix.TCSETS, &t)
+	})
 }
 
+// Run sends the command and waits for its final result code, at most Timeout.
+// The information text is returned with one line per row, a failed command returns an *ATError.
 func (a *AT) Run(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.Timeout)
+	defer cancel()
+	return a.RunContext(ctx, command)
+}
+
+// RunContext is like Run but the deadline is taken from ctx, e.g. for a slow network scan.
+func (a *AT) RunContext(ctx context.Context, command string) (string, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	if _, err := a.f.WriteString(command + "\r\n"); err != nil {
+	select {
+	case <-a.done:
+		return "", ErrATClosed
+	default:
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(strings.ToUpper(command), "AT"), "=")
+	p := &pendingCommand{
+		command: command,
+		name:    strings.TrimRight(name, "?"),
+		result:  make(chan error, 1),
+	}
+	a.state.Lock()
+	a.pending = p
+	a.state.Unlock()
+	defer func() {
+		a.state.Lock()
+		a.pending = nil
+		a.state.Unlock()
+	}()
+	if _, err := a.f.WriteString(command + "\r"); err != nil {
 		return "", err
 	}
+	select {
+	case err := <-p.result:
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(p.lines, "\n"), nil
+	case <-ctx.Done():
+		a.state.Lock()
+		// If the result of the command may have been taken for a stale one, the modem owes nothing more.
+		a.stale = !p.resynced
+		a.state.Unlock()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%s: %w", command, ErrATTimeout)
+		}
+		return "", ctx.Err()
+	case <-a.done:
+		return "", ErrATClosed
+	}
+}
+
+func (a *AT) read() {
 	reader := bufio.NewReader(a.f)
-	var sb strings.Builder
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			return "", err
+			a.close()
+			return
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			a.dispatch(line)
+		}
+	}
+}
+
+func (a *AT) dispatch(line string) {
+	a.state.Lock()
+	defer a.state.Unlock()
+	p := a.pending
+	if p != nil && strings.EqualFold(line, p.command) {
+		// Echo of the command, the modem is in ATE1 mode. What follows is the response to it.
+		a.stale = false
+		return
+	}
+	if p == nil || a.unsolicited(p, line) {
+		if isFinalResult(line) {
+			// A late result of a command that already timed out.
+			slog.Debug("[AT] Discarding stray result", "line", line)
+			a.stale = false
+			return
+		}
+		if a.stale && !a.isURC(line) {
+			slog.Debug("[AT] Discarding stray response", "line", line)
+			return
 		}
-		line = strings.TrimSpace(line)
-		switch {
-		case strings.Contains(line, "OK"):
-			return strings.TrimSpace(sb.String()), nil
-		case strings.Contains(line, "ERR"):
-			return "", errors.New(line)
+		a.publish(line)
+		return
+	}
+	if a.stale {
+		// The late response of a timed out command, the next final result code ends it.
+		slog.Debug("[AT] Discarding stray response", "line", line)
+		if isFinalResult(line) {
+			a.stale = false
+			p.resynced = true
+		}
+		return
+	}
+	switch {
+	case line == "OK":
+		p.result <- nil
+		a.pending = nil
+	case isFinalResult(line):
+		p.result <- newATError(p.command, line)
+		a.pending = nil
+	default:
+		p.lines = append(p.lines, line)
+	}
+}
+
+// unsolicited reports whether the line is an unsolicited result code rather than a response to p.
+// +CREG: is both a response to AT+CREG? and an URC, so prefixes matching the command itself belong to it.
+func (a *AT) unsolicited(p *pendingCommand, line string) bool {
+	if p.name != "" && strings.HasPrefix(line, p.name+":") {
+		return false
+	}
+	return a.isURC(line)
+}
+
+func (a *AT) isURC(line string) bool {
+	for _, prefix := range unsolicitedPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AT) publish(line string) {
+	slog.Debug("[AT] Unsolicited result", "line", line)
+	for ch := range a.subscribers {
+		select {
+		case ch <- line:
 		default:
-			sb.WriteString(line + "\n")
+			slog.Warn("[AT] Subscriber is too slow, dropping unsolicited result", "line", line)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every unsolicited result code, e.g. +CMTI: "SM",3.
+// The channel is closed by the returned function or once the port is closed.
+func (a *AT) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, 16)
+	a.state.Lock()
+	defer a.state.Unlock()
+	select {
+	case <-a.done:
+		close(ch)
+		return ch, func() {}
+	default:
+	}
+	a.subscribers[ch] = struct{}{}
+	return ch, func() {
+		a.state.Lock()
+		defer a.state.Unlock()
+		if _, ok := a.subscribers[ch]; ok {
+			delete(a.subscribers, ch)
+			close(ch)
 		}
 	}
 }
@@ -81,8 +283,22 @@ func (a *AT) Support(command string) bool {
 	return err == nil
 }
 
+func (a *AT) close() {
+	a.closeOnce.Do(func() {
+		a.state.Lock()
+		defer a.state.Unlock()
+		close(a.done)
+		for ch := range a.subscribers {
+			delete(a.subscribers, ch)
+			close(ch)
+		}
+	})
+}
+
 func (a *AT) Close() error {
-	if err := unix.IoctlSetTermios(int(a.f.Fd()), unix.TCSETS, a.oldTermios); err != nil {
+	a.close()
+	if err := a.control(func(fd int) error { return unix.IoctlSetTermios(fd, unix.TCSETS, a.oldTermios) }); err != nil {
+		a.f.Close()
 		return err
 	}
 	return a.f.Close()
@@ -94,14 +310,37 @@ type ATCommand interface {
 
 // region CSIM
 
-type CSIM struct {
-	at   *AT
-	data []byte
-}
+type CSIM struct{ at *AT }
 
 func NewCSIM(at *AT) ATCommand { return &CSIM{at: at} }
 
+// Run sends the APDU and returns its response with the status words.
+// A response announced with 61XX is fetched with GET RESPONSE and returned without them.
 func (c *CSIM) Run(command []byte) ([]byte, error) {
+	response, err := c.transmit(command)
+	if err != nil {
+		return nil, err
+	}
+	if response[len(response)-2] != 0x61 {
+		if response[len(response)-2] != 0x90 {
+			return response, fmt.Errorf("unexpected response: %X", response)
+		}
+		return response, nil
+	}
+	var data []byte
+	for response[len(response)-2] == 0x61 {
+		if response, err = c.transmit([]byte{0x00, 0xC0, 0x00, 0x00, response[len(response)-1]}); err != nil {
+			return nil, err
+		}
+		if sw1 := response[len(response)-2]; sw1 != 0x61 && sw1 != 0x90 {
+			return response, fmt.Errorf("unexpected response: %X", response)
+		}
+		data = append(data, response[:len(response)-2]...)
+	}
+	return data, nil
+}
+
+func (c *CSIM) transmit(command []byte) ([]byte, error) {
 	cmd := fmt.Sprintf("%X", command)
 	cmd = fmt.Sprintf("AT+CSIM=%d,\"%s\"", len(cmd), cmd)
 	slog.Debug("[AT] CSIM Sending", "command", cmd)
@@ -110,39 +349,24 @@ func (c *CSIM) Run(command []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	sw, err := c.sw(response)
-	if err != nil {
-		return nil, err
-	}
-	if sw[len(sw)-2] != 0x61 && sw[len(sw)-2] != 0x90 {
-		return sw, fmt.Errorf("unexpected response: %X", sw)
-	}
-	if sw[len(sw)-2] == 0x61 {
-		return c.read(sw[1:])
-	}
-	return sw, nil
+	return c.sw(response)
 }
 
-func (c *CSIM) read(length []byte) ([]byte, error) {
-	for {
-		b, err := c.Run(append([]byte{0x00, 0xC0, 0x00, 0x00}, length...))
-		if err != nil {
-			return nil, err
-		}
-		c.data = append(c.data, b[:len(b)-2]...)
-		if b[len(b)-2] == 0x90 {
-			break
-		}
+// sw decodes the response of +CSIM: <length>,"<response>", which ends with the status words.
+func (c *CSIM) sw(response string) ([]byte, error) {
+	_, value, ok := strings.Cut(response, ",")
+	value = strings.TrimSpace(value)
+	if !ok || len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return nil, fmt.Errorf("invalid response: %q", response)
 	}
-	return c.data, nil
-}
-
-func (c *CSIM) sw(sw string) ([]byte, error) {
-	lastIdx := strings.LastIndex(sw, ",")
-	if lastIdx == -1 {
-		return nil, errors.New("invalid response")
+	b, err := hex.DecodeString(value[1 : len(value)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 2 {
+		return nil, fmt.Errorf("invalid response: %q", response)
 	}
-	return hex.DecodeString(sw[lastIdx+2 : len(sw)-1])
+	return b, nil
 }
 
 // endregion
@@ -151,6 +375,8 @@ func (c *CSIM) sw(sw string) ([]byte, error) {
 
 type CRSM struct{ at *AT }
 
+var crsmResponse = regexp.MustCompile(`(?m)^\+CRSM:\s*(\d+),\s*(\d+)(?:,\s*"?([0-9A-Fa-f]*)"?)?\s*$`)
+
 func NewCRSM(at *AT) ATCommand { return &CRSM{at: at} }
 
 type CRSMInstruction uint16
@@ -169,11 +395,20 @@ type CRSMCommand struct {
 	FileID      uint16
 	P1          byte
 	P2          byte
-	Data        []byte
+	// Length is P3 of a command sending no data, the number of bytes to read.
+	Length byte
+	Data   []byte
+	// Path is the directory the file is in, e.g. 3F007F10 for DF_TELECOM, the modem looks for the file if it is empty.
+	Path []byte
 }
 
 func (c CRSMCommand) Bytes() []byte {
-	return fmt.Appendf(nil, "%d,%d,%d,%d,%d,\"%X\"", c.Instruction, c.FileID, c.P1, c.P2, len(c.Data), c.Data)
+	length := util.If(len(c.Data) > 0, byte(len(c.Data)), c.Length)
+	command := fmt.Appendf(nil, "%d,%d,%d,%d,%d,\"%X\"", c.Instruction, c.FileID, c.P1, c.P2, length, c.Data)
+	if len(c.Path) > 0 {
+		command = fmt.Appendf(command, ",\"%X\"", c.Path)
+	}
+	return command
 }
 
 func (c *CRSM) Run(command []byte) ([]byte, error) {
@@ -187,12 +422,16 @@ func (c *CRSM) Run(command []byte) ([]byte, error) {
 	return c.sw(response)
 }
 
-func (c *CRSM) sw(sw string) ([]byte, error) {
-	if !strings.Contains(sw, "+CRSM: 144") {
-		return nil, fmt.Errorf("unexpected response: %s", sw)
+// sw decodes the response of +CRSM: <sw1>,<sw2>[,"<response>"], a command not reading anything has none.
+func (c *CRSM) sw(response string) ([]byte, error) {
+	match := crsmResponse.FindStringSubmatch(response)
+	if match == nil {
+		return nil, fmt.Errorf("invalid response: %q", response)
+	}
+	if match[1] != "144" {
+		return nil, fmt.Errorf("unexpected response: %s", match[0])
 	}
-	data := strings.Replace(sw, "+CRSM: 144,0,", "", 1)
-	return hex.DecodeString(data[1 : len(data)-1])
+	return hex.DecodeString(match[3])
 }
 
 // endregion
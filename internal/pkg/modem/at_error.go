@@ -0,0 +1,156 @@
+package modem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ATErrorKind string
+
+const (
+	ATErrorKindGeneric   ATErrorKind = ""           // ERROR, NO CARRIER, BUSY and friends.
+	ATErrorKindEquipment ATErrorKind = "+CME ERROR" // Mobile equipment error, 3GPP TS 27.007 section 9.2.
+	ATErrorKindMessage   ATErrorKind = "+CMS ERROR" // Message service error, 3GPP TS 27.005 section 3.2.5.
+)
+
+// ATError is a command which ended with a final result code other than OK.
+type ATError struct {
+	Command string
+	Result  string
+	Kind    ATErrorKind
+	// Code is the numeric error code, -1 if the modem reports verbose errors (AT+CMEE=2) or none at all.
+	Code    int
+	Message string
+}
+
+func (e *ATError) Error() string {
+	if e.Message == "" || e.Kind == ATErrorKindGeneric {
+		return fmt.Sprintf("%s: %s", e.Command, e.Result)
+	}
+	if e.Code < 0 {
+		return fmt.Sprintf("%s: %s: %s", e.Command, e.Kind, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %d (%s)", e.Command, e.Kind, e.Code, e.Message)
+}
+
+var genericResults = []string{"ERROR", "NO CARRIER", "BUSY", "NO ANSWER", "NO DIALTONE", "COMMAND NOT SUPPORT"}
+
+func isFinalResult(line string) bool {
+	if line == "OK" || strings.HasPrefix(line, string(ATErrorKindEquipment)+":") || strings.HasPrefix(line, string(ATErrorKindMessage)+":") {
+		return true
+	}
+	for _, result := range genericResults {
+		if line == result {
+			return true
+		}
+	}
+	return false
+}
+
+func newATError(command string, result string) *ATError {
+	e := &ATError{Command: command, Result: result, Code: -1}
+	for kind, messages := range map[ATErrorKind]map[int]string{
+		ATErrorKindEquipment: cmeErrors,
+		ATErrorKindMessage:   cmsErrors,
+	} {
+		detail, ok := strings.CutPrefix(result, string(kind)+":")
+		if !ok {
+			continue
+		}
+		e.Kind = kind
+		detail = strings.TrimSpace(detail)
+		code, err := strconv.Atoi(detail)
+		if err != nil {
+			e.Message = detail
+			return e
+		}
+		e.Code = code
+		e.Message = messages[code]
+		if e.Message == "" {
+			e.Message = "unknown error"
+		}
+	}
+	return e
+}
+
+var cmeErrors = map[int]string{
+	0:   "phone failure",
+	1:   "no connection to phone",
+	2:   "phone-adaptor link reserved",
+	3:   "operation not allowed",
+	4:   "operation not supported",
+	5:   "PH-SIM PIN required",
+	6:   "PH-FSIM PIN required",
+	7:   "PH-FSIM PUK required",
+	10:  "SIM not inserted",
+	11:  "SIM PIN required",
+	12:  "SIM PUK required",
+	13:  "SIM failure",
+	14:  "SIM busy",
+	15:  "SIM wrong",
+	16:  "incorrect password",
+	17:  "SIM PIN2 required",
+	18:  "SIM PUK2 required",
+	20:  "memory full",
+	21:  "invalid index",
+	22:  "not found",
+	23:  "memory failure",
+	24:  "text string too long",
+	25:  "invalid characters in text string",
+	26:  "dial string too long",
+	27:  "invalid characters in dial string",
+	30:  "no network service",
+	31:  "network timeout",
+	32:  "network not allowed - emergency calls only",
+	40:  "network personalization PIN required",
+	41:  "network personalization PUK required",
+	42:  "network subset personalization PIN required",
+	43:  "network subset personalization PUK required",
+	44:  "service provider personalization PIN required",
+	45:  "service provider personalization PUK required",
+	46:  "corporate personalization PIN required",
+	47:  "corporate personalization PUK required",
+	48:  "hidden key required",
+	49:  "EAP method not supported",
+	50:  "incorrect parameters",
+	100: "unknown",
+	103: "illegal MS",
+	106: "illegal ME",
+	107: "GPRS services not allowed",
+	111: "PLMN not allowed",
+	112: "location area not allowed",
+	113: "roaming not allowed in this location area",
+	132: "service option not supported",
+	133: "requested service option not subscribed",
+	134: "service option temporarily out of order",
+	148: "unspecified GPRS error",
+	149: "PDP authentication failure",
+	150: "invalid mobile class",
+}
+
+var cmsErrors = map[int]string{
+	300: "ME failure",
+	301: "SMS service of ME reserved",
+	302: "operation not allowed",
+	303: "operation not supported",
+	304: "invalid PDU mode parameter",
+	305: "invalid text mode parameter",
+	310: "SIM not inserted",
+	311: "SIM PIN required",
+	312: "PH-SIM PIN required",
+	313: "SIM failure",
+	314: "SIM busy",
+	315: "SIM wrong",
+	316: "SIM PUK required",
+	317: "SIM PIN2 required",
+	318: "SIM PUK2 required",
+	320: "memory failure",
+	321: "invalid memory index",
+	322: "memory full",
+	330: "SMSC address unknown",
+	331: "no network service",
+	332: "network timeout",
+	340: "no +CNMA acknowledgement expected",
+	500: "unknown error",
+}
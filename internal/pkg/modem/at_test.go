@@ -0,0 +1,338 @@
+package modem
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/fake/serial"
+)
+
+func newTestAT(t *testing.T) (*serial.Modem, *AT) {
+	t.Helper()
+	fake, err := serial.NewModem()
+	if err != nil {
+		t.Skipf("pseudo terminals are unavailable: %v", err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	at, err := NewAT(fake.Device)
+	if err != nil {
+		t.Fatalf("NewAT() error = %v", err)
+	}
+	t.Cleanup(func() { at.Close() })
+	return fake, at
+}
+
+func TestATRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		echo     bool
+		response serial.Response
+		want     string
+		wantErr  *ATError
+	}{
+		{name: "ok", response: serial.OK(), want: ""},
+		{name: "information text", response: serial.OK("+CSQ: 20,99"), want: "+CSQ: 20,99"},
+		{name: "several lines", response: serial.OK("+CPBR: 1,\"1\",129,\"A\"", "+CPBR: 2,\"2\",129,\"B\""), want: "+CPBR: 1,\"1\",129,\"A\"\n+CPBR: 2,\"2\",129,\"B\""},
+		{name: "echo", echo: true, response: serial.OK("+CSQ: 20,99"), want: "+CSQ: 20,99"},
+		{name: "response starting with AT", echo: true, response: serial.OK("ATM-100"), want: "ATM-100"},
+		{name: "error", response: serial.Error(), wantErr: &ATError{Result: "ERROR", Kind: ATErrorKindGeneric, Code: -1}},
+		{name: "cme error", response: serial.CMEError(10), wantErr: &ATError{Result: "+CME ERROR: 10", Kind: ATErrorKindEquipment, Code: 10}},
+		{name: "cms error", response: serial.CMSError(500), wantErr: &ATError{Result: "+CMS ERROR: 500", Kind: ATErrorKindMessage, Code: 500}},
+		{name: "verbose cme error", response: serial.Response{Result: "+CME ERROR: SIM not inserted"}, wantErr: &ATError{Result: "+CME ERROR: SIM not inserted", Kind: ATErrorKindEquipment, Code: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, at := newTestAT(t)
+			fake.Echo = tt.echo
+			fake.Reply("AT+CSQ", tt.response)
+			got, err := at.Run("AT+CSQ")
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("Run() = %q, want %q", got, tt.want)
+				}
+				return
+			}
+			var atErr *ATError
+			if !errors.As(err, &atErr) {
+				t.Fatalf("Run() error = %v, want an *ATError", err)
+			}
+			if atErr.Result != tt.wantErr.Result || atErr.Kind != tt.wantErr.Kind || atErr.Code != tt.wantErr.Code {
+				t.Errorf("Run() error = %+v, want %+v", atErr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestATUnsolicited(t *testing.T) {
+	fake, at := newTestAT(t)
+	urcs, unsubscribe := at.Subscribe()
+	defer unsubscribe()
+	fake.Reply("AT+CSQ", serial.Response{URCs: []string{"+CREG: 5"}, Lines: []string{"+CSQ: 20,99"}, Result: "OK"})
+	fake.Reply("AT+CREG?", serial.OK("+CREG: 0,1"))
+
+	got, err := at.Run("AT+CSQ")
+	if err != nil || got != "+CSQ: 20,99" {
+		t.Fatalf("Run(AT+CSQ) = %q, %v, want the response without the URC", got, err)
+	}
+	// +CREG: is the response of AT+CREG? rather than an URC.
+	if got, err = at.Run("AT+CREG?"); err != nil || got != "+CREG: 0,1" {
+		t.Fatalf("Run(AT+CREG?) = %q, %v, want %q", got, err, "+CREG: 0,1")
+	}
+	if err := fake.URC(`+CMTI: "SM",3`); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"+CREG: 5", `+CMTI: "SM",3`} {
+		select {
+		case got := <-urcs:
+			if got != want {
+				t.Errorf("URC = %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("URC %q was not published", want)
+		}
+	}
+	select {
+	case got := <-urcs:
+		t.Errorf("unexpected URC %q", got)
+	default:
+	}
+}
+
+func TestATTimeout(t *testing.T) {
+	for _, echo := range []bool{false, true} {
+		t.Run(fmt.Sprintf("echo %t", echo), func(t *testing.T) {
+			fake, at := newTestAT(t)
+			fake.Echo = echo
+			urcs, unsubscribe := at.Subscribe()
+			defer unsubscribe()
+			fake.Reply("AT+COPS=?", serial.Response{Lines: []string{`+COPS: (2,"Operator","Op","22210",7)`}, Result: "OK", Delay: 300 * time.Millisecond})
+			fake.Reply("AT+CSQ", serial.OK("+CSQ: 20,99"))
+
+			at.Timeout = 100 * time.Millisecond
+			if _, err := at.Run("AT+COPS=?"); !errors.Is(err, ErrATTimeout) {
+				t.Fatalf("Run(AT+COPS=?) error = %v, want %v", err, ErrATTimeout)
+			}
+			// The late response of AT+COPS=? arrives while AT+CSQ runs, it must not be taken for its response.
+			at.Timeout = 2 * time.Second
+			got, err := at.Run("AT+CSQ")
+			if err != nil || got != "+CSQ: 20,99" {
+				t.Fatalf("Run(AT+CSQ) = %q, %v, want %q", got, err, "+CSQ: 20,99")
+			}
+			select {
+			case got := <-urcs:
+				t.Errorf("the late response was published as URC %q", got)
+			default:
+			}
+		})
+	}
+}
+
+func TestATTimeoutWithoutResponse(t *testing.T) {
+	fake, at := newTestAT(t)
+	fake.Reply("AT+QHANG", serial.Response{})
+	fake.Reply("AT+CSQ", serial.OK("+CSQ: 20,99"))
+
+	at.Timeout = 100 * time.Millisecond
+	if _, err := at.Run("AT+QHANG"); !errors.Is(err, ErrATTimeout) {
+		t.Fatalf("Run(AT+QHANG) error = %v, want %v", err, ErrATTimeout)
+	}
+	// Without echo the result of the next command can't be told from a late one, at most that one is lost.
+	at.Run("AT+CSQ")
+	if got, err := at.Run("AT+CSQ"); err != nil || got != "+CSQ: 20,99" {
+		t.Fatalf("Run(AT+CSQ) = %q, %v, want %q", got, err, "+CSQ: 20,99")
+	}
+}
+
+func TestATClosed(t *testing.T) {
+	_, at := newTestAT(t)
+	if err := at.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := at.Run("AT"); !errors.Is(err, ErrATClosed) {
+		t.Errorf("Run() error = %v, want %v", err, ErrATClosed)
+	}
+}
+
+func TestATReopen(t *testing.T) {
+	fake, at := newTestAT(t)
+	fake.Reply("AT+CSQ", serial.OK("+CSQ: 20,99"))
+	if _, err := at.Run("AT+CSQ"); err != nil {
+		t.Fatal(err)
+	}
+	at.Close()
+	// The reader of the closed session must not take the response of the next one.
+	at, err := NewAT(fake.Device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer at.Close()
+	at.Timeout = time.Second
+	if got, err := at.Run("AT+CSQ"); err != nil || got != "+CSQ: 20,99" {
+		t.Fatalf("Run() = %q, %v, want %q", got, err, "+CSQ: 20,99")
+	}
+}
+
+func TestCSIM(t *testing.T) {
+	fcp := "621A8205422100260283026F3A8A01058B036F06058002020C8801F0"
+	tests := []struct {
+		name      string
+		responses map[string]string
+		command   []byte
+		want      []byte
+		wantErr   bool
+	}{
+		{
+			name:      "status words",
+			responses: map[string]string{`AT+CSIM=10,"00B0000000"`: `+CSIM: 4,"9000"`},
+			command:   []byte{0x00, 0xB0, 0x00, 0x00, 0x00},
+			want:      []byte{0x90, 0x00},
+		},
+		{
+			name: "get response",
+			responses: map[string]string{
+				`AT+CSIM=18,"00A4080404`:  `+CSIM: 4,"611C"`,
+				`AT+CSIM=10,"00C000001C"`: fmt.Sprintf(`+CSIM: %d,"%s9000"`, len(fcp)+4, fcp),
+			},
+			command: []byte{0x00, 0xA4, 0x08, 0x04, 0x04, 0x7F, 0x10, 0x6F, 0x3A},
+			want:    mustDecodeHex(fcp),
+		},
+		{
+			name:      "error status words",
+			responses: map[string]string{`AT+CSIM=18,"00A4080404`: `+CSIM: 4,"6A82"`},
+			command:   []byte{0x00, 0xA4, 0x08, 0x04, 0x04, 0x7F, 0x10, 0x6F, 0x3A},
+			want:      []byte{0x6A, 0x82},
+			wantErr:   true,
+		},
+		{
+			name:      "short response",
+			responses: map[string]string{`AT+CSIM=10,"00B0000000"`: `+CSIM: 2,"90"`},
+			command:   []byte{0x00, 0xB0, 0x00, 0x00, 0x00},
+			wantErr:   true,
+		},
+		{
+			name:      "empty response",
+			responses: map[string]string{`AT+CSIM=10,"00B0000000"`: `+CSIM: 0,""`},
+			command:   []byte{0x00, 0xB0, 0x00, 0x00, 0x00},
+			wantErr:   true,
+		},
+		{
+			name:      "malformed response",
+			responses: map[string]string{`AT+CSIM=10,"00B0000000"`: `+CSIM: 4`},
+			command:   []byte{0x00, 0xB0, 0x00, 0x00, 0x00},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, at := newTestAT(t)
+			for prefix, response := range tt.responses {
+				fake.Reply(prefix, serial.OK(response))
+			}
+			csim := NewCSIM(at)
+			// Twice, the response of a command must not carry over into the next one.
+			for range 2 {
+				got, err := csim.Run(tt.command)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Run() error = %v, wantErr %t", err, tt.wantErr)
+				}
+				if !bytes.Equal(got, tt.want) {
+					t.Fatalf("Run() = %X, want %X", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCRSM(t *testing.T) {
+	tests := []struct {
+		name     string
+		response serial.Response
+		want     []byte
+		wantErr  bool
+	}{
+		{name: "data", response: serial.OK(`+CRSM: 144,0,"0102FF"`), want: []byte{0x01, 0x02, 0xFF}},
+		{name: "no data", response: serial.OK("+CRSM: 144,0"), want: []byte{}},
+		{name: "empty data", response: serial.OK(`+CRSM: 144,0,""`), want: []byte{}},
+		{name: "unquoted data", response: serial.OK("+CRSM: 144,0,0102"), want: []byte{0x01, 0x02}},
+		{name: "file not found", response: serial.OK("+CRSM: 106,130"), wantErr: true},
+		{name: "malformed response", response: serial.OK("+CRSM: 144"), wantErr: true},
+		{name: "no response", response: serial.OK(), wantErr: true},
+		{name: "command failed", response: serial.CMEError(4), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, at := newTestAT(t)
+			fake.Reply("AT+CRSM=", tt.response)
+			got, err := NewCRSM(at).Run(CRSMCommand{Instruction: CRSMReadRecord, FileID: 0x6F3A, P1: 1, P2: 4, Length: 3}.Bytes())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, tt.want) {
+				t.Errorf("Run() = %X, want %X", got, tt.want)
+			}
+			if commands := fake.Commands(); commands[len(commands)-1] != `AT+CRSM=178,28474,1,4,3,""` {
+				t.Errorf("command = %q", commands[len(commands)-1])
+			}
+		})
+	}
+}
+
+func TestSetMSISDN(t *testing.T) {
+	// EF_MSISDN with records of 30 bytes, 16 of them for the name.
+	fcp := "620B82054221001E0183026F40"
+	record := "4D65" + strings.Repeat("FF", 14) + "0791" + "933313325476" + strings.Repeat("FF", 6)
+	tests := []struct {
+		name    string
+		setup   func(fake *serial.Modem)
+		command string
+	}{
+		{
+			name: "CRSM",
+			setup: func(fake *serial.Modem) {
+				fake.Reply("AT+CRSM=?", serial.OK())
+				fake.Reply("AT+CRSM=192,28480", serial.OK(fmt.Sprintf(`+CRSM: 144,0,"%s"`, fcp)))
+				fake.Reply("AT+CRSM=220,28480", serial.OK("+CRSM: 144,0"))
+			},
+			command: fmt.Sprintf(`AT+CRSM=220,28480,1,4,30,"%s"`, record),
+		},
+		{
+			name: "CSIM",
+			setup: func(fake *serial.Modem) {
+				fake.Reply("AT+CSIM=?", serial.OK())
+				fake.Reply(`AT+CSIM=18,"00A40804047FFF6F40"`, serial.OK(`+CSIM: 4,"610D"`))
+				fake.Reply(`AT+CSIM=10,"00C000000D"`, serial.OK(fmt.Sprintf(`+CSIM: %d,"%s9000"`, len(fcp)+4, fcp)))
+				fake.Reply(`AT+CSIM=70,"00DC01041E`, serial.OK(`+CSIM: 4,"9000"`))
+			},
+			command: fmt.Sprintf(`AT+CSIM=70,"00DC01041E%s"`, record),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, at := newTestAT(t)
+			tt.setup(fake)
+			if err := new(Modem).updateMSISDN(at, true, "Me", "+393331234567"); err != nil {
+				t.Fatalf("updateMSISDN() error = %v", err)
+			}
+			commands := fake.Commands()
+			if got := commands[len(commands)-1]; got != tt.command {
+				t.Errorf("command = %s, want %s", got, tt.command)
+			}
+		})
+	}
+}
+
+func mustDecodeHex(value string) []byte {
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
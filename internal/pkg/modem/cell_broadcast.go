@@ -0,0 +1,205 @@
+package modem
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+)
+
+const (
+	ModemCellBroadcastInterface = ModemInterface + ".CellBroadcast"
+	ModemCbmInterface           = ModemManagerInterface + ".Cbm"
+)
+
+type CellBroadcast struct {
+	Path        dbus.ObjectPath
+	State       CellBroadcastState
+	Channel     uint32
+	MessageCode uint32
+	Update      uint32
+	Text        string
+}
+
+func (c *CellBroadcast) Severity() CellBroadcastSeverity {
+	return ParseCellBroadcastSeverity(c.Channel)
+}
+
+// CellBroadcastChannels is an inclusive range of channels, a single channel starts and ends with itself.
+type CellBroadcastChannels struct {
+	Start uint32
+	End   uint32
+}
+
+func (c CellBroadcastChannels) String() string {
+	if c.Start == c.End {
+		return strconv.FormatUint(uint64(c.Start), 10)
+	}
+	return fmt.Sprintf("%d-%d", c.Start, c.End)
+}
+
+// EmergencyCellBroadcastChannels are the ETWS and CMAS/EU-Alert channels of public warnings.
+var EmergencyCellBroadcastChannels = []CellBroadcastChannels{{Start: 4352, End: 4359}, {Start: 4370, End: 4399}}
+
+// FormatCellBroadcastChannels lists the channels like 4352-4359, 4370-4399, 50.
+func FormatCellBroadcastChannels(channels []CellBroadcastChannels) string {
+	ranges := make([]string, 0, len(channels))
+	for _, c := range channels {
+		ranges = append(ranges, c.String())
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// ParseCellBroadcastChannels parses channels listed like FormatCellBroadcastChannels does, channels go up to 65535.
+func ParseCellBroadcastChannels(s string) ([]CellBroadcastChannels, error) {
+	var channels []CellBroadcastChannels
+	for value := range strings.FieldsFuncSeq(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		start, end, found := strings.Cut(value, "-")
+		if !found {
+			end = start
+		}
+		first, err := strconv.ParseUint(start, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid channel %q", value)
+		}
+		last, err := strconv.ParseUint(end, 10, 16)
+		if err != nil || last < first {
+			return nil, fmt.Errorf("invalid channel %q", value)
+		}
+		channels = append(channels, CellBroadcastChannels{Start: uint32(first), End: uint32(last)})
+	}
+	slices.SortFunc(channels, func(a, b CellBroadcastChannels) int { return int(a.Start) - int(b.Start) })
+	return channels, nil
+}
+
+func (m *Modem) RetrieveCellBroadcast(path dbus.ObjectPath) (*CellBroadcast, error) {
+	dbusObject, err := m.privateDbusObject(path)
+	if err != nil {
+		return nil, err
+	}
+	var properties map[string]dbus.Variant
+	if err := dbusObject.Call("org.freedesktop.DBus.Properties.GetAll", 0, ModemCbmInterface).Store(&properties); err != nil {
+		return nil, err
+	}
+	cbm := &CellBroadcast{Path: path}
+	if state, ok := properties["State"].Value().(uint32); ok {
+		cbm.State = CellBroadcastState(state)
+	}
+	cbm.Channel, _ = properties["Channel"].Value().(uint32)
+	cbm.MessageCode, _ = properties["MessageCode"].Value().(uint32)
+	cbm.Update, _ = properties["Update"].Value().(uint32)
+	cbm.Text, _ = properties["Text"].Value().(string)
+	return cbm, nil
+}
+
+func (m *Modem) DeleteCellBroadcast(path dbus.ObjectPath) error {
+	return m.dbusObject.Call(ModemCellBroadcastInterface+".Delete", 0, path).Err
+}
+
+// CellBroadcastChannels returns the channels the modem listens to, it fails if the modem doesn't support cell broadcasts.
+func (m *Modem) CellBroadcastChannels() ([]CellBroadcastChannels, error) {
+	variant, err := m.dbusObject.GetProperty(ModemCellBroadcastInterface + ".Channels")
+	if err != nil {
+		return nil, err
+	}
+	var channels []CellBroadcastChannels
+	for _, value := range variant.Value().([][]any) {
+		channels = append(channels, CellBroadcastChannels{Start: value[0].(uint32), End: value[1].(uint32)})
+	}
+	return channels, nil
+}
+
+func (m *Modem) SetCellBroadcastChannels(channels []CellBroadcastChannels) error {
+	return m.dbusObject.Call(ModemCellBroadcastInterface+".SetChannels", 0, channels).Err
+}
+
+// ApplyCellBroadcastChannels sets the channels saved for the modem, if any.
+func (m *Modem) ApplyCellBroadcastChannels() error {
+	if m.State == ModemStateLocked {
+		return nil
+	}
+	saved, ok, err := config.C.CellBroadcastChannels(m.EquipmentIdentifier)
+	if err != nil || !ok {
+		return err
+	}
+	channels, err := ParseCellBroadcastChannels(saved)
+	if err != nil {
+		return err
+	}
+	slog.Info("Applying cell broadcast channels", "modem", m.EquipmentIdentifier, "channels", saved)
+	return m.SetCellBroadcastChannels(channels)
+}
+
+// cellBroadcastPagesTimeout is how long the pages of a message are waited for, they are broadcast every few seconds.
+const cellBroadcastPagesTimeout = time.Minute
+
+// SubscribeCellBroadcast calls subscriber once a cell broadcast message has been completely received.
+// Messages whose pages are still arriving are polled alongside the signals, so one doesn't hold up the next.
+func (m *Modem) SubscribeCellBroadcast(ctx context.Context, subscriber func(message *CellBroadcast) error) error {
+	dbusConn, err := m.SystemBusPrivate()
+	if err != nil {
+		return err
+	}
+	defer dbusConn.Close()
+	if err := dbusConn.AddMatchSignal(
+		dbus.WithMatchInterface(ModemCellBroadcastInterface),
+		dbus.WithMatchMember("Added"),
+		dbus.WithMatchObjectPath(m.objectPath),
+	); err != nil {
+		return err
+	}
+	signalChan := make(chan *dbus.Signal, 10)
+	dbusConn.Signal(signalChan)
+	defer dbusConn.RemoveSignal(signalChan)
+	// pending maps the messages not received completely yet to when they were added.
+	pending := make(map[dbus.ObjectPath]time.Time)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case sig, ok := <-signalChan:
+			if !ok {
+				return nil
+			}
+			path, ok := sig.Body[0].(dbus.ObjectPath)
+			if !ok {
+				continue
+			}
+			pending[path] = time.Now()
+			m.deliverCellBroadcasts(pending, subscriber)
+		case <-ticker.C:
+			m.deliverCellBroadcasts(pending, subscriber)
+		case <-ctx.Done():
+			slog.Info("Unsubscribing from modem cell broadcasts", "path", m.dbusObject.Path())
+			return nil
+		}
+	}
+}
+
+// deliverCellBroadcasts passes the pending messages whose pages have all arrived to subscriber.
+// A message still incomplete after cellBroadcastPagesTimeout is passed as it is rather than dropping a warning.
+func (m *Modem) deliverCellBroadcasts(pending map[dbus.ObjectPath]time.Time, subscriber func(message *CellBroadcast) error) {
+	for _, path := range slices.SortedFunc(maps.Keys(pending), func(a, b dbus.ObjectPath) int { return pending[a].Compare(pending[b]) }) {
+		message, err := m.RetrieveCellBroadcast(path)
+		if err != nil {
+			slog.Error("Failed to process cell broadcast", "error", err, "path", path)
+			delete(pending, path)
+			continue
+		}
+		if message.State != CellBroadcastStateReceived && time.Since(pending[path]) < cellBroadcastPagesTimeout {
+			continue
+		}
+		delete(pending, path)
+		if err := subscriber(message); err != nil {
+			slog.Error("Failed to process cell broadcast", "error", err, "path", path)
+		}
+	}
+}
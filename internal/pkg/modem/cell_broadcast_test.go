@@ -0,0 +1,80 @@
+package modem
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCellBroadcastChannels(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []CellBroadcastChannels
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "50", want: []CellBroadcastChannels{{50, 50}}},
+		{value: "4370-4399", want: []CellBroadcastChannels{{4370, 4399}}},
+		{value: "4370-4399, 4352-4359,50", want: []CellBroadcastChannels{{50, 50}, {4352, 4359}, {4370, 4399}}},
+		{value: "  50   919 ", want: []CellBroadcastChannels{{50, 50}, {919, 919}}},
+		{value: "4370-4370", want: []CellBroadcastChannels{{4370, 4370}}},
+		{value: "0-65535", want: []CellBroadcastChannels{{0, 65535}}},
+		{value: "65536", wantErr: true},
+		{value: "4370-65536", wantErr: true},
+		{value: "4399-4370", wantErr: true},
+		{value: "-4370", wantErr: true},
+		{value: "4370-", wantErr: true},
+		{value: "4370-4380-4390", wantErr: true},
+		{value: "fifty", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "50, abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCellBroadcastChannels(tt.value)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("ParseCellBroadcastChannels(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	formatted := FormatCellBroadcastChannels(EmergencyCellBroadcastChannels)
+	if formatted != "4352-4359, 4370-4399" {
+		t.Errorf("FormatCellBroadcastChannels() = %q", formatted)
+	}
+	if got, err := ParseCellBroadcastChannels(formatted); err != nil || !slices.Equal(got, EmergencyCellBroadcastChannels) {
+		t.Errorf("ParseCellBroadcastChannels(%q) = %v, %v, want the emergency channels back", formatted, got, err)
+	}
+}
+
+func TestParseCellBroadcastSeverity(t *testing.T) {
+	tests := []struct {
+		channels []uint32
+		want     CellBroadcastSeverity
+	}{
+		{[]uint32{4352, 4353, 4354}, CellBroadcastSeverityEarthquake},
+		{[]uint32{4355}, CellBroadcastSeverityTest},
+		{[]uint32{4356, 4359}, CellBroadcastSeverityExtreme},
+		{[]uint32{4370, 4383}, CellBroadcastSeverityPresidential},
+		{[]uint32{4371, 4372, 4384, 4385}, CellBroadcastSeverityExtreme},
+		{[]uint32{4373, 4378, 4386, 4391}, CellBroadcastSeveritySevere},
+		{[]uint32{4379, 4392}, CellBroadcastSeverityAmber},
+		{[]uint32{4380, 4381, 4393, 4394, 4398}, CellBroadcastSeverityTest},
+		{[]uint32{4396, 4397}, CellBroadcastSeverityPublicSafety},
+		{[]uint32{0, 50, 919, 4351, 4360, 4369, 4382, 4395, 4399, 4400, 65535}, CellBroadcastSeverityNone},
+	}
+	for _, tt := range tests {
+		for _, channel := range tt.channels {
+			if got := ParseCellBroadcastSeverity(channel); got != tt.want {
+				t.Errorf("ParseCellBroadcastSeverity(%d) = %s, want %s", channel, got, tt.want)
+			}
+		}
+	}
+	for severity, warning := range map[CellBroadcastSeverity]bool{
+		CellBroadcastSeverityNone:         false,
+		CellBroadcastSeverityTest:         false,
+		CellBroadcastSeverityPresidential: true,
+		CellBroadcastSeverityAmber:        true,
+	} {
+		if severity.Warning() != warning {
+			t.Errorf("%s.Warning() = %t, want %t", severity, severity.Warning(), warning)
+		}
+	}
+}
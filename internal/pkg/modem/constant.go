@@ -1,5 +1,10 @@
 package modem
 
+import (
+	"fmt"
+	"strings"
+)
+
 type ModemState int32
 
 const (
@@ -18,6 +23,37 @@ const (
 	ModemStateConnected                           // One or more packet data bearers is active and connected.
 )
 
+func (m ModemState) String() string {
+	switch m {
+	case ModemStateFailed:
+		return "Failed"
+	case ModemStateInitializing:
+		return "Initializing"
+	case ModemStateLocked:
+		return "Locked"
+	case ModemStateDisabled:
+		return "Disabled"
+	case ModemStateDisabling:
+		return "Disabling"
+	case ModemStateEnabling:
+		return "Enabling"
+	case ModemStateEnabled:
+		return "Enabled"
+	case ModemStateSearching:
+		return "Searching"
+	case ModemStateRegistered:
+		return "Registered"
+	case ModemStateDisconnecting:
+		return "Disconnecting"
+	case ModemStateConnecting:
+		return "Connecting"
+	case ModemStateConnected:
+		return "Connected"
+	default:
+		return "Unknown"
+	}
+}
+
 type ModemPortType uint32
 
 const (
@@ -42,6 +78,97 @@ const (
 	SMSStateSent                      // The message was successfully sent.
 )
 
+type SMSPduType uint32
+
+const (
+	SMSPduTypeUnknown      SMSPduType = iota // Unknown type.
+	SMSPduTypeDeliver                        // 3GPP SMS-DELIVER, a received message.
+	SMSPduTypeSubmit                         // 3GPP SMS-SUBMIT, a message to send.
+	SMSPduTypeStatusReport                   // 3GPP SMS-STATUS-REPORT, the delivery report of a sent message.
+)
+
+func (t SMSPduType) String() string {
+	switch t {
+	case SMSPduTypeDeliver:
+		return "Deliver"
+	case SMSPduTypeSubmit:
+		return "Submit"
+	case SMSPduTypeStatusReport:
+		return "Status report"
+	default:
+		return "Unknown"
+	}
+}
+
+// SMSClass is the message class, class 0 messages are flash messages to be shown straight away and not stored.
+type SMSClass int32
+
+const (
+	SMSClassNone  SMSClass = -1
+	SMSClassFlash SMSClass = 0
+)
+
+type SMSStorage uint32
+
+const (
+	SMSStorageUnknown SMSStorage = iota // Storage unknown.
+	SMSStorageSm                        // SIM card storage area.
+	SMSStorageMe                        // Mobile equipment storage area.
+	SMSStorageMt                        // Sum of SIM and Mobile equipment storages.
+	SMSStorageSr                        // Status report message storage area.
+	SMSStorageBm                        // Broadcast message storage area.
+	SMSStorageTa                        // Terminal adaptor message storage area.
+)
+
+func (s SMSStorage) String() string {
+	switch s {
+	case SMSStorageSm:
+		return "SIM"
+	case SMSStorageMe:
+		return "Modem"
+	case SMSStorageMt:
+		return "SIM and modem"
+	case SMSStorageSr:
+		return "Status reports"
+	case SMSStorageBm:
+		return "Broadcasts"
+	case SMSStorageTa:
+		return "Terminal adaptor"
+	default:
+		return "Unknown"
+	}
+}
+
+// SMSDeliveryState is the status of a delivery report, see 3GPP TS 23.040 9.2.3.15.
+// The values below 0x20 mean the message was delivered, up to 0x3F the network keeps trying and above it gave up.
+type SMSDeliveryState uint32
+
+const (
+	SMSDeliveryStateCompletedReceived             SMSDeliveryState = 0x00  // Delivery completed, message received by the SME.
+	SMSDeliveryStateCompletedForwardedUnconfirmed SMSDeliveryState = 0x01  // Forwarded by the SC to the SME but unable to confirm delivery.
+	SMSDeliveryStateCompletedReplacedBySc         SMSDeliveryState = 0x02  // Message replaced by the SC.
+	SMSDeliveryStateTemporaryErrorCongestion      SMSDeliveryState = 0x20  // Temporary error, congestion.
+	SMSDeliveryStateTemporaryErrorSmeBusy         SMSDeliveryState = 0x21  // Temporary error, SME busy.
+	SMSDeliveryStateErrorRemoteProcedure          SMSDeliveryState = 0x40  // Permanent error, remote procedure error.
+	SMSDeliveryStateErrorValidityPeriodExpired    SMSDeliveryState = 0x46  // Permanent error, validity period expired.
+	SMSDeliveryStateUnknown                       SMSDeliveryState = 0x100 // Unknown state.
+)
+
+func (s SMSDeliveryState) String() string {
+	switch {
+	case s == SMSDeliveryStateUnknown:
+		return "Unknown"
+	case s == SMSDeliveryStateErrorValidityPeriodExpired:
+		return "Expired"
+	case s < 0x20:
+		return "Delivered"
+	case s < 0x40:
+		return "Pending"
+	default:
+		return "Failed"
+	}
+}
+
 type Modem3gppRegistrationState uint32
 
 const (
@@ -87,6 +214,41 @@ func (m Modem3gppRegistrationState) String() string {
 	}
 }
 
+// Registered reports whether the modem can use the network, SMS only and CSFB registrations included.
+func (m Modem3gppRegistrationState) Registered() bool {
+	return m == Modem3gppRegistrationStateHome || m.Roaming() ||
+		m == Modem3gppRegistrationStateHomeSmsOnly ||
+		m == Modem3gppRegistrationStateHomeCsfbNotPreferred
+}
+
+func (m Modem3gppRegistrationState) Roaming() bool {
+	return m == Modem3gppRegistrationStateRoaming ||
+		m == Modem3gppRegistrationStateRoamingSmsOnly ||
+		m == Modem3gppRegistrationStateRoamingCsfbNotPreferred
+}
+
+type Modem3gppNetworkAvailability uint32
+
+const (
+	Modem3gppNetworkAvailabilityUnknown   Modem3gppNetworkAvailability = iota // Unknown availability.
+	Modem3gppNetworkAvailabilityAvailable                                     // Network is available.
+	Modem3gppNetworkAvailabilityCurrent                                       // Network is the current one.
+	Modem3gppNetworkAvailabilityForbidden                                     // Network is forbidden.
+)
+
+func (m Modem3gppNetworkAvailability) String() string {
+	switch m {
+	case Modem3gppNetworkAvailabilityAvailable:
+		return "Available"
+	case Modem3gppNetworkAvailabilityCurrent:
+		return "Current"
+	case Modem3gppNetworkAvailabilityForbidden:
+		return "Forbidden"
+	default:
+		return "Unknown"
+	}
+}
+
 type Modem3gppUssdSessionState uint32
 
 const (
@@ -201,3 +363,337 @@ func (m ModemAccessTechnology) String() string {
 		return "Unknown"
 	}
 }
+
+// Generation returns the mobile generation of the access technology, e.g. 2 for GSM and 4 for LTE, 0 if it has none.
+func (m ModemAccessTechnology) Generation() int {
+	switch m {
+	case ModemAccessTechnologyGsm, ModemAccessTechnologyGsmCompact, ModemAccessTechnologyGprs, ModemAccessTechnologyEdge,
+		ModemAccessTechnology1xrtt:
+		return 2
+	case ModemAccessTechnologyUmts, ModemAccessTechnologyHsdpa, ModemAccessTechnologyHsupa, ModemAccessTechnologyHspa,
+		ModemAccessTechnologyHspaPlus, ModemAccessTechnologyEvdo0, ModemAccessTechnologyEvdoa, ModemAccessTechnologyEvdob:
+		return 3
+	case ModemAccessTechnologyLte, ModemAccessTechnologyLteCatM, ModemAccessTechnologyLteNBIot:
+		return 4
+	case ModemAccessTechnology5GNR:
+		return 5
+	default:
+		return 0
+	}
+}
+
+type ModemMode uint32
+
+const (
+	ModemModeNone ModemMode = 0          // None.
+	ModemModeCs   ModemMode = 1 << 0     // CSD, GSM, and other circuit-switched technologies.
+	ModemMode2G   ModemMode = 1 << 1     // GPRS, EDGE.
+	ModemMode3G   ModemMode = 1 << 2     // UMTS, HSxPA.
+	ModemMode4G   ModemMode = 1 << 3     // LTE.
+	ModemMode5G   ModemMode = 1 << 4     // 5GNR. Since 1.14.
+	ModemModeAny  ModemMode = 0xFFFFFFFF // Any mode can be used (only this value allowed for POTS modems).
+)
+
+func (m ModemMode) String() string {
+	switch m {
+	case ModemModeNone:
+		return "None"
+	case ModemModeAny:
+		return "Any"
+	}
+	var modes []string
+	for _, mode := range []ModemMode{ModemModeCs, ModemMode2G, ModemMode3G, ModemMode4G, ModemMode5G} {
+		if m&mode == 0 {
+			continue
+		}
+		modes = append(modes, map[ModemMode]string{
+			ModemModeCs: "CS",
+			ModemMode2G: "2G",
+			ModemMode3G: "3G",
+			ModemMode4G: "4G",
+			ModemMode5G: "5G",
+		}[mode])
+	}
+	return strings.Join(modes, "/")
+}
+
+// ModemBand is a radio frequency band, see MMModemBand.
+// Only the ranges are listed, the bands within them are numbered after the 3GPP band.
+type ModemBand uint32
+
+const (
+	ModemBandUnknown ModemBand = 0   // Unknown or invalid band.
+	ModemBandEgsm    ModemBand = 1   // GSM/GPRS/EDGE 900 MHz.
+	ModemBandDcs     ModemBand = 2   // GSM/GPRS/EDGE 1800 MHz.
+	ModemBandPcs     ModemBand = 3   // GSM/GPRS/EDGE 1900 MHz.
+	ModemBandG850    ModemBand = 4   // GSM/GPRS/EDGE 850 MHz.
+	ModemBandEutran1 ModemBand = 31  // E-UTRAN band 1, up to band 85 at 115.
+	ModemBandCdmaBc0 ModemBand = 128 // CDMA Band Class 0, up to class 19 at 147.
+	ModemBandAny     ModemBand = 256 // For certain operations, allow the modem to select a band automatically.
+	ModemBandNgran1  ModemBand = 301 // NGRAN band 1, the others follow at 300 + the band number.
+)
+
+// utranBands maps the UTRAN bands, which are numbered out of order, to their 3GPP band.
+var utranBands = map[ModemBand]int{
+	5: 1, 6: 3, 7: 4, 8: 6, 9: 5, 10: 8, 11: 9, 12: 2, 13: 7,
+	210: 10, 211: 11, 212: 12, 213: 13, 214: 14, 219: 19, 220: 20, 221: 21, 222: 22, 225: 25, 226: 26, 232: 32,
+}
+
+// String names the band the way operators do, e.g. B3 for LTE, n78 for 5G and UMTS B1 for 3G.
+func (m ModemBand) String() string {
+	switch {
+	case m == ModemBandEgsm:
+		return "GSM 900"
+	case m == ModemBandDcs:
+		return "DCS 1800"
+	case m == ModemBandPcs:
+		return "PCS 1900"
+	case m == ModemBandG850:
+		return "GSM 850"
+	case m == ModemBandAny:
+		return "Any"
+	case m >= ModemBandEutran1 && m < ModemBandEutran1+85:
+		return fmt.Sprintf("B%d", m-ModemBandEutran1+1)
+	case m >= ModemBandCdmaBc0 && m < ModemBandCdmaBc0+20:
+		return fmt.Sprintf("BC%d", m-ModemBandCdmaBc0)
+	case m >= ModemBandNgran1 && m < ModemBandNgran1+300:
+		return fmt.Sprintf("n%d", m-ModemBandNgran1+1)
+	}
+	if band, ok := utranBands[m]; ok {
+		return fmt.Sprintf("UMTS B%d", band)
+	}
+	return fmt.Sprintf("Band %d", m)
+}
+
+// ParseModemBand is the reverse of ModemBand.String, the names are case insensitive.
+func ParseModemBand(name string) (ModemBand, error) {
+	for band := ModemBandEgsm; band < ModemBandNgran1+300; band++ {
+		if strings.EqualFold(band.String(), strings.TrimSpace(name)) {
+			return band, nil
+		}
+	}
+	return ModemBandUnknown, fmt.Errorf("unknown band %q", name)
+}
+
+type ModemLock uint32
+
+const (
+	ModemLockUnknown     ModemLock = iota // Lock reason unknown.
+	ModemLockNone                         // Modem is unlocked.
+	ModemLockSimPin                       // SIM requires the PIN code.
+	ModemLockSimPin2                      // SIM requires the PIN2 code.
+	ModemLockSimPuk                       // SIM requires the PUK code.
+	ModemLockSimPuk2                      // SIM requires the PUK2 code.
+	ModemLockPhSpPin                      // Modem requires the service provider PIN code.
+	ModemLockPhSpPuk                      // Modem requires the service provider PUK code.
+	ModemLockPhNetPin                     // Modem requires the network PIN code.
+	ModemLockPhNetPuk                     // Modem requires the network PUK code.
+	ModemLockPhSimPin                     // Modem requires the PIN code.
+	ModemLockPhCorpPin                    // Modem requires the corporate PIN code.
+	ModemLockPhCorpPuk                    // Modem requires the corporate PUK code.
+	ModemLockPhFsimPin                    // Modem requires the PH-FSIM PIN code.
+	ModemLockPhFsimPuk                    // Modem requires the PH-FSIM PUK code.
+	ModemLockPhNetsubPin                  // Modem requires the network subset PIN code.
+	ModemLockPhNetsubPuk                  // Modem requires the network subset PUK code.
+)
+
+func (m ModemLock) String() string {
+	switch m {
+	case ModemLockNone:
+		return "None"
+	case ModemLockSimPin:
+		return "SIM PIN"
+	case ModemLockSimPin2:
+		return "SIM PIN2"
+	case ModemLockSimPuk:
+		return "SIM PUK"
+	case ModemLockSimPuk2:
+		return "SIM PUK2"
+	case ModemLockPhSpPin:
+		return "Service provider PIN"
+	case ModemLockPhSpPuk:
+		return "Service provider PUK"
+	case ModemLockPhNetPin:
+		return "Network PIN"
+	case ModemLockPhNetPuk:
+		return "Network PUK"
+	case ModemLockPhSimPin:
+		return "PH-SIM PIN"
+	case ModemLockPhCorpPin:
+		return "Corporate PIN"
+	case ModemLockPhCorpPuk:
+		return "Corporate PUK"
+	case ModemLockPhFsimPin:
+		return "PH-FSIM PIN"
+	case ModemLockPhFsimPuk:
+		return "PH-FSIM PUK"
+	case ModemLockPhNetsubPin:
+		return "Network subset PIN"
+	case ModemLockPhNetsubPuk:
+		return "Network subset PUK"
+	default:
+		return "Unknown"
+	}
+}
+
+type CallState int32
+
+const (
+	CallStateUnknown    CallState = iota // Default state for a new outgoing call.
+	CallStateDialing                     // Outgoing call started. Wait for free channel.
+	CallStateRingingOut                  // Outgoing call attached to GSM network, waiting for an answer.
+	CallStateRingingIn                   // Incoming call is waiting for an answer.
+	CallStateActive                      // Call is active between two peers.
+	CallStateHeld                        // Held call (by +CHLD AT command).
+	CallStateWaiting                     // Waiting call (by +CCWA AT command).
+	CallStateTerminated                  // Call is terminated.
+)
+
+func (c CallState) String() string {
+	switch c {
+	case CallStateDialing:
+		return "Dialing"
+	case CallStateRingingOut:
+		return "Ringing"
+	case CallStateRingingIn:
+		return "Ringing"
+	case CallStateActive:
+		return "Active"
+	case CallStateHeld:
+		return "Held"
+	case CallStateWaiting:
+		return "Waiting"
+	case CallStateTerminated:
+		return "Terminated"
+	default:
+		return "Unknown"
+	}
+}
+
+type CallStateReason int32
+
+const (
+	CallStateReasonUnknown          CallStateReason = iota // Default value for a new outgoing call.
+	CallStateReasonOutgoingStarted                         // Outgoing call is started.
+	CallStateReasonIncomingNew                             // Received a new incoming call.
+	CallStateReasonAccepted                                // Dialing or Ringing call is accepted.
+	CallStateReasonTerminated                              // Call is correctly terminated.
+	CallStateReasonRefusedOrBusy                           // Remote peer is busy or refused call.
+	CallStateReasonError                                   // Wrong number or generic network error.
+	CallStateReasonAudioSetupFailed                        // Error setting up audio channel.
+	CallStateReasonTransferred                             // Call has been transferred.
+	CallStateReasonDeflected                               // Call has been deflected to a new number.
+)
+
+func (c CallStateReason) String() string {
+	switch c {
+	case CallStateReasonOutgoingStarted:
+		return "Outgoing started"
+	case CallStateReasonIncomingNew:
+		return "Incoming"
+	case CallStateReasonAccepted:
+		return "Accepted"
+	case CallStateReasonTerminated:
+		return "Terminated"
+	case CallStateReasonRefusedOrBusy:
+		return "Refused or busy"
+	case CallStateReasonError:
+		return "Error"
+	case CallStateReasonAudioSetupFailed:
+		return "Audio setup failed"
+	case CallStateReasonTransferred:
+		return "Transferred"
+	case CallStateReasonDeflected:
+		return "Deflected"
+	default:
+		return "Unknown"
+	}
+}
+
+type CallDirection int32
+
+const (
+	CallDirectionUnknown  CallDirection = iota // Unknown.
+	CallDirectionIncoming                      // Call from network.
+	CallDirectionOutgoing                      // Call to network.
+)
+
+type CellBroadcastState uint32
+
+const (
+	CellBroadcastStateUnknown   CellBroadcastState = iota // State unknown or not reportable.
+	CellBroadcastStateReceiving                           // The message is being received but is not yet complete.
+	CellBroadcastStateReceived                            // The message has been completely received.
+)
+
+// CellBroadcastSeverity is the kind of public warning a cell broadcast channel carries, see 3GPP TS 23.041.
+type CellBroadcastSeverity uint32
+
+const (
+	CellBroadcastSeverityNone         CellBroadcastSeverity = iota // Not a public warning channel.
+	CellBroadcastSeverityPresidential                              // National alert, can't be opted out of.
+	CellBroadcastSeverityExtreme                                   // Extreme threat to life or property.
+	CellBroadcastSeveritySevere                                    // Severe threat to life or property.
+	CellBroadcastSeverityAmber                                     // Child abduction emergency.
+	CellBroadcastSeverityEarthquake                                // ETWS earthquake or tsunami warning.
+	CellBroadcastSeverityPublicSafety                              // Public safety message.
+	CellBroadcastSeverityTest                                      // Test or exercise.
+)
+
+// ParseCellBroadcastSeverity tells the kind of warning from the channel, ETWS uses 4352 to 4359 and CMAS, EU-Alert
+// and the like 4370 to 4399, the second half of which repeats the first in the language of the country.
+func ParseCellBroadcastSeverity(channel uint32) CellBroadcastSeverity {
+	switch {
+	case channel >= 4352 && channel <= 4354:
+		return CellBroadcastSeverityEarthquake
+	case channel == 4355:
+		return CellBroadcastSeverityTest
+	case channel >= 4356 && channel <= 4359:
+		return CellBroadcastSeverityExtreme
+	}
+	if channel >= 4383 && channel <= 4395 {
+		channel -= 13
+	}
+	switch {
+	case channel == 4370:
+		return CellBroadcastSeverityPresidential
+	case channel >= 4371 && channel <= 4372:
+		return CellBroadcastSeverityExtreme
+	case channel >= 4373 && channel <= 4378:
+		return CellBroadcastSeveritySevere
+	case channel == 4379:
+		return CellBroadcastSeverityAmber
+	case channel >= 4380 && channel <= 4381, channel == 4398:
+		return CellBroadcastSeverityTest
+	case channel >= 4396 && channel <= 4397:
+		return CellBroadcastSeverityPublicSafety
+	default:
+		return CellBroadcastSeverityNone
+	}
+}
+
+// Warning tells whether the message warns of an actual threat, rather than being a test or a regular broadcast.
+func (s CellBroadcastSeverity) Warning() bool {
+	return s != CellBroadcastSeverityNone && s != CellBroadcastSeverityTest
+}
+
+func (s CellBroadcastSeverity) String() string {
+	switch s {
+	case CellBroadcastSeverityPresidential:
+		return "Presidential alert"
+	case CellBroadcastSeverityExtreme:
+		return "Extreme alert"
+	case CellBroadcastSeveritySevere:
+		return "Severe alert"
+	case CellBroadcastSeverityAmber:
+		return "Amber alert"
+	case CellBroadcastSeverityEarthquake:
+		return "Earthquake and tsunami warning"
+	case CellBroadcastSeverityPublicSafety:
+		return "Public safety"
+	case CellBroadcastSeverityTest:
+		return "Test alert"
+	default:
+		return "Cell broadcast"
+	}
+}
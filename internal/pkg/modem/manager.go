@@ -121,12 +121,30 @@ func (m *Manager) Subscribe(subscriber func(map[dbus.ObjectPath]*Modem) error) e
 	defer m.dbusConn.RemoveSignal(sig)
 
 	for {
-		event := <-sig
+		event, ok := <-sig
+		// The channel is closed along with the connection.
+		if !ok {
+			return nil
+		}
 		modemPath := event.Body[0].(dbus.ObjectPath)
 		if event.Name == ModemManagerInterfacesAdded {
-			slog.Info("New modem plugged in", "path", modemPath)
 			raw := event.Body[1].(map[string]map[string]dbus.Variant)
-			modem, err := m.createModem(modemPath, raw["org.freedesktop.ModemManager1.Modem"])
+			data, ok := raw["org.freedesktop.ModemManager1.Modem"]
+			if ok {
+				slog.Info("New modem plugged in", "path", modemPath)
+			} else {
+				// A locked modem announces the rest of its interfaces once its SIM has been unlocked.
+				if _, known := m.modems[modemPath]; !known {
+					continue
+				}
+				slog.Info("Modem unlocked", "path", modemPath)
+				if err := m.dbusConn.Object(ModemManagerInterface, modemPath).
+					Call("org.freedesktop.DBus.Properties.GetAll", 0, ModemInterface).Store(&data); err != nil {
+					slog.Error("Failed to reload modem", "error", err, "path", modemPath)
+					continue
+				}
+			}
+			modem, err := m.createModem(modemPath, data)
 			if err != nil {
 				slog.Error("Failed to create modem", "error", err)
 				continue
@@ -138,6 +156,13 @@ func (m *Manager) Subscribe(subscriber func(map[dbus.ObjectPath]*Modem) error) e
 					continue
 				}
 			}
+			// A replugged modem may have forgotten its radio configuration.
+			if err := modem.ApplyRadioDefaults(); err != nil {
+				slog.Error("Failed to apply radio defaults", "error", err, "path", modemPath)
+			}
+			if err := modem.ApplyCellBroadcastChannels(); err != nil {
+				slog.Error("Failed to apply cell broadcast channels", "error", err, "path", modemPath)
+			}
 			m.updateModem(modem)
 		} else {
 			slog.Info("Modem unplugged", "path", modemPath)
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/godbus/dbus/v5"
+
+	"github.com/damonto/telegram-sms/internal/pkg/util"
 )
 
 const ModemMessagingInterface = ModemInterface + ".Messaging"
@@ -24,11 +26,25 @@ func (m *Modem) ListMessages() ([]*SMS, error) {
 	return s, err
 }
 
-func (m *Modem) CreateMessage(to string, text string) (dbus.ObjectPath, error) {
+// CreateMessage creates a message to send, with the text or options.Data if it is set.
+func (m *Modem) CreateMessage(to string, text string, options SMSOptions) (dbus.ObjectPath, error) {
 	var path dbus.ObjectPath
 	data := map[string]any{
 		"number": to,
-		"text":   text,
+	}
+	if options.Data != nil {
+		data["data"] = options.Data
+	} else {
+		data["text"] = util.If(options.GSM7, ToGSM7(text), text)
+	}
+	if options.SMSC != "" {
+		data["smsc"] = options.SMSC
+	}
+	if options.Validity > 0 {
+		data["validity"] = smsValidity{Type: smsValidityRelative, Value: dbus.MakeVariant(uint32(options.Validity.Minutes()))}
+	}
+	if options.Flash {
+		data["class"] = int32(SMSClassFlash)
 	}
 	err := m.dbusObject.Call(ModemMessagingInterface+".Create", 0, &data).Store(&path)
 	return path, err
@@ -44,6 +60,7 @@ func (m *Modem) SubscribeMessaging(ctx context.Context, subscriber func(message
 		return err
 	}
 	dbusConn.AddMatchSignal(
+		dbus.WithMatchInterface(ModemMessagingInterface),
 		dbus.WithMatchMember("Added"),
 		dbus.WithMatchPathNamespace(m.objectPath),
 	)
@@ -52,7 +69,10 @@ func (m *Modem) SubscribeMessaging(ctx context.Context, subscriber func(message
 	defer dbusConn.RemoveSignal(signalChan)
 	for {
 		select {
-		case sig := <-signalChan:
+		case sig, ok := <-signalChan:
+			if !ok {
+				return nil
+			}
 			if !sig.Body[1].(bool) {
 				continue
 			}
@@ -0,0 +1,56 @@
+package modem_test
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/simulator"
+)
+
+func TestSendMessageOptions(t *testing.T) {
+	sim, err := simulator.New(&simulator.Scenario{Modems: []*simulator.ModemSpec{{
+		ID:                 "alpha",
+		IMEI:               "861234567890123",
+		OperatorCode:       "22210",
+		OperatorName:       "Vodafone",
+		Registration:       "home",
+		AccessTechnologies: []string{"lte"},
+		SIM:                simulator.SIMSpec{ICCID: "8939100000000000077", IMSI: "222100000000077", OperatorIdentifier: "22210"},
+	}}})
+	if err != nil {
+		t.Skipf("the simulator can't be started: %v", err)
+	}
+	defer sim.Close()
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", sim.Address)
+	mm, err := modem.NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modems, err := mm.Modems()
+	if err != nil || len(modems) != 1 {
+		t.Fatalf("Modems() = %v, %v, want one modem", modems, err)
+	}
+	m := slices.Collect(maps.Values(modems))[0]
+
+	sms, err := m.SendMessage("+393331234567", "“Hello”", modem.SMSOptions{
+		SMSC:     "+393492000200",
+		Validity: 24 * time.Hour,
+		Flash:    true,
+		GSM7:     true,
+	})
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if sms.Text != `"Hello"` {
+		t.Errorf("text = %q, want the quotes replaced", sms.Text)
+	}
+	if sms.Validity != 24*time.Hour {
+		t.Errorf("validity = %s, want 24h", sms.Validity)
+	}
+	if sms.SMSC != "+393492000200" || !sms.Flash() {
+		t.Errorf("SMSC = %q, flash = %t, want the SMSC and a flash message", sms.SMSC, sms.Flash())
+	}
+}
@@ -0,0 +1,628 @@
+package modem
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+// phonebookTimeout is the deadline of reading the whole phonebook, a full SIM holds a few hundred entries.
+const phonebookTimeout = 30 * time.Second
+
+var (
+	ErrPhonebookFull        = errors.New("the phonebook is full")
+	ErrPhonebookUnsupported = errors.New("the modem supports neither AT+CPBR nor AT+CRSM")
+)
+
+var (
+	cpbrRange = regexp.MustCompile(`^\+CPBR:\s*\((\d+)-(\d+)\)(?:,(\d+),(\d+))?`)
+	cpbrEntry = regexp.MustCompile(`^\+CPBR:\s*(\d+),"([^"]*)",(\d+),"([^"]*)"`)
+	cscsValue = regexp.MustCompile(`^\+CSCS:\s*"([^"]+)"`)
+	// phonebookNumber are the characters a dialling number may have.
+	phonebookNumber = regexp.MustCompile(`^\+?[0-9*#pw]*$`)
+)
+
+// PhonebookEntry is a record of the phonebook on the SIM (EF_ADN).
+type PhonebookEntry struct {
+	Index  int
+	Name   string
+	Number string
+}
+
+// phonebookBackend reads and writes the records, either with the phonebook commands of the modem
+// or with the records of EF_ADN on modems lacking them.
+type phonebookBackend interface {
+	open(p *Phonebook) error
+	entries() ([]*PhonebookEntry, error)
+	write(entry *PhonebookEntry) error
+	erase(index int) error
+	close() error
+}
+
+// Phonebook is the phonebook on the SIM, accessed over the AT port.
+type Phonebook struct {
+	at      *AT
+	backend phonebookBackend
+	// First and Last are the indexes of the records, NumberLength and NameLength their limits.
+	First        int
+	Last         int
+	NumberLength int
+	NameLength   int
+}
+
+// OpenPhonebook opens the phonebook with AT+CPBS and AT+CPBR, or with AT+CRSM if the modem doesn't support them.
+func (m *Modem) OpenPhonebook() (*Phonebook, error) {
+	port, err := m.Port(ModemPortTypeAt)
+	if err != nil {
+		return nil, err
+	}
+	at, err := NewAT(port.Device)
+	if err != nil {
+		return nil, err
+	}
+	at.Timeout = phonebookTimeout
+	p := &Phonebook{at: at, backend: &cpbPhonebook{at: at}}
+	if err := p.backend.open(p); err != nil {
+		p.backend.close()
+		if !at.Support("AT+CRSM=?") {
+			at.Close()
+			return nil, fmt.Errorf("%w: %w", ErrPhonebookUnsupported, err)
+		}
+		p.backend = &adnPhonebook{crsm: NewCRSM(at)}
+		if err := p.backend.open(p); err != nil {
+			at.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Method tells how the phonebook is accessed, for the admins to know what to blame.
+func (p *Phonebook) Method() string {
+	if _, ok := p.backend.(*adnPhonebook); ok {
+		return "EF_ADN via AT+CRSM"
+	}
+	return "AT+CPBR"
+}
+
+// Entries reads every record in use.
+func (p *Phonebook) Entries() ([]*PhonebookEntry, error) {
+	return p.backend.entries()
+}
+
+// Write saves the entry in its record, or in the first free one if its index is zero.
+func (p *Phonebook) Write(entry *PhonebookEntry) error {
+	if !phonebookNumber.MatchString(entry.Number) || entry.Number == "" {
+		return fmt.Errorf("invalid number %q", entry.Number)
+	}
+	if digits := len(strings.TrimPrefix(entry.Number, "+")); p.NumberLength > 0 && digits > p.NumberLength {
+		return fmt.Errorf("the number has %d digits, the SIM takes up to %d", digits, p.NumberLength)
+	}
+	if length := adnAlphaLength(entry.Name); p.NameLength > 0 && length > p.NameLength {
+		return fmt.Errorf("the name takes %d bytes, the SIM takes up to %d", length, p.NameLength)
+	}
+	if entry.Index == 0 {
+		entries, err := p.Entries()
+		if err != nil {
+			return err
+		}
+		for index := p.First; index <= p.Last; index++ {
+			if !slices.ContainsFunc(entries, func(e *PhonebookEntry) bool { return e.Index == index }) {
+				entry.Index = index
+				break
+			}
+		}
+		if entry.Index == 0 {
+			return ErrPhonebookFull
+		}
+	}
+	if entry.Index < p.First || entry.Index > p.Last {
+		return fmt.Errorf("the index must be between %d and %d", p.First, p.Last)
+	}
+	return p.backend.write(entry)
+}
+
+// Delete frees the record.
+func (p *Phonebook) Delete(index int) error {
+	if index < p.First || index > p.Last {
+		return fmt.Errorf("the index must be between %d and %d", p.First, p.Last)
+	}
+	return p.backend.erase(index)
+}
+
+// Close restores the settings changed while it was open and releases the AT port.
+func (p *Phonebook) Close() error {
+	if err := p.backend.close(); err != nil {
+		p.at.Close()
+		return err
+	}
+	return p.at.Close()
+}
+
+// region AT+CPBR
+
+// cpbPhonebook uses the phonebook commands of 3GPP TS 27.007.
+// The character set is switched to UCS-2 while it is open, so names in any alphabet survive.
+type cpbPhonebook struct {
+	at *AT
+	// charset is the character set to restore on close.
+	charset string
+	ucs2    bool
+	// numberUCS2 is set once the modem is seen encoding the numbers in UCS-2 too, as Quectel modems do.
+	numberUCS2 bool
+	first      int
+	last       int
+}
+
+func (c *cpbPhonebook) open(p *Phonebook) error {
+	if _, err := c.at.Run(`AT+CPBS="SM"`); err != nil {
+		return fmt.Errorf("the modem can't select the SIM phonebook: %w", err)
+	}
+	if response, err := c.at.Run("AT+CSCS?"); err == nil {
+		if match := cscsValue.FindStringSubmatch(response); match != nil {
+			c.charset = match[1]
+		}
+	}
+	c.ucs2 = c.charset == "UCS2"
+	if !c.ucs2 {
+		if _, err := c.at.Run(`AT+CSCS="UCS2"`); err == nil {
+			c.ucs2 = true
+		}
+	}
+	response, err := c.at.Run("AT+CPBR=?")
+	if err != nil {
+		return err
+	}
+	match := cpbrRange.FindStringSubmatch(response)
+	if match == nil {
+		return fmt.Errorf("unexpected response: %s", response)
+	}
+	c.first, _ = strconv.Atoi(match[1])
+	c.last, _ = strconv.Atoi(match[2])
+	p.First, p.Last = c.first, c.last
+	p.NumberLength, _ = strconv.Atoi(match[3])
+	p.NameLength, _ = strconv.Atoi(match[4])
+	return nil
+}
+
+func (c *cpbPhonebook) entries() ([]*PhonebookEntry, error) {
+	response, err := c.at.Run(fmt.Sprintf("AT+CPBR=%d,%d", c.first, c.last))
+	if err != nil {
+		// Most modems report an empty phonebook as not found.
+		var atErr *ATError
+		if errors.As(err, &atErr) && atErr.Code == 22 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []*PhonebookEntry
+	for _, line := range strings.Split(response, "\n") {
+		match := cpbrEntry.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		entry := &PhonebookEntry{Number: match[2], Name: c.decode(match[4])}
+		entry.Index, _ = strconv.Atoi(match[1])
+		// Digits alone are valid hex too, so a number encoded in UCS-2 is told apart by the result.
+		if number := c.decode(match[2]); number != "" && number != match[2] && phonebookNumber.MatchString(number) {
+			entry.Number = number
+			c.numberUCS2 = true
+		}
+		if toa, _ := strconv.Atoi(match[3]); toa == 145 && !strings.HasPrefix(entry.Number, "+") {
+			entry.Number = "+" + entry.Number
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (c *cpbPhonebook) write(entry *PhonebookEntry) error {
+	// Without UCS-2 the name is sent as it is, a quote or a line break would end the command and start another.
+	if !c.ucs2 && strings.ContainsFunc(entry.Name, func(r rune) bool { return r == '"' || unicode.IsControl(r) }) {
+		return errors.New("the name can't have quotes or line breaks, the modem doesn't take names in UCS-2")
+	}
+	toa := 129
+	if strings.HasPrefix(entry.Number, "+") {
+		toa = 145
+	}
+	number := util.If(c.numberUCS2, c.encode(entry.Number), entry.Number)
+	_, err := c.at.Run(fmt.Sprintf(`AT+CPBW=%d,"%s",%d,"%s"`, entry.Index, number, toa, c.encode(entry.Name)))
+	return err
+}
+
+func (c *cpbPhonebook) erase(index int) error {
+	_, err := c.at.Run(fmt.Sprintf("AT+CPBW=%d", index))
+	return err
+}
+
+// decode reads a string in the character set of the phonebook.
+func (c *cpbPhonebook) decode(value string) string {
+	if !c.ucs2 || len(value)%4 != 0 {
+		return value
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+func (c *cpbPhonebook) encode(value string) string {
+	if !c.ucs2 {
+		return value
+	}
+	var encoded strings.Builder
+	for _, unit := range utf16.Encode([]rune(value)) {
+		fmt.Fprintf(&encoded, "%04X", unit)
+	}
+	return encoded.String()
+}
+
+func (c *cpbPhonebook) close() error {
+	if c.charset == "" || c.charset == "UCS2" {
+		return nil
+	}
+	_, err := c.at.Run(fmt.Sprintf("AT+CSCS=%q", c.charset))
+	return err
+}
+
+// endregion
+
+// region EF_ADN
+
+const (
+	// adnFileID is EF_ADN in DF_TELECOM, 3GPP TS 51.011 section 10.5.1.
+	adnFileID = 0x6F3A
+	// pbrFileID is EF_PBR, which lists the files of a DF_PHONEBOOK, 3GPP TS 31.102 section 4.4.2.1.
+	pbrFileID = 0x4F30
+)
+
+var (
+	// dfTelecom is where EF_ADN is found on SIMs and most USIMs.
+	dfTelecom = []byte{0x3F, 0x00, 0x7F, 0x10}
+	// dfPhonebooks are DF_PHONEBOOK in DF_TELECOM and in ADF_USIM, where USIMs lacking EF_ADN in DF_TELECOM keep it.
+	dfPhonebooks = [][]byte{{0x3F, 0x00, 0x7F, 0x10, 0x5F, 0x3A}, {0x3F, 0x00, 0x7F, 0xFF, 0x5F, 0x3A}}
+)
+
+// adnPhonebook reads and updates the records of EF_ADN with restricted SIM access.
+// A record is the name padded to the record length minus 14, followed by the number in BCD.
+type adnPhonebook struct {
+	crsm    ATCommand
+	fileID  uint16
+	path    []byte
+	length  int
+	records int
+}
+
+func (a *adnPhonebook) open(p *Phonebook) error {
+	if err := a.locate(); err != nil {
+		return err
+	}
+	if a.length < 14 {
+		return fmt.Errorf("unexpected record length %d", a.length)
+	}
+	p.First, p.Last = 1, a.records
+	p.NameLength, p.NumberLength = a.length-14, 20
+	return nil
+}
+
+// locate finds EF_ADN in DF_TELECOM, or else in a DF_PHONEBOOK, where its file ID is listed in EF_PBR.
+func (a *adnPhonebook) locate() error {
+	err := a.describe(adnFileID, dfTelecom)
+	if err == nil {
+		return nil
+	}
+	for _, path := range dfPhonebooks {
+		if fileID, pbrErr := a.pbrADN(path); pbrErr == nil && a.describe(fileID, path) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("the SIM has no phonebook, EF_ADN is neither in DF_TELECOM nor in DF_PHONEBOOK: %w", err)
+}
+
+// describe reads the size of a linear fixed file.
+func (a *adnPhonebook) describe(fileID uint16, path []byte) error {
+	response, err := a.crsm.Run(CRSMCommand{Instruction: CRSMGetResponse, FileID: fileID, Path: path}.Bytes())
+	if err != nil {
+		return err
+	}
+	if a.length, a.records, err = recordFile(response); err != nil {
+		return err
+	}
+	a.fileID, a.path = fileID, path
+	return nil
+}
+
+// pbrADN returns the file ID of EF_ADN, listed in the first record of EF_PBR of a DF_PHONEBOOK.
+func (a *adnPhonebook) pbrADN(path []byte) (uint16, error) {
+	response, err := a.crsm.Run(CRSMCommand{Instruction: CRSMGetResponse, FileID: pbrFileID, Path: path}.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	length, _, err := recordFile(response)
+	if err != nil {
+		return 0, err
+	}
+	record, err := a.crsm.Run(CRSMCommand{
+		Instruction: CRSMReadRecord,
+		FileID:      pbrFileID,
+		P1:          1,
+		P2:          4,
+		Length:      byte(length),
+		Path:        path,
+	}.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	// EF_ADN is the tag C0 of the type 1 files (tag A8).
+	files := tlvTag(record, 0xA8)
+	if files == nil {
+		return 0, fmt.Errorf("EF_PBR lists no files: %X", record)
+	}
+	adn := tlvTag(files[2:], 0xC0)
+	if len(adn) < 4 {
+		return 0, fmt.Errorf("EF_PBR lists no EF_ADN: %X", record)
+	}
+	return uint16(adn[2])<<8 | uint16(adn[3]), nil
+}
+
+// recordFile returns the record length and the number of records of a linear fixed file from its GET RESPONSE,
+// an FCP template from a USIM or the response of a SIM, 3GPP TS 51.011 section 9.2.1.
+func recordFile(response []byte) (length int, records int, err error) {
+	if len(response) > 0 && response[0] == 0x62 {
+		// The file descriptor (tag 82) holds the record length and the number of records.
+		descriptor := fcpTag(response, 0x82)
+		if len(descriptor) < 7 {
+			return 0, 0, fmt.Errorf("unexpected response: %X", response)
+		}
+		return int(descriptor[4])<<8 | int(descriptor[5]), int(descriptor[6]), nil
+	}
+	// The file size is in bytes 3 and 4, the record length in byte 15.
+	if len(response) < 15 || response[14] == 0 {
+		return 0, 0, fmt.Errorf("unexpected response: %X", response)
+	}
+	length = int(response[14])
+	return length, (int(response[2])<<8 | int(response[3])) / length, nil
+}
+
+// fcpTag finds a tag of an FCP template, returned with its tag and length bytes.
+func fcpTag(fcp []byte, tag byte) []byte {
+	if len(fcp) < 2 || fcp[0] != 0x62 {
+		return nil
+	}
+	return tlvTag(fcp[2:], tag)
+}
+
+// tlvTag finds a tag among TLV objects with one byte tags and lengths, returned with its tag and length bytes.
+func tlvTag(data []byte, tag byte) []byte {
+	for len(data) >= 2 && len(data) >= 2+int(data[1]) {
+		if data[0] == tag {
+			return data[:2+int(data[1])]
+		}
+		data = data[2+int(data[1]):]
+	}
+	return nil
+}
+
+func (a *adnPhonebook) entries() ([]*PhonebookEntry, error) {
+	var entries []*PhonebookEntry
+	for index := 1; index <= a.records; index++ {
+		record, err := a.crsm.Run(CRSMCommand{
+			Instruction: CRSMReadRecord,
+			FileID:      a.fileID,
+			P1:          byte(index),
+			P2:          4,
+			Length:      byte(a.length),
+			Path:        a.path,
+		}.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if entry := DecodeADN(record); entry != nil {
+			entry.Index = index
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (a *adnPhonebook) write(entry *PhonebookEntry) error {
+	record, err := EncodeADN(entry, a.length)
+	if err != nil {
+		return err
+	}
+	return a.update(entry.Index, record)
+}
+
+func (a *adnPhonebook) erase(index int) error {
+	return a.update(index, bytes.Repeat([]byte{0xFF}, a.length))
+}
+
+func (a *adnPhonebook) update(index int, record []byte) error {
+	_, err := a.crsm.Run(CRSMCommand{
+		Instruction: CRSMUpdateRecord,
+		FileID:      a.fileID,
+		P1:          byte(index),
+		P2:          4,
+		Data:        record,
+		Path:        a.path,
+	}.Bytes())
+	return err
+}
+
+func (a *adnPhonebook) close() error {
+	return nil
+}
+
+// EncodeADN builds an EF_ADN record of the given length, the name is written in the GSM default alphabet
+// if it allows it and in UCS-2 otherwise.
+func EncodeADN(entry *PhonebookEntry, length int) ([]byte, error) {
+	record := bytes.Repeat([]byte{0xFF}, length)
+	alpha := adnAlpha(entry.Name)
+	if len(alpha) > length-14 {
+		return nil, fmt.Errorf("the name takes %d bytes, the SIM takes up to %d", len(alpha), length-14)
+	}
+	copy(record, alpha)
+	digits := strings.TrimPrefix(entry.Number, "+")
+	if len(digits) > 20 {
+		return nil, fmt.Errorf("the number has %d digits, the SIM takes up to 20", len(digits))
+	}
+	bcd := bytes.Repeat([]byte{0xFF}, 10)
+	for i, r := range digits {
+		nibble := strings.IndexRune("0123456789*#p", r)
+		if nibble < 0 {
+			return nil, fmt.Errorf("invalid number %q", entry.Number)
+		}
+		if i%2 == 0 {
+			bcd[i/2] = 0xF0 | byte(nibble)
+		} else {
+			bcd[i/2] = bcd[i/2]&0x0F | byte(nibble)<<4
+		}
+	}
+	offset := length - 14
+	record[offset] = byte((len(digits)+1)/2 + 1)
+	record[offset+1] = util.If(strings.HasPrefix(entry.Number, "+"), byte(0x91), byte(0x81))
+	copy(record[offset+2:], bcd)
+	return record, nil
+}
+
+// DecodeADN reads an EF_ADN record, nil if it is free.
+func DecodeADN(record []byte) *PhonebookEntry {
+	if len(record) < 14 {
+		return nil
+	}
+	offset := len(record) - 14
+	entry := &PhonebookEntry{Name: decodeADNAlpha(record[:offset])}
+	if size := int(record[offset]); size >= 2 && size <= 11 {
+		var number strings.Builder
+		if record[offset+1] == 0x91 {
+			number.WriteByte('+')
+		}
+	digits:
+		for _, b := range record[offset+2 : offset+1+size] {
+			for _, nibble := range []byte{b & 0x0F, b >> 4} {
+				if nibble == 0x0F {
+					break digits
+				}
+				if nibble < 13 {
+					number.WriteByte("0123456789*#p"[nibble])
+				}
+			}
+		}
+		entry.Number = number.String()
+	}
+	if entry.Name == "" && entry.Number == "" {
+		return nil
+	}
+	return entry
+}
+
+// adnAlpha encodes a name as an alpha identifier, 3GPP TS 31.102 annex A.
+func adnAlpha(name string) []byte {
+	if _, ok := gsm7Septets(name); ok {
+		var alpha []byte
+		for _, r := range name {
+			if index := slices.Index([]rune(gsm7Extension), r); index >= 0 {
+				alpha = append(alpha, 0x1B, gsm7ExtensionCodes[index])
+				continue
+			}
+			alpha = append(alpha, gsm7Code(r))
+		}
+		return alpha
+	}
+	alpha := []byte{0x80}
+	for _, unit := range utf16.Encode([]rune(name)) {
+		alpha = append(alpha, byte(unit>>8), byte(unit))
+	}
+	return alpha
+}
+
+func adnAlphaLength(name string) int {
+	return len(adnAlpha(name))
+}
+
+// decodeADNAlpha reads an alpha identifier in the GSM default alphabet or any of the three UCS-2 forms.
+func decodeADNAlpha(alpha []byte) string {
+	if len(alpha) == 0 || alpha[0] == 0xFF {
+		return ""
+	}
+	var units []uint16
+	switch alpha[0] {
+	case 0x80:
+		for i := 1; i+1 < len(alpha) && !(alpha[i] == 0xFF && alpha[i+1] == 0xFF); i += 2 {
+			units = append(units, uint16(alpha[i])<<8|uint16(alpha[i+1]))
+		}
+		return string(utf16.Decode(units))
+	case 0x81, 0x82:
+		// A length, a base pointer and characters either from the GSM alphabet or offset from the base.
+		header := util.If(alpha[0] == 0x81, 3, 4)
+		if len(alpha) < header {
+			return ""
+		}
+		base := uint16(alpha[2]) << 7
+		if alpha[0] == 0x82 {
+			base = uint16(alpha[2])<<8 | uint16(alpha[3])
+		}
+		var name strings.Builder
+		for _, b := range alpha[header:min(len(alpha), header+int(alpha[1]))] {
+			if b&0x80 != 0 {
+				name.WriteString(string(utf16.Decode([]uint16{base + uint16(b&0x7F)})))
+				continue
+			}
+			name.WriteString(gsm7Rune(b))
+		}
+		return name.String()
+	}
+	var name strings.Builder
+	for i := 0; i < len(alpha) && alpha[i] != 0xFF; i++ {
+		if alpha[i] == 0x1B && i+1 < len(alpha) {
+			if index := bytes.IndexByte(gsm7ExtensionCodes, alpha[i+1]); index >= 0 {
+				name.WriteRune([]rune(gsm7Extension)[index])
+			}
+			i++
+			continue
+		}
+		name.WriteString(gsm7Rune(alpha[i]))
+	}
+	return name.String()
+}
+
+// gsm7ExtensionCodes are the codes following the escape of the characters in gsm7Extension.
+var gsm7ExtensionCodes = []byte{0x0A, 0x14, 0x28, 0x29, 0x2F, 0x3C, 0x3D, 0x3E, 0x40, 0x65}
+
+// gsm7Code returns the code of a character of the default alphabet, which skips the escape at 0x1B.
+func gsm7Code(r rune) byte {
+	index := slices.Index([]rune(gsm7Basic), r)
+	if index >= 0x1B {
+		index++
+	}
+	return byte(index)
+}
+
+func gsm7Rune(code byte) string {
+	basic := []rune(gsm7Basic)
+	index := int(code)
+	if index == 0x1B || index > len(basic) {
+		return ""
+	}
+	if index > 0x1B {
+		index--
+	}
+	return string(basic[index])
+}
+
+// endregion
@@ -0,0 +1,109 @@
+package modem
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/damonto/telegram-sms/internal/pkg/fake/serial"
+)
+
+func TestCPBPhonebookWriteWithoutUCS2(t *testing.T) {
+	fake, at := newTestAT(t)
+	fake.Reply("AT+CPBS=", serial.OK())
+	fake.Reply("AT+CSCS?", serial.OK(`+CSCS: "IRA"`))
+	fake.Reply("AT+CSCS=", serial.Error())
+	fake.Reply("AT+CPBR=?", serial.OK("+CPBR: (1-250),40,18"))
+	fake.Reply("AT+CPBW=", serial.OK())
+	p := &Phonebook{at: at, backend: &cpbPhonebook{at: at}}
+	if err := p.backend.open(p); err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+
+	for _, name := range []string{`Anna", 129, "x`, "Anna\r\nAT+CFUN=0", "Anna\x1A"} {
+		if err := p.Write(&PhonebookEntry{Index: 1, Name: name, Number: "+393331234567"}); err == nil {
+			t.Errorf("Write(%q) error = nil, want the name refused", name)
+		}
+	}
+	for _, command := range fake.Commands() {
+		if strings.HasPrefix(command, "AT+CPBW") {
+			t.Fatalf("%q sent for a refused name", command)
+		}
+	}
+	if err := p.Write(&PhonebookEntry{Index: 1, Name: "Anna", Number: "+393331234567"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if commands := fake.Commands(); commands[len(commands)-1] != `AT+CPBW=1,"+393331234567",145,"Anna"` {
+		t.Errorf("command = %q", commands[len(commands)-1])
+	}
+}
+
+// fakeSIM answers restricted SIM access commands from a table, the files missing from it are not found.
+type fakeSIM map[string][]byte
+
+func (s fakeSIM) Run(command []byte) ([]byte, error) {
+	if response, ok := s[string(command)]; ok {
+		return response, nil
+	}
+	return nil, fmt.Errorf("unexpected response: +CRSM: 106,130")
+}
+
+func (s fakeSIM) getResponse(fileID uint16, path []byte, response string) {
+	s[string(CRSMCommand{Instruction: CRSMGetResponse, FileID: fileID, Path: path}.Bytes())] = mustDecodeHex(response)
+}
+
+func (s fakeSIM) readRecord(fileID uint16, path []byte, length byte, record string) {
+	s[string(CRSMCommand{Instruction: CRSMReadRecord, FileID: fileID, P1: 1, P2: 4, Length: length, Path: path}.Bytes())] = mustDecodeHex(record)
+}
+
+func TestADNPhonebookOpen(t *testing.T) {
+	const (
+		// A linear fixed file of 10 records of 30 bytes, as a USIM and as a SIM describe it.
+		fcp     = "621982054221001E0A83026F3AA5038001718A01058B036F06038002012C"
+		gsm     = "0000012C6F3A040011FFEE0102011E"
+		pbrFCP  = "620F8205422100100183024F3080020100"
+		pbrData = "A80FC0034F3A01C5034F0902C4034F1103FFFF"
+	)
+	phonebook := func(sim fakeSIM, path []byte) {
+		sim.getResponse(pbrFileID, path, pbrFCP)
+		sim.readRecord(pbrFileID, path, 0x10, pbrData)
+		sim.getResponse(0x4F3A, path, fcp)
+	}
+	tests := []struct {
+		name       string
+		setup      func(sim fakeSIM)
+		wantFileID uint16
+		wantPath   []byte
+	}{
+		{name: "USIM", setup: func(sim fakeSIM) { sim.getResponse(adnFileID, dfTelecom, fcp) }, wantFileID: adnFileID, wantPath: dfTelecom},
+		{name: "SIM", setup: func(sim fakeSIM) { sim.getResponse(adnFileID, dfTelecom, gsm) }, wantFileID: adnFileID, wantPath: dfTelecom},
+		{name: "DF_PHONEBOOK in DF_TELECOM", setup: func(sim fakeSIM) { phonebook(sim, dfPhonebooks[0]) }, wantFileID: 0x4F3A, wantPath: dfPhonebooks[0]},
+		{name: "DF_PHONEBOOK in ADF_USIM", setup: func(sim fakeSIM) { phonebook(sim, dfPhonebooks[1]) }, wantFileID: 0x4F3A, wantPath: dfPhonebooks[1]},
+		{name: "no phonebook", setup: func(sim fakeSIM) {}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := make(fakeSIM)
+			tt.setup(sim)
+			a := &adnPhonebook{crsm: sim}
+			p := new(Phonebook)
+			err := a.open(p)
+			if tt.wantPath == nil {
+				if err == nil || !strings.Contains(err.Error(), "no phonebook") {
+					t.Fatalf("open() error = %v, want the phonebook reported missing", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open() error = %v", err)
+			}
+			if a.fileID != tt.wantFileID || !bytes.Equal(a.path, tt.wantPath) {
+				t.Errorf("EF_ADN found at %04X in %X, want %04X in %X", a.fileID, a.path, tt.wantFileID, tt.wantPath)
+			}
+			if p.First != 1 || p.Last != 10 || p.NameLength != 16 || a.length != 30 {
+				t.Errorf("records %d-%d of %d bytes with names of %d, want 1-10 of 30 with names of 16", p.First, p.Last, a.length, p.NameLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package modem
+
+// UnlockRequired returns the code the modem is waiting for, ModemLockNone once it is unlocked.
+func (m *Modem) UnlockRequired() (ModemLock, error) {
+	variant, err := m.dbusObject.GetProperty(ModemInterface + ".UnlockRequired")
+	if err != nil {
+		return ModemLockUnknown, err
+	}
+	return ModemLock(variant.Value().(uint32)), nil
+}
+
+// UnlockRetries returns the attempts left for each code, a code the modem doesn't report is missing.
+func (m *Modem) UnlockRetries() (map[ModemLock]uint32, error) {
+	variant, err := m.dbusObject.GetProperty(ModemInterface + ".UnlockRetries")
+	if err != nil {
+		return nil, err
+	}
+	retries := make(map[ModemLock]uint32)
+	for lock, count := range variant.Value().(map[uint32]uint32) {
+		retries[ModemLock(lock)] = count
+	}
+	return retries, nil
+}
+
+func (m *Modem) SendPin(pin string) error {
+	return m.callSIM("SendPin", pin)
+}
+
+// SendPuk unblocks the SIM with the PUK and sets a new PIN.
+func (m *Modem) SendPuk(puk string, pin string) error {
+	return m.callSIM("SendPuk", puk, pin)
+}
+
+// EnablePin turns the PIN lock of the SIM on or off, the current PIN is required either way.
+func (m *Modem) EnablePin(pin string, enabled bool) error {
+	return m.callSIM("EnablePin", pin, enabled)
+}
+
+func (m *Modem) ChangePin(oldPin string, newPin string) error {
+	return m.callSIM("ChangePin", oldPin, newPin)
+}
+
+func (m *Modem) callSIM(method string, args ...any) error {
+	dbusObject, err := m.privateDbusObject(m.Sim.Path)
+	if err != nil {
+		return err
+	}
+	return dbusObject.Call(ModemSimInterface+"."+method, 0, args...).Err
+}
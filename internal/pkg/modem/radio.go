@@ -0,0 +1,147 @@
+package modem
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+)
+
+// ModemModes is a combination of allowed modes and the one preferred among them.
+type ModemModes struct {
+	Allowed   ModemMode
+	Preferred ModemMode
+}
+
+// String describes the combination, e.g. "3G/4G, prefer 4G" or "4G only".
+func (m ModemModes) String() string {
+	if m.Allowed&(m.Allowed-1) == 0 && m.Allowed != ModemModeNone && m.Allowed != ModemModeAny {
+		return m.Allowed.String() + " only"
+	}
+	if m.Preferred == ModemModeNone {
+		return m.Allowed.String()
+	}
+	return fmt.Sprintf("%s, prefer %s", m.Allowed, m.Preferred)
+}
+
+// ParseModemMode parses modes separated by slashes, e.g. 3G/4G.
+func ParseModemMode(name string) (ModemMode, error) {
+	var mode ModemMode
+	for _, part := range strings.Split(name, "/") {
+		switch strings.ToUpper(strings.TrimSpace(part)) {
+		case "":
+		case "CS":
+			mode |= ModemModeCs
+		case "2G":
+			mode |= ModemMode2G
+		case "3G":
+			mode |= ModemMode3G
+		case "4G":
+			mode |= ModemMode4G
+		case "5G":
+			mode |= ModemMode5G
+		case "ANY":
+			mode = ModemModeAny
+		default:
+			return ModemModeNone, fmt.Errorf("unknown mode %q", part)
+		}
+	}
+	return mode, nil
+}
+
+func (m *Modem) SupportedModes() ([]ModemModes, error) {
+	variant, err := m.dbusObject.GetProperty(ModemInterface + ".SupportedModes")
+	if err != nil {
+		return nil, err
+	}
+	var modes []ModemModes
+	for _, value := range variant.Value().([][]any) {
+		modes = append(modes, ModemModes{Allowed: ModemMode(value[0].(uint32)), Preferred: ModemMode(value[1].(uint32))})
+	}
+	return modes, nil
+}
+
+func (m *Modem) CurrentModes() (ModemModes, error) {
+	variant, err := m.dbusObject.GetProperty(ModemInterface + ".CurrentModes")
+	if err != nil {
+		return ModemModes{}, err
+	}
+	value := variant.Value().([]any)
+	return ModemModes{Allowed: ModemMode(value[0].(uint32)), Preferred: ModemMode(value[1].(uint32))}, nil
+}
+
+func (m *Modem) SetCurrentModes(modes ModemModes) error {
+	return m.dbusObject.Call(ModemInterface+".SetCurrentModes", 0, struct {
+		Allowed   uint32
+		Preferred uint32
+	}{uint32(modes.Allowed), uint32(modes.Preferred)}).Err
+}
+
+func (m *Modem) SupportedBands() ([]ModemBand, error) {
+	return m.bands("SupportedBands")
+}
+
+func (m *Modem) CurrentBands() ([]ModemBand, error) {
+	return m.bands("CurrentBands")
+}
+
+func (m *Modem) bands(property string) ([]ModemBand, error) {
+	variant, err := m.dbusObject.GetProperty(ModemInterface + "." + property)
+	if err != nil {
+		return nil, err
+	}
+	var bands []ModemBand
+	for _, band := range variant.Value().([]uint32) {
+		bands = append(bands, ModemBand(band))
+	}
+	return bands, nil
+}
+
+// SetCurrentBands restricts the modem to the bands, ModemBandAny alone allows every supported band again.
+func (m *Modem) SetCurrentBands(bands []ModemBand) error {
+	values := make([]uint32, 0, len(bands))
+	for _, band := range bands {
+		values = append(values, uint32(band))
+	}
+	return m.dbusObject.Call(ModemInterface+".SetCurrentBands", 0, values).Err
+}
+
+// ApplyRadioDefaults sets the modes and the bands saved for the modem, if any.
+func (m *Modem) ApplyRadioDefaults() error {
+	// A locked modem refuses to change its radio, it is announced again once the SIM has been unlocked.
+	if m.State == ModemStateLocked {
+		return nil
+	}
+	radio, err := config.C.Radio(m.EquipmentIdentifier)
+	if err != nil || radio == nil {
+		return err
+	}
+	if radio.Modes != "" {
+		var modes ModemModes
+		if modes.Allowed, err = ParseModemMode(radio.Modes); err != nil {
+			return err
+		}
+		if modes.Preferred, err = ParseModemMode(radio.Preferred); err != nil {
+			return err
+		}
+		if err := m.SetCurrentModes(modes); err != nil {
+			return fmt.Errorf("failed to set modes %s: %w", modes, err)
+		}
+	}
+	if len(radio.Bands) > 0 {
+		bands := make([]ModemBand, 0, len(radio.Bands))
+		for _, name := range radio.Bands {
+			band, err := ParseModemBand(name)
+			if err != nil {
+				return err
+			}
+			bands = append(bands, band)
+		}
+		if err := m.SetCurrentBands(bands); err != nil {
+			return fmt.Errorf("failed to set bands: %w", err)
+		}
+	}
+	slog.Info("Radio defaults applied", "modem", m.EquipmentIdentifier, "modes", radio.Modes, "preferred", radio.Preferred, "bands", radio.Bands)
+	return nil
+}
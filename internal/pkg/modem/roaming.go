@@ -0,0 +1,64 @@
+package modem
+
+import (
+	"fmt"
+
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+// Roaming compares the network serving a SIM with its home operator.
+type Roaming struct {
+	// Home is the MCC/MNC of the SIM and Visited the one of the serving network.
+	Home    string
+	Visited string
+	Active  bool
+}
+
+// NewRoaming detects roaming from the registration state, or from the serving network being in another country
+// than the SIM, as some modems keep reporting the home registration state abroad.
+func NewRoaming(home string, state Modem3gppRegistrationState, visited string) *Roaming {
+	r := &Roaming{Home: home, Visited: visited}
+	r.Active = state.Roaming() || state.Registered() && r.International()
+	return r
+}
+
+func (m *Modem) Roaming() (*Roaming, error) {
+	state, err := m.RegistrationState()
+	if err != nil {
+		return nil, err
+	}
+	code, err := m.OperatorCode()
+	if err != nil {
+		return nil, err
+	}
+	return NewRoaming(m.Sim.OperatorIdentifier, state, code), nil
+}
+
+// International reports whether the serving network is in another country than the SIM's home operator.
+func (r *Roaming) International() bool {
+	if len(r.Home) < 3 || len(r.Visited) < 3 {
+		return false
+	}
+	home, visited := util.LookupCountry(r.Home), util.LookupCountry(r.Visited)
+	if home == "" || visited == "" {
+		return r.Home[:3] != r.Visited[:3]
+	}
+	return home != visited
+}
+
+// Country returns the ISO 3166 country code of the serving network, empty if it is unknown.
+func (r *Roaming) Country() string {
+	return util.LookupCountry(r.Visited)
+}
+
+// String describes the visited network, e.g. "Rogers Communications (302720) in 🇨🇦 CA", or "No" when not roaming.
+func (r *Roaming) String() string {
+	if !r.Active {
+		return "No"
+	}
+	text := fmt.Sprintf("%s (%s)", util.LookupCarrier(r.Visited), r.Visited)
+	if country := r.Country(); country != "" {
+		text += fmt.Sprintf(" in %s %s", util.CountryFlag(country), country)
+	}
+	return text
+}
@@ -0,0 +1,136 @@
+package modem
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const ModemSignalInterface = ModemInterface + ".Signal"
+
+const (
+	// DefaultSignalRate is the refresh rate in seconds the extended signal metrics are polled with in the background.
+	DefaultSignalRate = 30
+	// LiveSignalRate is the refresh rate while someone is watching the signal, e.g. to position an antenna.
+	LiveSignalRate = 2
+)
+
+// SignalMetrics are the radio measurements of an access technology, a measurement the modem didn't report is NaN.
+type SignalMetrics struct {
+	Technology string
+	RSSI       float64 // dBm
+	RSRP       float64 // dBm
+	RSRQ       float64 // dB
+	SINR       float64 // dB
+	RSCP       float64 // dBm
+	ECIO       float64 // dB
+}
+
+// signalTechnologies are the properties of the Signal interface, newest technology first.
+var signalTechnologies = []struct {
+	property string
+	name     string
+}{
+	{"Nr5g", "5G NR"},
+	{"Lte", "LTE"},
+	{"Umts", "UMTS"},
+	{"Gsm", "GSM"},
+}
+
+// SetupSignal enables polling the extended signal metrics every rate seconds, 0 disables it.
+func (m *Modem) SetupSignal(rate uint32) error {
+	return m.dbusObject.Call(ModemSignalInterface+".Setup", 0, rate).Err
+}
+
+// SignalRate returns the refresh rate of the extended signal metrics, 0 if they are not polled.
+func (m *Modem) SignalRate() (uint32, error) {
+	variant, err := m.dbusObject.GetProperty(ModemSignalInterface + ".Rate")
+	if err != nil {
+		return 0, err
+	}
+	return variant.Value().(uint32), nil
+}
+
+// Signal returns the metrics of every access technology the modem has measurements for.
+// They are only available once polling has been enabled with SetupSignal.
+// A technology ModemManager doesn't know yet, e.g. Nr5g before 1.16, is skipped.
+func (m *Modem) Signal() ([]*SignalMetrics, error) {
+	var metrics []*SignalMetrics
+	var read bool
+	var failed error
+	for _, technology := range signalTechnologies {
+		variant, err := m.dbusObject.GetProperty(ModemSignalInterface + "." + technology.property)
+		if err != nil {
+			failed = err
+			continue
+		}
+		read = true
+		values, ok := variant.Value().(map[string]dbus.Variant)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		s := &SignalMetrics{
+			Technology: technology.name,
+			RSSI:       signalValue(values, "rssi"),
+			RSRP:       signalValue(values, "rsrp"),
+			RSRQ:       signalValue(values, "rsrq"),
+			// 5G NR reports the SINR as sinr, the older technologies as snr.
+			SINR: signalValue(values, "sinr", "snr"),
+			RSCP: signalValue(values, "rscp"),
+			ECIO: signalValue(values, "ecio"),
+		}
+		if !s.empty() {
+			metrics = append(metrics, s)
+		}
+	}
+	// Every property failing means the modem has no Signal interface at all.
+	if !read {
+		return nil, failed
+	}
+	return metrics, nil
+}
+
+// signalValue returns the first of the keys that is reported.
+func signalValue(values map[string]dbus.Variant, keys ...string) float64 {
+	for _, key := range keys {
+		if variant, ok := values[key]; ok {
+			if value, ok := variant.Value().(float64); ok {
+				return value
+			}
+		}
+	}
+	return math.NaN()
+}
+
+func (s *SignalMetrics) empty() bool {
+	for _, value := range []float64{s.RSSI, s.RSRP, s.RSRQ, s.SINR, s.RSCP, s.ECIO} {
+		if !math.IsNaN(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the reported measurements, e.g. "LTE RSRP -95.0 dBm, RSRQ -10.0 dB, SINR 12.5 dB".
+func (s *SignalMetrics) String() string {
+	var values []string
+	for _, v := range []struct {
+		name  string
+		value float64
+		unit  string
+	}{
+		{"RSSI", s.RSSI, "dBm"},
+		{"RSRP", s.RSRP, "dBm"},
+		{"RSRQ", s.RSRQ, "dB"},
+		{"SINR", s.SINR, "dB"},
+		{"RSCP", s.RSCP, "dBm"},
+		{"Ec/Io", s.ECIO, "dB"},
+	} {
+		if !math.IsNaN(v.value) {
+			values = append(values, fmt.Sprintf("%s %.1f %s", v.name, v.value, v.unit))
+		}
+	}
+	return s.Technology + " " + strings.Join(values, ", ")
+}
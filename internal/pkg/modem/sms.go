@@ -11,9 +11,30 @@ const ModemSMSInterface = ModemManagerInterface + ".Sms"
 type SMS struct {
 	objectPath dbus.ObjectPath
 	State      SMSState
+	PduType    SMSPduType
 	Number     string
 	Text       string
-	Timestamp  time.Time
+	// Data is the payload of a binary message, which has no text.
+	Data      []byte
+	SMSC      string
+	Class     SMSClass
+	Validity  time.Duration
+	Timestamp time.Time
+	// MessageReference, DeliveryState and DischargeTimestamp describe the delivery of a sent message, or a status report.
+	MessageReference   uint32
+	DeliveryState      SMSDeliveryState
+	DischargeTimestamp time.Time
+	Storage            SMSStorage
+}
+
+// Flash tells whether the message is a class 0 message, meant to be shown straight away rather than stored.
+func (s *SMS) Flash() bool {
+	return s.Class == SMSClassFlash
+}
+
+// Binary tells whether the message carries data rather than text, e.g. a WAP push or an OTA update.
+func (s *SMS) Binary() bool {
+	return s.Text == "" && len(s.Data) > 0
 }
 
 func (m *Modem) RetrieveSMS(objectPath dbus.ObjectPath) (*SMS, error) {
@@ -21,40 +42,89 @@ func (m *Modem) RetrieveSMS(objectPath dbus.ObjectPath) (*SMS, error) {
 	if err != nil {
 		return nil, err
 	}
-	sms := &SMS{objectPath: objectPath}
-	variant, err := dbusObject.GetProperty(ModemSMSInterface + ".State")
-	if err != nil {
+	var properties map[string]dbus.Variant
+	if err := dbusObject.Call("org.freedesktop.DBus.Properties.GetAll", 0, ModemSMSInterface).Store(&properties); err != nil {
 		return nil, err
 	}
-	sms.State = SMSState(variant.Value().(uint32))
-
-	variant, err = dbusObject.GetProperty(ModemSMSInterface + ".Number")
-	if err != nil {
-		return nil, err
+	sms := &SMS{objectPath: objectPath, Class: SMSClassNone, DeliveryState: SMSDeliveryStateUnknown}
+	if state, ok := properties["State"].Value().(uint32); ok {
+		sms.State = SMSState(state)
 	}
-	sms.Number = variant.Value().(string)
-
-	variant, err = dbusObject.GetProperty(ModemSMSInterface + ".Text")
-	if err != nil {
-		return nil, err
+	if pduType, ok := properties["PduType"].Value().(uint32); ok {
+		sms.PduType = SMSPduType(pduType)
 	}
-	sms.Text = variant.Value().(string)
-
-	variant, err = dbusObject.GetProperty(ModemSMSInterface + ".Timestamp")
-	if err != nil {
-		return nil, err
+	sms.Number, _ = properties["Number"].Value().(string)
+	sms.Text, _ = properties["Text"].Value().(string)
+	sms.Data, _ = properties["Data"].Value().([]byte)
+	sms.SMSC, _ = properties["SMSC"].Value().(string)
+	if class, ok := properties["Class"].Value().(int32); ok {
+		sms.Class = SMSClass(class)
 	}
-	if t := variant.Value().(string); t != "" {
-		sms.Timestamp, err = time.Parse("2006-01-02T15:04:05Z07", t)
-		if err != nil {
-			return nil, err
+	// Only relative validity periods are used, they are given in minutes.
+	if validity, ok := properties["Validity"].Value().([]any); ok && len(validity) == 2 {
+		if kind, _ := validity[0].(uint32); kind == smsValidityRelative {
+			if value, ok := validity[1].(dbus.Variant); ok {
+				minutes, _ := value.Value().(uint32)
+				sms.Validity = time.Duration(minutes) * time.Minute
+			}
 		}
 	}
+	sms.MessageReference, _ = properties["MessageReference"].Value().(uint32)
+	if deliveryState, ok := properties["DeliveryState"].Value().(uint32); ok {
+		sms.DeliveryState = SMSDeliveryState(deliveryState)
+	}
+	if storage, ok := properties["Storage"].Value().(uint32); ok {
+		sms.Storage = SMSStorage(storage)
+	}
+	if sms.Timestamp, err = parseSMSTimestamp(properties["Timestamp"]); err != nil {
+		return nil, err
+	}
+	if sms.DischargeTimestamp, err = parseSMSTimestamp(properties["DischargeTimestamp"]); err != nil {
+		return nil, err
+	}
 	return sms, nil
 }
 
+func parseSMSTimestamp(variant dbus.Variant) (time.Time, error) {
+	if t, _ := variant.Value().(string); t != "" {
+		return time.Parse("2006-01-02T15:04:05Z07", t)
+	}
+	return time.Time{}, nil
+}
+
+// smsValidityRelative is the MMSmsValidityType of a validity period given in minutes.
+const smsValidityRelative uint32 = 1
+
+// smsValidity is the (uv) validity of a message: its type and, for a relative one, the minutes.
+type smsValidity struct {
+	Type  uint32
+	Value dbus.Variant
+}
+
+// SMSOptions are the settings of a message to send, the zero value sends a plain text message.
+type SMSOptions struct {
+	// SMSC overrides the message center of the SIM.
+	SMSC string
+	// Validity is how long the network keeps trying to deliver the message, the network decides if zero.
+	Validity time.Duration
+	// Flash sends a class 0 message, which is shown straight away and not stored by the recipient.
+	Flash bool
+	// GSM7 replaces the characters GSM-7 lacks, e.g. typographic quotes and accents, so that the text
+	// isn't sent as UCS-2. ModemManager picks the encoding itself, UCS-2 is used only when the text needs it.
+	GSM7 bool
+	// Data is sent as an 8-bit binary message instead of the text.
+	Data []byte
+	// Storage stores the message there instead of sending it, e.g. SMSStorageSm to keep it on the SIM.
+	Storage SMSStorage
+}
+
 func (m *Modem) SendSMS(to string, text string) (*SMS, error) {
-	path, err := m.CreateMessage(to, text)
+	return m.SendMessage(to, text, SMSOptions{})
+}
+
+// SendMessage sends the message, or stores it if options.Storage is set.
+func (m *Modem) SendMessage(to string, text string, options SMSOptions) (*SMS, error) {
+	path, err := m.CreateMessage(to, text, options)
 	if err != nil {
 		return nil, err
 	}
@@ -62,7 +132,12 @@ func (m *Modem) SendSMS(to string, text string) (*SMS, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := dbusObject.Call(ModemSMSInterface+".Send", 0).Err; err != nil {
+	if options.Storage != SMSStorageUnknown {
+		err = dbusObject.Call(ModemSMSInterface+".Store", 0, uint32(options.Storage)).Err
+	} else {
+		err = dbusObject.Call(ModemSMSInterface+".Send", 0).Err
+	}
+	if err != nil {
 		return nil, err
 	}
 	return m.RetrieveSMS(path)
@@ -0,0 +1,93 @@
+package modem
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// SMSEncoding is how the text of a message is encoded, ModemManager picks GSM-7 whenever the text allows it.
+type SMSEncoding uint32
+
+const (
+	SMSEncodingAuto SMSEncoding = iota // GSM-7 if the text allows it, UCS-2 otherwise.
+	SMSEncodingGSM7                    // 7-bit GSM default alphabet, 160 characters per message.
+	SMSEncodingUCS2                    // UTF-16, 70 characters per message.
+	SMSEncodingData                    // 8-bit binary data, 140 bytes per message.
+)
+
+func (e SMSEncoding) String() string {
+	switch e {
+	case SMSEncodingAuto:
+		return "Auto"
+	case SMSEncodingGSM7:
+		return "GSM-7"
+	case SMSEncodingUCS2:
+		return "UCS-2"
+	default:
+		return "8-bit data"
+	}
+}
+
+// The GSM 03.38 default alphabet, the characters of the extension table take two septets.
+const (
+	gsm7Basic     = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extension = "\f^{}\\[~]|€"
+)
+
+// gsm7Replacements are the characters phones commonly type that have a close GSM-7 equivalent,
+// typographic punctuation and the accented letters GSM-7 lacks.
+var gsm7Replacements = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "“", "\"", "”", "\"", "„", "\"",
+	"–", "-", "—", "-", "…", "...", "\u00a0", " ", "\t", " ", "•", "-",
+	"á", "a", "â", "a", "ã", "a", "ç", "Ç", "ê", "e", "ë", "e", "í", "i", "î", "i", "ï", "i",
+	"ó", "o", "ô", "o", "õ", "o", "ú", "u", "û", "u", "ý", "y", "ÿ", "y",
+	"Á", "A", "À", "A", "Â", "A", "Ã", "A", "È", "E", "Ê", "E", "Ë", "E", "Í", "I", "Ì", "I", "Î", "I", "Ï", "I",
+	"Ó", "O", "Ò", "O", "Ô", "O", "Õ", "O", "Ú", "U", "Ù", "U", "Û", "U", "Ý", "Y",
+)
+
+// SMSSegments returns the encoding the text would be sent with and the number of messages it takes.
+func SMSSegments(text string) (SMSEncoding, int) {
+	if septets, ok := gsm7Septets(text); ok {
+		return SMSEncodingGSM7, segments(septets, 160, 153)
+	}
+	return SMSEncodingUCS2, segments(len(utf16.Encode([]rune(text))), 70, 67)
+}
+
+// SMSDataSegments returns the number of messages binary data takes.
+func SMSDataSegments(data []byte) int {
+	return segments(len(data), 140, 134)
+}
+
+// segments splits a message longer than single into parts of concatenated, their header takes some room.
+func segments(length int, single int, concatenated int) int {
+	if length <= single {
+		return 1
+	}
+	return (length + concatenated - 1) / concatenated
+}
+
+func gsm7Septets(text string) (int, bool) {
+	var septets int
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			septets++
+		case strings.ContainsRune(gsm7Extension, r):
+			septets += 2
+		default:
+			return 0, false
+		}
+	}
+	return septets, true
+}
+
+// ToGSM7 makes the text fit the GSM-7 alphabet, so it is sent in fewer messages.
+// Characters without an equivalent become question marks.
+func ToGSM7(text string) string {
+	return strings.Map(func(r rune) rune {
+		if _, ok := gsm7Septets(string(r)); ok {
+			return r
+		}
+		return '?'
+	}, gsm7Replacements.Replace(text))
+}
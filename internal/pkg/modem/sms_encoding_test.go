@@ -0,0 +1,89 @@
+package modem
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSMSSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		encoding SMSEncoding
+		segments int
+	}{
+		{"empty", "", SMSEncodingGSM7, 1},
+		{"GSM-7 single", strings.Repeat("a", 160), SMSEncodingGSM7, 1},
+		{"GSM-7 one over", strings.Repeat("a", 161), SMSEncodingGSM7, 2},
+		{"GSM-7 two full", strings.Repeat("a", 306), SMSEncodingGSM7, 2},
+		{"GSM-7 three", strings.Repeat("a", 307), SMSEncodingGSM7, 3},
+		{"extension fits", strings.Repeat("a", 158) + "€", SMSEncodingGSM7, 1},
+		{"extension overflows", strings.Repeat("a", 159) + "€", SMSEncodingGSM7, 2},
+		{"extension only", strings.Repeat("{", 80), SMSEncodingGSM7, 1},
+		{"extension only over", strings.Repeat("{", 81), SMSEncodingGSM7, 2},
+		{"UCS-2 single", strings.Repeat("я", 70), SMSEncodingUCS2, 1},
+		{"UCS-2 one over", strings.Repeat("я", 71), SMSEncodingUCS2, 2},
+		{"UCS-2 two full", strings.Repeat("я", 134), SMSEncodingUCS2, 2},
+		{"UCS-2 three", strings.Repeat("я", 135), SMSEncodingUCS2, 3},
+		{"one character forces UCS-2", strings.Repeat("a", 69) + "’", SMSEncodingUCS2, 1},
+		{"surrogate pairs take two", strings.Repeat("😀", 35), SMSEncodingUCS2, 1},
+		{"surrogate pairs over", strings.Repeat("😀", 36), SMSEncodingUCS2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, segments := SMSSegments(tt.text)
+			if encoding != tt.encoding || segments != tt.segments {
+				t.Errorf("SMSSegments() = %s, %d, want %s, %d", encoding, segments, tt.encoding, tt.segments)
+			}
+		})
+	}
+}
+
+func TestSMSDataSegments(t *testing.T) {
+	for length, want := range map[int]int{0: 1, 140: 1, 141: 2, 268: 2, 269: 3} {
+		if got := SMSDataSegments(make([]byte, length)); got != want {
+			t.Errorf("SMSDataSegments(%d bytes) = %d, want %d", length, got, want)
+		}
+	}
+}
+
+func TestGSM7Septets(t *testing.T) {
+	tests := []struct {
+		text    string
+		septets int
+		ok      bool
+	}{
+		{"Hello", 5, true},
+		{"@£$¥", 4, true},
+		{"[]", 4, true},
+		{"a\fb", 4, true},
+		{"Ελλάδα", 0, false},
+		{"’", 0, false},
+	}
+	for _, tt := range tests {
+		if septets, ok := gsm7Septets(tt.text); septets != tt.septets || ok != tt.ok {
+			t.Errorf("gsm7Septets(%q) = %d, %t, want %d, %t", tt.text, septets, ok, tt.septets, tt.ok)
+		}
+	}
+}
+
+func TestToGSM7(t *testing.T) {
+	tests := map[string]string{
+		"plain":             "plain",
+		"“quoted” – it’s…":  `"quoted" - it's...`,
+		"café à São Paulo":  "café à Sao Paulo",
+		"Ünïcode ê":         "Ünicode e",
+		"price: 5€ {ok}":    "price: 5€ {ok}",
+		"emoji 😀 and 中文":    "emoji ? and ??",
+		"tab\tand nbsp • x": "tab and nbsp - x",
+	}
+	for text, want := range tests {
+		got := ToGSM7(text)
+		if got != want {
+			t.Errorf("ToGSM7(%q) = %q, want %q", text, got, want)
+		}
+		if _, ok := gsm7Septets(got); !ok {
+			t.Errorf("ToGSM7(%q) = %q doesn't fit GSM-7", text, got)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package modem
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const statusSettleTime = 500 * time.Millisecond
+
+// Status is the network side of a modem as reported by ModemManager.
+type Status struct {
+	State              ModemState
+	SignalQuality      uint32
+	RegistrationState  Modem3gppRegistrationState
+	OperatorCode       string
+	OperatorName       string
+	AccessTechnologies []ModemAccessTechnology
+}
+
+// Registered reports whether the modem is registered to a network, SMS only and CSFB registrations included.
+func (s *Status) Registered() bool {
+	return s.RegistrationState.Registered()
+}
+
+// Generation returns the newest generation of the access technologies, e.g. 4 for LTE, 0 if it is unknown.
+func (s *Status) Generation() int {
+	var generation int
+	for _, technology := range s.AccessTechnologies {
+		generation = max(generation, technology.Generation())
+	}
+	return generation
+}
+
+func (m *Modem) Status() (*Status, error) {
+	status := new(Status)
+	variant, err := m.dbusObject.GetProperty(ModemInterface + ".State")
+	if err != nil {
+		return nil, err
+	}
+	status.State = ModemState(variant.Value().(int32))
+	if status.SignalQuality, _, err = m.SignalQuality(); err != nil {
+		return nil, err
+	}
+	if status.AccessTechnologies, err = m.AccessTechnologies(); err != nil {
+		return nil, err
+	}
+	// The 3GPP interface is gone while the modem is disabled or locked.
+	if status.RegistrationState, err = m.RegistrationState(); err != nil {
+		status.RegistrationState = Modem3gppRegistrationStateUnknown
+		return status, nil
+	}
+	status.OperatorCode, _ = m.OperatorCode()
+	status.OperatorName, _ = m.OperatorName()
+	return status, nil
+}
+
+// SubscribeStatus calls subscriber with the previous and the current status every time one of them changes.
+// It is called once at first with the initial status as both, so a status that is already bad can be noticed.
+func (m *Modem) SubscribeStatus(ctx context.Context, subscriber func(previous *Status, current *Status) error) error {
+	dbusConn, err := m.SystemBusPrivate()
+	if err != nil {
+		return err
+	}
+	defer dbusConn.Close()
+	for _, iface := range []string{ModemInterface, Modem3GPPInterface} {
+		if err := dbusConn.AddMatchSignal(
+			dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+			dbus.WithMatchMember("PropertiesChanged"),
+			dbus.WithMatchObjectPath(m.objectPath),
+			dbus.WithMatchArg(0, iface),
+		); err != nil {
+			return err
+		}
+	}
+	signalChan := make(chan *dbus.Signal, 10)
+	dbusConn.Signal(signalChan)
+	defer dbusConn.RemoveSignal(signalChan)
+	current, err := m.Status()
+	if err != nil {
+		return err
+	}
+	if err := subscriber(current, current); err != nil {
+		slog.Error("Failed to process modem status", "error", err, "path", m.objectPath)
+	}
+	// ModemManager reports a network change as a burst of separate property changes,
+	// they are collected for a moment so the subscriber sees the change as a whole.
+	next := *current
+	var flush <-chan time.Time
+	for {
+		select {
+		case sig, ok := <-signalChan:
+			if !ok {
+				return nil
+			}
+			if len(sig.Body) < 2 {
+				continue
+			}
+			changed, ok := sig.Body[1].(map[string]dbus.Variant)
+			if !ok {
+				continue
+			}
+			next.apply(changed)
+			if flush == nil {
+				flush = time.After(statusSettleTime)
+			}
+		case <-flush:
+			flush = nil
+			previous := current
+			current = &Status{}
+			*current = next
+			current.AccessTechnologies = slices.Clone(next.AccessTechnologies)
+			if err := subscriber(previous, current); err != nil {
+				slog.Error("Failed to process modem status", "error", err, "path", m.objectPath)
+			}
+		case <-ctx.Done():
+			slog.Info("Unsubscribing from modem status", "path", m.dbusObject.Path())
+			return nil
+		}
+	}
+}
+
+func (s *Status) apply(changed map[string]dbus.Variant) {
+	for property, variant := range changed {
+		switch value := variant.Value().(type) {
+		case int32:
+			if property == "State" {
+				s.State = ModemState(value)
+			}
+		case uint32:
+			switch property {
+			case "RegistrationState":
+				s.RegistrationState = Modem3gppRegistrationState(value)
+			case "AccessTechnologies":
+				s.AccessTechnologies = ModemAccessTechnology(value).UnmarshalBitmask(value)
+			}
+		case []any:
+			if property == "SignalQuality" && len(value) > 0 {
+				s.SignalQuality, _ = value[0].(uint32)
+			}
+		case string:
+			switch property {
+			case "OperatorCode":
+				s.OperatorCode = value
+			case "OperatorName":
+				s.OperatorName = value
+			}
+		}
+	}
+}
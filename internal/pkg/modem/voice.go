@@ -0,0 +1,196 @@
+package modem
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	ModemVoiceInterface = ModemInterface + ".Voice"
+	ModemCallInterface  = ModemManagerInterface + ".Call"
+)
+
+type Call struct {
+	Path        dbus.ObjectPath
+	Number      string
+	Direction   CallDirection
+	State       CallState
+	StateReason CallStateReason
+}
+
+func (m *Modem) RetrieveCall(path dbus.ObjectPath) (*Call, error) {
+	dbusObject, err := m.privateDbusObject(path)
+	if err != nil {
+		return nil, err
+	}
+	var properties map[string]dbus.Variant
+	if err := dbusObject.Call("org.freedesktop.DBus.Properties.GetAll", 0, ModemCallInterface).Store(&properties); err != nil {
+		return nil, err
+	}
+	call := &Call{Path: path}
+	call.Number, _ = properties["Number"].Value().(string)
+	if direction, ok := properties["Direction"].Value().(int32); ok {
+		call.Direction = CallDirection(direction)
+	}
+	if state, ok := properties["State"].Value().(int32); ok {
+		call.State = CallState(state)
+	}
+	if reason, ok := properties["StateReason"].Value().(int32); ok {
+		call.StateReason = CallStateReason(reason)
+	}
+	return call, nil
+}
+
+// CreateCall prepares an outgoing call, which is only dialed by StartCall.
+func (m *Modem) CreateCall(number string) (dbus.ObjectPath, error) {
+	var path dbus.ObjectPath
+	err := m.dbusObject.Call(ModemVoiceInterface+".CreateCall", 0, map[string]any{"number": number}).Store(&path)
+	return path, err
+}
+
+func (m *Modem) StartCall(path dbus.ObjectPath) error {
+	return m.callCall(path, "Start")
+}
+
+// SendDtmf plays the tones on an active call, e.g. to navigate an IVR menu.
+func (m *Modem) SendDtmf(path dbus.ObjectPath, tones string) error {
+	return m.callCall(path, "SendDtmf", tones)
+}
+
+func (m *Modem) AcceptCall(path dbus.ObjectPath) error {
+	return m.callCall(path, "Accept")
+}
+
+func (m *Modem) HangupCall(path dbus.ObjectPath) error {
+	return m.callCall(path, "Hangup")
+}
+
+// DeleteCall removes a call from the modem, ModemManager keeps terminated calls around until then.
+func (m *Modem) DeleteCall(path dbus.ObjectPath) error {
+	return m.dbusObject.Call(ModemVoiceInterface+".DeleteCall", 0, path).Err
+}
+
+func (m *Modem) callCall(path dbus.ObjectPath, method string, args ...any) error {
+	dbusObject, err := m.privateDbusObject(path)
+	if err != nil {
+		return err
+	}
+	return dbusObject.Call(ModemCallInterface+"."+method, 0, args...).Err
+}
+
+// SubscribeCalls calls subscriber when a call is added to the modem and every time its state changes,
+// until it is terminated.
+func (m *Modem) SubscribeCalls(ctx context.Context, subscriber func(call *Call) error) error {
+	dbusConn, err := m.SystemBusPrivate()
+	if err != nil {
+		return err
+	}
+	defer dbusConn.Close()
+	if err := dbusConn.AddMatchSignal(
+		dbus.WithMatchInterface(ModemVoiceInterface),
+		dbus.WithMatchMember("CallAdded"),
+		dbus.WithMatchObjectPath(m.objectPath),
+	); err != nil {
+		return err
+	}
+	// The calls live outside of the modem's path, the ones that aren't ours are ignored.
+	if err := dbusConn.AddMatchSignal(
+		dbus.WithMatchInterface(ModemCallInterface),
+		dbus.WithMatchMember("StateChanged"),
+		dbus.WithMatchPathNamespace(ModemManagerObjectPath+"/Call"),
+	); err != nil {
+		return err
+	}
+	signalChan := make(chan *dbus.Signal, 10)
+	dbusConn.Signal(signalChan)
+	defer dbusConn.RemoveSignal(signalChan)
+	calls := make(map[dbus.ObjectPath]*Call)
+	for {
+		select {
+		case sig, ok := <-signalChan:
+			if !ok {
+				return nil
+			}
+			var call *Call
+			switch sig.Name {
+			case ModemVoiceInterface + ".CallAdded":
+				path, ok := sig.Body[0].(dbus.ObjectPath)
+				if !ok {
+					continue
+				}
+				if call, err = m.RetrieveCall(path); err != nil {
+					slog.Error("Failed to retrieve call", "error", err, "path", path)
+					continue
+				}
+				calls[path] = call
+			case ModemCallInterface + ".StateChanged":
+				if call, ok = calls[sig.Path]; !ok || len(sig.Body) < 3 {
+					continue
+				}
+				state, _ := sig.Body[1].(int32)
+				reason, _ := sig.Body[2].(uint32)
+				call.State, call.StateReason = CallState(state), CallStateReason(reason)
+			default:
+				continue
+			}
+			if call.State == CallStateTerminated {
+				delete(calls, call.Path)
+			}
+			current := *call
+			if err := subscriber(&current); err != nil {
+				slog.Error("Failed to process call", "error", err, "path", call.Path)
+			}
+		case <-ctx.Done():
+			slog.Info("Unsubscribing from modem calls", "path", m.dbusObject.Path())
+			return nil
+		}
+	}
+}
+
+// WatchCall calls subscriber with the current state of the call and every time it changes, until it is terminated.
+func (m *Modem) WatchCall(ctx context.Context, path dbus.ObjectPath, subscriber func(call *Call) error) error {
+	dbusConn, err := m.SystemBusPrivate()
+	if err != nil {
+		return err
+	}
+	defer dbusConn.Close()
+	if err := dbusConn.AddMatchSignal(
+		dbus.WithMatchInterface(ModemCallInterface),
+		dbus.WithMatchMember("StateChanged"),
+		dbus.WithMatchObjectPath(path),
+	); err != nil {
+		return err
+	}
+	signalChan := make(chan *dbus.Signal, 10)
+	dbusConn.Signal(signalChan)
+	defer dbusConn.RemoveSignal(signalChan)
+	call, err := m.RetrieveCall(path)
+	if err != nil {
+		return err
+	}
+	for {
+		current := *call
+		if err := subscriber(&current); err != nil {
+			slog.Error("Failed to process call", "error", err, "path", path)
+		}
+		if call.State == CallStateTerminated {
+			return nil
+		}
+		select {
+		case sig, ok := <-signalChan:
+			if !ok {
+				return nil
+			}
+			if len(sig.Body) < 3 {
+				continue
+			}
+			state, _ := sig.Body[1].(int32)
+			reason, _ := sig.Body[2].(uint32)
+			call.State, call.StateReason = CallState(state), CallStateReason(reason)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+// Watcher turns the status changes of a modem into notifications for the network events enabled in the config.
+type Watcher struct {
+	// home is the MCC/MNC of the SIM, to tell roaming networks apart.
+	home   string
+	notify func(event config.NetworkEvent, message string)
+	mutex  sync.Mutex
+	// signal is the latest signal quality, weak is set once the weak signal has been notified.
+	signal  uint32
+	weak    bool
+	timer   *time.Timer
+	stopped bool
+}
+
+func NewWatcher(home string, notify func(event config.NetworkEvent, message string)) *Watcher {
+	return &Watcher{home: home, notify: notify}
+}
+
+// Update compares the statuses and notifies of what changed, it is meant to be passed to modem.SubscribeStatus.
+func (w *Watcher) Update(previous *modem.Status, current *modem.Status) error {
+	w.registration(previous, current)
+	w.roaming(previous, current)
+	w.operator(previous, current)
+	w.fallback(previous, current)
+	w.watchSignal(current)
+	return nil
+}
+
+// Stop cancels a pending weak signal notification.
+func (w *Watcher) Stop() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+func (w *Watcher) send(event config.NetworkEvent, message string) {
+	if config.C.NetworkEvent(event) {
+		w.notify(event, message)
+	}
+}
+
+func (w *Watcher) registration(previous *modem.Status, current *modem.Status) {
+	switch {
+	case previous.Registered() && !current.Registered():
+		w.send(config.NetworkEventRegistration, fmt.Sprintf("Lost the registration to %s, it is %s now.",
+			operator(previous), strings.ToLower(current.RegistrationState.String())))
+	case !previous.Registered() && current.Registered():
+		w.send(config.NetworkEventRegistration, fmt.Sprintf("Registered to %s.", operator(current)))
+	}
+}
+
+func (w *Watcher) roaming(previous *modem.Status, current *modem.Status) {
+	was := modem.NewRoaming(w.home, previous.RegistrationState, previous.OperatorCode)
+	is := modem.NewRoaming(w.home, current.RegistrationState, current.OperatorCode)
+	switch {
+	case !was.Active && is.Active:
+		w.send(config.NetworkEventRoaming, fmt.Sprintf("Roaming started on %s%s.", operator(current), country(is)))
+	case was.Active && !is.Active && current.Registered():
+		w.send(config.NetworkEventRoaming, fmt.Sprintf("Roaming ended, back on %s%s.", operator(current), country(is)))
+	}
+}
+
+func (w *Watcher) operator(previous *modem.Status, current *modem.Status) {
+	if previous.OperatorCode != "" && current.OperatorCode != "" && previous.OperatorCode != current.OperatorCode {
+		w.send(config.NetworkEventOperator, fmt.Sprintf("The operator changed from %s to %s.", operator(previous), operator(current)))
+	}
+}
+
+func (w *Watcher) fallback(previous *modem.Status, current *modem.Status) {
+	if previous.Generation() >= 4 && current.Generation() == 2 {
+		w.send(config.NetworkEventFallback, fmt.Sprintf("Fell back from %s to %s.",
+			technologies(previous.AccessTechnologies), technologies(current.AccessTechnologies)))
+	}
+}
+
+// watchSignal notifies once the signal has stayed below the threshold for the configured duration, and once it recovers.
+// Notifications are sent after the mutex is released, sending one can take as long as the Bot API does.
+func (w *Watcher) watchSignal(current *modem.Status) {
+	w.mutex.Lock()
+	w.signal = current.SignalQuality
+	if w.stopped {
+		w.mutex.Unlock()
+		return
+	}
+	if current.SignalQuality < uint32(config.C.SignalThreshold) {
+		if w.timer == nil && !w.weak {
+			w.timer = time.AfterFunc(config.C.SignalDuration, w.weakSignal)
+		}
+		w.mutex.Unlock()
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	recovered := w.weak
+	w.weak = false
+	w.mutex.Unlock()
+	if recovered {
+		w.send(config.NetworkEventSignal, fmt.Sprintf("The signal recovered to %d%%.", current.SignalQuality))
+	}
+}
+
+func (w *Watcher) weakSignal() {
+	w.mutex.Lock()
+	if w.timer == nil || w.stopped {
+		w.mutex.Unlock()
+		return
+	}
+	w.timer = nil
+	w.weak = true
+	signal := w.signal
+	w.mutex.Unlock()
+	w.send(config.NetworkEventSignal, fmt.Sprintf("The signal has been below %d%% for %s, it is %d%% now.",
+		config.C.SignalThreshold, config.C.SignalDuration, signal))
+}
+
+func operator(status *modem.Status) string {
+	name := util.If(status.OperatorName != "", status.OperatorName, util.LookupCarrier(status.OperatorCode))
+	if status.OperatorCode == "" {
+		return util.If(name != "", name, "unknown")
+	}
+	return fmt.Sprintf("%s (%s)", name, status.OperatorCode)
+}
+
+func country(roaming *modem.Roaming) string {
+	if country := roaming.Country(); country != "" {
+		return fmt.Sprintf(" in %s %s", util.CountryFlag(country), country)
+	}
+	return ""
+}
+
+func technologies(accessTechnologies []modem.ModemAccessTechnology) string {
+	if len(accessTechnologies) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(accessTechnologies))
+	for _, technology := range accessTechnologies {
+		names = append(names, technology.String())
+	}
+	return strings.Join(names, "/")
+}
@@ -0,0 +1,252 @@
+package network
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+)
+
+type notification struct {
+	event   config.NetworkEvent
+	message string
+}
+
+// recorder collects the notifications of a watcher.
+type recorder struct {
+	mutex         sync.Mutex
+	notifications []notification
+}
+
+func (r *recorder) notify(event config.NetworkEvent, message string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.notifications = append(r.notifications, notification{event, message})
+}
+
+func (r *recorder) take() []notification {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	notifications := r.notifications
+	r.notifications = nil
+	return notifications
+}
+
+// waitFor waits until n notifications were sent and takes them.
+func (r *recorder) waitFor(t *testing.T, n int) []notification {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mutex.Lock()
+		count := len(r.notifications)
+		r.mutex.Unlock()
+		if count >= n {
+			return r.take()
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d notifications were sent, want %d", len(r.take()), n)
+	return nil
+}
+
+func useConfig(t *testing.T, events string, threshold uint, duration time.Duration) {
+	t.Helper()
+	previous := *config.C
+	t.Cleanup(func() { *config.C = previous })
+	config.C.NetworkEvents, config.C.SignalThreshold, config.C.SignalDuration = events, threshold, duration
+}
+
+func TestWatcherTransitions(t *testing.T) {
+	useConfig(t, "registration,roaming,operator,fallback", 0, time.Hour)
+	home := &modem.Status{
+		RegistrationState:  modem.Modem3gppRegistrationStateHome,
+		OperatorCode:       "22210",
+		OperatorName:       "Vodafone",
+		AccessTechnologies: []modem.ModemAccessTechnology{modem.ModemAccessTechnologyLte},
+	}
+	with := func(change func(s *modem.Status)) *modem.Status {
+		status := *home
+		change(&status)
+		return &status
+	}
+	searching := with(func(s *modem.Status) {
+		s.RegistrationState, s.OperatorCode, s.OperatorName = modem.Modem3gppRegistrationStateSearching, "", ""
+	})
+	abroad := with(func(s *modem.Status) {
+		s.RegistrationState, s.OperatorCode, s.OperatorName = modem.Modem3gppRegistrationStateRoaming, "20801", "Orange"
+	})
+	tests := []struct {
+		name     string
+		previous *modem.Status
+		current  *modem.Status
+		want     []notification
+	}{
+		{name: "unchanged", previous: home, current: home},
+		{
+			name:     "registration lost",
+			previous: home,
+			current:  searching,
+			want:     []notification{{config.NetworkEventRegistration, "Lost the registration to Vodafone (22210), it is searching now."}},
+		},
+		{
+			name:     "registered",
+			previous: searching,
+			current:  home,
+			want:     []notification{{config.NetworkEventRegistration, "Registered to Vodafone (22210)."}},
+		},
+		{
+			name:     "roaming started",
+			previous: home,
+			current:  abroad,
+			want: []notification{
+				{config.NetworkEventRoaming, "Roaming started on Orange (20801)"},
+				{config.NetworkEventOperator, "The operator changed from Vodafone (22210) to Orange (20801)."},
+			},
+		},
+		{
+			name:     "roaming ended",
+			previous: abroad,
+			current:  home,
+			want: []notification{
+				{config.NetworkEventRoaming, "Roaming ended, back on Vodafone (22210)"},
+				{config.NetworkEventOperator, "The operator changed from Orange (20801) to Vodafone (22210)."},
+			},
+		},
+		{
+			// Losing the network abroad isn't the end of roaming.
+			name:     "registration lost abroad",
+			previous: abroad,
+			current:  searching,
+			want:     []notification{{config.NetworkEventRegistration, "Lost the registration to Orange (20801)"}},
+		},
+		{
+			name:     "fallback to 2G",
+			previous: home,
+			current: with(func(s *modem.Status) {
+				s.AccessTechnologies = []modem.ModemAccessTechnology{modem.ModemAccessTechnologyGsm}
+			}),
+			want: []notification{{config.NetworkEventFallback, "Fell back from"}},
+		},
+		{
+			name:     "3G isn't a fallback",
+			previous: home,
+			current: with(func(s *modem.Status) {
+				s.AccessTechnologies = []modem.ModemAccessTechnology{modem.ModemAccessTechnologyUmts}
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(recorder)
+			w := NewWatcher("22210", r.notify)
+			defer w.Stop()
+			if err := w.Update(tt.previous, tt.current); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			got := r.take()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Update() notified %q, want %q", got, tt.want)
+			}
+			for idx, want := range tt.want {
+				if got[idx].event != want.event || !strings.Contains(got[idx].message, want.message) {
+					t.Errorf("notification %d = %q, want %q", idx, got[idx], want)
+				}
+			}
+		})
+	}
+
+	t.Run("disabled events", func(t *testing.T) {
+		useConfig(t, "signal", 0, time.Hour)
+		r := new(recorder)
+		w := NewWatcher("22210", r.notify)
+		defer w.Stop()
+		w.Update(home, abroad)
+		w.Update(abroad, searching)
+		if got := r.take(); len(got) != 0 {
+			t.Errorf("Update() notified %q of disabled events", got)
+		}
+	})
+}
+
+func TestWatcherSignal(t *testing.T) {
+	useConfig(t, "signal", 20, 50*time.Millisecond)
+	status := func(signal uint32) *modem.Status {
+		return &modem.Status{RegistrationState: modem.Modem3gppRegistrationStateHome, OperatorCode: "22210", SignalQuality: signal}
+	}
+
+	t.Run("weak then recovered", func(t *testing.T) {
+		r := new(recorder)
+		w := NewWatcher("22210", r.notify)
+		defer w.Stop()
+		w.Update(status(60), status(15))
+		w.Update(status(15), status(10))
+		got := r.waitFor(t, 1)
+		if len(got) != 1 || got[0].event != config.NetworkEventSignal || !strings.Contains(got[0].message, "below 20% for 50ms, it is 10% now") {
+			t.Fatalf("notified %q, want the weak signal once", got)
+		}
+		// The weak signal is notified once however long it lasts.
+		w.Update(status(10), status(5))
+		time.Sleep(100 * time.Millisecond)
+		if got := r.take(); len(got) != 0 {
+			t.Fatalf("notified %q again", got)
+		}
+		w.Update(status(5), status(60))
+		if got := r.take(); len(got) != 1 || !strings.Contains(got[0].message, "recovered to 60%") {
+			t.Fatalf("notified %q, want the recovery", got)
+		}
+	})
+
+	t.Run("short dip", func(t *testing.T) {
+		r := new(recorder)
+		w := NewWatcher("22210", r.notify)
+		defer w.Stop()
+		w.Update(status(60), status(15))
+		w.Update(status(15), status(40))
+		time.Sleep(100 * time.Millisecond)
+		if got := r.take(); len(got) != 0 {
+			t.Fatalf("notified %q of a dip shorter than the duration", got)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		r := new(recorder)
+		w := NewWatcher("22210", r.notify)
+		w.Update(status(60), status(15))
+		w.Stop()
+		time.Sleep(100 * time.Millisecond)
+		w.Update(status(15), status(60))
+		if got := r.take(); len(got) != 0 {
+			t.Fatalf("notified %q after Stop()", got)
+		}
+	})
+
+	// A slow notification mustn't hold the watcher, the next status change goes through meanwhile.
+	t.Run("notify outside the lock", func(t *testing.T) {
+		r := new(recorder)
+		var w *Watcher
+		updated := make(chan struct{})
+		w = NewWatcher("22210", func(event config.NetworkEvent, message string) {
+			r.notify(event, message)
+			if strings.Contains(message, "below") {
+				w.Update(status(15), status(12))
+				close(updated)
+			}
+		})
+		w.Update(status(60), status(15))
+		select {
+		case <-updated:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Update() blocked while the weak signal was notified")
+		}
+		w.mutex.Lock()
+		signal := w.signal
+		w.mutex.Unlock()
+		if signal != 12 {
+			t.Errorf("signal = %d, want 12", signal)
+		}
+		w.Stop()
+	})
+}
@@ -0,0 +1,77 @@
+package simulator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// bus is a private dbus-daemon standing in for the system bus.
+type bus struct {
+	dir     string
+	cmd     *exec.Cmd
+	Address string
+}
+
+func startBus() (*bus, error) {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		return nil, errors.New("dbus-daemon is required to run the simulator")
+	}
+	b := new(bus)
+	if b.dir, err = os.MkdirTemp("", "telegram-sms-simulator-"); err != nil {
+		return nil, err
+	}
+	config := filepath.Join(b.dir, "bus.conf")
+	if err := os.WriteFile(config, fmt.Appendf(nil, busConfig, filepath.Join(b.dir, "bus.sock")), 0600); err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.cmd = exec.Command(path, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := b.cmd.StdoutPipe()
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	if err := b.cmd.Start(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	// dbus-daemon prints the address once it is ready to accept connections.
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("failed to start dbus-daemon: %w", err)
+	}
+	b.Address = strings.TrimSpace(address)
+	return b, nil
+}
+
+func (b *bus) Close() error {
+	if b.cmd != nil && b.cmd.Process != nil {
+		if err := b.cmd.Process.Kill(); err != nil {
+			return err
+		}
+		// The daemon exits with "signal: killed", which is expected here.
+		b.cmd.Wait()
+	}
+	return os.RemoveAll(b.dir)
+}
@@ -0,0 +1,254 @@
+package simulator
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+)
+
+// loadIntrospection reads the interfaces ModemManager describes in its introspection XML,
+// the fake is checked against them rather than against what the bot expects.
+func loadIntrospection(t *testing.T) map[string]introspect.Interface {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "introspection", "*.xml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no introspection XML found: %v", err)
+	}
+	interfaces := make(map[string]introspect.Interface)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var node introspect.Node
+		if err := xml.Unmarshal(data, &node); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		for _, iface := range node.Interfaces {
+			interfaces[iface.Name] = iface
+		}
+	}
+	return interfaces
+}
+
+// argsSignature joins the types of the arguments going in direction, methods take "in" ones by default.
+func argsSignature(args []introspect.Arg, direction string) string {
+	var signature strings.Builder
+	for _, arg := range args {
+		if arg.Direction == direction || (arg.Direction == "" && direction == "in") {
+			signature.WriteString(arg.Type)
+		}
+	}
+	return signature.String()
+}
+
+// methodSignature is the D-Bus signature a method table entry takes and returns, the trailing *dbus.Error aside.
+func methodSignature(t *testing.T, fn any) (in string, out string) {
+	t.Helper()
+	typ := reflect.TypeOf(fn)
+	for i := range typ.NumIn() {
+		in += dbus.SignatureOfType(typ.In(i)).String()
+	}
+	if typ.NumOut() == 0 || typ.Out(typ.NumOut()-1) != reflect.TypeOf((*dbus.Error)(nil)) {
+		t.Fatalf("%s doesn't return a *dbus.Error last", typ)
+	}
+	for i := range typ.NumOut() - 1 {
+		out += dbus.SignatureOfType(typ.Out(i)).String()
+	}
+	return in, out
+}
+
+func TestIntrospection(t *testing.T) {
+	interfaces := loadIntrospection(t)
+	sim, err := New(&Scenario{Modems: []*ModemSpec{{
+		ID:                 "alpha",
+		IMEI:               "861234567890123",
+		OperatorCode:       "22210",
+		OperatorName:       "Vodafone",
+		AccessTechnologies: []string{"lte"},
+		Signal:             70,
+		SIM:                SIMSpec{ICCID: "8939100000000000077", IMSI: "222100000000077", OperatorIdentifier: "22210"},
+	}}})
+	if err != nil {
+		t.Skipf("the simulator can't be started: %v", err)
+	}
+	defer sim.Close()
+	m := sim.modems["alpha"]
+
+	conn, err := dbus.Connect(sim.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.AddMatchSignal(dbus.WithMatchSender(modem.ModemManagerInterface)); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 64)
+	conn.Signal(signals)
+
+	// Every signal the fake knows is emitted, then every kind of object is created again to check its properties.
+	if err := sim.unplug(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.plug(m); err != nil {
+		t.Fatal(err)
+	}
+	populate := func() {
+		t.Helper()
+		if err := m.receive("+393331234567", "Hello", nil, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ring("+393331234567", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.broadcast(4370, "Test alert"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	populate()
+	if derr := m.hangupAll(); derr != nil {
+		t.Fatal(derr)
+	}
+	m.mutex.Lock()
+	calls, cbms := m.callPaths(), m.cbmPaths()
+	m.mutex.Unlock()
+	for _, path := range calls {
+		if derr := m.deleteCall(path); derr != nil {
+			t.Fatal(derr)
+		}
+	}
+	for _, path := range cbms {
+		if derr := m.deleteCellBroadcast(path); derr != nil {
+			t.Fatal(derr)
+		}
+	}
+	populate()
+
+	t.Run("methods", func(t *testing.T) {
+		tables := m.methods()
+		tables[modem.ModemSimInterface] = m.simMethods()
+		tables[modem.ModemSMSInterface] = m.smsMethods(nil, sms{})
+		tables[modem.ModemCallInterface] = m.callMethods(nil)
+		tables[modem.ModemManagerInterface] = sim.managerMethods()
+		tables[objectManagerInterface] = map[string]any{"GetManagedObjects": sim.managedObjects}
+		for name, table := range tables {
+			iface, ok := interfaces[name]
+			if !ok {
+				t.Errorf("ModemManager has no interface %s", name)
+				continue
+			}
+			for method, fn := range table {
+				idx := slices.IndexFunc(iface.Methods, func(m introspect.Method) bool { return m.Name == method })
+				if idx < 0 {
+					t.Errorf("%s has no method %s", name, method)
+					continue
+				}
+				in, out := methodSignature(t, fn)
+				if want := argsSignature(iface.Methods[idx].Args, "in"); in != want {
+					t.Errorf("%s.%s takes %q, ModemManager takes %q", name, method, in, want)
+				}
+				if want := argsSignature(iface.Methods[idx].Args, "out"); out != want {
+					t.Errorf("%s.%s returns %q, ModemManager returns %q", name, method, out, want)
+				}
+			}
+		}
+	})
+
+	// The values are those godbus puts on the wire, a client decodes structs into []any and loses their signature.
+	t.Run("properties", func(t *testing.T) {
+		type object struct {
+			iface      string
+			properties map[string]dbus.Variant
+		}
+		m.mutex.Lock()
+		var objects []object
+		for iface, properties := range m.interfaces() {
+			objects = append(objects, object{iface, properties})
+		}
+		all := func(props *prop.Properties, iface string) {
+			properties, _ := props.GetAll(iface)
+			objects = append(objects, object{iface, properties})
+		}
+		all(m.simProps, modem.ModemSimInterface)
+		for _, props := range m.messages {
+			all(props, modem.ModemSMSInterface)
+		}
+		for _, call := range m.calls {
+			all(call.props, modem.ModemCallInterface)
+		}
+		for _, props := range m.cbms {
+			all(props, modem.ModemCbmInterface)
+		}
+		complete := len(m.messages) > 0 && len(m.calls) > 0 && len(m.cbms) > 0
+		m.mutex.Unlock()
+		if !complete {
+			t.Fatal("want a message, a call and a cell broadcast to check")
+		}
+		for _, o := range objects {
+			iface, ok := interfaces[o.iface]
+			if !ok {
+				t.Errorf("ModemManager has no interface %s", o.iface)
+				continue
+			}
+			for property, value := range o.properties {
+				idx := slices.IndexFunc(iface.Properties, func(p introspect.Property) bool { return p.Name == property })
+				if idx < 0 {
+					t.Errorf("%s has no property %s", o.iface, property)
+					continue
+				}
+				if got, want := value.Signature().String(), iface.Properties[idx].Type; got != want {
+					t.Errorf("%s.%s is %q, ModemManager has %q", o.iface, property, got, want)
+				}
+			}
+		}
+	})
+
+	t.Run("signals", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for {
+			var sig *dbus.Signal
+			select {
+			case sig = <-signals:
+			case <-time.After(500 * time.Millisecond):
+			}
+			if sig == nil {
+				break
+			}
+			name, member := sig.Name[:strings.LastIndex(sig.Name, ".")], sig.Name[strings.LastIndex(sig.Name, ".")+1:]
+			if name == "org.freedesktop.DBus" || name == "org.freedesktop.DBus.Properties" {
+				continue
+			}
+			seen[sig.Name] = true
+			iface, ok := interfaces[name]
+			if !ok {
+				t.Errorf("ModemManager has no interface %s", name)
+				continue
+			}
+			idx := slices.IndexFunc(iface.Signals, func(s introspect.Signal) bool { return s.Name == member })
+			if idx < 0 {
+				t.Errorf("%s has no signal %s", name, member)
+				continue
+			}
+			if got, want := dbus.SignatureOf(sig.Body...).String(), argsSignature(iface.Signals[idx].Args, ""); got != want {
+				t.Errorf("%s carries %q, ModemManager sends %q", sig.Name, got, want)
+			}
+		}
+		for _, name := range []string{messagingAdded, callAdded, callDeleted, callChanged, cbmAdded, cbmDeleted,
+			modem.ModemManagerInterfacesAdded, modem.ModemManagerInterfacesRemoved} {
+			if !seen[name] {
+				t.Errorf("signal %s wasn't emitted", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,1555 @@
+package simulator
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	sgp22 "github.com/damonto/euicc-go/v2"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+
+	"github.com/damonto/telegram-sms/internal/pkg/fake/euicc"
+	"github.com/damonto/telegram-sms/internal/pkg/fake/serial"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+const (
+	errorFailed      = modem.ModemManagerInterface + ".Error.Core.Failed"
+	errorWrongState  = modem.ModemManagerInterface + ".Error.Core.WrongState"
+	errorNotFound    = modem.ModemManagerInterface + ".Error.Core.NotFound"
+	errorInvalidArgs = modem.ModemManagerInterface + ".Error.Core.InvalidArgs"
+
+	errorIncorrectPassword = modem.ModemManagerInterface + ".Error.MobileEquipment.IncorrectPassword"
+	errorSimPuk            = modem.ModemManagerInterface + ".Error.MobileEquipment.SimPuk"
+	errorSimFailure        = modem.ModemManagerInterface + ".Error.MobileEquipment.SimFailure"
+
+	// The attempts a SIM allows before blocking the PIN and for good.
+	pinRetries = 3
+	pukRetries = 10
+
+	messagingAdded = modem.ModemMessagingInterface + ".Added"
+	callAdded      = modem.ModemVoiceInterface + ".CallAdded"
+	callDeleted    = modem.ModemVoiceInterface + ".CallDeleted"
+	callChanged    = modem.ModemCallInterface + ".StateChanged"
+	cbmAdded       = modem.ModemCellBroadcastInterface + ".Added"
+	cbmDeleted     = modem.ModemCellBroadcastInterface + ".Deleted"
+
+	// callerHangupDelay is how long a caller stays on the line once the call has been answered.
+	callerHangupDelay = 10 * time.Second
+	// How long an outgoing call takes to reach the callee, to be answered, and the callee stays on the line.
+	dialingDelay      = time.Second
+	answerDelay       = 3 * time.Second
+	calleeHangupDelay = 2 * time.Minute
+
+	// The MSISDN record length advertised for EF_MSISDN, 14 bytes for the number and 14 for the name.
+	msisdnRecordLength = 28
+
+	// The SIM phonebook has phonebookRecords records, with names of up to phonebookNameLength bytes.
+	phonebookRecords      = 100
+	phonebookNameLength   = 16
+	phonebookNumberLength = 20
+	// adnRecordLength is the length of an EF_ADN record, the name followed by 14 bytes for the number.
+	adnRecordLength = phonebookNameLength + 14
+
+	// +CME ERROR: 21, invalid index, 22, not found and 25, invalid characters in text string.
+	cmeInvalidIndex      = 21
+	cmeNotFound          = 22
+	cmeInvalidCharacters = 25
+)
+
+type signalQuality struct {
+	Quality uint32
+	Recent  bool
+}
+
+type channels struct {
+	Start uint32
+	End   uint32
+}
+
+type validity struct {
+	Type  uint32
+	Value dbus.Variant
+}
+
+// sms are the properties a message is created with.
+type sms struct {
+	state  modem.SMSState
+	number string
+	text   string
+	data   []byte
+	class  modem.SMSClass
+	smsc   string
+	// validity is relative, in minutes.
+	validity uint32
+}
+
+type modes struct {
+	Allowed   uint32
+	Preferred uint32
+}
+
+// The radio capabilities every virtual modem reports, an LTE Cat 4 module with 3G and 2G fallback.
+var (
+	supportedModes = []modes{
+		{uint32(modem.ModemMode4G), uint32(modem.ModemModeNone)},
+		{uint32(modem.ModemMode3G), uint32(modem.ModemModeNone)},
+		{uint32(modem.ModemMode3G | modem.ModemMode4G), uint32(modem.ModemMode4G)},
+		{uint32(modem.ModemMode2G | modem.ModemMode3G | modem.ModemMode4G), uint32(modem.ModemMode4G)},
+	}
+	supportedBands = []uint32{1, 2, 5, 10, 31, 33, 37, 38, 50, 58}
+	// Like most modems, a virtual modem listens to the public warning channels out of the box.
+	defaultChannels = []channels{{4352, 4359}, {4370, 4399}}
+)
+
+type port struct {
+	Name string
+	Type uint32
+}
+
+// virtualModem is a single modem of the fleet together with its SIM, eUICC, AT port and messages.
+type virtualModem struct {
+	s        *Simulator
+	spec     *ModemSpec
+	mutex    sync.Mutex
+	path     dbus.ObjectPath
+	plugged  bool
+	props    *prop.Properties
+	simPath  dbus.ObjectPath
+	simProps *prop.Properties
+	card     *euicc.Card
+	port     *serial.Modem
+	msisdn   []byte
+	// phonebook holds the records of the SIM phonebook by index, nil ones are free.
+	phonebook []*modem.PhonebookEntry
+	// charset is the character set of the AT port, AT+CSCS.
+	charset  string
+	menu     *USSDMenu
+	messages map[dbus.ObjectPath]*prop.Properties
+	calls    map[dbus.ObjectPath]*virtualCall
+	cbms     map[dbus.ObjectPath]*prop.Properties
+	// lock is the code the SIM waits for, it is reset on every plug like a real SIM losing power.
+	lock    modem.ModemLock
+	retries map[modem.ModemLock]uint32
+}
+
+func newVirtualModem(s *Simulator, spec *ModemSpec) (*virtualModem, error) {
+	m := &virtualModem{
+		s:        s,
+		spec:     spec,
+		messages: make(map[dbus.ObjectPath]*prop.Properties),
+		calls:    make(map[dbus.ObjectPath]*virtualCall),
+		cbms:     make(map[dbus.ObjectPath]*prop.Properties),
+		retries:  map[modem.ModemLock]uint32{modem.ModemLockSimPin: pinRetries, modem.ModemLockSimPuk: pukRetries},
+		charset:  "IRA",
+	}
+	spec.SIM.PIN = util.If(spec.SIM.PIN != "", spec.SIM.PIN, "1234")
+	spec.SIM.PUK = util.If(spec.SIM.PUK != "", spec.SIM.PUK, "12345678")
+	var err error
+	if m.msisdn, err = encodeMSISDN("", spec.Number); err != nil {
+		return nil, fmt.Errorf("modem %s: %w", spec.ID, err)
+	}
+	m.phonebook = make([]*modem.PhonebookEntry, phonebookRecords)
+	for index, entry := range spec.SIM.Phonebook {
+		m.phonebook[index] = &modem.PhonebookEntry{Index: index + 1, Name: entry.Name, Number: entry.Number}
+	}
+	if spec.EUICC != nil {
+		if m.card, err = newCard(spec.EUICC, m.switchProfile); err != nil {
+			return nil, fmt.Errorf("modem %s: %w", spec.ID, err)
+		}
+	}
+	if m.port, err = serial.NewModem(); err != nil {
+		return nil, fmt.Errorf("modem %s: %w", spec.ID, err)
+	}
+	m.port.Handle("", m.handleAT)
+	return m, nil
+}
+
+// portName is the AT port name as ModemManager reports it, without the /dev/ prefix.
+func (m *virtualModem) portName() string {
+	return strings.TrimPrefix(m.port.Device, "/dev/")
+}
+
+func (m *virtualModem) device() string {
+	return "/sys/devices/simulator/" + m.spec.ID
+}
+
+// export publishes the modem and its SIM on the bus under a fresh object path, like ModemManager does on every plug.
+func (m *virtualModem) export(state modem.ModemState) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	conn := m.s.conn
+	m.path = m.s.nextPath("Modem")
+	m.simPath = m.s.nextPath("SIM")
+
+	registration, _ := registrationState(m.spec.Registration)
+	technologies, _ := accessTechnologies(m.spec.AccessTechnologies)
+	m.lock = modem.ModemLockNone
+	switch {
+	case m.retries[modem.ModemLockSimPin] == 0:
+		m.lock = modem.ModemLockSimPuk
+	case m.spec.SIM.PINLock:
+		m.lock = modem.ModemLockSimPin
+	}
+	if m.lock != modem.ModemLockNone {
+		state = modem.ModemStateLocked
+	}
+	var err error
+	if m.simProps, err = prop.Export(conn, m.simPath, prop.Map{
+		modem.ModemSimInterface: {
+			"Active":             readonly(true),
+			"SimIdentifier":      readonly(m.spec.SIM.ICCID),
+			"Eid":                readonly(m.eid()),
+			"Imsi":               readonly(m.spec.SIM.IMSI),
+			"OperatorIdentifier": readonly(m.spec.SIM.OperatorIdentifier),
+			"OperatorName":       readonly(m.spec.SIM.OperatorName),
+		},
+	}); err != nil {
+		return err
+	}
+	if m.props, err = prop.Export(conn, m.path, prop.Map{
+		modem.ModemInterface: {
+			"Device":              readonly(m.device()),
+			"Manufacturer":        readonly(m.spec.Manufacturer),
+			"EquipmentIdentifier": readonly(m.spec.IMEI),
+			"Drivers":             readonly([]string{"option"}),
+			"Model":               readonly(m.spec.Model),
+			"Revision":            readonly(m.spec.Revision),
+			"State":               readonly(int32(state)),
+			"PrimaryPort":         readonly(m.portName()),
+			"Ports":               readonly([]port{{Name: m.portName(), Type: uint32(modem.ModemPortTypeAt)}}),
+			"Sim":                 readonly(m.simPath),
+			"SimSlots":            readonly([]dbus.ObjectPath{m.simPath}),
+			"PrimarySimSlot":      readonly(uint32(1)),
+			"OwnNumbers":          readonly(m.ownNumbers()),
+			"AccessTechnologies":  readonly(technologies),
+			"SignalQuality":       readonly(signalQuality{Quality: m.spec.Signal, Recent: true}),
+			"SupportedModes":      readonly(supportedModes),
+			"CurrentModes":        readonly(supportedModes[len(supportedModes)-1]),
+			"SupportedBands":      readonly(supportedBands),
+			"CurrentBands":        readonly(supportedBands),
+			"UnlockRequired":      readonly(uint32(m.lock)),
+			"UnlockRetries":       readonly(m.unlockRetries()),
+		},
+		modem.Modem3GPPInterface: {
+			"Imei":              readonly(m.spec.IMEI),
+			"RegistrationState": readonly(uint32(registration)),
+			"OperatorCode":      readonly(m.spec.OperatorCode),
+			"OperatorName":      readonly(m.spec.OperatorName),
+		},
+		modem.Modem3GPPInterface + ".Ussd": {
+			"State":               readonly(uint32(modem.Modem3gppUssdSessionStateIdle)),
+			"NetworkNotification": readonly(""),
+			"NetworkRequest":      readonly(""),
+		},
+		modem.ModemMessagingInterface: {
+			"Messages": readonly([]dbus.ObjectPath{}),
+		},
+		modem.ModemVoiceInterface: {
+			"Calls": readonly([]dbus.ObjectPath{}),
+		},
+		modem.ModemCellBroadcastInterface: {
+			"CellBroadcasts": readonly([]dbus.ObjectPath{}),
+			"Channels":       readonly(defaultChannels),
+		},
+		modem.ModemSignalInterface: {
+			"Rate": readonly(uint32(0)),
+			"Nr5g": readonly(map[string]dbus.Variant{}),
+			"Lte":  readonly(map[string]dbus.Variant{}),
+			"Umts": readonly(map[string]dbus.Variant{}),
+			"Gsm":  readonly(map[string]dbus.Variant{}),
+		},
+	}); err != nil {
+		return err
+	}
+	for iface, methods := range m.methods() {
+		if err := conn.ExportMethodTable(methods, m.path, iface); err != nil {
+			return err
+		}
+	}
+	if err := conn.ExportMethodTable(m.simMethods(), m.simPath, modem.ModemSimInterface); err != nil {
+		return err
+	}
+	m.plugged = true
+	return nil
+}
+
+// methods are the method tables of the modem object per interface.
+func (m *virtualModem) methods() map[string]map[string]any {
+	return map[string]map[string]any{
+		modem.ModemInterface: {
+			"Enable":            m.enable,
+			"SetPrimarySimSlot": m.setPrimarySimSlot,
+			"SetCurrentModes":   m.setCurrentModes,
+			"SetCurrentBands":   m.setCurrentBands,
+		},
+		modem.ModemInterface + ".Simple": {
+			"GetStatus": m.status,
+		},
+		modem.ModemSignalInterface: {
+			"Setup": m.setupSignal,
+		},
+		modem.Modem3GPPInterface: {
+			"Scan":     m.scan,
+			"Register": m.register,
+		},
+		modem.Modem3GPPInterface + ".Ussd": {
+			"Initiate": m.initiateUSSD,
+			"Respond":  m.respondUSSD,
+			"Cancel":   m.cancelUSSD,
+		},
+		modem.ModemMessagingInterface: {
+			"List":   m.listMessages,
+			"Create": m.createMessage,
+			"Delete": m.deleteMessage,
+		},
+		modem.ModemVoiceInterface: {
+			"ListCalls":  m.listCalls,
+			"CreateCall": m.createCall,
+			"DeleteCall": m.deleteCall,
+			"HangupAll":  m.hangupAll,
+		},
+		modem.ModemCellBroadcastInterface: {
+			"List":        m.listCellBroadcasts,
+			"Delete":      m.deleteCellBroadcast,
+			"SetChannels": m.setChannels,
+		},
+	}
+}
+
+// simMethods is the method table of the SIM object.
+func (m *virtualModem) simMethods() map[string]any {
+	return map[string]any{
+		"SendPin":   m.sendPin,
+		"SendPuk":   m.sendPuk,
+		"EnablePin": m.enablePin,
+		"ChangePin": m.changePin,
+	}
+}
+
+// unexport removes the modem, its SIM and its messages from the bus.
+func (m *virtualModem) unexport() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	conn := m.s.conn
+	for _, iface := range []string{
+		modem.ModemInterface,
+		modem.ModemInterface + ".Simple",
+		modem.ModemSignalInterface,
+		modem.Modem3GPPInterface,
+		modem.Modem3GPPInterface + ".Ussd",
+		modem.ModemMessagingInterface,
+		modem.ModemVoiceInterface,
+		modem.ModemCellBroadcastInterface,
+	} {
+		conn.ExportMethodTable(nil, m.path, iface)
+	}
+	conn.ExportMethodTable(nil, m.simPath, modem.ModemSimInterface)
+	conn.Export(nil, m.path, "org.freedesktop.DBus.Properties")
+	conn.Export(nil, m.simPath, "org.freedesktop.DBus.Properties")
+	for path := range m.messages {
+		conn.ExportMethodTable(nil, path, modem.ModemSMSInterface)
+		conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	}
+	clear(m.messages)
+	for path, call := range m.calls {
+		call.stop()
+		conn.ExportMethodTable(nil, path, modem.ModemCallInterface)
+		conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	}
+	clear(m.calls)
+	for path := range m.cbms {
+		conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	}
+	clear(m.cbms)
+	m.menu = nil
+	m.plugged = false
+}
+
+// interfaces returns the properties of every interface of the modem, as listed by GetManagedObjects.
+func (m *virtualModem) interfaces() map[string]map[string]dbus.Variant {
+	interfaces := make(map[string]map[string]dbus.Variant, 6)
+	for _, iface := range []string{
+		modem.ModemInterface,
+		modem.ModemSignalInterface,
+		modem.Modem3GPPInterface,
+		modem.Modem3GPPInterface + ".Ussd",
+		modem.ModemMessagingInterface,
+		modem.ModemVoiceInterface,
+		modem.ModemCellBroadcastInterface,
+	} {
+		interfaces[iface], _ = m.props.GetAll(iface)
+	}
+	return interfaces
+}
+
+func (m *virtualModem) eid() string {
+	if m.card == nil {
+		return ""
+	}
+	return strings.ToUpper(hex.EncodeToString(m.card.EID))
+}
+
+func (m *virtualModem) ownNumbers() []string {
+	if number := decodeMSISDN(m.msisdn); number != "" {
+		return []string{number}
+	}
+	return []string{}
+}
+
+// switchProfile is called by the eUICC once a profile has been enabled, the SIM then reports the new ICCID.
+func (m *virtualModem) switchProfile(id sgp22.ICCID) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	iccid := id.String()
+	m.spec.SIM.ICCID = iccid
+	if m.plugged {
+		m.simProps.SetMust(modem.ModemSimInterface, "SimIdentifier", iccid)
+	}
+	slog.Info("[Simulator] Profile enabled", "modem", m.spec.ID, "iccid", iccid)
+}
+
+// update applies a scripted change to the modem and announces the properties that actually changed.
+func (m *virtualModem) update(change func(spec *ModemSpec)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	change(m.spec)
+	if !m.plugged {
+		return
+	}
+	registration, _ := registrationState(m.spec.Registration)
+	technologies, _ := accessTechnologies(m.spec.AccessTechnologies)
+	state := modem.ModemState(m.props.GetMust(modem.ModemInterface, "State").(int32))
+	// A disabled modem stays disabled, an enabled one follows the registration.
+	if state >= modem.ModemStateEnabled {
+		state = util.If(registration.Registered(), modem.ModemStateRegistered, modem.ModemStateSearching)
+	}
+	for _, p := range []struct {
+		iface string
+		name  string
+		value any
+	}{
+		{modem.ModemInterface, "State", int32(state)},
+		{modem.ModemInterface, "SignalQuality", signalQuality{Quality: m.spec.Signal, Recent: true}},
+		{modem.ModemInterface, "AccessTechnologies", technologies},
+		{modem.Modem3GPPInterface, "RegistrationState", uint32(registration)},
+		{modem.Modem3GPPInterface, "OperatorCode", m.spec.OperatorCode},
+		{modem.Modem3GPPInterface, "OperatorName", m.spec.OperatorName},
+	} {
+		if !reflect.DeepEqual(m.props.GetMust(p.iface, p.name), p.value) {
+			m.props.SetMust(p.iface, p.name, p.value)
+		}
+	}
+	m.updateSignal()
+}
+
+func newCard(spec *EUICCSpec, onEnable func(iccid sgp22.ICCID)) (*euicc.Card, error) {
+	card := &euicc.Card{OnEnable: onEnable}
+	var err error
+	if card.EID, err = hex.DecodeString(spec.EID); err != nil {
+		return nil, err
+	}
+	for _, p := range spec.Profiles {
+		iccid, err := sgp22.NewICCID(p.ICCID)
+		if err != nil {
+			return nil, err
+		}
+		card.Profiles = append(card.Profiles, &euicc.Profile{
+			ICCID:               iccid,
+			State:               util.If(p.Enabled, sgp22.ProfileEnabled, sgp22.ProfileDisabled),
+			Nickname:            p.Nickname,
+			ServiceProviderName: p.ProviderName,
+			ProfileName:         p.ProfileName,
+			SMDPAddress:         p.SMDPAddress,
+		})
+	}
+	return card, nil
+}
+
+// region Modem
+
+func (m *virtualModem) enable(enable bool) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lock != modem.ModemLockNone {
+		return dbus.NewError(errorWrongState, []any{"the modem is locked"})
+	}
+	m.props.SetMust(modem.ModemInterface, "State", int32(util.If(enable, modem.ModemStateRegistered, modem.ModemStateDisabled)))
+	return nil
+}
+
+func (m *virtualModem) setPrimarySimSlot(slot uint32) *dbus.Error {
+	if slot != 1 {
+		return dbus.NewError(errorFailed, []any{fmt.Sprintf("SIM slot %d is not available", slot)})
+	}
+	return nil
+}
+
+func (m *virtualModem) setCurrentModes(current modes) *dbus.Error {
+	if !slices.Contains(supportedModes, current) {
+		return dbus.NewError(errorFailed, []any{fmt.Sprintf("modes %s are not supported", modem.ModemModes{
+			Allowed:   modem.ModemMode(current.Allowed),
+			Preferred: modem.ModemMode(current.Preferred),
+		})})
+	}
+	m.props.SetMust(modem.ModemInterface, "CurrentModes", current)
+	return nil
+}
+
+// setCurrentBands restricts the bands, like ModemManager a lone ModemBandAny selects all of them.
+func (m *virtualModem) setCurrentBands(bands []uint32) *dbus.Error {
+	if slices.Equal(bands, []uint32{uint32(modem.ModemBandAny)}) {
+		bands = supportedBands
+	}
+	for _, band := range bands {
+		if !slices.Contains(supportedBands, band) {
+			return dbus.NewError(errorFailed, []any{fmt.Sprintf("band %s is not supported", modem.ModemBand(band))})
+		}
+	}
+	m.props.SetMust(modem.ModemInterface, "CurrentBands", bands)
+	return nil
+}
+
+func (m *virtualModem) status() (map[string]dbus.Variant, *dbus.Error) {
+	return map[string]dbus.Variant{
+		"state":                    dbus.MakeVariant(m.props.GetMust(modem.ModemInterface, "State")),
+		"signal-quality":           dbus.MakeVariant(m.props.GetMust(modem.ModemInterface, "SignalQuality")),
+		"access-technologies":      dbus.MakeVariant(m.props.GetMust(modem.ModemInterface, "AccessTechnologies")),
+		"m3gpp-registration-state": dbus.MakeVariant(m.props.GetMust(modem.Modem3GPPInterface, "RegistrationState")),
+		"m3gpp-operator-code":      dbus.MakeVariant(m.props.GetMust(modem.Modem3GPPInterface, "OperatorCode")),
+		"m3gpp-operator-name":      dbus.MakeVariant(m.props.GetMust(modem.Modem3GPPInterface, "OperatorName")),
+	}, nil
+}
+
+// setupSignal starts "polling" the extended signal metrics, which are derived from the signal quality.
+func (m *virtualModem) setupSignal(rate uint32) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.props.SetMust(modem.ModemSignalInterface, "Rate", rate)
+	m.updateSignal()
+	return nil
+}
+
+func (m *virtualModem) updateSignal() {
+	rate := m.props.GetMust(modem.ModemSignalInterface, "Rate").(uint32)
+	technologies, _ := accessTechnologies(m.spec.AccessTechnologies)
+	generation := 0
+	for _, technology := range modem.ModemAccessTechnology(technologies).UnmarshalBitmask(technologies) {
+		generation = max(generation, technology.Generation())
+	}
+	quality := float64(m.spec.Signal)
+	metrics := map[string]map[string]dbus.Variant{"Nr5g": {}, "Lte": {}, "Umts": {}, "Gsm": {}}
+	if rate > 0 {
+		switch generation {
+		case 5:
+			metrics["Nr5g"] = signalValues("rsrp", -140+quality*0.97, "rsrq", -20+quality*0.17, "sinr", -5+quality*0.35)
+		case 4:
+			metrics["Lte"] = signalValues("rssi", -113+quality*0.62, "rsrp", -140+quality*0.97, "rsrq", -20+quality*0.17, "snr", -5+quality*0.35)
+		case 3:
+			metrics["Umts"] = signalValues("rssi", -113+quality*0.62, "rscp", -120+quality*0.95, "ecio", -24+quality*0.24)
+		case 2:
+			metrics["Gsm"] = signalValues("rssi", -113+quality*0.62)
+		}
+	}
+	for property, values := range metrics {
+		if !reflect.DeepEqual(m.props.GetMust(modem.ModemSignalInterface, property), values) {
+			m.props.SetMust(modem.ModemSignalInterface, property, values)
+		}
+	}
+}
+
+func signalValues(pairs ...any) map[string]dbus.Variant {
+	values := make(map[string]dbus.Variant, len(pairs)/2)
+	for idx := 0; idx < len(pairs); idx += 2 {
+		values[pairs[idx].(string)] = dbus.MakeVariant(math.Round(pairs[idx+1].(float64)*10) / 10)
+	}
+	return values
+}
+
+// endregion
+
+// region SIM
+
+func (m *virtualModem) unlockRetries() map[uint32]uint32 {
+	retries := make(map[uint32]uint32, len(m.retries))
+	for lock, count := range m.retries {
+		retries[uint32(lock)] = count
+	}
+	return retries
+}
+
+// verify checks a PIN or PUK against the SIM, which blocks the PIN or itself once the attempts are used up.
+func (m *virtualModem) verify(lock modem.ModemLock, code string, expected string) *dbus.Error {
+	if m.retries[lock] == 0 {
+		return dbus.NewError(util.If(lock == modem.ModemLockSimPin, errorSimPuk, errorSimFailure), []any{fmt.Sprintf("%s is blocked", lock)})
+	}
+	if code == expected {
+		m.retries[lock] = util.If[uint32](lock == modem.ModemLockSimPin, pinRetries, pukRetries)
+		m.props.SetMust(modem.ModemInterface, "UnlockRetries", m.unlockRetries())
+		return nil
+	}
+	m.retries[lock]--
+	if lock == modem.ModemLockSimPin && m.retries[lock] == 0 {
+		m.lock = modem.ModemLockSimPuk
+		m.props.SetMust(modem.ModemInterface, "UnlockRequired", uint32(m.lock))
+	}
+	m.props.SetMust(modem.ModemInterface, "UnlockRetries", m.unlockRetries())
+	return dbus.NewError(errorIncorrectPassword, []any{fmt.Sprintf("incorrect %s", lock)})
+}
+
+// unlock lets the modem finish its initialization, which announces the rest of its interfaces like ModemManager does.
+func (m *virtualModem) unlock() *dbus.Error {
+	m.lock = modem.ModemLockNone
+	m.props.SetMust(modem.ModemInterface, "UnlockRequired", uint32(m.lock))
+	m.props.SetMust(modem.ModemInterface, "State", int32(modem.ModemStateDisabled))
+	interfaces := m.interfaces()
+	delete(interfaces, modem.ModemInterface)
+	slog.Info("[Simulator] SIM unlocked", "modem", m.spec.ID)
+	if err := m.s.conn.Emit(modem.ModemManagerObjectPath, modem.ModemManagerInterfacesAdded, m.path, interfaces); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+func (m *virtualModem) sendPin(pin string) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lock != modem.ModemLockSimPin {
+		return dbus.NewError(util.If(m.lock == modem.ModemLockSimPuk, errorSimPuk, errorWrongState), []any{"the SIM doesn't wait for the PIN"})
+	}
+	if err := m.verify(modem.ModemLockSimPin, pin, m.spec.SIM.PIN); err != nil {
+		return err
+	}
+	return m.unlock()
+}
+
+func (m *virtualModem) sendPuk(puk string, pin string) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lock != modem.ModemLockSimPuk {
+		return dbus.NewError(errorWrongState, []any{"the SIM doesn't wait for the PUK"})
+	}
+	if err := m.verify(modem.ModemLockSimPuk, puk, m.spec.SIM.PUK); err != nil {
+		return err
+	}
+	m.spec.SIM.PIN = pin
+	m.retries[modem.ModemLockSimPin] = pinRetries
+	m.props.SetMust(modem.ModemInterface, "UnlockRetries", m.unlockRetries())
+	return m.unlock()
+}
+
+func (m *virtualModem) enablePin(pin string, enabled bool) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lock != modem.ModemLockNone {
+		return dbus.NewError(errorWrongState, []any{"the SIM is locked"})
+	}
+	if err := m.verify(modem.ModemLockSimPin, pin, m.spec.SIM.PIN); err != nil {
+		return err
+	}
+	m.spec.SIM.PINLock = enabled
+	slog.Info("[Simulator] SIM PIN lock changed", "modem", m.spec.ID, "enabled", enabled)
+	return nil
+}
+
+func (m *virtualModem) changePin(oldPin string, newPin string) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lock != modem.ModemLockNone {
+		return dbus.NewError(errorWrongState, []any{"the SIM is locked"})
+	}
+	if err := m.verify(modem.ModemLockSimPin, oldPin, m.spec.SIM.PIN); err != nil {
+		return err
+	}
+	m.spec.SIM.PIN = newPin
+	slog.Info("[Simulator] SIM PIN changed", "modem", m.spec.ID)
+	return nil
+}
+
+// endregion
+
+// region 3GPP
+
+// scanDelay is how long a scan takes, real modems take a minute or more.
+const scanDelay = 3 * time.Second
+
+func (m *virtualModem) scan() ([]map[string]dbus.Variant, *dbus.Error) {
+	time.Sleep(scanDelay)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	technologies, _ := accessTechnologies(m.spec.AccessTechnologies)
+	current := modem.ModemAccessTechnology(technologies).UnmarshalBitmask(technologies)
+	var results []map[string]dbus.Variant
+	if m.spec.OperatorCode != "" && len(current) > 0 {
+		results = append(results, network(m.spec.OperatorCode, m.spec.OperatorName, current[len(current)-1], modem.Modem3gppNetworkAvailabilityCurrent))
+	}
+	for _, n := range m.spec.Networks {
+		if n.OperatorCode == m.spec.OperatorCode {
+			continue
+		}
+		technology, _ := accessTechnologies([]string{n.AccessTechnology})
+		results = append(results, network(n.OperatorCode, n.OperatorName, modem.ModemAccessTechnology(technology),
+			util.If(n.Forbidden, modem.Modem3gppNetworkAvailabilityForbidden, modem.Modem3gppNetworkAvailabilityAvailable)))
+	}
+	return results, nil
+}
+
+func network(code string, name string, technology modem.ModemAccessTechnology, availability modem.Modem3gppNetworkAvailability) map[string]dbus.Variant {
+	return map[string]dbus.Variant{
+		"status":            dbus.MakeVariant(uint32(availability)),
+		"operator-long":     dbus.MakeVariant(name),
+		"operator-short":    dbus.MakeVariant(name),
+		"operator-code":     dbus.MakeVariant(code),
+		"access-technology": dbus.MakeVariant(uint32(technology)),
+	}
+}
+
+// register switches to a scanned network, automatic selection goes back to the SIM's home network if it is around.
+func (m *virtualModem) register(code string) *dbus.Error {
+	m.mutex.Lock()
+	if code == "" {
+		code = m.spec.SIM.OperatorIdentifier
+	}
+	idx := slices.IndexFunc(m.spec.Networks, func(n *NetworkSpec) bool { return n.OperatorCode == code })
+	current := code == m.spec.OperatorCode
+	if idx < 0 || current {
+		m.mutex.Unlock()
+		if current || code == m.spec.SIM.OperatorIdentifier {
+			return nil
+		}
+		return dbus.NewError(errorFailed, []any{fmt.Sprintf("network %s not found", code)})
+	}
+	n := m.spec.Networks[idx]
+	if n.Forbidden {
+		m.mutex.Unlock()
+		return dbus.NewError(errorFailed, []any{fmt.Sprintf("network %s is forbidden", code)})
+	}
+	// The network we leave is still around and shows up in the next scan.
+	if !slices.ContainsFunc(m.spec.Networks, func(n *NetworkSpec) bool { return n.OperatorCode == m.spec.OperatorCode }) {
+		technologies := m.spec.AccessTechnologies
+		m.spec.Networks = append(m.spec.Networks, &NetworkSpec{
+			OperatorCode:     m.spec.OperatorCode,
+			OperatorName:     m.spec.OperatorName,
+			AccessTechnology: util.If(len(technologies) > 0, technologies[len(technologies)-1], "lte"),
+		})
+	}
+	m.mutex.Unlock()
+	m.update(func(spec *ModemSpec) {
+		spec.OperatorCode = n.OperatorCode
+		spec.OperatorName = n.OperatorName
+		spec.AccessTechnologies = []string{n.AccessTechnology}
+		spec.Registration = util.If(n.OperatorCode == spec.SIM.OperatorIdentifier, "home", "roaming")
+	})
+	slog.Info("[Simulator] Network registered", "modem", m.spec.ID, "operator", code)
+	return nil
+}
+
+// endregion
+
+// region USSD
+
+func (m *virtualModem) initiateUSSD(command string) (string, *dbus.Error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.menu != nil {
+		return "", dbus.NewError(errorWrongState, []any{"there is already an ongoing USSD session"})
+	}
+	menu, ok := m.spec.USSD[command]
+	if !ok {
+		return "", dbus.NewError(errorFailed, []any{fmt.Sprintf("unknown USSD code %s", command)})
+	}
+	return m.showUSSD(menu), nil
+}
+
+func (m *virtualModem) respondUSSD(response string) (string, *dbus.Error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.menu == nil {
+		return "", dbus.NewError(errorWrongState, []any{"there is no ongoing USSD session"})
+	}
+	menu, ok := m.menu.Options[strings.TrimSpace(response)]
+	if !ok {
+		return "Invalid option.\n" + m.menu.Text, nil
+	}
+	return m.showUSSD(menu), nil
+}
+
+func (m *virtualModem) cancelUSSD() *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.menu = nil
+	m.props.SetMust(modem.Modem3GPPInterface+".Ussd", "State", uint32(modem.Modem3gppUssdSessionStateIdle))
+	return nil
+}
+
+// pushUSSD sends a network initiated notification, or a request when the menu has options to choose from.
+func (m *virtualModem) pushUSSD(menu *USSDMenu) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.plugged {
+		return errors.New("modem is unplugged")
+	}
+	if len(menu.Options) == 0 {
+		m.props.SetMust(modem.Modem3GPPInterface+".Ussd", "NetworkNotification", menu.Text)
+		slog.Info("[Simulator] USSD notification pushed", "modem", m.spec.ID)
+		return nil
+	}
+	m.showUSSD(menu)
+	m.props.SetMust(modem.Modem3GPPInterface+".Ussd", "NetworkRequest", menu.Text)
+	slog.Info("[Simulator] USSD request pushed", "modem", m.spec.ID)
+	return nil
+}
+
+// showUSSD moves the session to menu and keeps it open while the menu has options to choose from.
+func (m *virtualModem) showUSSD(menu *USSDMenu) string {
+	state := modem.Modem3gppUssdSessionStateIdle
+	m.menu = nil
+	if len(menu.Options) > 0 {
+		state = modem.Modem3gppUssdSessionStateUserResponse
+		m.menu = menu
+	}
+	m.props.SetMust(modem.Modem3GPPInterface+".Ussd", "State", uint32(state))
+	return menu.Text
+}
+
+// endregion
+
+// region Messaging
+
+func (m *virtualModem) listMessages() ([]dbus.ObjectPath, *dbus.Error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	paths := make([]dbus.ObjectPath, 0, len(m.messages))
+	for path := range m.messages {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+func (m *virtualModem) createMessage(properties map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	message := sms{state: modem.SMSStateUnknown, class: modem.SMSClassNone}
+	message.number, _ = properties["number"].Value().(string)
+	message.text, _ = properties["text"].Value().(string)
+	message.data, _ = properties["data"].Value().([]byte)
+	message.smsc, _ = properties["smsc"].Value().(string)
+	if v, ok := properties["validity"]; ok {
+		// ModemManager takes the validity as (uv), only relative periods in minutes are supported here.
+		value, ok := v.Value().([]any)
+		if !ok || len(value) != 2 {
+			return "", dbus.NewError(errorInvalidArgs, []any{fmt.Sprintf("validity must be (uv), not %s", v.Signature())})
+		}
+		kind, _ := value[0].(uint32)
+		minutes, ok := value[1].(dbus.Variant)
+		if kind != 1 || !ok {
+			return "", dbus.NewError(errorInvalidArgs, []any{"only relative validity periods are supported"})
+		}
+		if message.validity, ok = minutes.Value().(uint32); !ok {
+			return "", dbus.NewError(errorInvalidArgs, []any{"relative validity must be in minutes as u"})
+		}
+	}
+	if class, ok := properties["class"].Value().(int32); ok {
+		message.class = modem.SMSClass(class)
+	}
+	if message.number == "" {
+		return "", dbus.NewError(errorFailed, []any{"missing number"})
+	}
+	if message.text != "" && message.data != nil {
+		return "", dbus.NewError(errorInvalidArgs, []any{"text and data are mutually exclusive"})
+	}
+	path, err := m.addMessage(message)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return path, nil
+}
+
+func (m *virtualModem) deleteMessage(path dbus.ObjectPath) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.messages[path]; !ok {
+		return dbus.NewError(errorNotFound, []any{fmt.Sprintf("no SMS found with path %s", path)})
+	}
+	delete(m.messages, path)
+	m.s.conn.ExportMethodTable(nil, path, modem.ModemSMSInterface)
+	m.s.conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	m.updateMessages()
+	return nil
+}
+
+// receive delivers an incoming SMS and announces it with the Messaging.Added signal.
+// A binary message carries data instead of text, a flash message is of class 0.
+func (m *virtualModem) receive(from string, text string, data []byte, flash bool) error {
+	path, err := m.addMessage(sms{
+		state:  modem.SMSStateReceived,
+		number: from,
+		text:   text,
+		data:   data,
+		class:  util.If(flash, modem.SMSClassFlash, modem.SMSClassNone),
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("[Simulator] SMS received", "modem", m.spec.ID, "from", from, "path", path, "flash", flash, "size", len(data))
+	return m.s.conn.Emit(m.path, messagingAdded, path, true)
+}
+
+func (m *virtualModem) addMessage(message sms) (dbus.ObjectPath, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.plugged {
+		return "", errors.New("modem is unplugged")
+	}
+	path := m.s.nextPath("SMS")
+	received := message.state == modem.SMSStateReceived
+	props, err := prop.Export(m.s.conn, path, prop.Map{
+		modem.ModemSMSInterface: {
+			"State":              readonly(uint32(message.state)),
+			"PduType":            readonly(uint32(util.If(received, modem.SMSPduTypeDeliver, modem.SMSPduTypeSubmit))),
+			"Number":             readonly(message.number),
+			"Text":               readonly(message.text),
+			"Data":               readonly(util.If(message.data != nil, message.data, []byte{})),
+			"SMSC":               readonly(util.If(received, "+10000000000", message.smsc)),
+			"Validity":           readonly(validity{Type: util.If(message.validity > 0, uint32(1), 0), Value: dbus.MakeVariant(message.validity)}),
+			"Class":              readonly(int32(message.class)),
+			"MessageReference":   readonly(uint32(0)),
+			"Timestamp":          readonly(util.If(received, time.Now().Format("2006-01-02T15:04:05Z07"), "")),
+			"DischargeTimestamp": readonly(""),
+			"DeliveryState":      readonly(uint32(modem.SMSDeliveryStateUnknown)),
+			"Storage":            readonly(uint32(util.If(received, modem.SMSStorageMe, modem.SMSStorageUnknown))),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := m.s.conn.ExportMethodTable(m.smsMethods(props, message), path, modem.ModemSMSInterface); err != nil {
+		return "", err
+	}
+	m.messages[path] = props
+	m.updateMessages()
+	return path, nil
+}
+
+// smsMethods is the method table of a message.
+func (m *virtualModem) smsMethods(props *prop.Properties, message sms) map[string]any {
+	return map[string]any{
+		"Send": func() *dbus.Error {
+			props.SetMust(modem.ModemSMSInterface, "State", uint32(modem.SMSStateSent))
+			slog.Info("[Simulator] SMS sent", "modem", m.spec.ID, "to", message.number, "text", message.text,
+				"data", hex.EncodeToString(message.data), "class", message.class, "smsc", message.smsc, "validity", message.validity)
+			return nil
+		},
+		"Store": func(storage uint32) *dbus.Error {
+			props.SetMust(modem.ModemSMSInterface, "State", uint32(modem.SMSStateStored))
+			props.SetMust(modem.ModemSMSInterface, "Storage", storage)
+			slog.Info("[Simulator] SMS stored", "modem", m.spec.ID, "to", message.number, "storage", modem.SMSStorage(storage))
+			return nil
+		},
+	}
+}
+
+func (m *virtualModem) updateMessages() {
+	paths := make([]dbus.ObjectPath, 0, len(m.messages))
+	for path := range m.messages {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	m.props.SetMust(modem.ModemMessagingInterface, "Messages", paths)
+}
+
+// endregion
+
+// region Voice
+
+// virtualCall is a call of the modem, it ends by itself once timer fires.
+type virtualCall struct {
+	path  dbus.ObjectPath
+	props *prop.Properties
+	timer *time.Timer
+}
+
+func (c *virtualCall) state() modem.CallState {
+	return modem.CallState(c.props.GetMust(modem.ModemCallInterface, "State").(int32))
+}
+
+func (c *virtualCall) stop() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+func (m *virtualModem) listCalls() ([]dbus.ObjectPath, *dbus.Error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.callPaths(), nil
+}
+
+func (m *virtualModem) callPaths() []dbus.ObjectPath {
+	paths := make([]dbus.ObjectPath, 0, len(m.calls))
+	for path := range m.calls {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func (m *virtualModem) deleteCall(path dbus.ObjectPath) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	call, ok := m.calls[path]
+	if !ok {
+		return dbus.NewError(errorNotFound, []any{fmt.Sprintf("no call found with path %s", path)})
+	}
+	call.stop()
+	delete(m.calls, path)
+	m.s.conn.ExportMethodTable(nil, path, modem.ModemCallInterface)
+	m.s.conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	m.props.SetMust(modem.ModemVoiceInterface, "Calls", m.callPaths())
+	if err := m.s.conn.Emit(m.path, callDeleted, path); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+func (m *virtualModem) hangupAll() *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, call := range m.calls {
+		if call.state() != modem.CallStateTerminated {
+			m.setCallState(call, modem.CallStateTerminated, modem.CallStateReasonTerminated)
+		}
+	}
+	return nil
+}
+
+// ring delivers an incoming call, the caller gives up after ring unless the call is answered.
+func (m *virtualModem) ring(from string, ring time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.plugged {
+		return errors.New("modem is unplugged")
+	}
+	call, err := m.addCall(from, modem.CallDirectionIncoming, modem.CallStateRingingIn, modem.CallStateReasonIncomingNew)
+	if err != nil {
+		return err
+	}
+	m.endCallAfter(call, ring)
+	slog.Info("[Simulator] Incoming call", "modem", m.spec.ID, "from", from, "path", call.path)
+	return nil
+}
+
+func (m *virtualModem) createCall(properties map[string]dbus.Variant) (dbus.ObjectPath, *dbus.Error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	number, _ := properties["number"].Value().(string)
+	if number == "" {
+		return "", dbus.NewError(errorInvalidArgs, []any{"the number is missing"})
+	}
+	call, err := m.addCall(number, modem.CallDirectionOutgoing, modem.CallStateUnknown, modem.CallStateReasonUnknown)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return call.path, nil
+}
+
+func (m *virtualModem) addCall(number string, direction modem.CallDirection, state modem.CallState, reason modem.CallStateReason) (*virtualCall, error) {
+	call := &virtualCall{path: m.s.nextPath("Call")}
+	var err error
+	if call.props, err = prop.Export(m.s.conn, call.path, prop.Map{
+		modem.ModemCallInterface: {
+			"Number":      readonly(number),
+			"Direction":   readonly(int32(direction)),
+			"State":       readonly(int32(state)),
+			"StateReason": readonly(int32(reason)),
+		},
+	}); err != nil {
+		return nil, err
+	}
+	if err := m.s.conn.ExportMethodTable(m.callMethods(call), call.path, modem.ModemCallInterface); err != nil {
+		return nil, err
+	}
+	m.calls[call.path] = call
+	m.props.SetMust(modem.ModemVoiceInterface, "Calls", m.callPaths())
+	return call, m.s.conn.Emit(m.path, callAdded, call.path)
+}
+
+// callMethods is the method table of a call.
+func (m *virtualModem) callMethods(call *virtualCall) map[string]any {
+	return map[string]any{
+		"Accept":   func() *dbus.Error { return m.acceptCall(call) },
+		"Hangup":   func() *dbus.Error { return m.hangupCall(call) },
+		"Start":    func() *dbus.Error { return m.startCall(call) },
+		"SendDtmf": func(tones string) *dbus.Error { return m.sendDtmf(call, tones) },
+	}
+}
+
+func (m *virtualModem) setCallState(call *virtualCall, state modem.CallState, reason modem.CallStateReason) {
+	old := call.state()
+	call.props.SetMust(modem.ModemCallInterface, "State", int32(state))
+	call.props.SetMust(modem.ModemCallInterface, "StateReason", int32(reason))
+	if state == modem.CallStateTerminated {
+		call.stop()
+	}
+	if err := m.s.conn.Emit(call.path, callChanged, int32(old), int32(state), uint32(reason)); err != nil {
+		slog.Error("[Simulator] Failed to announce call state", "error", err, "path", call.path)
+	}
+}
+
+// endCallAfter hangs up on the remote side, unless the call has already ended.
+func (m *virtualModem) endCallAfter(call *virtualCall, delay time.Duration) {
+	call.stop()
+	call.timer = time.AfterFunc(delay, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if _, ok := m.calls[call.path]; ok && call.state() != modem.CallStateTerminated {
+			m.setCallState(call, modem.CallStateTerminated, modem.CallStateReasonTerminated)
+			slog.Info("[Simulator] Call ended by the remote side", "modem", m.spec.ID, "path", call.path)
+		}
+	})
+}
+
+func (m *virtualModem) acceptCall(call *virtualCall) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if call.state() != modem.CallStateRingingIn {
+		return dbus.NewError(errorWrongState, []any{"the call isn't ringing"})
+	}
+	m.setCallState(call, modem.CallStateActive, modem.CallStateReasonAccepted)
+	m.endCallAfter(call, callerHangupDelay)
+	return nil
+}
+
+// startCall dials an outgoing call, the callee answers shortly after it starts ringing.
+func (m *virtualModem) startCall(call *virtualCall) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if call.state() != modem.CallStateUnknown {
+		return dbus.NewError(errorWrongState, []any{"the call has already been started"})
+	}
+	m.setCallState(call, modem.CallStateDialing, modem.CallStateReasonOutgoingStarted)
+	slog.Info("[Simulator] Dialing", "modem", m.spec.ID, "number", call.props.GetMust(modem.ModemCallInterface, "Number"), "path", call.path)
+	call.timer = time.AfterFunc(dialingDelay, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if call.state() != modem.CallStateDialing {
+			return
+		}
+		m.setCallState(call, modem.CallStateRingingOut, modem.CallStateReasonOutgoingStarted)
+		call.timer = time.AfterFunc(answerDelay, func() {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			if call.state() != modem.CallStateRingingOut {
+				return
+			}
+			m.setCallState(call, modem.CallStateActive, modem.CallStateReasonAccepted)
+			m.endCallAfter(call, calleeHangupDelay)
+		})
+	})
+	return nil
+}
+
+func (m *virtualModem) sendDtmf(call *virtualCall, tones string) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if call.state() != modem.CallStateActive {
+		return dbus.NewError(errorWrongState, []any{"the call isn't active"})
+	}
+	if strings.Trim(tones, "0123456789*#ABCD") != "" {
+		return dbus.NewError(errorInvalidArgs, []any{fmt.Sprintf("invalid DTMF tones %q", tones)})
+	}
+	slog.Info("[Simulator] DTMF received", "modem", m.spec.ID, "tones", tones, "path", call.path)
+	return nil
+}
+
+func (m *virtualModem) hangupCall(call *virtualCall) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if call.state() == modem.CallStateTerminated {
+		return dbus.NewError(errorWrongState, []any{"the call has already ended"})
+	}
+	m.setCallState(call, modem.CallStateTerminated, modem.CallStateReasonTerminated)
+	return nil
+}
+
+// endregion
+
+// region Cell broadcast
+
+func (m *virtualModem) listCellBroadcasts() ([]dbus.ObjectPath, *dbus.Error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.cbmPaths(), nil
+}
+
+func (m *virtualModem) cbmPaths() []dbus.ObjectPath {
+	paths := make([]dbus.ObjectPath, 0, len(m.cbms))
+	for path := range m.cbms {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func (m *virtualModem) deleteCellBroadcast(path dbus.ObjectPath) *dbus.Error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.cbms[path]; !ok {
+		return dbus.NewError(errorNotFound, []any{fmt.Sprintf("no cell broadcast found with path %s", path)})
+	}
+	delete(m.cbms, path)
+	m.s.conn.Export(nil, path, "org.freedesktop.DBus.Properties")
+	m.props.SetMust(modem.ModemCellBroadcastInterface, "CellBroadcasts", m.cbmPaths())
+	if err := m.s.conn.Emit(m.path, cbmDeleted, path); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+func (m *virtualModem) setChannels(ranges []channels) *dbus.Error {
+	for _, r := range ranges {
+		if r.Start > r.End || r.End > 0xffff {
+			return dbus.NewError(errorInvalidArgs, []any{fmt.Sprintf("invalid channels %d-%d", r.Start, r.End)})
+		}
+	}
+	m.props.SetMust(modem.ModemCellBroadcastInterface, "Channels", ranges)
+	slog.Info("[Simulator] Cell broadcast channels changed", "modem", m.spec.ID, "channels", ranges)
+	return nil
+}
+
+// broadcast delivers a cell broadcast message, unless the modem doesn't listen to its channel.
+func (m *virtualModem) broadcast(channel uint32, text string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.plugged {
+		return errors.New("modem is unplugged")
+	}
+	ranges := m.props.GetMust(modem.ModemCellBroadcastInterface, "Channels").([]channels)
+	if !slices.ContainsFunc(ranges, func(r channels) bool { return channel >= r.Start && channel <= r.End }) {
+		slog.Info("[Simulator] Cell broadcast ignored", "modem", m.spec.ID, "channel", channel)
+		return nil
+	}
+	path := m.s.nextPath("Cbm")
+	props, err := prop.Export(m.s.conn, path, prop.Map{
+		modem.ModemCbmInterface: {
+			"State":       readonly(uint32(modem.CellBroadcastStateReceived)),
+			"Channel":     readonly(channel),
+			"MessageCode": readonly(uint32(len(m.cbms) + 1)),
+			"Update":      readonly(uint32(0)),
+			"Text":        readonly(text),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	m.cbms[path] = props
+	m.props.SetMust(modem.ModemCellBroadcastInterface, "CellBroadcasts", m.cbmPaths())
+	slog.Info("[Simulator] Cell broadcast received", "modem", m.spec.ID, "channel", channel, "path", path)
+	return m.s.conn.Emit(m.path, cbmAdded, path)
+}
+
+// endregion
+
+// region AT
+
+func (m *virtualModem) handleAT(command string) serial.Response {
+	name, args, _ := strings.Cut(command, "=")
+	switch strings.ToUpper(name) {
+	case "AT", "ATE0":
+		return serial.OK()
+	case "ATI":
+		return serial.OK(m.spec.Manufacturer, m.spec.Model, "Revision: "+m.spec.Revision)
+	case "AT+CGSN":
+		return serial.OK(m.spec.IMEI)
+	case "AT+CIMI":
+		return serial.OK(m.spec.SIM.IMSI)
+	case "AT+CSIM":
+		if args == "?" {
+			return serial.OK()
+		}
+		return m.csim(args)
+	case "AT+CRSM":
+		if args == "?" {
+			return serial.OK()
+		}
+		return m.crsm(args)
+	case "AT+CSCS?":
+		return serial.OK(fmt.Sprintf(`+CSCS: "%s"`, m.charset))
+	case "AT+CSCS":
+		charset := strings.Trim(args, `"`)
+		if !slices.Contains([]string{"IRA", "GSM", "UCS2"}, charset) {
+			return serial.CMEError(cmeInvalidCharacters)
+		}
+		m.charset = charset
+		return serial.OK()
+	case "AT+CPBS":
+		if args != `"SM"` || m.spec.CRSMPhonebook {
+			return serial.Error()
+		}
+		return serial.OK()
+	case "AT+CPBR":
+		if m.spec.CRSMPhonebook {
+			return serial.Error()
+		}
+		return m.cpbr(args)
+	case "AT+CPBW":
+		if m.spec.CRSMPhonebook {
+			return serial.Error()
+		}
+		return m.cpbw(args)
+	}
+	return serial.Error()
+}
+
+// cpbr lists the phonebook records in a range of indexes, the strings are in the character set of the port.
+func (m *virtualModem) cpbr(args string) serial.Response {
+	if args == "?" {
+		return serial.OK(fmt.Sprintf("+CPBR: (1-%d),%d,%d", phonebookRecords, phonebookNumberLength, phonebookNameLength))
+	}
+	from, to, ok := strings.Cut(args, ",")
+	if !ok {
+		to = from
+	}
+	first, err := strconv.Atoi(from)
+	if err != nil {
+		return serial.CMEError(cmeInvalidIndex)
+	}
+	last, err := strconv.Atoi(to)
+	if err != nil || first < 1 || last > phonebookRecords || first > last {
+		return serial.CMEError(cmeInvalidIndex)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var lines []string
+	for _, entry := range m.phonebook[first-1 : last] {
+		if entry != nil {
+			lines = append(lines, fmt.Sprintf(`+CPBR: %d,"%s",%d,"%s"`, entry.Index, m.encode(entry.Number),
+				util.If(strings.HasPrefix(entry.Number, "+"), 145, 129), m.encode(entry.Name)))
+		}
+	}
+	if len(lines) == 0 {
+		return serial.CMEError(cmeNotFound)
+	}
+	return serial.OK(lines...)
+}
+
+var cpbwArgs = regexp.MustCompile(`^(\d*)(?:,"([^"]*)",(\d+)(?:,"([^"]*)")?)?$`)
+
+// cpbw writes a phonebook record, the first free one without an index, or deletes it without a number.
+func (m *virtualModem) cpbw(args string) serial.Response {
+	match := cpbwArgs.FindStringSubmatch(args)
+	if match == nil || match[1] == "" && match[2] == "" {
+		return serial.CMEError(cmeInvalidCharacters)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	index := slices.Index(m.phonebook, nil) + 1
+	if match[1] != "" {
+		index, _ = strconv.Atoi(match[1])
+	}
+	if index < 1 || index > phonebookRecords {
+		return serial.CMEError(cmeInvalidIndex)
+	}
+	if match[2] == "" {
+		m.phonebook[index-1] = nil
+		slog.Info("[Simulator] Phonebook entry deleted", "modem", m.spec.ID, "index", index)
+		return serial.OK()
+	}
+	entry := &modem.PhonebookEntry{Index: index, Number: m.decode(match[2]), Name: m.decode(match[4])}
+	if toa, _ := strconv.Atoi(match[3]); toa == 145 && !strings.HasPrefix(entry.Number, "+") {
+		entry.Number = "+" + entry.Number
+	}
+	if _, err := modem.EncodeADN(entry, adnRecordLength); err != nil {
+		return serial.CMEError(cmeInvalidCharacters)
+	}
+	m.phonebook[index-1] = entry
+	slog.Info("[Simulator] Phonebook entry written", "modem", m.spec.ID, "index", index, "name", entry.Name, "number", entry.Number)
+	return serial.OK()
+}
+
+// decode reads a string in the character set of the port, numbers are accepted in UCS-2 or as they are.
+func (m *virtualModem) decode(value string) string {
+	data, err := hex.DecodeString(value)
+	if m.charset != "UCS2" || err != nil || len(data)%2 != 0 {
+		return value
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// encode writes a string in the character set of the port, like Quectel modems UCS-2 applies to the numbers too.
+func (m *virtualModem) encode(value string) string {
+	if m.charset != "UCS2" {
+		return value
+	}
+	var encoded strings.Builder
+	for _, unit := range utf16.Encode([]rune(value)) {
+		fmt.Fprintf(&encoded, "%04X", unit)
+	}
+	return encoded.String()
+}
+
+func (m *virtualModem) csim(args string) serial.Response {
+	_, data, ok := strings.Cut(args, ",")
+	if !ok {
+		return serial.CMEError(cmeInvalidCharacters)
+	}
+	command, err := hex.DecodeString(strings.Trim(data, `"`))
+	if err != nil {
+		return serial.CMEError(cmeInvalidCharacters)
+	}
+	response := []byte{euicc.SWFileNotFound >> 8, euicc.SWFileNotFound & 0xFF}
+	if m.card != nil {
+		response = m.card.Exchange(command)
+	}
+	return serial.OK(fmt.Sprintf(`+CSIM: %d,"%X"`, len(response)*2, response))
+}
+
+// crsm implements restricted SIM access to EF_MSISDN (6F40), to set the own number, and EF_ADN (6F3A), the phonebook.
+func (m *virtualModem) crsm(args string) serial.Response {
+	fields := strings.Split(args, ",")
+	if len(fields) < 2 {
+		return serial.CMEError(cmeInvalidCharacters)
+	}
+	instruction, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return serial.CMEError(cmeInvalidCharacters)
+	}
+	if fields[1] == "28474" {
+		return m.adn(modem.CRSMInstruction(instruction), fields)
+	}
+	if fields[1] != "28480" {
+		return serial.OK(`+CRSM: 106,130,""`)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	switch modem.CRSMInstruction(instruction) {
+	case modem.CRSMGetResponse:
+		return serial.OK(fmt.Sprintf(`+CRSM: 144,0,"620F8205422100%02X0183026F408002%04X"`, msisdnRecordLength, msisdnRecordLength))
+	case modem.CRSMReadRecord:
+		return serial.OK(fmt.Sprintf(`+CRSM: 144,0,"%X"`, m.msisdn))
+	case modem.CRSMUpdateRecord:
+		if len(fields) < 6 {
+			return serial.CMEError(cmeInvalidCharacters)
+		}
+		record, err := hex.DecodeString(strings.Trim(fields[5], `"`))
+		if err != nil || len(record) != msisdnRecordLength {
+			return serial.OK(`+CRSM: 103,0,""`)
+		}
+		m.msisdn = record
+		if m.plugged {
+			m.props.SetMust(modem.ModemInterface, "OwnNumbers", m.ownNumbers())
+		}
+		slog.Info("[Simulator] MSISDN updated", "modem", m.spec.ID, "number", decodeMSISDN(record))
+		return serial.OK(`+CRSM: 144,0,""`)
+	}
+	return serial.OK(`+CRSM: 109,0,""`)
+}
+
+// adn reads and updates the phonebook records, which are kept decoded so AT+CPBR and AT+CPBW see the same phonebook.
+func (m *virtualModem) adn(instruction modem.CRSMInstruction, fields []string) serial.Response {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if instruction == modem.CRSMGetResponse {
+		return serial.OK(fmt.Sprintf(`+CRSM: 144,0,"620F8205422100%02X%02X83026F3A8002%04X"`, adnRecordLength, phonebookRecords, adnRecordLength*phonebookRecords))
+	}
+	if len(fields) < 5 {
+		return serial.CMEError(cmeInvalidCharacters)
+	}
+	index, err := strconv.Atoi(fields[2])
+	if err != nil || index < 1 || index > phonebookRecords {
+		return serial.OK(`+CRSM: 106,131,""`)
+	}
+	switch instruction {
+	case modem.CRSMReadRecord:
+		record := bytes.Repeat([]byte{0xFF}, adnRecordLength)
+		if entry := m.phonebook[index-1]; entry != nil {
+			if record, err = modem.EncodeADN(entry, adnRecordLength); err != nil {
+				return serial.OK(`+CRSM: 111,0,""`)
+			}
+		}
+		return serial.OK(fmt.Sprintf(`+CRSM: 144,0,"%X"`, record))
+	case modem.CRSMUpdateRecord:
+		if len(fields) < 6 {
+			return serial.CMEError(cmeInvalidCharacters)
+		}
+		record, err := hex.DecodeString(strings.Trim(fields[5], `"`))
+		if err != nil || len(record) != adnRecordLength {
+			return serial.OK(`+CRSM: 103,0,""`)
+		}
+		m.phonebook[index-1] = modem.DecodeADN(record)
+		if entry := m.phonebook[index-1]; entry != nil {
+			entry.Index = index
+			slog.Info("[Simulator] Phonebook record updated", "modem", m.spec.ID, "index", index, "name", entry.Name, "number", entry.Number)
+		} else {
+			slog.Info("[Simulator] Phonebook record erased", "modem", m.spec.ID, "index", index)
+		}
+		return serial.OK(`+CRSM: 144,0,""`)
+	}
+	return serial.OK(`+CRSM: 109,0,""`)
+}
+
+// endregion
+
+// encodeMSISDN builds an EF_MSISDN record the same way the bot does when it updates the number.
+func encodeMSISDN(name string, number string) ([]byte, error) {
+	record := make([]byte, msisdnRecordLength)
+	for idx := range record {
+		record[idx] = 0xFF
+	}
+	copy(record, name)
+	if number == "" {
+		return record, nil
+	}
+	digits := strings.TrimPrefix(number, "+")
+	if len(digits)%2 != 0 {
+		digits += "F"
+	}
+	bcd, err := hex.DecodeString(digits)
+	if err != nil || len(bcd) > 10 {
+		return nil, fmt.Errorf("invalid number %q", number)
+	}
+	for idx := range bcd {
+		bcd[idx] = bcd[idx]>>4 | bcd[idx]<<4
+	}
+	offset := msisdnRecordLength - 14
+	record[offset] = byte(len(bcd) + 1)
+	record[offset+1] = util.If(strings.HasPrefix(number, "+"), byte(0x91), byte(0x81))
+	copy(record[offset+2:], bcd)
+	return record, nil
+}
+
+func decodeMSISDN(record []byte) string {
+	offset := len(record) - 14
+	if offset < 0 || record[offset] < 2 || int(record[offset]) > 11 {
+		return ""
+	}
+	bcd := slices.Clone(record[offset+2 : offset+1+int(record[offset])])
+	for idx := range bcd {
+		bcd[idx] = bcd[idx]>>4 | bcd[idx]<<4
+	}
+	number := strings.TrimRight(strings.ToUpper(hex.EncodeToString(bcd)), "F")
+	if record[offset+1] == 0x91 {
+		number = "+" + number
+	}
+	return number
+}
+
+func readonly(value any) *prop.Prop {
+	return &prop.Prop{Value: value, Emit: prop.EmitTrue}
+}
@@ -0,0 +1,261 @@
+package simulator
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+)
+
+//go:embed scenario.json
+var defaultScenario []byte
+
+// defaultRing is how long an incoming call rings unless the event says otherwise.
+const defaultRing = 20 * time.Second
+
+type Scenario struct {
+	Modems []*ModemSpec `json:"modems"`
+	Events []*Event     `json:"events"`
+}
+
+type ModemSpec struct {
+	ID                 string               `json:"id"`
+	Manufacturer       string               `json:"manufacturer"`
+	Model              string               `json:"model"`
+	Revision           string               `json:"revision"`
+	IMEI               string               `json:"imei"`
+	Number             string               `json:"number"`
+	OperatorCode       string               `json:"operatorCode"`
+	OperatorName       string               `json:"operatorName"`
+	Registration       string               `json:"registration"`
+	AccessTechnologies []string             `json:"accessTechnologies"`
+	Signal             uint32               `json:"signal"`
+	Unplugged          bool                 `json:"unplugged"`
+	SIM                SIMSpec              `json:"sim"`
+	EUICC              *EUICCSpec           `json:"euicc,omitempty"`
+	USSD               map[string]*USSDMenu `json:"ussd,omitempty"`
+	// Networks are found by a scan besides the current one.
+	Networks []*NetworkSpec `json:"networks,omitempty"`
+	// CRSMPhonebook makes the modem lack AT+CPBR and friends, so the SIM phonebook is only reached with AT+CRSM.
+	CRSMPhonebook bool `json:"crsmPhonebook,omitempty"`
+}
+
+type NetworkSpec struct {
+	OperatorCode     string `json:"operatorCode"`
+	OperatorName     string `json:"operatorName"`
+	AccessTechnology string `json:"accessTechnology"`
+	Forbidden        bool   `json:"forbidden,omitempty"`
+}
+
+type SIMSpec struct {
+	ICCID              string `json:"iccid"`
+	IMSI               string `json:"imsi"`
+	OperatorIdentifier string `json:"operatorIdentifier"`
+	OperatorName       string `json:"operatorName"`
+	// PIN is asked for every time the modem is plugged in while PINLock is on, the PUK unblocks the SIM.
+	PIN     string `json:"pin,omitempty"`
+	PINLock bool   `json:"pinLock,omitempty"`
+	PUK     string `json:"puk,omitempty"`
+	// Phonebook are the contacts saved on the SIM.
+	Phonebook []*PhonebookSpec `json:"phonebook,omitempty"`
+}
+
+type PhonebookSpec struct {
+	Name   string `json:"name"`
+	Number string `json:"number"`
+}
+
+type EUICCSpec struct {
+	EID      string         `json:"eid"`
+	Profiles []*ProfileSpec `json:"profiles"`
+}
+
+type ProfileSpec struct {
+	ICCID        string `json:"iccid"`
+	ProviderName string `json:"providerName"`
+	ProfileName  string `json:"profileName"`
+	Nickname     string `json:"nickname,omitempty"`
+	Enabled      bool   `json:"enabled,omitempty"`
+	// SMDPAddress receives the profile notifications. Leave it empty to generate none.
+	SMDPAddress string `json:"smdpAddress,omitempty"`
+}
+
+// USSDMenu is a single screen of a USSD session.
+// A menu without options ends the session once it has been shown.
+type USSDMenu struct {
+	Text    string               `json:"text"`
+	Options map[string]*USSDMenu `json:"options,omitempty"`
+}
+
+type EventType string
+
+const (
+	EventTypeSMS          EventType = "sms"
+	EventTypeUnplug       EventType = "unplug"
+	EventTypePlug         EventType = "plug"
+	EventTypeSignal       EventType = "signal"
+	EventTypeRegistration EventType = "registration"
+	EventTypeUSSD         EventType = "ussd"
+	EventTypeCall         EventType = "call"
+	EventTypeBroadcast    EventType = "broadcast"
+)
+
+// Event is a scripted change applied to a modem once At has elapsed since the simulator started.
+type Event struct {
+	At    Duration  `json:"at"`
+	Modem string    `json:"modem"`
+	Type  EventType `json:"type"`
+	From  string    `json:"from,omitempty"`
+	Text  string    `json:"text,omitempty"`
+	Count int       `json:"count,omitempty"`
+	// Data is the hex encoded payload of a binary SMS, which has no text, and Flash makes an SMS a class 0 message.
+	Data         string   `json:"data,omitempty"`
+	Flash        bool     `json:"flash,omitempty"`
+	Interval     Duration `json:"interval,omitempty"`
+	Signal       uint32   `json:"signal,omitempty"`
+	Registration string   `json:"registration,omitempty"`
+	OperatorCode string   `json:"operatorCode,omitempty"`
+	OperatorName string   `json:"operatorName,omitempty"`
+	// AccessTechnologies replaces the access technologies on a registration event, if set.
+	AccessTechnologies []string `json:"accessTechnologies,omitempty"`
+	// Channel is the cell broadcast channel of a broadcast event, e.g. 4371 for an extreme alert.
+	Channel uint32 `json:"channel,omitempty"`
+	// Ring is how long an incoming call rings before the caller gives up, 20s unless set.
+	Ring Duration `json:"ring,omitempty"`
+	// USSD is pushed by the network, as a request if it has options to choose from, otherwise as a notification.
+	USSD *USSDMenu `json:"ussd,omitempty"`
+}
+
+type Duration struct{ time.Duration }
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// LoadScenario reads a scenario file. An empty path loads the built-in demo scenario.
+func LoadScenario(path string) (*Scenario, error) {
+	data := defaultScenario
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var s Scenario
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, s.validate()
+}
+
+func (s *Scenario) validate() error {
+	ids := make(map[string]bool, len(s.Modems))
+	for _, m := range s.Modems {
+		if m.ID == "" || ids[m.ID] {
+			return fmt.Errorf("modem id %q is empty or duplicated", m.ID)
+		}
+		ids[m.ID] = true
+		if _, err := registrationState(m.Registration); err != nil {
+			return fmt.Errorf("modem %s: %w", m.ID, err)
+		}
+		if _, err := accessTechnologies(m.AccessTechnologies); err != nil {
+			return fmt.Errorf("modem %s: %w", m.ID, err)
+		}
+		for _, n := range m.Networks {
+			if _, err := accessTechnologies([]string{n.AccessTechnology}); err != nil || n.OperatorCode == "" {
+				return fmt.Errorf("modem %s: network %q is invalid", m.ID, n.OperatorCode)
+			}
+		}
+		if len(m.SIM.Phonebook) > phonebookRecords {
+			return fmt.Errorf("modem %s: the phonebook holds at most %d contacts", m.ID, phonebookRecords)
+		}
+	}
+	for idx, e := range s.Events {
+		if !ids[e.Modem] {
+			return fmt.Errorf("event %d: unknown modem %q", idx, e.Modem)
+		}
+		switch e.Type {
+		case EventTypeSMS:
+			if _, err := hex.DecodeString(e.Data); err != nil {
+				return fmt.Errorf("event %d: data is not hex encoded", idx)
+			}
+		case EventTypeUnplug, EventTypePlug, EventTypeSignal, EventTypeCall:
+		case EventTypeRegistration:
+			if _, err := registrationState(e.Registration); err != nil {
+				return fmt.Errorf("event %d: %w", idx, err)
+			}
+			if _, err := accessTechnologies(e.AccessTechnologies); err != nil {
+				return fmt.Errorf("event %d: %w", idx, err)
+			}
+		case EventTypeBroadcast:
+			if e.Channel == 0 || e.Text == "" {
+				return fmt.Errorf("event %d: channel and text are required", idx)
+			}
+		case EventTypeUSSD:
+			if e.USSD == nil {
+				return fmt.Errorf("event %d: ussd is required", idx)
+			}
+		default:
+			return fmt.Errorf("event %d: unknown type %q", idx, e.Type)
+		}
+	}
+	return nil
+}
+
+func registrationState(name string) (modem.Modem3gppRegistrationState, error) {
+	switch strings.ToLower(name) {
+	case "", "home":
+		return modem.Modem3gppRegistrationStateHome, nil
+	case "roaming":
+		return modem.Modem3gppRegistrationStateRoaming, nil
+	case "searching":
+		return modem.Modem3gppRegistrationStateSearching, nil
+	case "idle":
+		return modem.Modem3gppRegistrationStateIdle, nil
+	case "denied":
+		return modem.Modem3gppRegistrationStateDenied, nil
+	case "emergency":
+		return modem.Modem3gppRegistrationStateEmergencyOnly, nil
+	}
+	return 0, fmt.Errorf("unknown registration state %q", name)
+}
+
+func accessTechnologies(names []string) (uint32, error) {
+	var bitmask uint32
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "gsm":
+			bitmask |= uint32(modem.ModemAccessTechnologyGsm)
+		case "gprs":
+			bitmask |= uint32(modem.ModemAccessTechnologyGprs)
+		case "edge":
+			bitmask |= uint32(modem.ModemAccessTechnologyEdge)
+		case "umts":
+			bitmask |= uint32(modem.ModemAccessTechnologyUmts)
+		case "hspa":
+			bitmask |= uint32(modem.ModemAccessTechnologyHspa)
+		case "lte":
+			bitmask |= uint32(modem.ModemAccessTechnologyLte)
+		case "5gnr":
+			bitmask |= uint32(modem.ModemAccessTechnology5GNR)
+		default:
+			return 0, fmt.Errorf("unknown access technology %q", name)
+		}
+	}
+	return bitmask, nil
+}
@@ -0,0 +1,175 @@
+package simulator
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadScenario(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name    string
+		path    string
+		modems  int
+		events  int
+		wantErr string
+	}{
+		{name: "bundled", path: ""},
+		{
+			name:   "file",
+			path:   write("valid.json", `{"modems":[{"id":"a","registration":"roaming","accessTechnologies":["lte"]}],"events":[{"at":"5s","modem":"a","type":"sms","from":"+1","text":"Hi"}]}`),
+			modems: 1,
+			events: 1,
+		},
+		{name: "missing", path: filepath.Join(dir, "missing.json"), wantErr: "no such file"},
+		{name: "not JSON", path: write("broken.json", `{"modems":`), wantErr: "unexpected end"},
+		{name: "bad duration", path: write("duration.json", `{"modems":[{"id":"a"}],"events":[{"at":"soon","modem":"a","type":"plug"}]}`), wantErr: "invalid duration"},
+		{name: "invalid", path: write("invalid.json", `{"modems":[{"id":"a"}],"events":[{"modem":"b","type":"plug"}]}`), wantErr: `unknown modem "b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := LoadScenario(tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadScenario() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadScenario() error = %v", err)
+			}
+			if tt.path == "" {
+				// The demo scenario must keep showing off every kind of event.
+				if len(s.Modems) < 2 || len(s.Events) == 0 {
+					t.Fatalf("bundled scenario has %d modems and %d events", len(s.Modems), len(s.Events))
+				}
+				return
+			}
+			if len(s.Modems) != tt.modems || len(s.Events) != tt.events {
+				t.Fatalf("LoadScenario() = %d modems and %d events, want %d and %d", len(s.Modems), len(s.Events), tt.modems, tt.events)
+			}
+			if s.Events[0].At.Duration != 5*time.Second {
+				t.Errorf("event at %s, want 5s", s.Events[0].At)
+			}
+		})
+	}
+	if _, err := LoadScenario(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadScenario() of a missing file error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestScenarioValidate(t *testing.T) {
+	modem := func(id string) *ModemSpec { return &ModemSpec{ID: id} }
+	tests := []struct {
+		name     string
+		scenario Scenario
+		wantErr  string
+	}{
+		{name: "empty"},
+		{
+			name: "every event",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{
+				{Modem: "a", Type: EventTypeSMS, Data: "0102"},
+				{Modem: "a", Type: EventTypeUnplug},
+				{Modem: "a", Type: EventTypePlug},
+				{Modem: "a", Type: EventTypeSignal, Signal: 10},
+				{Modem: "a", Type: EventTypeCall},
+				{Modem: "a", Type: EventTypeRegistration, Registration: "roaming", AccessTechnologies: []string{"GSM", "5gnr"}},
+				{Modem: "a", Type: EventTypeBroadcast, Channel: 4370, Text: "Alert"},
+				{Modem: "a", Type: EventTypeUSSD, USSD: &USSDMenu{Text: "Hi"}},
+			}},
+		},
+		{name: "no id", scenario: Scenario{Modems: []*ModemSpec{modem("")}}, wantErr: "empty or duplicated"},
+		{name: "duplicated id", scenario: Scenario{Modems: []*ModemSpec{modem("a"), modem("a")}}, wantErr: "empty or duplicated"},
+		{
+			name:     "unknown registration",
+			scenario: Scenario{Modems: []*ModemSpec{{ID: "a", Registration: "abroad"}}},
+			wantErr:  `unknown registration state "abroad"`,
+		},
+		{
+			name:     "unknown access technology",
+			scenario: Scenario{Modems: []*ModemSpec{{ID: "a", AccessTechnologies: []string{"6g"}}}},
+			wantErr:  `unknown access technology "6g"`,
+		},
+		{
+			name:     "network without operator",
+			scenario: Scenario{Modems: []*ModemSpec{{ID: "a", Networks: []*NetworkSpec{{AccessTechnology: "lte"}}}}},
+			wantErr:  "network",
+		},
+		{
+			name:     "network with unknown access technology",
+			scenario: Scenario{Modems: []*ModemSpec{{ID: "a", Networks: []*NetworkSpec{{OperatorCode: "22210", AccessTechnology: "wifi"}}}}},
+			wantErr:  `network "22210" is invalid`,
+		},
+		{
+			name:     "phonebook too large",
+			scenario: Scenario{Modems: []*ModemSpec{{ID: "a", SIM: SIMSpec{Phonebook: make([]*PhonebookSpec, phonebookRecords+1)}}}},
+			wantErr:  "phonebook holds at most",
+		},
+		{
+			name:     "unknown modem",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{{Modem: "b", Type: EventTypePlug}}},
+			wantErr:  `event 0: unknown modem "b"`,
+		},
+		{
+			name:     "data not hex",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{{Modem: "a", Type: EventTypeSMS, Data: "xyz"}}},
+			wantErr:  "not hex encoded",
+		},
+		{
+			name:     "registration event",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{{Modem: "a", Type: EventTypeRegistration, Registration: "lost"}}},
+			wantErr:  "unknown registration state",
+		},
+		{
+			name:     "registration event technology",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{{Modem: "a", Type: EventTypeRegistration, AccessTechnologies: []string{"4g"}}}},
+			wantErr:  "unknown access technology",
+		},
+		{
+			name:     "broadcast without channel",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{{Modem: "a", Type: EventTypeBroadcast, Text: "Alert"}}},
+			wantErr:  "channel and text are required",
+		},
+		{
+			name:     "broadcast without text",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{{Modem: "a", Type: EventTypeBroadcast, Channel: 4370}}},
+			wantErr:  "channel and text are required",
+		},
+		{
+			name:     "USSD without menu",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{{Modem: "a", Type: EventTypeUSSD}}},
+			wantErr:  "ussd is required",
+		},
+		{
+			name:     "unknown type",
+			scenario: Scenario{Modems: []*ModemSpec{modem("a")}, Events: []*Event{{Modem: "a", Type: "fax"}}},
+			wantErr:  `unknown type "fax"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.scenario.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package simulator
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+const objectManagerInterface = "org.freedesktop.DBus.ObjectManager"
+
+// Simulator runs a private D-Bus daemon with a fake ModemManager serving the modems of a scenario.
+// Point the bot at it by setting DBUS_SYSTEM_BUS_ADDRESS to Address before connecting to the system bus.
+type Simulator struct {
+	Address  string
+	scenario *Scenario
+	bus      *bus
+	conn     *dbus.Conn
+	mutex    sync.Mutex
+	modems   map[string]*virtualModem
+	indexes  map[string]int
+	timers   []*time.Timer
+}
+
+func New(scenario *Scenario) (*Simulator, error) {
+	s := &Simulator{
+		scenario: scenario,
+		modems:   make(map[string]*virtualModem, len(scenario.Modems)),
+		indexes:  make(map[string]int, 3),
+	}
+	var err error
+	if s.bus, err = startBus(); err != nil {
+		return nil, err
+	}
+	s.Address = s.bus.Address
+	if s.conn, err = dbus.Connect(s.Address); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.exportManager(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	for _, spec := range scenario.Modems {
+		m, err := newVirtualModem(s, spec)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.modems[spec.ID] = m
+		if spec.Unplugged {
+			continue
+		}
+		if err := m.export(modem.ModemStateRegistered); err != nil {
+			s.Close()
+			return nil, err
+		}
+		slog.Info("[Simulator] Modem plugged in", "modem", spec.ID, "path", m.path, "port", m.port.Device)
+	}
+	reply, err := s.conn.RequestName(modem.ModemManagerInterface, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		s.Close()
+		return nil, errors.New("failed to own the ModemManager bus name")
+	}
+	return s, nil
+}
+
+func (s *Simulator) exportManager() error {
+	if err := s.conn.ExportMethodTable(map[string]any{
+		"GetManagedObjects": s.managedObjects,
+	}, modem.ModemManagerObjectPath, objectManagerInterface); err != nil {
+		return err
+	}
+	return s.conn.ExportMethodTable(s.managerMethods(), modem.ModemManagerObjectPath, modem.ModemManagerInterface)
+}
+
+// managerMethods is the method table of the ModemManager object.
+func (s *Simulator) managerMethods() map[string]any {
+	return map[string]any{
+		"ScanDevices":   func() *dbus.Error { return nil },
+		"SetLogging":    func(level string) *dbus.Error { return nil },
+		"InhibitDevice": s.inhibitDevice,
+	}
+}
+
+// nextPath allocates the next object path of the given kind, e.g. /org/freedesktop/ModemManager1/SMS/3.
+func (s *Simulator) nextPath(kind string) dbus.ObjectPath {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path := dbus.ObjectPath(fmt.Sprintf("%s/%s/%d", modem.ModemManagerObjectPath, kind, s.indexes[kind]))
+	s.indexes[kind]++
+	return path
+}
+
+func (s *Simulator) managedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, *dbus.Error) {
+	objects := make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant, len(s.modems))
+	for _, m := range s.modems {
+		m.mutex.Lock()
+		if m.plugged {
+			objects[m.path] = m.interfaces()
+		}
+		m.mutex.Unlock()
+	}
+	return objects, nil
+}
+
+// inhibitDevice mirrors ModemManager, which drops the modem while it is inhibited and probes it again afterwards.
+func (s *Simulator) inhibitDevice(uid string, inhibit bool) *dbus.Error {
+	for _, m := range s.modems {
+		if m.device() != uid {
+			continue
+		}
+		var err error
+		if inhibit {
+			err = s.unplug(m)
+		} else {
+			err = s.plug(m)
+		}
+		if err != nil {
+			return dbus.MakeFailedError(err)
+		}
+		return nil
+	}
+	return dbus.NewError(errorNotFound, []any{fmt.Sprintf("device %s not found", uid)})
+}
+
+func (s *Simulator) plug(m *virtualModem) error {
+	m.mutex.Lock()
+	plugged := m.plugged
+	m.mutex.Unlock()
+	if plugged {
+		return nil
+	}
+	// A freshly plugged modem starts disabled, the bot is expected to enable it.
+	if err := m.export(modem.ModemStateDisabled); err != nil {
+		return err
+	}
+	slog.Info("[Simulator] Modem plugged in", "modem", m.spec.ID, "path", m.path)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return s.conn.Emit(modem.ModemManagerObjectPath, modem.ModemManagerInterfacesAdded, m.path, m.interfaces())
+}
+
+func (s *Simulator) unplug(m *virtualModem) error {
+	m.mutex.Lock()
+	plugged, path := m.plugged, m.path
+	m.mutex.Unlock()
+	if !plugged {
+		return nil
+	}
+	m.unexport()
+	slog.Info("[Simulator] Modem unplugged", "modem", m.spec.ID, "path", path)
+	return s.conn.Emit(modem.ModemManagerObjectPath, modem.ModemManagerInterfacesRemoved, path, []string{
+		modem.ModemInterface,
+		modem.Modem3GPPInterface,
+		modem.Modem3GPPInterface + ".Ussd",
+		modem.ModemMessagingInterface,
+		modem.ModemVoiceInterface,
+		modem.ModemCellBroadcastInterface,
+	})
+}
+
+// Start schedules the events of the scenario relative to now.
+func (s *Simulator) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, e := range s.scenario.Events {
+		s.timers = append(s.timers, time.AfterFunc(e.At.Duration, func() {
+			if err := s.apply(e); err != nil {
+				slog.Error("[Simulator] Failed to apply event", "type", e.Type, "modem", e.Modem, "error", err)
+			}
+		}))
+	}
+}
+
+func (s *Simulator) apply(e *Event) error {
+	m := s.modems[e.Modem]
+	slog.Debug("[Simulator] Applying event", "type", e.Type, "modem", e.Modem)
+	switch e.Type {
+	case EventTypeSMS:
+		data, err := hex.DecodeString(e.Data)
+		if err != nil {
+			return err
+		}
+		count := max(e.Count, 1)
+		for idx := range count {
+			text := e.Text
+			if count > 1 {
+				text = fmt.Sprintf("%s (%d/%d)", e.Text, idx+1, count)
+			}
+			if err := m.receive(e.From, text, data, e.Flash); err != nil {
+				return err
+			}
+			if idx < count-1 {
+				time.Sleep(e.Interval.Duration)
+			}
+		}
+	case EventTypeUSSD:
+		return m.pushUSSD(e.USSD)
+	case EventTypeBroadcast:
+		return m.broadcast(e.Channel, e.Text)
+	case EventTypeCall:
+		return m.ring(e.From, util.If(e.Ring.Duration > 0, e.Ring.Duration, defaultRing))
+	case EventTypeUnplug:
+		return s.unplug(m)
+	case EventTypePlug:
+		return s.plug(m)
+	case EventTypeSignal:
+		m.update(func(spec *ModemSpec) { spec.Signal = e.Signal })
+	case EventTypeRegistration:
+		m.update(func(spec *ModemSpec) {
+			spec.Registration = e.Registration
+			spec.OperatorCode = util.If(e.OperatorCode != "", e.OperatorCode, spec.OperatorCode)
+			spec.OperatorName = util.If(e.OperatorName != "", e.OperatorName, spec.OperatorName)
+			if len(e.AccessTechnologies) > 0 {
+				spec.AccessTechnologies = e.AccessTechnologies
+			}
+		})
+	}
+	return nil
+}
+
+func (s *Simulator) Close() error {
+	s.mutex.Lock()
+	for _, timer := range s.timers {
+		timer.Stop()
+	}
+	s.mutex.Unlock()
+	var err error
+	for _, m := range s.modems {
+		err = errors.Join(err, m.port.Close())
+	}
+	if s.conn != nil {
+		err = errors.Join(err, s.conn.Close())
+	}
+	return errors.Join(err, s.bus.Close())
+}
@@ -0,0 +1,250 @@
+package ussd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+)
+
+const (
+	DefaultAlertInterval   = 24 * time.Hour
+	DefaultAlertExpiryDays = 3
+)
+
+// expiryLayouts are the date formats tried on the expiry of a balance, day first as most operators write it.
+var expiryLayouts = []string{
+	"2006-01-02",
+	"02/01/2006",
+	"2/1/2006",
+	"02/01/06",
+	"02.01.2006",
+	"2.1.2006",
+	"02-01-2006",
+}
+
+// Alert is the balance check schedule of a single SIM and the limits the admins are warned about.
+type Alert struct {
+	ICCID string `json:"iccid"`
+	// Threshold is the balance below which an alert is sent, zero disables it.
+	Threshold float64 `json:"threshold,omitempty"`
+	// ExpiryDays is how many days before the expiry an alert is sent, 3 by default.
+	ExpiryDays int `json:"expiryDays,omitempty"`
+	// ExpiryLayout is the Go time layout of the expiry, if it is none of the common ones.
+	ExpiryLayout string `json:"expiryLayout,omitempty"`
+	// Interval is how often the balance is checked, e.g. 12h, 24h by default.
+	Interval string `json:"interval,omitempty"`
+}
+
+func (a *Alert) interval() time.Duration {
+	if interval, err := time.ParseDuration(a.Interval); err == nil && interval > 0 {
+		return interval
+	}
+	return DefaultAlertInterval
+}
+
+// Expiry parses the expiry of a balance with the layout of the alert or the common ones.
+func (a *Alert) Expiry(expiry string) (time.Time, error) {
+	layouts := expiryLayouts
+	if a.ExpiryLayout != "" {
+		layouts = []string{a.ExpiryLayout}
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, expiry, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown expiry format: %s", expiry)
+}
+
+// Check returns why the balance needs the attention of the admins, nothing if it doesn't.
+func (a *Alert) Check(balance *Balance, now time.Time) []string {
+	var reasons []string
+	if a.Threshold > 0 && balance.Amount < a.Threshold {
+		reasons = append(reasons, fmt.Sprintf("The balance %.2f is below %.2f.", balance.Amount, a.Threshold))
+	}
+	if balance.Expiry == "" {
+		return reasons
+	}
+	expiry, err := a.Expiry(balance.Expiry)
+	if err != nil {
+		slog.Warn("Failed to parse balance expiry", "iccid", balance.ICCID, "error", err)
+		return reasons
+	}
+	// The SIM is valid through the whole day of the expiry.
+	days := int(math.Ceil(expiry.AddDate(0, 0, 1).Sub(now).Hours()/24)) - 1
+	switch {
+	case days < 0:
+		reasons = append(reasons, fmt.Sprintf("The validity expired on %s.", balance.Expiry))
+	case days == 0:
+		reasons = append(reasons, fmt.Sprintf("The validity expires today (%s).", balance.Expiry))
+	case days <= a.expiryDays():
+		reasons = append(reasons, fmt.Sprintf("The validity expires in %d day(s) on %s.", days, balance.Expiry))
+	}
+	return reasons
+}
+
+func (a *Alert) expiryDays() int {
+	if a.ExpiryDays > 0 {
+		return a.ExpiryDays
+	}
+	return DefaultAlertExpiryDays
+}
+
+// Alerts is the file the alerts are configured in, it is read on every check so it can be edited at any time.
+type Alerts struct {
+	path string
+}
+
+type alertsFile struct {
+	Alerts []*Alert `json:"alerts"`
+}
+
+func NewAlerts(path string) *Alerts {
+	return &Alerts{path: path}
+}
+
+func (a *Alerts) List() ([]*Alert, error) {
+	data, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f alertsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", a.path, err)
+	}
+	return f.Alerts, nil
+}
+
+// Monitor checks the balance of the SIMs with an alert on their schedule and notifies when a limit is reached.
+type Monitor struct {
+	mm        *modem.Manager
+	alerts    *Alerts
+	shortcuts *Shortcuts
+	balances  *Balances
+	notify    func(m *modem.Modem, balance *Balance, reasons []string) error
+	mutex     sync.Mutex
+	// notified keeps the reasons last sent per ICCID, so the same alert isn't repeated on every check.
+	notified map[string]string
+	// attempted keeps when the balance was last checked per ICCID, a failed check is only recorded here
+	// and waits for the interval too, instead of querying the network on every tick.
+	attempted map[string]time.Time
+}
+
+func NewMonitor(mm *modem.Manager, alerts *Alerts, shortcuts *Shortcuts, balances *Balances, notify func(m *modem.Modem, balance *Balance, reasons []string) error) *Monitor {
+	return &Monitor{
+		mm:        mm,
+		alerts:    alerts,
+		shortcuts: shortcuts,
+		balances:  balances,
+		notify:    notify,
+		notified:  make(map[string]string),
+		attempted: make(map[string]time.Time),
+	}
+}
+
+// Run checks the schedules every minute until the context is cancelled.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		if err := m.check(); err != nil {
+			slog.Error("Failed to check balances", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) check() error {
+	alerts, err := m.alerts.List()
+	if err != nil || len(alerts) == 0 {
+		return err
+	}
+	latest, err := m.balances.Latest()
+	if err != nil {
+		return err
+	}
+	modems, err := m.mm.Modems()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, alert := range alerts {
+		for _, mo := range modems {
+			if mo.Sim == nil || mo.Sim.Identifier != alert.ICCID {
+				continue
+			}
+			if last, ok := latest[alert.ICCID]; ok && time.Since(last.CheckedAt) < alert.interval() {
+				continue
+			}
+			if !m.attempt(alert) {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.checkBalance(mo, alert)
+			}()
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// attempt records a check of the SIM, unless the last one is less than the interval ago.
+func (m *Monitor) attempt(alert *Alert) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if time.Since(m.attempted[alert.ICCID]) < alert.interval() {
+		return false
+	}
+	m.attempted[alert.ICCID] = time.Now()
+	return true
+}
+
+func (m *Monitor) checkBalance(mo *modem.Modem, alert *Alert) {
+	balance, err := CheckBalance(mo, m.shortcuts, m.balances)
+	if errors.Is(err, ErrUSSDBusy) {
+		// The admins are using USSD, the check is postponed to the next tick rather than the next interval.
+		slog.Info("Balance check postponed, a USSD session is in progress", "modem", mo.EquipmentIdentifier, "iccid", alert.ICCID)
+		m.mutex.Lock()
+		delete(m.attempted, alert.ICCID)
+		m.mutex.Unlock()
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to check balance", "modem", mo.EquipmentIdentifier, "iccid", alert.ICCID, "error", err)
+		return
+	}
+	reasons := alert.Check(balance, time.Now())
+	key := strings.Join(reasons, "\n")
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if key == m.notified[alert.ICCID] {
+		return
+	}
+	if key == "" {
+		delete(m.notified, alert.ICCID)
+		return
+	}
+	if err := m.notify(mo, balance, reasons); err != nil {
+		slog.Error("Failed to send balance alert", "iccid", alert.ICCID, "error", err)
+		return
+	}
+	m.notified[alert.ICCID] = key
+}
@@ -0,0 +1,182 @@
+package ussd
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/simulator"
+)
+
+const testICCID = "8939100000000000077"
+
+func writeJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMonitorBacksOffAfterFailedCheck(t *testing.T) {
+	sim, err := simulator.New(&simulator.Scenario{Modems: []*simulator.ModemSpec{{
+		ID:                 "alpha",
+		IMEI:               "861234567890123",
+		OperatorCode:       "22210",
+		OperatorName:       "Vodafone",
+		Registration:       "home",
+		AccessTechnologies: []string{"lte"},
+		SIM:                simulator.SIMSpec{ICCID: testICCID, IMSI: "222100000000077", OperatorIdentifier: "22210"},
+		USSD: map[string]*simulator.USSDMenu{
+			"*100#": {Text: "Your balance is 1.50 EUR."},
+			"*101#": {Text: "Service temporarily unavailable."},
+			"*123#": {Text: "1. Offers", Options: map[string]*simulator.USSDMenu{"1": {Text: "No offers."}}},
+		},
+	}}})
+	if err != nil {
+		t.Skipf("the simulator can't be started: %v", err)
+	}
+	defer sim.Close()
+	t.Setenv("DBUS_SYSTEM_BUS_ADDRESS", sim.Address)
+	mm, err := modem.NewManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	alertsPath, shortcutsPath := filepath.Join(dir, "alerts.json"), filepath.Join(dir, "shortcuts.json")
+	writeJSON(t, alertsPath, alertsFile{Alerts: []*Alert{{ICCID: testICCID, Threshold: 2, Interval: "1h"}}})
+	// The network doesn't answer with a balance, the check fails.
+	writeJSON(t, shortcutsPath, map[string]any{"shortcuts": []*Shortcut{{Name: BalanceShortcut, Code: "*101#", ICCID: testICCID}}})
+	balances := NewBalances(filepath.Join(dir, "balances.jsonl"))
+	var notified [][]string
+	monitor := NewMonitor(mm, NewAlerts(alertsPath), NewShortcuts(shortcutsPath), balances, func(m *modem.Modem, balance *Balance, reasons []string) error {
+		notified = append(notified, reasons)
+		return nil
+	})
+
+	if err := monitor.check(); err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	// Even though the shortcut works now, the failed check must not be repeated before the interval.
+	writeJSON(t, shortcutsPath, map[string]any{"shortcuts": []*Shortcut{{Name: BalanceShortcut, Code: "*100#", ICCID: testICCID}}})
+	if err := monitor.check(); err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	if history, _ := balances.History(testICCID); len(history) != 0 || len(notified) != 0 {
+		t.Fatalf("the balance was checked again within the interval: %d records, %d alerts", len(history), len(notified))
+	}
+
+	writeJSON(t, alertsPath, alertsFile{Alerts: []*Alert{{ICCID: testICCID, Threshold: 2, Interval: "1ns"}}})
+	// An admin is in a USSD menu, the check waits for it instead of cancelling it.
+	modems, err := mm.Modems()
+	if err != nil || len(modems) != 1 {
+		t.Fatalf("Modems() = %v, %v, want one modem", modems, err)
+	}
+	m := slices.Collect(maps.Values(modems))[0]
+	if _, err := m.InitiateUSSD("*123#"); err != nil {
+		t.Fatalf("InitiateUSSD() error = %v", err)
+	}
+	if err := monitor.check(); err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	if state, err := m.USSDState(); err != nil || state != modem.Modem3gppUssdSessionStateUserResponse {
+		t.Fatalf("USSDState() = %v, %v, want the session of the admin still open", state, err)
+	}
+	if history, _ := balances.History(testICCID); len(history) != 0 {
+		t.Fatalf("the balance was checked during the USSD session of the admin: %d records", len(history))
+	}
+	if err := m.CancelUSSD(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := monitor.check(); err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	history, err := balances.History(testICCID)
+	if err != nil || len(history) != 1 || history[0].Amount != 1.5 {
+		t.Fatalf("History() = %v, %v, want the balance of 1.50 once the interval passed", history, err)
+	}
+	if len(notified) != 1 {
+		t.Fatalf("%d alerts sent, want 1", len(notified))
+	}
+}
+
+func TestAlertCheck(t *testing.T) {
+	now := time.Date(2026, 10, 19, 15, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		alert   Alert
+		balance Balance
+		want    []string
+	}{
+		{name: "nothing to report", alert: Alert{Threshold: 2}, balance: Balance{Amount: 5, Expiry: "2026-12-31"}},
+		{name: "below threshold", alert: Alert{Threshold: 2}, balance: Balance{Amount: 1.5}, want: []string{"below 2.00"}},
+		{name: "threshold disabled", balance: Balance{Amount: 0}},
+		{name: "expired yesterday", balance: Balance{Amount: 5, Expiry: "2026-10-18"}, want: []string{"expired on 2026-10-18"}},
+		{name: "expires today", balance: Balance{Amount: 5, Expiry: "2026-10-19"}, want: []string{"expires today"}},
+		{name: "expires tomorrow", balance: Balance{Amount: 5, Expiry: "20/10/2026"}, want: []string{"in 1 day(s)"}},
+		{name: "last day of the default window", balance: Balance{Amount: 5, Expiry: "2026-10-22"}, want: []string{"in 3 day(s)"}},
+		{name: "outside the default window", balance: Balance{Amount: 5, Expiry: "2026-10-23"}},
+		{name: "wider window", alert: Alert{ExpiryDays: 7}, balance: Balance{Amount: 5, Expiry: "2026-10-23"}, want: []string{"in 4 day(s)"}},
+		{name: "unknown expiry format", balance: Balance{Amount: 5, Expiry: "next week"}},
+		{
+			name:    "both",
+			alert:   Alert{Threshold: 10},
+			balance: Balance{Amount: 1, Expiry: "2026-10-01"},
+			want:    []string{"below 10.00", "expired"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons := tt.alert.Check(&tt.balance, now)
+			if len(reasons) != len(tt.want) {
+				t.Fatalf("Check() = %q, want %q", reasons, tt.want)
+			}
+			for idx, want := range tt.want {
+				if !strings.Contains(reasons[idx], want) {
+					t.Errorf("Check()[%d] = %q, want %q", idx, reasons[idx], want)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertExpiry(t *testing.T) {
+	tests := []struct {
+		expiry  string
+		layout  string
+		want    time.Time
+		wantErr bool
+	}{
+		{expiry: "2026-12-31", want: time.Date(2026, 12, 31, 0, 0, 0, 0, time.Local)},
+		{expiry: "31/12/2026", want: time.Date(2026, 12, 31, 0, 0, 0, 0, time.Local)},
+		{expiry: "1/2/2026", want: time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local)},
+		{expiry: "31/12/26", want: time.Date(2026, 12, 31, 0, 0, 0, 0, time.Local)},
+		{expiry: "31.12.2026", want: time.Date(2026, 12, 31, 0, 0, 0, 0, time.Local)},
+		{expiry: "1.2.2026", want: time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local)},
+		{expiry: "31-12-2026", want: time.Date(2026, 12, 31, 0, 0, 0, 0, time.Local)},
+		{expiry: "12/31/2026", wantErr: true},
+		{expiry: "2026/12/31", wantErr: true},
+		{expiry: "", wantErr: true},
+		{expiry: "12/31/2026", layout: "01/02/2006", want: time.Date(2026, 12, 31, 0, 0, 0, 0, time.Local)},
+		// The layout of the alert replaces the common ones.
+		{expiry: "2026-12-31", layout: "01/02/2006", wantErr: true},
+	}
+	for _, tt := range tests {
+		alert := Alert{ExpiryLayout: tt.layout}
+		got, err := alert.Expiry(tt.expiry)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("Expiry(%q) with layout %q = %v, %v, want %v", tt.expiry, tt.layout, got, err, tt.want)
+		}
+	}
+}
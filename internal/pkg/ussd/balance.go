@@ -0,0 +1,96 @@
+package ussd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Balance is the result of a single balance check.
+type Balance struct {
+	ICCID     string    `json:"iccid"`
+	IMEI      string    `json:"imei"`
+	Operator  string    `json:"operator"`
+	Amount    float64   `json:"amount"`
+	Expiry    string    `json:"expiry,omitempty"`
+	Response  string    `json:"response"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Balances is the balance history, one JSON record per line appended on every check.
+type Balances struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewBalances(path string) *Balances {
+	return &Balances{path: path}
+}
+
+func (b *Balances) Append(balance *Balance) error {
+	data, err := json.Marshal(balance)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// History returns the checks of a SIM, oldest first.
+func (b *Balances) History(iccid string) ([]*Balance, error) {
+	var history []*Balance
+	err := b.each(func(balance *Balance) {
+		if balance.ICCID == iccid {
+			history = append(history, balance)
+		}
+	})
+	return history, err
+}
+
+// Latest returns the last check of every SIM, keyed by ICCID.
+func (b *Balances) Latest() (map[string]*Balance, error) {
+	latest := make(map[string]*Balance)
+	err := b.each(func(balance *Balance) {
+		latest[balance.ICCID] = balance
+	})
+	return latest, err
+}
+
+func (b *Balances) each(fn func(balance *Balance)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	f, err := os.Open(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var balance Balance
+		// A line cut short by a crash is skipped instead of hiding the whole history.
+		if err := json.Unmarshal(scanner.Bytes(), &balance); err != nil {
+			continue
+		}
+		fn(&balance)
+	}
+	return scanner.Err()
+}
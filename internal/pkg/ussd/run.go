@@ -0,0 +1,73 @@
+package ussd
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+)
+
+// ErrUSSDBusy is returned when another USSD session is open, e.g. a /ussd menu or a network request.
+var ErrUSSDBusy = errors.New("another USSD session is in progress, try again once it has ended")
+
+// Run executes a one-shot USSD code, a session left open by the network is cancelled afterwards.
+// A session that is already open belongs to someone else and is left alone, ErrUSSDBusy is returned instead.
+func Run(m *modem.Modem, code string) (string, error) {
+	if state, err := m.USSDState(); err == nil && state != modem.Modem3gppUssdSessionStateIdle {
+		return "", ErrUSSDBusy
+	}
+	response, err := m.InitiateUSSD(code)
+	if err != nil {
+		return "", err
+	}
+	if state, err := m.USSDState(); err == nil && state != modem.Modem3gppUssdSessionStateIdle {
+		if err := m.CancelUSSD(); err != nil {
+			slog.Warn("Failed to cancel USSD session", "error", err)
+		}
+	}
+	return response, nil
+}
+
+// Operator returns the MCC/MNC of the network the modem is registered to, or of the SIM if it is not registered.
+func Operator(m *modem.Modem) string {
+	if code, err := m.OperatorCode(); err == nil && code != "" {
+		return code
+	}
+	return m.Sim.OperatorIdentifier
+}
+
+// Shortcut finds the shortcut for the SIM in the modem, by ICCID, then by the network's and the SIM's operator.
+func (s *Shortcuts) Shortcut(name string, m *modem.Modem) (*Shortcut, error) {
+	shortcut, err := s.Find(name, m.Sim.Identifier, Operator(m))
+	if errors.Is(err, ErrShortcutNotFound) && m.Sim.OperatorIdentifier != Operator(m) {
+		return s.Find(name, m.Sim.Identifier, m.Sim.OperatorIdentifier)
+	}
+	return shortcut, err
+}
+
+// CheckBalance runs the balance shortcut on the modem and appends the result to the history.
+func CheckBalance(m *modem.Modem, shortcuts *Shortcuts, balances *Balances) (*Balance, error) {
+	shortcut, err := shortcuts.Shortcut(BalanceShortcut, m)
+	if err != nil {
+		return nil, err
+	}
+	response, err := Run(m, shortcut.Code)
+	if err != nil {
+		return nil, err
+	}
+	balance := &Balance{
+		ICCID:     m.Sim.Identifier,
+		IMEI:      m.EquipmentIdentifier,
+		Operator:  Operator(m),
+		Response:  response,
+		CheckedAt: time.Now(),
+	}
+	if balance.Amount, balance.Expiry, err = shortcut.Parse(response); err != nil {
+		return balance, err
+	}
+	if err := balances.Append(balance); err != nil {
+		return balance, err
+	}
+	return balance, nil
+}
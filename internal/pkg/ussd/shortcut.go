@@ -0,0 +1,188 @@
+package ussd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+// BalanceShortcut is the name of the shortcut /balance runs on every modem.
+const BalanceShortcut = "balance"
+
+var (
+	// The amount is the first number following the word balance, e.g. "Your balance is $12.34".
+	defaultAmount = regexp.MustCompile(`(?i)balance\D*?(\d+(?:[.,]\d+)?)`)
+	// The expiry is the first date following valid or expire, e.g. "valid until 2026-12-31".
+	defaultExpiry = regexp.MustCompile(`(?i)(?:valid|expir\w*)\D*?(\d{4}-\d{2}-\d{2}|\d{1,2}[./-]\d{1,2}[./-]\d{2,4})`)
+)
+
+var ErrShortcutNotFound = errors.New("shortcut not found")
+
+// Shortcut is a saved USSD code, bound either to a single SIM or to every SIM of an operator.
+type Shortcut struct {
+	Name string `json:"name"`
+	Code string `json:"code"`
+	// ICCID binds the shortcut to a single SIM, it takes precedence over Operator.
+	ICCID string `json:"iccid,omitempty"`
+	// Operator is the MCC/MNC of the network, e.g. 310260.
+	Operator string `json:"operator,omitempty"`
+	// Amount and Expiry are regular expressions whose first group captures the value in the response.
+	Amount string `json:"amount,omitempty"`
+	Expiry string `json:"expiry,omitempty"`
+}
+
+// Scope describes what the shortcut is bound to.
+func (s *Shortcut) Scope() string {
+	if s.ICCID != "" {
+		return "ICCID " + s.ICCID
+	}
+	return "operator " + s.Operator
+}
+
+// Parse extracts the amount and the expiry from the response of a balance shortcut.
+// The expiry is optional, a response without an amount is an error.
+func (s *Shortcut) Parse(response string) (amount float64, expiry string, err error) {
+	amountPattern, expiryPattern := defaultAmount, defaultExpiry
+	if s.Amount != "" {
+		if amountPattern, err = regexp.Compile(s.Amount); err != nil {
+			return 0, "", fmt.Errorf("invalid amount pattern: %w", err)
+		}
+	}
+	if s.Expiry != "" {
+		if expiryPattern, err = regexp.Compile(s.Expiry); err != nil {
+			return 0, "", fmt.Errorf("invalid expiry pattern: %w", err)
+		}
+	}
+	if match := expiryPattern.FindStringSubmatch(response); len(match) > 1 {
+		expiry = match[1]
+	}
+	match := amountPattern.FindStringSubmatch(response)
+	if len(match) < 2 {
+		return 0, expiry, errors.New("no amount found in the response")
+	}
+	amount, err = strconv.ParseFloat(strings.ReplaceAll(match[1], ",", "."), 64)
+	return amount, expiry, err
+}
+
+// Shortcuts is the file the shortcuts are saved in.
+// The file is read on every call, so it can be edited by hand while the bot is running.
+type Shortcuts struct {
+	path  string
+	mutex sync.Mutex
+}
+
+type shortcutsFile struct {
+	Shortcuts []*Shortcut `json:"shortcuts"`
+}
+
+func NewShortcuts(path string) *Shortcuts {
+	return &Shortcuts{path: path}
+}
+
+func (s *Shortcuts) List() ([]*Shortcut, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.load()
+}
+
+// For returns the shortcuts applicable to a SIM, a shortcut bound to the ICCID hides the operator's one of the same name.
+func (s *Shortcuts) For(iccid string, operator string) ([]*Shortcut, error) {
+	shortcuts, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var applicable []*Shortcut
+	for _, shortcut := range shortcuts {
+		if shortcut.ICCID != "" && shortcut.ICCID == iccid {
+			applicable = slices.DeleteFunc(applicable, func(a *Shortcut) bool { return a.Name == shortcut.Name })
+			applicable = append(applicable, shortcut)
+		}
+	}
+	for _, shortcut := range shortcuts {
+		if shortcut.ICCID == "" && shortcut.Operator == operator &&
+			!slices.ContainsFunc(applicable, func(a *Shortcut) bool { return a.Name == shortcut.Name }) {
+			applicable = append(applicable, shortcut)
+		}
+	}
+	return applicable, nil
+}
+
+func (s *Shortcuts) Find(name string, iccid string, operator string) (*Shortcut, error) {
+	shortcuts, err := s.For(iccid, operator)
+	if err != nil {
+		return nil, err
+	}
+	for _, shortcut := range shortcuts {
+		if shortcut.Name == name {
+			return shortcut, nil
+		}
+	}
+	return nil, ErrShortcutNotFound
+}
+
+// Save adds the shortcut or replaces the one with the same name and scope.
+func (s *Shortcuts) Save(shortcut *Shortcut) error {
+	if shortcut.Name == "" || shortcut.Code == "" {
+		return errors.New("a shortcut needs a name and a code")
+	}
+	if (shortcut.ICCID == "") == (shortcut.Operator == "") {
+		return errors.New("a shortcut is bound to either an ICCID or an operator")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	shortcuts, err := s.load()
+	if err != nil {
+		return err
+	}
+	shortcuts = slices.DeleteFunc(shortcuts, func(a *Shortcut) bool { return a.same(shortcut) })
+	return s.store(append(shortcuts, shortcut))
+}
+
+func (s *Shortcuts) Delete(shortcut *Shortcut) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	shortcuts, err := s.load()
+	if err != nil {
+		return err
+	}
+	n := len(shortcuts)
+	if shortcuts = slices.DeleteFunc(shortcuts, func(a *Shortcut) bool { return a.same(shortcut) }); len(shortcuts) == n {
+		return ErrShortcutNotFound
+	}
+	return s.store(shortcuts)
+}
+
+func (s *Shortcut) same(other *Shortcut) bool {
+	return s.Name == other.Name && s.ICCID == other.ICCID && s.Operator == other.Operator
+}
+
+func (s *Shortcuts) load() ([]*Shortcut, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f shortcutsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return f.Shortcuts, nil
+}
+
+func (s *Shortcuts) store(shortcuts []*Shortcut) error {
+	data, err := json.MarshalIndent(shortcutsFile{Shortcuts: shortcuts}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFile(s.path, data)
+}
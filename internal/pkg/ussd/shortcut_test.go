@@ -0,0 +1,130 @@
+package ussd
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShortcutParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		shortcut Shortcut
+		response string
+		amount   float64
+		expiry   string
+		wantErr  string
+	}{
+		{
+			name:     "default",
+			response: "Your balance is $12.34, valid until 2026-12-31.",
+			amount:   12.34,
+			expiry:   "2026-12-31",
+		},
+		{
+			name:     "comma decimals",
+			response: "Saldo/Balance: 5,50 EUR. Scadenza/Expiry 31/12/2026",
+			amount:   5.5,
+			expiry:   "31/12/2026",
+		},
+		{
+			name:     "integer and short year",
+			response: "BALANCE 20 USD, expires on 1.2.27",
+			amount:   20,
+			expiry:   "1.2.27",
+		},
+		{name: "no expiry", response: "Your balance is 3.00", amount: 3},
+		{name: "no amount", response: "Valid until 2026-12-31.", expiry: "2026-12-31", wantErr: "no amount found"},
+		{
+			name:     "custom patterns",
+			shortcut: Shortcut{Amount: `Credito:\s*(\d+)`, Expiry: `fino al (\d{2}/\d{2}/\d{4})`},
+			response: "Credito: 7 euro, fino al 01/02/2027. Balance 99",
+			amount:   7,
+			expiry:   "01/02/2027",
+		},
+		{
+			name:     "custom amount without group",
+			shortcut: Shortcut{Amount: `Credito:\s*\d+`},
+			response: "Credito: 7 euro",
+			wantErr:  "no amount found",
+		},
+		{
+			name:     "custom expiry without group",
+			shortcut: Shortcut{Expiry: `valid until \S+`},
+			response: "Your balance is 1.00, valid until 2026-12-31",
+			amount:   1,
+		},
+		{name: "invalid amount pattern", shortcut: Shortcut{Amount: `(`}, response: "balance 1", wantErr: "invalid amount pattern"},
+		{name: "invalid expiry pattern", shortcut: Shortcut{Expiry: `[`}, response: "balance 1", wantErr: "invalid expiry pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amount, expiry, err := tt.shortcut.Parse(tt.response)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if amount != tt.amount || expiry != tt.expiry {
+				t.Errorf("Parse() = %v, %q, want %v, %q", amount, expiry, tt.amount, tt.expiry)
+			}
+		})
+	}
+}
+
+func TestShortcutsFor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shortcuts.json")
+	writeJSON(t, path, shortcutsFile{Shortcuts: []*Shortcut{
+		{Name: "balance", Code: "*100#", Operator: "22210"},
+		{Name: "balance", Code: "*101#", ICCID: testICCID},
+		{Name: "offers", Code: "*121#", Operator: "22210"},
+		{Name: "data", Code: "*200#", ICCID: "8939100000000000099"},
+		{Name: "roaming", Code: "*300#", Operator: "22201"},
+	}})
+	shortcuts := NewShortcuts(path)
+	tests := []struct {
+		name     string
+		iccid    string
+		operator string
+		codes    map[string]string
+	}{
+		{name: "ICCID over operator", iccid: testICCID, operator: "22210", codes: map[string]string{"balance": "*101#", "offers": "*121#"}},
+		{name: "operator only", iccid: "8939100000000000088", operator: "22210", codes: map[string]string{"balance": "*100#", "offers": "*121#"}},
+		{name: "ICCID on another network", iccid: testICCID, operator: "22201", codes: map[string]string{"balance": "*101#", "roaming": "*300#"}},
+		{name: "nothing applicable", iccid: "8939100000000000088", operator: "310260", codes: map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applicable, err := shortcuts.For(tt.iccid, tt.operator)
+			if err != nil {
+				t.Fatalf("For() error = %v", err)
+			}
+			codes := make(map[string]string, len(applicable))
+			for _, shortcut := range applicable {
+				if _, ok := codes[shortcut.Name]; ok {
+					t.Errorf("For() returned %s twice", shortcut.Name)
+				}
+				codes[shortcut.Name] = shortcut.Code
+			}
+			if len(codes) != len(tt.codes) {
+				t.Fatalf("For() = %v, want %v", codes, tt.codes)
+			}
+			for name, code := range tt.codes {
+				if codes[name] != code {
+					t.Errorf("For() %s = %q, want %q", name, codes[name], code)
+				}
+			}
+			shortcut, err := shortcuts.Find("balance", tt.iccid, tt.operator)
+			if want, ok := tt.codes["balance"]; ok != (err == nil) || (ok && shortcut.Code != want) {
+				t.Errorf("Find() = %v, %v, want %q", shortcut, err, want)
+			}
+		})
+	}
+
+	missing, err := NewShortcuts(filepath.Join(t.TempDir(), "missing.json")).For(testICCID, "22210")
+	if err != nil || len(missing) != 0 {
+		t.Errorf("For() without a file = %v, %v, want nothing", missing, err)
+	}
+}
@@ -5,6 +5,7 @@ package util
 import (
 	_ "embed"
 	"encoding/json"
+	"strings"
 )
 
 //go:embed carrier.json
@@ -16,21 +17,49 @@ type Carrier struct {
 	MccmncTuple map[string][]string `json:"mccmnc_tuple,omitempty"`
 }
 
-var dictionary map[string]string
+var (
+	dictionary map[string]string
+	// countries maps the MCC/MNC and, for networks missing from the list, the MCC alone to an ISO 3166 country code.
+	countries map[string]string
+)
 
 func init() {
 	dictionary = make(map[string]string)
+	countries = make(map[string]string)
 	var c []Carrier
 	if err := json.Unmarshal(carrier, &c); err != nil {
 		panic(err)
 	}
+	// An MCC is shared by a few territories, it is attributed to the country with the most networks.
+	mcc := make(map[string]map[string]int)
 	for _, v := range c {
-		for _, tuple := range v.MccmncTuple {
+		for country, tuple := range v.MccmncTuple {
 			for _, mccmnc := range tuple {
 				dictionary[mccmnc] = v.Operator
+				if country == "XX" || len(mccmnc) < 5 {
+					continue
+				}
+				countries[mccmnc] = country
+				if mcc[mccmnc[:3]] == nil {
+					mcc[mccmnc[:3]] = make(map[string]int)
+				}
+				mcc[mccmnc[:3]][country]++
 			}
 		}
 	}
+	for code, count := range mcc {
+		countries[code] = maxKey(count)
+	}
+}
+
+func maxKey(count map[string]int) string {
+	var key string
+	for k, n := range count {
+		if n > count[key] || n == count[key] && k < key {
+			key = k
+		}
+	}
+	return key
 }
 
 func LookupCarrier(mccmnc string) string {
@@ -39,3 +68,29 @@ func LookupCarrier(mccmnc string) string {
 	}
 	return "Unknown"
 }
+
+// LookupCountry returns the ISO 3166 country code of a network, or an empty string if it is unknown.
+func LookupCountry(mccmnc string) string {
+	if country, ok := countries[mccmnc]; ok {
+		return country
+	}
+	if len(mccmnc) >= 3 {
+		return countries[mccmnc[:3]]
+	}
+	return ""
+}
+
+// CountryFlag returns the flag emoji of an ISO 3166 country code.
+func CountryFlag(country string) string {
+	if len(country) != 2 {
+		return ""
+	}
+	var flag []rune
+	for _, r := range strings.ToUpper(country) {
+		if r < 'A' || r > 'Z' {
+			return ""
+		}
+		flag = append(flag, 0x1F1E6+r-'A')
+	}
+	return string(flag)
+}
@@ -0,0 +1,19 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces the file atomically, so a crash never leaves a truncated file behind.
+// It is readable by the owner only as it may hold secrets, the directory is created if needed.
+func WriteFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,120 @@
+package voice
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/util"
+)
+
+// HangupDelay is how long an automatically answered call lasts, long enough for the caller to notice.
+const HangupDelay = 3 * time.Second
+
+// Record is an incoming call, as the admins are told about it once it has ended.
+type Record struct {
+	Number string
+	// Name is the contact the number belongs to, if any.
+	Name    string
+	Started time.Time
+	// Answered is zero if the call was missed.
+	Answered time.Time
+	Ended    time.Time
+	Policy   config.CallPolicy
+	Reason   modem.CallStateReason
+}
+
+func (r *Record) Missed() bool {
+	return r.Answered.IsZero()
+}
+
+// String describes the outcome of the call, e.g. "Missed call from +14155550100".
+func (r *Record) String() string {
+	from := util.If(r.Number != "", r.Number, "a withheld number")
+	if r.Name != "" {
+		from = fmt.Sprintf("%s (%s)", r.Name, r.Number)
+	}
+	switch {
+	case r.Policy == config.CallPolicyReject:
+		return fmt.Sprintf("Rejected call from %s", from)
+	case r.Policy == config.CallPolicyHangup && !r.Missed():
+		return fmt.Sprintf("Answered and hung up call from %s", from)
+	case r.Missed():
+		return fmt.Sprintf("Missed call from %s, it rang for %s", from, r.Ended.Sub(r.Started).Round(time.Second))
+	default:
+		return fmt.Sprintf("Answered call from %s, it lasted %s", from, r.Ended.Sub(r.Answered).Round(time.Second))
+	}
+}
+
+// Watcher follows the incoming calls of a modem, applies its call policy and reports every call once it has ended.
+type Watcher struct {
+	modem   *modem.Modem
+	notify  func(record *Record)
+	records map[dbus.ObjectPath]*Record
+}
+
+func NewWatcher(m *modem.Modem, notify func(record *Record)) *Watcher {
+	return &Watcher{modem: m, notify: notify, records: make(map[dbus.ObjectPath]*Record)}
+}
+
+// Update is meant to be passed to modem.SubscribeCalls, outgoing calls are ignored.
+func (w *Watcher) Update(call *modem.Call) error {
+	if call.Direction != modem.CallDirectionIncoming {
+		return nil
+	}
+	record, ok := w.records[call.Path]
+	if !ok {
+		record = &Record{Number: call.Number, Started: time.Now()}
+		w.records[call.Path] = record
+		if call.State == modem.CallStateRingingIn {
+			w.apply(call, record)
+		}
+	}
+	switch call.State {
+	case modem.CallStateActive:
+		if record.Missed() {
+			record.Answered = time.Now()
+		}
+	case modem.CallStateTerminated:
+		delete(w.records, call.Path)
+		record.Ended = time.Now()
+		record.Reason = call.StateReason
+		w.notify(record)
+		if err := w.modem.DeleteCall(call.Path); err != nil {
+			slog.Warn("Failed to delete call", "error", err, "path", call.Path)
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) apply(call *modem.Call, record *Record) {
+	policy, err := config.C.CallPolicy(w.modem.EquipmentIdentifier)
+	if err != nil {
+		slog.Error("Failed to get call policy", "error", err, "modem", w.modem.EquipmentIdentifier)
+		return
+	}
+	switch policy {
+	case config.CallPolicyReject:
+		err = w.modem.HangupCall(call.Path)
+	case config.CallPolicyHangup:
+		if err = w.modem.AcceptCall(call.Path); err == nil {
+			time.AfterFunc(HangupDelay, func() {
+				if err := w.modem.HangupCall(call.Path); err != nil {
+					slog.Warn("Failed to hang up call", "error", err, "path", call.Path)
+				}
+			})
+		}
+	default:
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to apply call policy", "error", err, "policy", policy, "path", call.Path)
+		return
+	}
+	record.Policy = policy
+	slog.Info("Call policy applied", "policy", policy, "modem", w.modem.EquipmentIdentifier, "number", call.Number)
+}
@@ -2,16 +2,27 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/damonto/telegram-sms/internal/app"
+	"github.com/damonto/telegram-sms/internal/app/handler"
 	"github.com/damonto/telegram-sms/internal/pkg/config"
+	"github.com/damonto/telegram-sms/internal/pkg/contact"
 	"github.com/damonto/telegram-sms/internal/pkg/modem"
+	"github.com/damonto/telegram-sms/internal/pkg/network"
+	"github.com/damonto/telegram-sms/internal/pkg/simulator"
+	"github.com/damonto/telegram-sms/internal/pkg/ussd"
 	"github.com/damonto/telegram-sms/internal/pkg/util"
+	"github.com/damonto/telegram-sms/internal/pkg/voice"
 	"github.com/godbus/dbus/v5"
 	"github.com/mymmrac/telego"
 	tu "github.com/mymmrac/telego/telegoutil"
@@ -31,6 +42,13 @@ func init() {
 	flag.BoolVar(&config.C.Compatible, "compatible", false, "Enable if your modem does not support proactive refresh")
 	flag.StringVar(&config.C.Endpoint, "endpoint", "https://api.telegram.org", "Telegram Bot API endpoint")
 	flag.BoolVar(&config.C.Verbose, "verbose", false, "Enable verbose logging")
+	flag.BoolVar(&config.C.Simulate, "simulate", false, "Run against a scripted virtual modem fleet instead of ModemManager")
+	flag.StringVar(&config.C.Scenario, "scenario", "", "Simulator scenario file (default: built-in demo scenario)")
+	flag.StringVar(&config.C.DataDir, "data-dir", "/var/lib/telegram-sms", "Directory for saved USSD shortcuts and balance history")
+	flag.StringVar(&config.C.NetworkEvents, "network-events", "registration,roaming,operator,signal,fallback", "Network changes to notify of: registration, roaming, operator, signal and fallback (comma separated, empty to disable)")
+	flag.UintVar(&config.C.SignalThreshold, "signal-threshold", 15, "Signal quality in percent below which the signal is considered weak")
+	flag.DurationVar(&config.C.SignalDuration, "signal-duration", 5*time.Minute, "How long the signal has to stay weak before notifying")
+	flag.DurationVar(&config.C.BulkInterval, "bulk-interval", 5*time.Second, "Pause between the messages sent from a CSV file with /bulk")
 	flag.Parse()
 }
 
@@ -38,7 +56,7 @@ func main() {
 	if config.C.Verbose {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 	}
-	if os.Geteuid() != 0 {
+	if os.Geteuid() != 0 && !config.C.Simulate {
 		slog.Error("Please run as root")
 		os.Exit(1)
 	}
@@ -56,11 +74,21 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if config.C.Simulate {
+		s, err := simulate()
+		if err != nil {
+			panic(err)
+		}
+		defer s.Close()
+	}
 	mm, err := modem.NewManager()
 	if err != nil {
 		panic(err)
 	}
-	go subscribe(bot, mm)
+	book := contact.NewBook(config.C.DataPath("contacts.json"))
+	shortcuts := ussd.NewShortcuts(config.C.DataPath("shortcuts.json"))
+	balances := ussd.NewBalances(config.C.DataPath("balances.jsonl"))
+	go subscribe(bot, mm, book)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
@@ -70,7 +98,17 @@ func main() {
 	}
 	slog.Info("Bot started", "username", me.Username, "id", me.ID)
 
-	app, err := app.New(ctx, bot, mm)
+	go ussd.NewMonitor(
+		mm,
+		ussd.NewAlerts(config.C.DataPath("alerts.json")),
+		shortcuts,
+		balances,
+		func(m *modem.Modem, balance *ussd.Balance, reasons []string) error {
+			return sendBalanceAlert(bot, m, balance, reasons)
+		},
+	).Run(ctx)
+
+	app, err := app.New(ctx, bot, mm, book, shortcuts, balances)
 	if err != nil {
 		panic(err)
 	}
@@ -85,7 +123,34 @@ func main() {
 	slog.Info("Goodbye!")
 }
 
-func subscribe(bot *telego.Bot, mm *modem.Manager) {
+// simulate starts the simulator and points the system bus at it, so it must run before modem.NewManager.
+func simulate() (*simulator.Simulator, error) {
+	// Keep the simulated shortcuts and balances away from a real installation unless asked otherwise.
+	dataDir := filepath.Join(os.TempDir(), "telegram-sms-simulator")
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "data-dir" {
+			dataDir = config.C.DataDir
+		}
+	})
+	config.C.DataDir = dataDir
+	scenario, err := simulator.LoadScenario(config.C.Scenario)
+	if err != nil {
+		return nil, err
+	}
+	s, err := simulator.New(scenario)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", s.Address); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.Start()
+	slog.Info("Simulator started", "address", s.Address, "modems", len(scenario.Modems), "events", len(scenario.Events))
+	return s, nil
+}
+
+func subscribe(bot *telego.Bot, mm *modem.Manager, book *contact.Book) {
 	var err error
 	subscribers := make(map[dbus.ObjectPath]*Subscriber)
 	modems, err := mm.Modems()
@@ -93,14 +158,22 @@ func subscribe(bot *telego.Bot, mm *modem.Manager) {
 		panic(err)
 	}
 
-	go subscribeMessaging(bot, modems, subscribers)
+	for path, m := range modems {
+		if err := m.ApplyRadioDefaults(); err != nil {
+			slog.Error("Failed to apply radio defaults", "error", err, "path", path)
+		}
+		if err := m.ApplyCellBroadcastChannels(); err != nil {
+			slog.Error("Failed to apply cell broadcast channels", "error", err, "path", path)
+		}
+	}
+	go subscribeModems(bot, book, modems, subscribers)
 
 	err = mm.Subscribe(func(modems map[dbus.ObjectPath]*modem.Modem) error {
 		for path, s := range subscribers {
 			slog.Debug("Canceling subscriber", "path", path)
 			s.cancel()
 		}
-		go subscribeMessaging(bot, modems, subscribers)
+		go subscribeModems(bot, book, modems, subscribers)
 		return nil
 	})
 	if err != nil {
@@ -108,13 +181,24 @@ func subscribe(bot *telego.Bot, mm *modem.Manager) {
 	}
 }
 
-func subscribeMessaging(bot *telego.Bot, modems map[dbus.ObjectPath]*modem.Modem, subscribers map[dbus.ObjectPath]*Subscriber) {
+func subscribeModems(bot *telego.Bot, book *contact.Book, modems map[dbus.ObjectPath]*modem.Modem, subscribers map[dbus.ObjectPath]*Subscriber) {
 	for path, m := range modems {
+		if m.State == modem.ModemStateLocked {
+			go func(m *modem.Modem) {
+				if err := unlock(bot, m); err != nil {
+					slog.Error("Failed to unlock SIM", "error", err, "path", path)
+				}
+			}(m)
+		}
+		// Poll the extended signal metrics in the background, so /modem can show them straight away.
+		if err := m.SetupSignal(modem.DefaultSignalRate); err != nil {
+			slog.Warn("Failed to set up extended signal metrics", "path", path, "error", err)
+		}
 		slog.Info("Subscribing to modem messaging", "path", path)
 		ctx, cancel := context.WithCancel(context.Background())
 		go func(ctx context.Context, m *modem.Modem) {
 			if err := m.SubscribeMessaging(ctx, func(message *modem.SMS) error {
-				if err := send(bot, m, message); err != nil {
+				if err := send(bot, book, m, message); err != nil {
 					slog.Error("Failed to send message", "error", err)
 				}
 				return nil
@@ -122,35 +206,286 @@ func subscribeMessaging(bot *telego.Bot, modems map[dbus.ObjectPath]*modem.Modem
 				slog.Error("Failed to subscribe to modem messaging", "error", err)
 			}
 		}(ctx, m)
+		slog.Info("Subscribing to modem USSD", "path", path)
+		go func(ctx context.Context, m *modem.Modem) {
+			if err := m.SubscribeUSSD(ctx, func(message *modem.USSDNetworkMessage) error {
+				return sendUSSD(bot, m, message)
+			}); err != nil {
+				slog.Error("Failed to subscribe to modem USSD", "error", err)
+			}
+		}(ctx, m)
+		slog.Info("Subscribing to modem calls", "path", path)
+		go func(ctx context.Context, m *modem.Modem) {
+			watcher := voice.NewWatcher(m, func(record *voice.Record) {
+				if err := sendCall(bot, book, m, record); err != nil {
+					slog.Error("Failed to send call notification", "error", err)
+				}
+			})
+			if err := m.SubscribeCalls(ctx, watcher.Update); err != nil {
+				slog.Error("Failed to subscribe to modem calls", "error", err)
+			}
+		}(ctx, m)
+		slog.Info("Subscribing to modem cell broadcasts", "path", path)
+		go func(ctx context.Context, m *modem.Modem) {
+			if err := m.SubscribeCellBroadcast(ctx, func(message *modem.CellBroadcast) error {
+				if err := sendCellBroadcast(bot, m, message); err != nil {
+					return err
+				}
+				return m.DeleteCellBroadcast(message.Path)
+			}); err != nil {
+				slog.Error("Failed to subscribe to modem cell broadcasts", "error", err)
+			}
+		}(ctx, m)
+		slog.Info("Subscribing to modem status", "path", path)
+		go func(ctx context.Context, m *modem.Modem) {
+			watcher := network.NewWatcher(m.Sim.OperatorIdentifier, func(event config.NetworkEvent, message string) {
+				if err := sendNetworkEvent(bot, m, event, message); err != nil {
+					slog.Error("Failed to send network event", "error", err)
+				}
+			})
+			defer watcher.Stop()
+			if err := m.SubscribeStatus(ctx, watcher.Update); err != nil {
+				slog.Error("Failed to subscribe to modem status", "error", err)
+			}
+		}(ctx, m)
 		subscribers[path] = &Subscriber{ctx: ctx, cancel: cancel}
 	}
 }
 
-func send(bot *telego.Bot, modem *modem.Modem, messsage *modem.SMS) error {
+// maxHexDump is the largest binary message shown as a hex dump, larger ones are attached as a file.
+const maxHexDump = 280
+
+func send(bot *telego.Bot, book *contact.Book, modem *modem.Modem, messsage *modem.SMS) error {
 	template := `
-[ ] *\[%s\] \- %s*
-> %s
+[ ] *\[%s\] \- %s*%s
+%s
 `
 	operatorName, err := modem.OperatorName()
 	if err != nil {
 		slog.Error("Failed to get operator name", "error", err)
 		operatorName = "unknown"
 	}
-	message := fmt.Sprintf(
-		template,
-		util.EscapeText(operatorName),
-		util.EscapeText(messsage.Number),
-		fmt.Sprintf("`%s`", util.EscapeText(messsage.Text)),
-	)
+	// Flash messages would pop up on a phone, they stand out here too.
+	from := book.Label(messsage.Number)
+	title := util.EscapeText(util.If(messsage.Flash(), "⚡ Flash message from ", "") + from)
+	body := fmt.Sprintf("> `%s`", util.EscapeText(messsage.Text))
+	if messsage.Binary() {
+		body = "_" + util.EscapeText(fmt.Sprintf("Binary message, %d bytes", len(messsage.Data))) + "_"
+		if len(messsage.Data) <= maxHexDump {
+			body += fmt.Sprintf("\n```\n%s```", util.EscapeText(hex.Dump(messsage.Data)))
+		}
+	}
+	message := fmt.Sprintf(template, util.EscapeText(operatorName), title, roaming(modem), body)
 	for _, adminId := range config.C.AdminId.MarshalInt64() {
+		if messsage.Binary() && len(messsage.Data) > maxHexDump {
+			name := fmt.Sprintf("sms-%s-%s.bin", messsage.Number, messsage.Timestamp.Format("20060102150405"))
+			if _, err := bot.SendDocument(context.Background(), tu.Document(
+				tu.ID(adminId),
+				tu.FileFromBytes(messsage.Data, name),
+			).WithCaption(message).WithParseMode(telego.ModeMarkdownV2)); err != nil {
+				slog.Error("Failed to send binary message", "error", err, "to", adminId)
+				continue
+			}
+			slog.Info("Binary message sent", "to", adminId, "size", len(messsage.Data))
+			continue
+		}
 		msg, err := bot.SendMessage(context.Background(), tu.Message(
 			tu.ID(adminId),
 			message,
 		).WithParseMode(telego.ModeMarkdownV2))
 		if err != nil {
 			slog.Error("Failed to send message", "error", err, "to", adminId, "message", message)
+			continue
+		}
+		slog.Info("Message sent", "id", msg.Chat.ID, "to", adminId, "flash", messsage.Flash())
+	}
+	return nil
+}
+
+// roaming annotates a forwarded message received while roaming.
+func roaming(modem *modem.Modem) string {
+	roaming, err := modem.Roaming()
+	if err != nil {
+		slog.Warn("Failed to detect roaming", "error", err)
+		return ""
+	}
+	if !roaming.Active {
+		return ""
+	}
+	return "\n_" + util.EscapeText("Roaming on "+roaming.String()) + "_"
+}
+
+func sendUSSD(bot *telego.Bot, modem *modem.Modem, message *modem.USSDNetworkMessage) error {
+	template := `
+[ ] *\[%s\] \- USSD %s*
+> %s
+`
+	operatorName, err := modem.OperatorName()
+	if err != nil {
+		slog.Error("Failed to get operator name", "error", err)
+		operatorName = "unknown"
+	}
+	text := fmt.Sprintf(
+		template,
+		util.EscapeText(operatorName),
+		util.If(message.Request, "request", "notification"),
+		fmt.Sprintf("`%s`", util.EscapeText(message.Text)),
+	)
+	var opts []func(*telego.SendMessageParams)
+	if message.Request {
+		opts = append(opts, func(params *telego.SendMessageParams) {
+			params.WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton("Reply").
+					WithCallbackData(handler.CallbackQueryUSSDNetworkPrefix + ":" + modem.EquipmentIdentifier),
+			)))
+		})
+	}
+	if err := notifyAdmins(bot, text, opts...); err != nil {
+		return err
+	}
+	slog.Info("USSD message forwarded", "request", message.Request)
+	return nil
+}
+
+// notifyAdmins sends the MarkdownV2 text to every admin, opts adjust the message before it is sent.
+func notifyAdmins(bot *telego.Bot, text string, opts ...func(*telego.SendMessageParams)) error {
+	var errs error
+	for _, adminId := range config.C.AdminId.MarshalInt64() {
+		params := tu.Message(tu.ID(adminId), text).WithParseMode(telego.ModeMarkdownV2)
+		for _, opt := range opts {
+			opt(params)
+		}
+		if _, err := bot.SendMessage(context.Background(), params); err != nil {
+			errs = errors.Join(errs, fmt.Errorf("failed to notify %d: %w", adminId, err))
+		}
+	}
+	return errs
+}
+
+func sendNetworkEvent(bot *telego.Bot, modem *modem.Modem, event config.NetworkEvent, message string) error {
+	template := `
+[ ] *\[%s\] \- Network %s*
+%s
+`
+	text := fmt.Sprintf(
+		template,
+		util.EscapeText(util.If(modem.Number != "", modem.Number, modem.EquipmentIdentifier)),
+		util.EscapeText(string(event)),
+		util.EscapeText(message),
+	)
+	if err := notifyAdmins(bot, text); err != nil {
+		return err
+	}
+	slog.Info("Network event sent", "event", event)
+	return nil
+}
+
+func sendCall(bot *telego.Bot, book *contact.Book, modem *modem.Modem, record *voice.Record) error {
+	template := `
+[ ] *\[%s\] \- %s*
+%s
+`
+	if c, err := book.Find(record.Number); err == nil {
+		record.Name = c.Name
+	}
+	text := fmt.Sprintf(
+		template,
+		util.EscapeText(util.If(modem.Number != "", modem.Number, modem.EquipmentIdentifier)),
+		util.If(record.Missed(), "Missed call", "Call"),
+		util.EscapeText(fmt.Sprintf("%s at %s.", record, record.Started.Format(time.DateTime))),
+	)
+	if err := notifyAdmins(bot, text); err != nil {
+		return err
+	}
+	slog.Info("Call notification sent", "number", record.Number, "missed", record.Missed())
+	return nil
+}
+
+func sendCellBroadcast(bot *telego.Bot, modem *modem.Modem, message *modem.CellBroadcast) error {
+	template := `
+[ ] *\[%s\] \- %s*
+%s
+> %s
+`
+	text := fmt.Sprintf(
+		template,
+		util.EscapeText(util.If(modem.Number != "", modem.Number, modem.EquipmentIdentifier)),
+		util.EscapeText(util.If(message.Severity().Warning(), "🚨 ", "")+message.Severity().String()),
+		util.EscapeText(fmt.Sprintf("Channel %d, message %d, update %d", message.Channel, message.MessageCode, message.Update)),
+		fmt.Sprintf("`%s`", util.EscapeText(message.Text)),
+	)
+	if err := notifyAdmins(bot, text); err != nil {
+		return err
+	}
+	slog.Info("Cell broadcast forwarded", "channel", message.Channel)
+	return nil
+}
+
+// unlock enters the saved PIN of a locked SIM, otherwise the admins are asked to unlock it with /pin.
+func unlock(bot *telego.Bot, m *modem.Modem) error {
+	lock, err := m.UnlockRequired()
+	if err != nil {
+		return err
+	}
+	var message string
+	if lock == modem.ModemLockSimPin {
+		pin, err := config.C.PIN(m.Sim.Identifier)
+		if err != nil {
+			return err
+		}
+		retries, err := m.UnlockRetries()
+		if err != nil {
+			return err
+		}
+		// The last attempt is left to the admins, a wrong saved PIN must not block the SIM.
+		if count, ok := retries[modem.ModemLockSimPin]; pin != "" && (!ok || count > 1) {
+			err := m.SendPin(pin)
+			if err == nil {
+				slog.Info("SIM unlocked with the saved PIN", "iccid", m.Sim.Identifier)
+				return sendSIMLock(bot, m, "The SIM has been unlocked with the saved PIN.")
+			}
+			slog.Warn("The saved PIN was rejected", "error", err, "iccid", m.Sim.Identifier)
+			message = fmt.Sprintf("The saved PIN was rejected (%s) and has been forgotten.\n", err)
+			if err := config.C.SavePIN(m.Sim.Identifier, ""); err != nil {
+				return err
+			}
 		}
-		slog.Info("Message sent", "id", msg.Chat.ID, "to", adminId)
 	}
+	return sendSIMLock(bot, m, message+fmt.Sprintf("The SIM %s is locked and requires the %s, use /pin to unlock it.", m.Sim.Identifier, lock))
+}
+
+func sendSIMLock(bot *telego.Bot, modem *modem.Modem, message string) error {
+	template := `
+[ ] *\[%s\] \- SIM lock*
+%s
+`
+	text := fmt.Sprintf(
+		template,
+		util.EscapeText(util.If(modem.Number != "", modem.Number, modem.EquipmentIdentifier)),
+		util.EscapeText(message),
+	)
+	if err := notifyAdmins(bot, text); err != nil {
+		return err
+	}
+	slog.Info("SIM lock alert sent", "iccid", modem.Sim.Identifier)
+	return nil
+}
+
+func sendBalanceAlert(bot *telego.Bot, modem *modem.Modem, balance *ussd.Balance, reasons []string) error {
+	template := `
+[ ] *\[%s\] \- Balance alert*
+%s
+> %s
+`
+	text := fmt.Sprintf(
+		template,
+		util.EscapeText(util.If(modem.Number != "", modem.Number, balance.ICCID)),
+		util.EscapeText(strings.Join(reasons, "\n")),
+		fmt.Sprintf("`%s`", util.EscapeText(balance.Response)),
+	)
+	if err := notifyAdmins(bot, text); err != nil {
+		return err
+	}
+	slog.Info("Balance alert sent", "iccid", balance.ICCID)
 	return nil
 }